@@ -0,0 +1,212 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+  "slices"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+var ackListenerExtType = ExtTypeFor[AckListenerExt]()
+
+// Most signals an AckListenerExt keeps unacked when MaxUnacked isn't set, the oldest is dropped to make room for more
+const ACK_LISTENER_MAX_UNACKED = 256
+
+// Times an AckListenerExt redelivers a signal when MaxRedeliveries isn't set, before dropping it
+const ACK_LISTENER_MAX_REDELIVERIES = 5
+
+// Sent by the consumer of an AckListenerExt to confirm it received a signal
+type AckSignal struct {
+  SignalHeader
+  Signal uuid.UUID `gv:"signal"`
+}
+func (signal AckSignal) String() string {
+  return fmt.Sprintf("AckSignal(%s, %s)", signal.SignalHeader, signal.Signal)
+}
+func NewAckSignal(signal_id uuid.UUID) *AckSignal {
+  return &AckSignal{
+    NewSignalHeader(),
+    signal_id,
+  }
+}
+
+// Queued by an AckListenerExt to redeliver a signal if it hasn't been acked
+type RedeliverSignal struct {
+  SignalHeader
+  Signal uuid.UUID `gv:"signal"`
+}
+func (signal RedeliverSignal) String() string {
+  return fmt.Sprintf("RedeliverSignal(%s, %s)", signal.SignalHeader, signal.Signal)
+}
+func NewRedeliverSignal(signal_id uuid.UUID) *RedeliverSignal {
+  return &RedeliverSignal{
+    NewSignalHeader(),
+    signal_id,
+  }
+}
+
+// A listener whose consumer acks each signal of the types in SignalTypes it receives with an AckSignal.
+// Signals that aren't acked within Timeout are sent to the channel again up to MaxRedeliveries times, and unacked signals
+// are kept in the DB so they're redelivered when the node is loaded again. Signals of other types are sent once and not tracked.
+type AckListenerExt struct {
+  Buffer int `gv:"buffer"`
+  Timeout time.Duration `gv:"timeout"`
+  // Names of the signal types that need acks
+  SignalTypes []string `gv:"signal_types"`
+  MaxUnacked int `gv:"max_unacked"`
+  MaxRedeliveries int `gv:"max_redeliveries"`
+  // Signals that haven't been acked, with the time they'll be redelivered
+  Unacked []QueuedSignal `gv:"unacked" gql:"-"`
+  // Times each unacked signal has been redelivered
+  Redelivered map[uuid.UUID]int `gv:"redelivered" gql:"-"`
+  Chan chan Signal
+
+  // Map from unacked signals to their pending RedeliverSignal
+  redeliveries map[uuid.UUID]uuid.UUID
+}
+
+func NewAckListenerExt(buffer int, timeout time.Duration, signal_types ...string) *AckListenerExt {
+  return &AckListenerExt{
+    Buffer: buffer,
+    Timeout: timeout,
+    SignalTypes: signal_types,
+    MaxUnacked: ACK_LISTENER_MAX_UNACKED,
+    MaxRedeliveries: ACK_LISTENER_MAX_REDELIVERIES,
+    Unacked: []QueuedSignal{},
+    Redelivered: map[uuid.UUID]int{},
+    Chan: make(chan Signal, buffer),
+  }
+}
+
+func (ext *AckListenerExt) Load(ctx *Context, node *Node) error {
+  ext.Chan = make(chan Signal, ext.Buffer)
+  ext.Chan <- NewLoadedSignal()
+  // Redeliveries queued before the node was unloaded are still in its signal queue, they just can't be dequeued early
+  ext.redeliveries = map[uuid.UUID]uuid.UUID{}
+  if ext.Redelivered == nil {
+    ext.Redelivered = map[uuid.UUID]int{}
+  }
+  for _, unacked := range(ext.Unacked) {
+    ext.send(ctx, node, unacked.Signal)
+  }
+  return nil
+}
+
+func (ext *AckListenerExt) Unload(ctx *Context, node *Node) {
+  ext.Chan <- NewUnloadedSignal()
+  close(ext.Chan)
+}
+
+// Ack a signal received from the node's AckListenerExt
+func (ext *AckListenerExt) Ack(ctx *Context, node *Node, signal_id uuid.UUID) error {
+  return ctx.Send(node, []Message{{node.ID, NewAckSignal(signal_id)}})
+}
+
+func (ext *AckListenerExt) send(ctx *Context, node *Node, signal Signal) {
+  select {
+  case ext.Chan <- signal:
+  default:
+    ctx.Log.Logf("listener", "ACK_LISTENER_OVERFLOW: %s, %s will be redelivered", node.ID, signal.ID())
+  }
+}
+
+func (ext *AckListenerExt) unacked(signal_id uuid.UUID) int {
+  return slices.IndexFunc(ext.Unacked, func(unacked QueuedSignal) bool {
+    return unacked.Signal.ID() == signal_id
+  })
+}
+
+func (ext *AckListenerExt) maxUnacked() int {
+  if ext.MaxUnacked <= 0 {
+    return ACK_LISTENER_MAX_UNACKED
+  }
+  return ext.MaxUnacked
+}
+
+func (ext *AckListenerExt) maxRedeliveries() int {
+  if ext.MaxRedeliveries <= 0 {
+    return ACK_LISTENER_MAX_REDELIVERIES
+  }
+  return ext.MaxRedeliveries
+}
+
+// Stop tracking the unacked signal at i and dequeue its redelivery
+func (ext *AckListenerExt) remove(node *Node, i int) {
+  signal_id := ext.Unacked[i].Signal.ID()
+  ext.Unacked = slices.Delete(ext.Unacked, i, i+1)
+  delete(ext.Redelivered, signal_id)
+  redeliver_id, pending := ext.redeliveries[signal_id]
+  if pending {
+    node.DequeueSignal(redeliver_id)
+    delete(ext.redeliveries, signal_id)
+  }
+}
+
+// Queue a redelivery of the signal after Timeout, returning when it will be redelivered
+func (ext *AckListenerExt) queueRedelivery(node *Node, signal_id uuid.UUID) time.Time {
+  redeliver := NewRedeliverSignal(signal_id)
+  ext.redeliveries[signal_id] = redeliver.ID()
+  at := time.Now().Add(ext.Timeout)
+  node.QueueSignal(at, redeliver)
+  return at
+}
+
+func (ext *AckListenerExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  var changes Changes = nil
+
+  switch sig := signal.(type) {
+  case *AckSignal:
+    i := ext.unacked(sig.Signal)
+    if i == -1 {
+      break
+    }
+
+    ext.remove(node, i)
+    changes.Add(ackListenerExtType, ChangeRemove, "unacked", "redelivered")
+
+  case *RedeliverSignal:
+    if source != node.ID {
+      break
+    }
+
+    i := ext.unacked(sig.Signal)
+    if i == -1 {
+      break
+    }
+
+    delete(ext.redeliveries, sig.Signal)
+    if ext.Redelivered[sig.Signal] >= ext.maxRedeliveries() {
+      ctx.Log.Logf("listener", "ACK_LISTENER_EXPIRED: %s dropping %s after %d redeliveries", node.ID, sig.Signal, ext.Redelivered[sig.Signal])
+      ext.remove(node, i)
+      changes.Add(ackListenerExtType, ChangeRemove, "unacked", "redelivered")
+      break
+    }
+
+    ctx.Log.Logf("listener", "%s redelivering %s", node.ID, sig.Signal)
+    ext.send(ctx, node, ext.Unacked[i].Signal)
+    ext.Redelivered[sig.Signal] += 1
+    ext.Unacked[i].Time = ext.queueRedelivery(node, sig.Signal)
+    changes.Add(ackListenerExtType, ChangeSet, "unacked", "redelivered")
+
+  default:
+    ctx.Log.Logf("listener", "%s - %+v", node.ID, reflect.TypeOf(signal))
+    if slices.Contains(ext.SignalTypes, signalTypeName(signal)) == false {
+      ext.send(ctx, node, signal)
+      break
+    }
+
+    if len(ext.Unacked) >= ext.maxUnacked() {
+      ctx.Log.Logf("listener", "ACK_LISTENER_FULL: %s dropping oldest unacked %s", node.ID, ext.Unacked[0].Signal.ID())
+      ext.remove(node, 0)
+      changes.Add(ackListenerExtType, ChangeRemove, "redelivered")
+    }
+
+    ext.Unacked = append(ext.Unacked, QueuedSignal{signal, ext.queueRedelivery(node, signal.ID())})
+    changes.Add(ackListenerExtType, ChangeAdd, "unacked")
+    ext.send(ctx, node, signal)
+  }
+
+  return nil, changes
+}
@@ -0,0 +1,83 @@
+package graphvent
+
+import (
+  "errors"
+  "fmt"
+)
+
+var (
+  AliasNotFoundError = errors.New("Alias not found in DB")
+  AliasTakenError = errors.New("Alias is already assigned to another node")
+)
+
+// Assign a name to a node, the name can't already be assigned to a different node.
+// The node is sent an AliasSignal with the "add" action.
+func (ctx *Context) SetAlias(alias string, id NodeID) error {
+  if alias == "" {
+    return fmt.Errorf("Cannot set empty alias")
+  }
+
+  err := ctx.checkMaintenance("set aliases")
+  if err != nil {
+    return err
+  }
+
+  node, err := ctx.GetNode(id)
+  if err != nil {
+    return err
+  }
+
+  err = ctx.DB.WriteAlias(ctx, alias, id)
+  if err != nil {
+    return err
+  }
+
+  return ctx.Send(node, []Message{{node.ID, NewAliasSignal("add", alias)}})
+}
+
+// Remove a name from the node it's assigned to, which is sent an AliasSignal with the "remove" action
+func (ctx *Context) RemoveAlias(alias string) error {
+  err := ctx.checkMaintenance("remove aliases")
+  if err != nil {
+    return err
+  }
+
+  id, err := ctx.DB.RemoveAlias(ctx, alias)
+  if err != nil {
+    return err
+  }
+
+  node, err := ctx.GetNode(id)
+  if errors.Is(err, NodeNotFoundError) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+
+  return ctx.Send(node, []Message{{node.ID, NewAliasSignal("remove", alias)}})
+}
+
+// Get the ID of the node a name is assigned to
+func (ctx *Context) ResolveAlias(alias string) (NodeID, error) {
+  return ctx.DB.LoadAlias(ctx, alias)
+}
+
+// Parse str as a NodeID, or resolve it as an alias if it isn't one
+func (ctx *Context) ParseNode(str string) (NodeID, error) {
+  id, err := ParseID(str)
+  if err == nil {
+    return id, nil
+  }
+
+  return ctx.ResolveAlias(str)
+}
+
+// Send a signal to the node an alias is assigned to
+func (ctx *Context) SendAlias(node *Node, alias string, signal Signal) error {
+  id, err := ctx.ResolveAlias(alias)
+  if err != nil {
+    return err
+  }
+
+  return ctx.Send(node, []Message{{id, signal}})
+}
@@ -0,0 +1,136 @@
+package graphvent
+
+import (
+  "crypto/ed25519"
+  "crypto/hmac"
+  "crypto/sha256"
+  "errors"
+  "fmt"
+  "sync"
+)
+
+// Creates nodes of a registered type the first time a signal is sent to their ID, instead of failing with NodeNotFoundError.
+// Since a node's ID is derived from its key, the policy has to be able to produce the key for the IDs it creates.
+type AutoCreatePolicy struct {
+  // Name used in logs and errors
+  Name string
+  NodeType string
+  // Get the key of the node with the ID, failing with KeyNotFoundError if the policy doesn't create it
+  Key func(ctx *Context, id NodeID) (ed25519.PrivateKey, error)
+  // Whether source is allowed to create the node by sending to it
+  Allow func(ctx *Context, source NodeID, id NodeID) bool
+  // Extensions to create the node with
+  Template func(ctx *Context, id NodeID) ([]Extension, error)
+}
+
+// Add a policy for creating nodes when they're first sent to, policies are tried in the order they're added
+func AddAutoCreate(ctx *Context, policy AutoCreatePolicy) error {
+  if policy.Key == nil || policy.Allow == nil || policy.Template == nil {
+    return fmt.Errorf("Auto create policy %s needs a Key, Allow, and Template", policy.Name)
+  }
+
+  _, exists := ctx.NodeTypes[NodeTypeFor(policy.NodeType)]
+  if exists == false {
+    return fmt.Errorf("Cannot auto create unregistered node type %s", policy.NodeType)
+  }
+
+  for _, existing := range(ctx.autoCreate) {
+    if existing.Name == policy.Name {
+      return fmt.Errorf("Auto create policy %s already exists", policy.Name)
+    }
+  }
+
+  ctx.autoCreate = append(ctx.autoCreate, policy)
+  return nil
+}
+
+// Get the node, creating it with the first auto create policy that has its key if it doesn't exist
+func (ctx *Context) getOrCreateNode(source NodeID, id NodeID) (*Node, error) {
+  node, err := ctx.lookupNode(id)
+  if err == nil || len(ctx.autoCreate) == 0 || errors.Is(err, NodeNotFoundError) == false {
+    return node, err
+  }
+  not_found := err
+
+  for _, policy := range(ctx.autoCreate) {
+    key, err := policy.Key(ctx, id)
+    if errors.Is(err, KeyNotFoundError) {
+      continue
+    } else if err != nil {
+      return nil, fmt.Errorf("Failed to get key for %s from auto create policy %s: %w", id, policy.Name, err)
+    } else if KeyID(key.Public().(ed25519.PublicKey)) != id {
+      return nil, fmt.Errorf("Auto create policy %s returned a key for %s instead of %s", policy.Name, KeyID(key.Public().(ed25519.PublicKey)), id)
+    }
+
+    // Denied sources get the same error as if there was no policy, so they can't probe for IDs
+    if policy.Allow(ctx, source, id) == false {
+      ctx.Log.Logf("node", "AUTO_CREATE_DENIED: %s can't create %s with %s", source, id, policy.Name)
+      return nil, not_found
+    }
+
+    extensions, err := policy.Template(ctx, id)
+    if err != nil {
+      return nil, fmt.Errorf("Failed to create extensions for %s from auto create policy %s: %w", id, policy.Name, err)
+    }
+
+    node, err := ctx.NewNode(key, policy.NodeType, extensions...)
+    if err != nil {
+      // Another sender may have created the node first
+      existing, get_err := ctx.lookupNode(id)
+      if get_err == nil {
+        return existing, nil
+      }
+      return nil, fmt.Errorf("Failed to auto create %s with %s: %w", id, policy.Name, err)
+    }
+
+    ctx.Log.Logf("node", "AUTO_CREATE: %s created %s with %s", source, id, policy.Name)
+    return node, nil
+  }
+
+  return nil, not_found
+}
+
+// Keys derived from a secret and a name, so the ID of a named node like a per-team scratch node is known before it's created.
+// Use Key as an AutoCreatePolicy's Key to create the nodes for names that ID has been called with.
+type DerivedKeys struct {
+  secret []byte
+  lock sync.RWMutex
+  names map[NodeID]string
+}
+
+func NewDerivedKeys(secret []byte) (*DerivedKeys, error) {
+  if len(secret) < 32 {
+    return nil, fmt.Errorf("Derived key secret must be at least 32 bytes, got %d", len(secret))
+  }
+  return &DerivedKeys{
+    secret: secret,
+    names: map[NodeID]string{},
+  }, nil
+}
+
+func (keys *DerivedKeys) derive(name string) ed25519.PrivateKey {
+  mac := hmac.New(sha256.New, keys.secret)
+  mac.Write([]byte(name))
+  return ed25519.NewKeyFromSeed(mac.Sum(nil))
+}
+
+// Get the ID of the node for name
+func (keys *DerivedKeys) ID(name string) NodeID {
+  id := KeyID(keys.derive(name).Public().(ed25519.PublicKey))
+
+  keys.lock.Lock()
+  defer keys.lock.Unlock()
+  keys.names[id] = name
+  return id
+}
+
+// Get the key for an ID returned by ID, failing with KeyNotFoundError for any other ID
+func (keys *DerivedKeys) Key(ctx *Context, id NodeID) (ed25519.PrivateKey, error) {
+  keys.lock.RLock()
+  name, known := keys.names[id]
+  keys.lock.RUnlock()
+  if known == false {
+    return nil, fmt.Errorf("%s: %w", id, KeyNotFoundError)
+  }
+  return keys.derive(name), nil
+}
@@ -0,0 +1,61 @@
+package graphvent
+
+import (
+  "errors"
+  "fmt"
+)
+
+// Set whether nodes of a registered type are added to the auto-load set when they're created.
+// Nodes created before it's set have to be added with SetNodeAutoLoad.
+func SetAutoLoad(ctx *Context, name string, enabled bool) error {
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set auto-load for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set auto-load for unregistered node type %s", name)
+  }
+
+  node_info.AutoLoad = enabled
+  ctx.NodeTypes[node_type] = node_info
+  return nil
+}
+
+// Add or remove a node from the auto-load set
+func (ctx *Context) SetNodeAutoLoad(id NodeID, enabled bool) error {
+  err := ctx.checkMaintenance("change auto-load")
+  if err != nil {
+    return err
+  }
+  return ctx.DB.WriteAutoLoad(ctx, id, enabled)
+}
+
+// Load every node in the auto-load set, so nodes waiting on queued signals run them after a restart.
+// Should be called after all the types are registered. Nodes that no longer exist are removed from the set.
+func (ctx *Context) LoadAutoNodes() ([]NodeID, error) {
+  ids, err := ctx.DB.LoadAutoLoad(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  loaded := make([]NodeID, 0, len(ids))
+  for _, id := range(ids) {
+    _, err := ctx.GetNode(id)
+    if errors.Is(err, NodeNotFoundError) {
+      ctx.Log.Logf("node", "Removing missing node %s from auto-load", id)
+      err = ctx.DB.WriteAutoLoad(ctx, id, false)
+      if err != nil {
+        return loaded, err
+      }
+    } else if err != nil {
+      return loaded, fmt.Errorf("Failed to auto-load %s: %w", id, err)
+    } else {
+      loaded = append(loaded, id)
+    }
+  }
+
+  return loaded, nil
+}
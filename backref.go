@@ -0,0 +1,238 @@
+package graphvent
+
+import (
+  "reflect"
+  "sync"
+  "time"
+)
+
+// A field on the source node that holds the ID of another node
+type Reference struct {
+  Source NodeID
+  Extension ExtType
+  Field Tag
+}
+
+// Whether values of the type can hold NodeIDs, interfaces are assumed to
+func holdsNodeIDs(t reflect.Type) bool {
+  return holdsNodeIDsVisit(t, map[reflect.Type]bool{})
+}
+
+func holdsNodeIDsVisit(t reflect.Type, visiting map[reflect.Type]bool) bool {
+  if t == reflect.TypeFor[NodeID]() {
+    return true
+  } else if visiting[t] {
+    return false
+  }
+  visiting[t] = true
+
+  switch t.Kind() {
+  case reflect.Interface:
+    return true
+  case reflect.Pointer, reflect.Slice, reflect.Array:
+    return holdsNodeIDsVisit(t.Elem(), visiting)
+  case reflect.Map:
+    return holdsNodeIDsVisit(t.Key(), visiting) || holdsNodeIDsVisit(t.Elem(), visiting)
+  case reflect.Struct:
+    for i := 0; i < t.NumField(); i++ {
+      if t.Field(i).IsExported() && holdsNodeIDsVisit(t.Field(i).Type, visiting) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// Get the distinct NodeIDs reachable from the value through exported fields, pointers, interfaces, and collections
+func NodeIDsIn(value reflect.Value) []NodeID {
+  ids := []NodeID{}
+  seen := map[NodeID]bool{}
+  collectNodeIDs(value, func(id NodeID) {
+    if seen[id] == false {
+      seen[id] = true
+      ids = append(ids, id)
+    }
+  })
+  return ids
+}
+
+func collectNodeIDs(value reflect.Value, add func(NodeID)) {
+  if value.IsValid() == false {
+    return
+  } else if value.Type() == reflect.TypeFor[NodeID]() {
+    add(NodeID(value.Interface().(NodeID)))
+    return
+  }
+
+  switch value.Kind() {
+  case reflect.Pointer, reflect.Interface:
+    if value.IsNil() == false {
+      collectNodeIDs(value.Elem(), add)
+    }
+  case reflect.Struct:
+    for i := 0; i < value.NumField(); i++ {
+      if value.Type().Field(i).IsExported() {
+        collectNodeIDs(value.Field(i), add)
+      }
+    }
+  case reflect.Slice, reflect.Array:
+    for i := 0; i < value.Len(); i++ {
+      collectNodeIDs(value.Index(i), add)
+    }
+  case reflect.Map:
+    iter := value.MapRange()
+    for iter.Next() {
+      collectNodeIDs(iter.Key(), add)
+      collectNodeIDs(iter.Value(), add)
+    }
+  }
+}
+
+// Index from nodes to the fields that hold their ID, for every node that's loaded.
+// Unloaded nodes are removed, since the DB has their references as of when they were unloaded.
+type referenceIndex struct {
+  lock sync.RWMutex
+  fields map[Reference][]NodeID
+  references map[NodeID]map[Reference]bool
+  sources map[NodeID]bool
+}
+
+func newReferenceIndex() *referenceIndex {
+  return &referenceIndex{
+    fields: map[Reference][]NodeID{},
+    references: map[NodeID]map[Reference]bool{},
+    sources: map[NodeID]bool{},
+  }
+}
+
+// Replace the IDs held by a field, must be called with the lock held
+func (index *referenceIndex) update(field Reference, ids []NodeID) {
+  current := map[NodeID]bool{}
+  for _, id := range(ids) {
+    current[id] = true
+    if index.references[id] == nil {
+      index.references[id] = map[Reference]bool{}
+    }
+    index.references[id][field] = true
+  }
+
+  for _, id := range(index.fields[field]) {
+    if current[id] == false {
+      delete(index.references[id], field)
+      if len(index.references[id]) == 0 {
+        delete(index.references, id)
+      }
+    }
+  }
+
+  if len(ids) == 0 {
+    delete(index.fields, field)
+  } else {
+    index.fields[field] = ids
+  }
+}
+
+// Index every field of a node that's being started
+func (ctx *Context) indexNode(node *Node) {
+  ctx.referenceIndex.lock.Lock()
+  defer ctx.referenceIndex.lock.Unlock()
+
+  ctx.referenceIndex.sources[node.ID] = true
+  for ext_type, ext := range(node.Extensions) {
+    ext_value := reflect.ValueOf(ext).Elem()
+    for tag, field_info := range(ctx.Extensions[ext_type].Fields) {
+      if field_info.HoldsNodeIDs {
+        ctx.referenceIndex.update(Reference{node.ID, ext_type, tag}, NodeIDsIn(fieldOrZero(ext_value, field_info.Index)))
+      }
+    }
+  }
+}
+
+// Remove the fields of a node that's been unloaded, so References reads them from the DB
+func (ctx *Context) unindexNode(node *Node) {
+  ctx.referenceIndex.lock.Lock()
+  defer ctx.referenceIndex.lock.Unlock()
+
+  delete(ctx.referenceIndex.sources, node.ID)
+  for ext_type := range(node.Extensions) {
+    for tag, field_info := range(ctx.Extensions[ext_type].Fields) {
+      if field_info.HoldsNodeIDs {
+        ctx.referenceIndex.update(Reference{node.ID, ext_type, tag}, nil)
+      }
+    }
+  }
+}
+
+// Mark the changed fields that can hold NodeIDs to be reindexed when the node is idle,
+// so fields changed by every signal in a burst are only walked once
+func (node *Node) markReferences(ctx *Context, changes Changes) {
+  for ext_type, fields := range(changes.ByExtension()) {
+    ext_info, exists := ctx.Extensions[ext_type]
+    if exists == false {
+      continue
+    }
+
+    for _, tag := range(fields) {
+      if ext_info.Fields[tag].HoldsNodeIDs {
+        if node.dirtyReferences == nil {
+          node.dirtyReferences = map[Reference]bool{}
+        }
+        node.dirtyReferences[Reference{node.ID, ext_type, tag}] = true
+      }
+    }
+  }
+}
+
+// Minimum time between reindexing a node's changed fields, so a burst of changes to a large field is only walked once
+const REFERENCE_FLUSH_INTERVAL = 10*time.Millisecond
+
+// Reindex the fields marked by markReferences if REFERENCE_FLUSH_INTERVAL has passed since the last flush or force is set.
+// Returns how long until the remaining marked fields can be flushed, or 0 if there are none
+func (node *Node) flushReferences(ctx *Context, force bool) time.Duration {
+  if len(node.dirtyReferences) == 0 {
+    return 0
+  }
+
+  since := time.Since(node.referencesFlushed)
+  if force == false && since < REFERENCE_FLUSH_INTERVAL {
+    return REFERENCE_FLUSH_INTERVAL - since
+  }
+
+  ctx.referenceIndex.lock.Lock()
+  defer ctx.referenceIndex.lock.Unlock()
+
+  for field := range(node.dirtyReferences) {
+    ext, exists := node.Extensions[field.Extension]
+    if exists == false {
+      continue
+    }
+    field_info := ctx.Extensions[field.Extension].Fields[field.Field]
+    ctx.referenceIndex.update(field, NodeIDsIn(fieldOrZero(reflect.ValueOf(ext).Elem(), field_info.Index)))
+  }
+  node.dirtyReferences = nil
+  node.referencesFlushed = time.Now()
+  return 0
+}
+
+// Get every field that holds the ID of the node. Loaded nodes are indexed when they're idle,
+// and the rest are read from the index the DB keeps as of when they were last written
+func (ctx *Context) References(id NodeID) ([]Reference, error) {
+  stored, err := ctx.DB.LoadReferences(ctx, id)
+  if err != nil {
+    return nil, err
+  }
+
+  ctx.referenceIndex.lock.RLock()
+  defer ctx.referenceIndex.lock.RUnlock()
+
+  references := []Reference{}
+  for _, reference := range(stored) {
+    if ctx.referenceIndex.sources[reference.Source] == false {
+      references = append(references, reference)
+    }
+  }
+  for reference := range(ctx.referenceIndex.references[id]) {
+    references = append(references, reference)
+  }
+  return references, nil
+}
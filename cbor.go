@@ -0,0 +1,633 @@
+package graphvent
+
+import (
+  "encoding"
+  "encoding/binary"
+  "fmt"
+  "math"
+  "reflect"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+// CBOR(RFC 8949) encoding of the values Serialize writes, for clients that have a CBOR library but not the TypeStack framing.
+// Structs are maps keyed by their gv tags, NodeIDs and UUIDs are byte strings with tag 37, times are RFC 3339 strings with tag 0,
+// other types with MarshalText are text strings, and nil pointers, slices, maps, and interfaces are null.
+// Interfaces and SerializedValues are the array [type, value], with the type stack as an array of names like ["slice", "graphvent.NodeID"]
+// and array lengths as unsigned integers. Indefinite length items aren't supported.
+type CBORFormat struct {}
+
+const (
+  cborUint = byte(0)
+  cborNegative = byte(1)
+  cborBytes = byte(2)
+  cborText = byte(3)
+  cborArray = byte(4)
+  cborMap = byte(5)
+  cborTag = byte(6)
+  cborSimple = byte(7)
+
+  cborFalse = byte(0xf4)
+  cborTrue = byte(0xf5)
+  cborNull = byte(0xf6)
+
+  cborTagTime = uint64(0)
+  cborTagUUID = uint64(37)
+)
+
+var uuidType = reflect.TypeFor[uuid.UUID]()
+var nodeIDType = reflect.TypeFor[NodeID]()
+var timeType = reflect.TypeFor[time.Time]()
+
+func (CBORFormat) Encode(ctx *Context, value reflect.Value) ([]byte, error) {
+  return encodeCBOR(ctx, nil, value)
+}
+
+func (CBORFormat) Decode(ctx *Context, data []byte, t reflect.Type) (reflect.Value, error) {
+  value, left, err := decodeCBOR(ctx, data, t)
+  if err != nil {
+    return reflect.Value{}, err
+  } else if len(left) != 0 {
+    return reflect.Value{}, fmt.Errorf("%d/%d bytes left after decoding %s from CBOR", len(left), len(data), t)
+  }
+  return value, nil
+}
+
+func appendCBORHead(data []byte, major byte, arg uint64) []byte {
+  major = major << 5
+  if arg < 24 {
+    return append(data, major | byte(arg))
+  } else if arg <= math.MaxUint8 {
+    return append(data, major | 24, byte(arg))
+  } else if arg <= math.MaxUint16 {
+    return binary.BigEndian.AppendUint16(append(data, major | 25), uint16(arg))
+  } else if arg <= math.MaxUint32 {
+    return binary.BigEndian.AppendUint32(append(data, major | 26), uint32(arg))
+  }
+  return binary.BigEndian.AppendUint64(append(data, major | 27), arg)
+}
+
+// Read the major type and argument of the next item, the argument of a float is its bits
+func readCBORHead(data []byte) (byte, uint64, []byte, error) {
+  if len(data) < 1 {
+    return 0, 0, nil, fmt.Errorf("Not enough data to decode CBOR item")
+  }
+
+  major := data[0] >> 5
+  info := data[0] & 0x1f
+  data = data[1:]
+  if info < 24 {
+    return major, uint64(info), data, nil
+  }
+
+  size := 0
+  switch info {
+  case 24:
+    size = 1
+  case 25:
+    size = 2
+  case 26:
+    size = 4
+  case 27:
+    size = 8
+  default:
+    return 0, 0, nil, fmt.Errorf("Unsupported CBOR additional info %d", info)
+  }
+  if len(data) < size {
+    return 0, 0, nil, fmt.Errorf("Not enough data to decode CBOR argument(got %d, want %d)", len(data), size)
+  }
+
+  var arg uint64
+  switch size {
+  case 1:
+    arg = uint64(data[0])
+  case 2:
+    arg = uint64(binary.BigEndian.Uint16(data))
+  case 4:
+    arg = uint64(binary.BigEndian.Uint32(data))
+  case 8:
+    arg = binary.BigEndian.Uint64(data)
+  }
+  return major, arg, data[size:], nil
+}
+
+func expectCBORHead(data []byte, major byte, t reflect.Type) (uint64, []byte, error) {
+  got, arg, left, err := readCBORHead(data)
+  if err != nil {
+    return 0, nil, err
+  } else if got != major {
+    return 0, nil, fmt.Errorf("Expected CBOR major type %d for %s, got %d", major, t, got)
+  }
+  return arg, left, nil
+}
+
+func readCBORString(data []byte, major byte, t reflect.Type) ([]byte, []byte, error) {
+  length, left, err := expectCBORHead(data, major, t)
+  if err != nil {
+    return nil, nil, err
+  } else if uint64(len(left)) < length {
+    return nil, nil, fmt.Errorf("Not enough data to decode %d byte string for %s", length, t)
+  }
+  return left[:length], left[length:], nil
+}
+
+// Get the data after the next item
+func skipCBOR(data []byte) ([]byte, error) {
+  major, arg, left, err := readCBORHead(data)
+  if err != nil {
+    return nil, err
+  }
+
+  switch major {
+  case cborBytes, cborText:
+    if uint64(len(left)) < arg {
+      return nil, fmt.Errorf("Not enough data to skip %d byte string", arg)
+    }
+    return left[arg:], nil
+  case cborArray, cborMap:
+    items := arg
+    if major == cborMap {
+      items *= 2
+    }
+    for i := uint64(0); i < items; i++ {
+      left, err = skipCBOR(left)
+      if err != nil {
+        return nil, err
+      }
+    }
+    return left, nil
+  case cborTag:
+    return skipCBOR(left)
+  default:
+    return left, nil
+  }
+}
+
+func encodeTypedCBOR(ctx *Context, data []byte, value reflect.Value) ([]byte, error) {
+  stack, err := typeStackNames(ctx, value.Type())
+  if err != nil {
+    return nil, err
+  }
+
+  data = appendCBORHead(data, cborArray, 2)
+  data = appendCBORHead(data, cborArray, uint64(len(stack)))
+  for _, name := range(stack) {
+    switch name := name.(type) {
+    case string:
+      data = append(appendCBORHead(data, cborText, uint64(len(name))), name...)
+    case uint64:
+      data = appendCBORHead(data, cborUint, name)
+    }
+  }
+  return encodeCBOR(ctx, data, value)
+}
+
+func decodeTypedCBOR(ctx *Context, data []byte) (reflect.Value, []byte, error) {
+  length, left, err := expectCBORHead(data, cborArray, reflect.TypeFor[any]())
+  if err != nil {
+    return reflect.Value{}, nil, err
+  } else if length != 2 {
+    return reflect.Value{}, nil, fmt.Errorf("Typed CBOR value is an array of %d instead of [type, value]", length)
+  }
+
+  num_names, left, err := expectCBORHead(left, cborArray, reflect.TypeFor[any]())
+  if err != nil {
+    return reflect.Value{}, nil, err
+  }
+  names := make([]any, 0, num_names)
+  for i := uint64(0); i < num_names; i++ {
+    major, arg, after_head, err := readCBORHead(left)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    switch major {
+    case cborText:
+      var name []byte
+      name, left, err = readCBORString(left, cborText, reflect.TypeFor[string]())
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      names = append(names, string(name))
+    case cborUint:
+      names = append(names, arg)
+      left = after_head
+    default:
+      return reflect.Value{}, nil, fmt.Errorf("Invalid CBOR type stack entry with major type %d", major)
+    }
+  }
+
+  t, err := unwrapStackNames(ctx, names)
+  if err != nil {
+    return reflect.Value{}, nil, err
+  }
+  return decodeCBOR(ctx, left, t)
+}
+
+func encodeCBOR(ctx *Context, data []byte, value reflect.Value) ([]byte, error) {
+  t := value.Type()
+  switch t {
+  case serializedValueType:
+    if value.IsNil() {
+      return append(data, cborNull), nil
+    }
+    inner, err := SerializedValue(value.Bytes()).Deserialize(ctx)
+    if err != nil {
+      return nil, err
+    }
+    return encodeTypedCBOR(ctx, data, inner)
+  case uuidType, nodeIDType:
+    data = appendCBORHead(data, cborTag, cborTagUUID)
+    data = appendCBORHead(data, cborBytes, 16)
+    for i := 0; i < 16; i++ {
+      data = append(data, byte(value.Index(i).Uint()))
+    }
+    return data, nil
+  case timeType:
+    data = appendCBORHead(data, cborTag, cborTagTime)
+  }
+
+  if t.Implements(textMarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    text, err := value.Interface().(encoding.TextMarshaler).MarshalText()
+    if err != nil {
+      return nil, err
+    }
+    return append(appendCBORHead(data, cborText, uint64(len(text))), text...), nil
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    if value.Bool() {
+      return append(data, cborTrue), nil
+    }
+    return append(data, cborFalse), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    i := value.Int()
+    if i < 0 {
+      return appendCBORHead(data, cborNegative, uint64(-1 - i)), nil
+    }
+    return appendCBORHead(data, cborUint, uint64(i)), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return appendCBORHead(data, cborUint, value.Uint()), nil
+  case reflect.Float32, reflect.Float64:
+    return binary.BigEndian.AppendUint64(append(data, cborSimple << 5 | 27), math.Float64bits(value.Float())), nil
+  case reflect.String:
+    return append(appendCBORHead(data, cborText, uint64(value.Len())), value.String()...), nil
+
+  case reflect.Pointer:
+    if value.IsNil() {
+      return append(data, cborNull), nil
+    }
+    return encodeCBOR(ctx, data, value.Elem())
+
+  case reflect.Slice:
+    if value.IsNil() {
+      return append(data, cborNull), nil
+    } else if t.Elem().Kind() == reflect.Uint8 {
+      return append(appendCBORHead(data, cborBytes, uint64(value.Len())), value.Bytes()...), nil
+    }
+    fallthrough
+  case reflect.Array:
+    data = appendCBORHead(data, cborArray, uint64(value.Len()))
+    for i := 0; i < value.Len(); i++ {
+      var err error
+      data, err = encodeCBOR(ctx, data, value.Index(i))
+      if err != nil {
+        return nil, err
+      }
+    }
+    return data, nil
+
+  case reflect.Map:
+    if value.IsNil() {
+      return append(data, cborNull), nil
+    }
+    data = appendCBORHead(data, cborMap, uint64(value.Len()))
+    iter := value.MapRange()
+    for iter.Next() {
+      var err error
+      data, err = encodeCBOR(ctx, data, iter.Key())
+      if err != nil {
+        return nil, err
+      }
+      data, err = encodeCBOR(ctx, data, iter.Value())
+      if err != nil {
+        return nil, err
+      }
+    }
+    return data, nil
+
+  case reflect.Struct:
+    info, registered := ctx.Types[t]
+    if registered == false {
+      return nil, fmt.Errorf("Cannot encode unregistered struct %s", t)
+    }
+    // Fields promoted through nil embedded pointers are left out
+    fields := map[string]reflect.Value{}
+    for _, field_info := range(info.Fields) {
+      field_value, set := structField(value, field_info.Index)
+      if set {
+        fields[t.FieldByIndex(field_info.Index).Tag.Get("gv")] = field_value
+      }
+    }
+    data = appendCBORHead(data, cborMap, uint64(len(fields)))
+    for gv_tag, field_value := range(fields) {
+      data = append(appendCBORHead(data, cborText, uint64(len(gv_tag))), gv_tag...)
+
+      var err error
+      data, err = encodeCBOR(ctx, data, field_value)
+      if err != nil {
+        return nil, err
+      }
+    }
+    return data, nil
+
+  case reflect.Interface:
+    if value.IsNil() {
+      return append(data, cborNull), nil
+    }
+    return encodeTypedCBOR(ctx, data, value.Elem())
+
+  default:
+    return nil, fmt.Errorf("Don't know how to encode %s as CBOR", t)
+  }
+}
+
+// Convert the bits of an IEEE 754 half precision float, which other CBOR encoders use for small floats
+func cborHalfFloat(bits uint16) float64 {
+  sign := 1.0
+  if bits & 0x8000 != 0 {
+    sign = -1.0
+  }
+  exponent := int(bits >> 10) & 0x1f
+  mantissa := float64(bits & 0x3ff)
+
+  switch exponent {
+  case 0:
+    return sign * math.Ldexp(mantissa, -24)
+  case 0x1f:
+    if mantissa == 0 {
+      return math.Inf(int(sign))
+    }
+    return math.NaN()
+  default:
+    return sign * math.Ldexp(mantissa + 1024, exponent - 25)
+  }
+}
+
+func decodeCBOR(ctx *Context, data []byte, t reflect.Type) (reflect.Value, []byte, error) {
+  value := reflect.New(t).Elem()
+  if len(data) == 0 {
+    return reflect.Value{}, nil, fmt.Errorf("Not enough data to decode %s from CBOR", t)
+  }
+
+  nullable := t == serializedValueType
+  switch t.Kind() {
+  case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+    nullable = true
+  }
+  if nullable && data[0] == cborNull {
+    return value, data[1:], nil
+  }
+
+  switch t {
+  case serializedValueType:
+    inner, left, err := decodeTypedCBOR(ctx, data)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    serialized, err := SerializeAny(ctx, inner)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    value.SetBytes(serialized)
+    return value, left, nil
+  case uuidType, nodeIDType:
+    tag, left, err := expectCBORHead(data, cborTag, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if tag != cborTagUUID {
+      return reflect.Value{}, nil, fmt.Errorf("Expected CBOR tag %d for %s, got %d", cborTagUUID, t, tag)
+    }
+    id, left, err := readCBORString(left, cborBytes, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if len(id) != 16 {
+      return reflect.Value{}, nil, fmt.Errorf("%s is %d bytes instead of 16", t, len(id))
+    }
+    reflect.Copy(value, reflect.ValueOf(id))
+    return value, left, nil
+  case timeType:
+    tag, left, err := expectCBORHead(data, cborTag, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if tag != cborTagTime {
+      return reflect.Value{}, nil, fmt.Errorf("Expected CBOR tag %d for %s, got %d", cborTagTime, t, tag)
+    }
+    data = left
+  }
+
+  if t.Implements(textMarshalerType) && reflect.PointerTo(t).Implements(textUnmarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    text, left, err := readCBORString(data, cborText, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    err = value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(text)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    return value, left, nil
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    switch data[0] {
+    case cborTrue:
+      value.SetBool(true)
+    case cborFalse:
+      value.SetBool(false)
+    default:
+      return reflect.Value{}, nil, fmt.Errorf("Expected a CBOR bool for %s, got %x", t, data[0])
+    }
+    return value, data[1:], nil
+
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    major, arg, left, err := readCBORHead(data)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if arg > math.MaxInt64 {
+      return reflect.Value{}, nil, fmt.Errorf("CBOR integer overflows %s", t)
+    }
+    var i int64
+    switch major {
+    case cborUint:
+      i = int64(arg)
+    case cborNegative:
+      i = -1 - int64(arg)
+    default:
+      return reflect.Value{}, nil, fmt.Errorf("Expected a CBOR integer for %s, got major type %d", t, major)
+    }
+    if value.OverflowInt(i) {
+      return reflect.Value{}, nil, fmt.Errorf("CBOR integer %d overflows %s", i, t)
+    }
+    value.SetInt(i)
+    return value, left, nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    arg, left, err := expectCBORHead(data, cborUint, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if value.OverflowUint(arg) {
+      return reflect.Value{}, nil, fmt.Errorf("CBOR integer %d overflows %s", arg, t)
+    }
+    value.SetUint(arg)
+    return value, left, nil
+  case reflect.Float32, reflect.Float64:
+    info := data[0] & 0x1f
+    arg, left, err := expectCBORHead(data, cborSimple, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    switch info {
+    case 25:
+      value.SetFloat(cborHalfFloat(uint16(arg)))
+    case 26:
+      value.SetFloat(float64(math.Float32frombits(uint32(arg))))
+    case 27:
+      value.SetFloat(math.Float64frombits(arg))
+    default:
+      return reflect.Value{}, nil, fmt.Errorf("Expected a CBOR float for %s, got %x", t, data[0])
+    }
+    return value, left, nil
+  case reflect.String:
+    text, left, err := readCBORString(data, cborText, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    value.SetString(string(text))
+    return value, left, nil
+
+  case reflect.Pointer:
+    elem, left, err := decodeCBOR(ctx, data, t.Elem())
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    pointer := reflect.New(t.Elem())
+    pointer.Elem().Set(elem)
+    value.Set(pointer)
+    return value, left, nil
+
+  case reflect.Slice:
+    if t.Elem().Kind() == reflect.Uint8 {
+      b, left, err := readCBORString(data, cborBytes, t)
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      value.SetBytes(append([]byte{}, b...))
+      return value, left, nil
+    }
+
+    length, left, err := expectCBORHead(data, cborArray, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if length > uint64(len(left)) {
+      return reflect.Value{}, nil, fmt.Errorf("CBOR array of %d is longer than the data left", length)
+    }
+    value.Set(reflect.MakeSlice(t, int(length), int(length)))
+    for i := 0; i < int(length); i++ {
+      var elem reflect.Value
+      elem, left, err = decodeCBOR(ctx, left, t.Elem())
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      value.Index(i).Set(elem)
+    }
+    return value, left, nil
+
+  case reflect.Array:
+    length, left, err := expectCBORHead(data, cborArray, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if length != uint64(t.Len()) {
+      return reflect.Value{}, nil, fmt.Errorf("Expected a CBOR array of %d for %s, got %d", t.Len(), t, length)
+    }
+    for i := 0; i < t.Len(); i++ {
+      var elem reflect.Value
+      elem, left, err = decodeCBOR(ctx, left, t.Elem())
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      value.Index(i).Set(elem)
+    }
+    return value, left, nil
+
+  case reflect.Map:
+    length, left, err := expectCBORHead(data, cborMap, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    } else if length > uint64(len(left)) {
+      return reflect.Value{}, nil, fmt.Errorf("CBOR map of %d is longer than the data left", length)
+    }
+    value.Set(reflect.MakeMapWithSize(t, int(length)))
+    for i := 0; i < int(length); i++ {
+      var key, elem reflect.Value
+      key, left, err = decodeCBOR(ctx, left, t.Key())
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      elem, left, err = decodeCBOR(ctx, left, t.Elem())
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      value.SetMapIndex(key, elem)
+    }
+    return value, left, nil
+
+  case reflect.Struct:
+    info, registered := ctx.Types[t]
+    if registered == false {
+      return reflect.Value{}, nil, fmt.Errorf("Cannot decode unregistered struct %s", t)
+    }
+    length, left, err := expectCBORHead(data, cborMap, t)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    for i := uint64(0); i < length; i++ {
+      var gv_tag []byte
+      gv_tag, left, err = readCBORString(left, cborText, t)
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      // Fields that have been removed from the struct are skipped
+      field_info, mapped := info.Fields[GetFieldTag(string(gv_tag))]
+      if mapped == false {
+        left, err = skipCBOR(left)
+        if err != nil {
+          return reflect.Value{}, nil, err
+        }
+        continue
+      }
+
+      var field reflect.Value
+      field, left, err = decodeCBOR(ctx, left, field_info.Type)
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      settableField(value, field_info.Index).Set(field)
+    }
+    if info.PostDeserializeIndex != -1 {
+      value.Addr().Method(info.PostDeserializeIndex).Call([]reflect.Value{reflect.ValueOf(ctx)})
+    }
+    return value, left, nil
+
+  case reflect.Interface:
+    elem, left, err := decodeTypedCBOR(ctx, data)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+    value.Set(elem)
+    return value, left, nil
+
+  default:
+    return reflect.Value{}, nil, fmt.Errorf("Don't know how to decode %s from CBOR", t)
+  }
+}
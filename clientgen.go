@@ -0,0 +1,187 @@
+package graphvent
+
+import (
+  "bytes"
+  "fmt"
+  "go/format"
+  "strings"
+  "text/template"
+)
+
+// Go types for the input types a generated client can send, NodeIDs and UUIDs are sent as strings
+var goClientTypes = map[string]string{
+  "string": "string",
+  "bool": "bool",
+  "int": "int",
+  "int8": "int8",
+  "int16": "int16",
+  "int32": "int32",
+  "int64": "int64",
+  "uint": "uint",
+  "uint8": "uint8",
+  "uint16": "uint16",
+  "uint32": "uint32",
+  "uint64": "uint64",
+  "float32": "float32",
+  "float64": "float64",
+  "graphvent.NodeID": "string",
+  "uuid.UUID": "string",
+}
+
+// Get the client type for a catalog field type, any if it doesn't have a known mapping
+func goClientType(t string) string {
+  if strings.HasPrefix(t, "[]") {
+    return "[]" + goClientType(t[2:])
+  } else if strings.HasPrefix(t, "*") {
+    return "*" + goClientType(t[1:])
+  }
+
+  client_type, known := goClientTypes[t]
+  if known == false {
+    return "any"
+  }
+  return client_type
+}
+
+// Convert a snake_case field name to an exported go name
+func goClientName(name string) string {
+  parts := strings.Split(name, "_")
+  for i, part := range(parts) {
+    if len(part) > 0 {
+      parts[i] = strings.ToUpper(part[:1]) + part[1:]
+    }
+  }
+  return strings.Join(parts, "")
+}
+
+type goClientField struct {
+  Name string
+  Type string
+  GQLName string
+}
+
+type goClientSignal struct {
+  Name string
+  Fields []goClientField
+}
+
+var goClientTemplate = template.Must(template.New("client").Parse(`// Code generated by graphvent.GenerateGoClient. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+)
+
+// Client for the SendSignal mutation of a graphvent GQL server
+type Client struct {
+  // URL of the server's /gql endpoint
+  URL string
+  HTTP *http.Client
+}
+
+func NewClient(url string) *Client {
+  return &Client{
+    URL: url,
+    HTTP: http.DefaultClient,
+  }
+}
+
+// A signal that can be sent with Client.Send
+type Signal interface {
+  SignalType() string
+}
+{{range .Signals}}
+type {{.Name}} struct {
+{{- range .Fields}}
+  {{.Name}} {{.Type}} ` + "`json:\"{{.GQLName}},omitempty\"`" + `
+{{- end}}
+}
+
+func ({{.Name}}) SignalType() string {
+  return "{{.Name}}"
+}
+{{end}}
+// Send the signal to the node from the server node, and return the ID of the sent signal.
+// The server only sends signals for clients with certificates, so HTTP has to be set up with one.
+func (client *Client) Send(node string, signal Signal) (string, error) {
+  payload, err := json.Marshal(map[string]any{
+    "query": "mutation($node: graphvent_NodeID!, $signal: SignalInput!) { SendSignal(node: $node, signal: $signal) }",
+    "variables": map[string]any{
+      "node": node,
+      "signal": map[string]any{
+        signal.SignalType(): signal,
+      },
+    },
+  })
+  if err != nil {
+    return "", err
+  }
+
+  resp, err := client.HTTP.Post(client.URL, "application/json", bytes.NewReader(payload))
+  if err != nil {
+    return "", err
+  }
+  defer resp.Body.Close()
+
+  var result struct {
+    Data struct {
+      SendSignal string
+    }
+    Errors []struct {
+      Message string
+    }
+  }
+  err = json.NewDecoder(resp.Body).Decode(&result)
+  if err != nil {
+    return "", err
+  } else if len(result.Errors) != 0 {
+    return "", fmt.Errorf("SendSignal error: %s", result.Errors[0].Message)
+  }
+  return result.Data.SendSignal, nil
+}
+`))
+
+// Generate the source of a go package with a typed struct for each signal in the catalog that SendSignal can send,
+// and a Client that sends them with the SendSignal mutation
+func GenerateGoClient(catalog SignalCatalog, package_name string) ([]byte, error) {
+  signals := []goClientSignal{}
+  for _, entry := range(catalog.Signals) {
+    if entry.Sendable == false {
+      continue
+    }
+
+    signal := goClientSignal{
+      Name: entry.Name,
+      Fields: []goClientField{},
+    }
+    for _, field := range(entry.Fields) {
+      if field.Input {
+        signal.Fields = append(signal.Fields, goClientField{
+          Name: goClientName(field.GQLName),
+          Type: goClientType(field.Type),
+          GQLName: field.GQLName,
+        })
+      }
+    }
+    signals = append(signals, signal)
+  }
+
+  var source bytes.Buffer
+  err := goClientTemplate.Execute(&source, map[string]any{
+    "Package": package_name,
+    "Signals": signals,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  formatted, err := format.Source(source.Bytes())
+  if err != nil {
+    return nil, fmt.Errorf("Generated client doesn't parse: %w", err)
+  }
+  return formatted, nil
+}
@@ -0,0 +1,210 @@
+package graphvent
+
+import (
+  "crypto/ed25519"
+  "crypto/rand"
+  "fmt"
+  "reflect"
+  "time"
+)
+
+// How long CloneNode waits for the node it's cloning to copy it's extensions
+const CLONE_TIMEOUT = time.Second
+
+// Sent by CloneNode to the node it's cloning, so the extensions are copied on the goroutine running the node.
+// It's never serialized, so it doesn't need to be registered.
+type cloneSignal struct {
+  SignalHeader
+  // Extensions that aren't copied, since they're overridden in the clone
+  skip map[ExtType]bool
+  result chan cloneResult
+}
+func (signal cloneSignal) String() string {
+  return fmt.Sprintf("cloneSignal(%s)", signal.SignalHeader)
+}
+
+type cloneResult struct {
+  extensions map[ExtType]Extension
+  err error
+}
+
+// Copy every extension of the node that isn't skipped, must be called from the goroutine running the node
+func (node *Node) cloneExtensions(ctx *Context, skip map[ExtType]bool) (map[ExtType]Extension, error) {
+  clones := map[ExtType]Extension{}
+  for ext_type, ext := range(node.Extensions) {
+    if skip[ext_type] {
+      continue
+    }
+
+    clone, err := CloneExtension(ctx, ext)
+    if err != nil {
+      return nil, fmt.Errorf("Failed to clone %s on %s: %w", ext_type, node.ID, err)
+    }
+    clones[ext_type] = clone
+  }
+  return clones, nil
+}
+
+// Create a new node with a fresh key that has a copy of each extension on the node with id.
+// Extensions in overrides are used instead of copying the extension of the same type.
+// Any NodeID in the copied extensions that's a key in remap is replaced with its value,
+// and references to the original node are replaced with the clone's ID unless remap has an entry for it.
+func (ctx *Context) CloneNode(id NodeID, overrides []Extension, remap map[NodeID]NodeID) (*Node, error) {
+  err := ctx.checkMaintenance("create nodes")
+  if err != nil {
+    return nil, err
+  }
+
+  node, err := ctx.GetNode(id)
+  if err != nil {
+    return nil, err
+  }
+
+  public, key, err := ed25519.GenerateKey(rand.Reader)
+  if err != nil {
+    return nil, err
+  }
+  clone_id := KeyID(public)
+
+  ids := map[NodeID]NodeID{
+    id: clone_id,
+  }
+  for from, to := range(remap) {
+    ids[from] = to
+  }
+
+  ext_map := map[ExtType]Extension{}
+  for _, ext := range(overrides) {
+    if ext == nil {
+      return nil, fmt.Errorf("Cannot clone node with nil override")
+    }
+    ext_map[ExtTypeOf(reflect.TypeOf(ext))] = ext
+  }
+
+  request := &cloneSignal{
+    SignalHeader: NewSignalHeader(),
+    skip: map[ExtType]bool{},
+    result: make(chan cloneResult, 1),
+  }
+  for ext_type := range(ext_map) {
+    request.skip[ext_type] = true
+  }
+  if ctx.deliver(node, node, request) == false {
+    return nil, fmt.Errorf("Failed to clone %s, it's inbox is full", id)
+  }
+
+  var result cloneResult
+  select {
+  case result = <-request.result:
+  case <-time.After(CLONE_TIMEOUT):
+    return nil, fmt.Errorf("Timed out waiting for %s to copy it's extensions", id)
+  }
+  if result.err != nil {
+    return nil, result.err
+  }
+
+  for ext_type, clone := range(result.extensions) {
+    cloned, resets := clone.(ClonedExtension)
+    if resets {
+      cloned.Cloned(ctx, remap)
+    }
+    remapNodeIDs(reflect.ValueOf(clone).Elem(), ids)
+    ext_map[ext_type] = clone
+  }
+
+  extensions := make([]Extension, 0, len(ext_map))
+  for _, ext := range(ext_map) {
+    extensions = append(extensions, ext)
+  }
+
+  return ctx.newNode(key, node.Type, extensions...)
+}
+
+// Create a deep copy of the serialized fields of ext, the unserialized fields are left zero the same as when it's loaded from the DB
+func CloneExtension(ctx *Context, ext Extension) (Extension, error) {
+  ext_type := ExtTypeOf(reflect.TypeOf(ext))
+  ext_info, exists := ctx.Extensions[ext_type]
+  if exists == false {
+    return nil, fmt.Errorf("%s is not a known extension", reflect.TypeOf(ext))
+  }
+
+  ext_value := reflect.ValueOf(ext).Elem()
+  clone := reflect.New(ext_info.Type)
+  for tag, field_info := range(ext_info.Fields) {
+    field_value, set := structField(ext_value, field_info.Index)
+    if set == false {
+      continue
+    }
+    size, err := SerializedSize(ctx, field_value)
+    if err != nil {
+      return nil, fmt.Errorf("Failed to size %s: %w", tag, err)
+    }
+
+    data := make([]byte, size)
+    written, err := SerializeValue(ctx, field_value, data)
+    if err != nil {
+      return nil, fmt.Errorf("Failed to serialize %s: %w", tag, err)
+    }
+
+    value, _, err := DeserializeValue(ctx, data[:written], field_info.Type)
+    if err != nil {
+      return nil, fmt.Errorf("Failed to deserialize %s: %w", tag, err)
+    }
+    settableField(clone.Elem(), field_info.Index).Set(value)
+  }
+
+  return clone.Interface().(Extension), nil
+}
+
+// Replace every settable NodeID reachable from value that's a key in ids
+func remapNodeIDs(value reflect.Value, ids map[NodeID]NodeID) {
+  if value.Type() == reflect.TypeFor[NodeID]() {
+    mapped, exists := ids[value.Interface().(NodeID)]
+    if exists && value.CanSet() {
+      value.Set(reflect.ValueOf(mapped))
+    }
+    return
+  }
+
+  switch value.Kind() {
+  case reflect.Pointer:
+    if value.IsNil() == false {
+      remapNodeIDs(value.Elem(), ids)
+    }
+  case reflect.Interface:
+    if value.IsNil() == false && value.CanSet() {
+      elem := reflect.New(value.Elem().Type()).Elem()
+      elem.Set(value.Elem())
+      remapNodeIDs(elem, ids)
+      value.Set(elem)
+    }
+  case reflect.Struct:
+    for i := 0; i < value.NumField(); i++ {
+      if value.Type().Field(i).IsExported() {
+        remapNodeIDs(value.Field(i), ids)
+      }
+    }
+  case reflect.Slice, reflect.Array:
+    for i := 0; i < value.Len(); i++ {
+      remapNodeIDs(value.Index(i), ids)
+    }
+  case reflect.Map:
+    if value.IsNil() || value.CanSet() == false {
+      return
+    }
+    remapped := reflect.MakeMapWithSize(value.Type(), value.Len())
+    iter := value.MapRange()
+    for iter.Next() {
+      key := reflect.New(value.Type().Key()).Elem()
+      key.Set(iter.Key())
+      remapNodeIDs(key, ids)
+
+      val := reflect.New(value.Type().Elem()).Elem()
+      val.Set(iter.Value())
+      remapNodeIDs(val, ids)
+
+      remapped.SetMapIndex(key, val)
+    }
+    value.Set(remapped)
+  }
+}
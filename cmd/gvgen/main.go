@@ -0,0 +1,46 @@
+// gvgen writes StaticSerializer methods for graphvent structs, meant to be run from a go:generate directive:
+//
+//   //go:generate go run ./cmd/gvgen -type StatusSignal,ErrorSignal -output signal_gv.go
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  gv "github.com/mekkanized/graphvent"
+)
+
+func main() {
+  type_list := flag.String("type", "", "comma separated list of struct names to generate serializers for")
+  output := flag.String("output", "", "file to write the serializers to, defaults to <file>_gv.go for the file with the go:generate directive")
+  dir := flag.String("dir", ".", "directory of the package the structs are in")
+  flag.Parse()
+
+  if *type_list == "" {
+    fmt.Fprintln(os.Stderr, "gvgen: -type is required")
+    os.Exit(2)
+  }
+
+  if *output == "" {
+    gofile := os.Getenv("GOFILE")
+    if gofile == "" {
+      fmt.Fprintln(os.Stderr, "gvgen: -output is required outside of go generate")
+      os.Exit(2)
+    }
+    *output = strings.TrimSuffix(gofile, ".go") + "_gv.go"
+  }
+
+  source, err := gv.GenerateSerializers(*dir, strings.Split(*type_list, ","))
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "gvgen: %s\n", err)
+    os.Exit(1)
+  }
+
+  err = os.WriteFile(*output, source, 0644)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "gvgen: %s\n", err)
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,48 @@
+package graphvent
+
+import (
+  "encoding/binary"
+  "fmt"
+
+  "github.com/golang/snappy"
+)
+
+// Default for Context.CompressAbove
+const SERIALIZED_VALUE_COMPRESS_SIZE = 4096
+
+// Written in place of the type stack of a SerializedValue whose type stack and value are snappy compressed after it.
+// Type stacks start with a registered type or kind hash, so values written before compression was added never start with it.
+var CompressedValueType = Hash("GRAPHVENT_COMPRESSION", "snappy")
+
+// Compress the value if the context is configured to and it's large enough to be worth it
+func compressValue(ctx *Context, data []byte) []byte {
+  if ctx.CompressAbove <= 0 || len(data) <= ctx.CompressAbove {
+    return data
+  }
+
+  compressed := make([]byte, 8 + snappy.MaxEncodedLen(len(data)))
+  binary.BigEndian.PutUint64(compressed, uint64(CompressedValueType))
+  compressed = compressed[:8 + len(snappy.Encode(compressed[8:], data))]
+  if len(compressed) >= len(data) {
+    return data
+  }
+  return compressed
+}
+
+// Whether the value was compressed by compressValue
+func (value SerializedValue) Compressed() bool {
+  return len(value) >= 8 && SerializedType(binary.BigEndian.Uint64(value[0:8])) == CompressedValueType
+}
+
+// Get the uncompressed type stack and value
+func (value SerializedValue) decompress() (SerializedValue, error) {
+  if value.Compressed() == false {
+    return value, nil
+  }
+
+  data, err := snappy.Decode(nil, value[8:])
+  if err != nil {
+    return nil, fmt.Errorf("Failed to decompress SerializedValue: %w", err)
+  }
+  return SerializedValue(data), nil
+}
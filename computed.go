@@ -0,0 +1,51 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+)
+
+// A node field computed from an extension when it's read, so derived data like counts isn't stored with the extension
+type ComputedFieldInfo struct {
+  Type reflect.Type
+  // Node field name used by TagMappings, empty to only map it explicitly
+  GQLName string
+  Compute func(ctx *Context, node *Node, ext Extension) any
+}
+
+// Register a field of E computed by compute whenever it's read by a ReadSignal or GQL.
+// Computed fields are mapped to node fields like stored fields, so they have to be registered before the node types that use them.
+// They aren't included in StatusSignals since there's no change to them to notify.
+func RegisterComputedField[E any, T interface { *E; Extension }, V any](ctx *Context, tag Tag, gql_name string, compute func(ctx *Context, node *Node, ext T) V) error {
+  ext_type := ExtTypeFor[E, T]()
+  ext_info, registered := ctx.Extensions[ext_type]
+  if registered == false {
+    return fmt.Errorf("Cannot register computed field %s on unregistered extension %s", tag, reflect.TypeFor[E]())
+  }
+
+  _, stored := ext_info.Fields[tag]
+  _, computed := ext_info.Computed[tag]
+  if stored || computed {
+    return fmt.Errorf("Cannot register computed field %s on %s, field already exists", tag, reflect.TypeFor[E]())
+  }
+
+  ext_info.Computed[tag] = ComputedFieldInfo{
+    Type: reflect.TypeFor[V](),
+    GQLName: gql_name,
+    Compute: func(ctx *Context, node *Node, ext Extension) any {
+      return compute(ctx, node, ext.(T))
+    },
+  }
+  return nil
+}
+
+// Number of requirements the lockable currently holds locked
+func lockableLockedCount(ctx *Context, node *Node, ext *LockableExt) int {
+  count := 0
+  for _, state := range(ext.Requirements) {
+    if state == Locked {
+      count += 1
+    }
+  }
+  return count
+}
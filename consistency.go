@@ -0,0 +1,245 @@
+package graphvent
+
+import (
+  "fmt"
+  "slices"
+  "sync"
+  "time"
+)
+
+// A relation between two fields that should hold across nodes: when Field on a source node references a target,
+// the target's Inverse field references the source
+type ReferenceInvariant struct {
+  Name string
+  Extension ExtType
+  Field Tag
+  InverseExtension ExtType
+  Inverse Tag
+  // Self references are skipped when set
+  IgnoreSelf bool
+  // Returns the node to send a repair from and the message that repairs a violation, or nil if it has to be repaired manually
+  Repair func(source NodeID, target NodeID) (NodeID, *Message)
+}
+
+// Requirement links are bidirectional, and a lock's owner has it as a requirement
+var LockableInvariants = []ReferenceInvariant{
+  {
+    Name: "requirement_has_dependency",
+    Extension: lockableExtType,
+    Field: "requirements",
+    InverseExtension: lockableExtType,
+    Inverse: "dependencies",
+    Repair: func(source NodeID, target NodeID) (NodeID, *Message) {
+      return source, &Message{target, NewDependencySignal("add")}
+    },
+  },
+  {
+    Name: "dependency_has_requirement",
+    Extension: lockableExtType,
+    Field: "dependencies",
+    InverseExtension: lockableExtType,
+    Inverse: "requirements",
+    Repair: func(source NodeID, target NodeID) (NodeID, *Message) {
+      return target, &Message{source, NewDependencySignal("remove")}
+    },
+  },
+  {
+    Name: "owner_has_requirement",
+    Extension: lockableExtType,
+    Field: "owner",
+    InverseExtension: lockableExtType,
+    Inverse: "requirements",
+    IgnoreSelf: true,
+  },
+}
+
+// A violation of a ReferenceInvariant
+type Inconsistency struct {
+  Invariant string
+  Source NodeID
+  Target NodeID
+  // Message that repairs the inconsistency when sent from RepairSource, nil if it has to be repaired manually
+  RepairSource NodeID
+  Repair *Message
+}
+
+func (inconsistency Inconsistency) String() string {
+  return fmt.Sprintf("%s: %s -> %s", inconsistency.Invariant, inconsistency.Source, inconsistency.Target)
+}
+
+type inconsistencyKey struct {
+  Invariant string
+  Source NodeID
+  Target NodeID
+}
+
+// An inconsistency whose target isn't loaded, so the target's inverse field has to be checked in the DB
+type unindexedInconsistency struct {
+  Inconsistency
+  inverse Reference
+}
+
+// Check the invariants against the reference index of loaded nodes. Targets that aren't loaded are checked against
+// the references the DB has for them, and skipped if those can't be read.
+func (ctx *Context) CheckConsistency(invariants []ReferenceInvariant) []Inconsistency {
+  inconsistencies := []Inconsistency{}
+  unindexed := []unindexedInconsistency{}
+
+  ctx.referenceIndex.lock.RLock()
+  for _, invariant := range(invariants) {
+    for field, targets := range(ctx.referenceIndex.fields) {
+      if field.Extension != invariant.Extension || field.Field != invariant.Field {
+        continue
+      }
+
+      for _, target := range(targets) {
+        if invariant.IgnoreSelf && target == field.Source {
+          continue
+        }
+
+        inverse := Reference{target, invariant.InverseExtension, invariant.Inverse}
+        if ctx.referenceIndex.references[field.Source][inverse] {
+          continue
+        }
+
+        inconsistency := Inconsistency{
+          Invariant: invariant.Name,
+          Source: field.Source,
+          Target: target,
+        }
+        if invariant.Repair != nil {
+          inconsistency.RepairSource, inconsistency.Repair = invariant.Repair(field.Source, target)
+        }
+
+        if ctx.referenceIndex.sources[target] {
+          inconsistencies = append(inconsistencies, inconsistency)
+        } else {
+          unindexed = append(unindexed, unindexedInconsistency{inconsistency, inverse})
+        }
+      }
+    }
+  }
+  ctx.referenceIndex.lock.RUnlock()
+
+  stored := map[NodeID][]Reference{}
+  for _, candidate := range(unindexed) {
+    references, read := stored[candidate.Source]
+    if read == false {
+      var err error
+      references, err = ctx.DB.LoadReferences(ctx, candidate.Source)
+      if err != nil {
+        ctx.Log.Logf("consistency", "Skipping %s, failed to load references to %s: %s", candidate.Inconsistency, candidate.Source, err)
+        continue
+      }
+      stored[candidate.Source] = references
+    }
+
+    if slices.Contains(references, candidate.inverse) == false {
+      inconsistencies = append(inconsistencies, candidate.Inconsistency)
+    }
+  }
+  return inconsistencies
+}
+
+// A ConsistencyChecker periodically checks invariants between nodes and reports, and optionally repairs, any that don't hold.
+// Changes that are still in progress look like violations, so an inconsistency is only reported once two checks in a row have found it.
+type ConsistencyChecker struct {
+  ctx *Context
+  interval time.Duration
+  invariants []ReferenceInvariant
+  autoRepair bool
+  report func([]Inconsistency)
+
+  suspect map[inconsistencyKey]bool
+  stop chan struct{}
+  done sync.WaitGroup
+}
+
+// Create a new ConsistencyChecker and start checking every interval.
+// report is called with the inconsistencies found by each check if it's not nil,
+// and the repair messages are sent when auto_repair is set.
+func NewConsistencyChecker(ctx *Context, interval time.Duration, auto_repair bool, report func([]Inconsistency), invariants ...ReferenceInvariant) (*ConsistencyChecker, error) {
+  if interval <= 0 {
+    return nil, fmt.Errorf("ConsistencyChecker interval must be positive, got %s", interval)
+  }
+
+  checker := &ConsistencyChecker{
+    ctx: ctx,
+    interval: interval,
+    invariants: invariants,
+    autoRepair: auto_repair,
+    report: report,
+    suspect: map[inconsistencyKey]bool{},
+    stop: make(chan struct{}),
+  }
+
+  checker.done.Add(1)
+  go checker.run()
+
+  return checker, nil
+}
+
+// Stop checking and wait for the current check to finish
+func (checker *ConsistencyChecker) Stop() {
+  close(checker.stop)
+  checker.done.Wait()
+}
+
+func (checker *ConsistencyChecker) run() {
+  defer checker.done.Done()
+
+  ticker := time.NewTicker(checker.interval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-checker.stop:
+      return
+    case <-ticker.C:
+      checker.check()
+    }
+  }
+}
+
+func (checker *ConsistencyChecker) check() {
+  ctx := checker.ctx
+
+  found := map[inconsistencyKey]bool{}
+  confirmed := []Inconsistency{}
+  for _, inconsistency := range(ctx.CheckConsistency(checker.invariants)) {
+    key := inconsistencyKey{inconsistency.Invariant, inconsistency.Source, inconsistency.Target}
+    found[key] = true
+    if checker.suspect[key] {
+      confirmed = append(confirmed, inconsistency)
+    }
+  }
+  checker.suspect = found
+
+  if len(confirmed) == 0 {
+    return
+  }
+
+  ctx.Log.Logf("consistency", "Found %d inconsistencies: %+v", len(confirmed), confirmed)
+  if checker.report != nil {
+    checker.report(confirmed)
+  }
+
+  if checker.autoRepair {
+    for _, inconsistency := range(confirmed) {
+      if inconsistency.Repair == nil {
+        continue
+      }
+
+      node, err := ctx.GetNode(inconsistency.RepairSource)
+      if err == nil {
+        err = ctx.Send(node, []Message{*inconsistency.Repair})
+      }
+      if err != nil {
+        ctx.Log.Logf("consistency", "Failed to repair %s: %s", inconsistency, err)
+      } else {
+        // Wait for the repair to be confirmed by two more checks before trying again
+        delete(checker.suspect, inconsistencyKey{inconsistency.Invariant, inconsistency.Source, inconsistency.Target})
+      }
+    }
+  }
+}
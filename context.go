@@ -1,6 +1,7 @@
 package graphvent
 
 import (
+	"crypto/cipher"
 	"crypto/ecdh"
 	"crypto/ed25519"
 	"encoding"
@@ -23,6 +24,11 @@ import (
 
 var (
   NodeNotFoundError = errors.New("Node not found in DB")
+  QuotaExceededError = errors.New("Quota exceeded")
+  // The node record doesn't match its checksum, as opposed to failing to deserialize after the types changed
+  CorruptNodeError = errors.New("Node record is corrupt")
+  // The node is loaded, so it's in-memory copy would overwrite anything written to the DB directly
+  NodeLoadedError = errors.New("Node is loaded")
   ECDH = ecdh.X25519()
 )
 
@@ -34,6 +40,8 @@ type NodeFieldInfo struct {
   Extension ExtType
   Index []int
   Type graphql.Type
+  // Set for computed fields, which have no Index
+  Compute func(*Context, *Node, Extension) any
 }
 
 type StructFieldInfo struct {
@@ -58,13 +66,32 @@ type ExtensionFieldInfo struct {
   Index []int
   Type reflect.Type
   NodeTag string
+  // Hash of the gv tag, computed at registration so the DB doesn't rehash it on every access
+  FieldTag FieldTag
+  // Node field name from the gql tag, empty if the field isn't tagged and "-" if it's hidden from GQL
+  GQLName string
+  // Whether the field's type can hold NodeIDs, so writes to it update the reference index
+  HoldsNodeIDs bool
 }
 
 type ExtensionInfo struct {
   ExtType
   Type reflect.Type
   Fields map[Tag]ExtensionFieldInfo
+  // Fields registered with RegisterComputedField
+  Computed map[Tag]ComputedFieldInfo
   Data interface{}
+
+  // Whether Process can run concurrently with the other extensions on a node
+  Parallel bool
+  // Extensions that must finish processing a signal before this one
+  ProcessAfter []ExtType
+  // Extensions that must be on the same node, loaded before this one
+  Requires []ExtType
+  // Whether GetOrAttachExt can attach the extension to nodes that don't have it
+  AutoAttach bool
+  // Version written with the extension's fields, 0 if it isn't a VersionedExtension
+  Version uint64
 }
 
 type NodeInfo struct {
@@ -73,6 +100,18 @@ type NodeInfo struct {
   RequiredExtensions []ExtType
   Fields map[string]NodeFieldInfo
   ReverseFields map[ExtType]map[Tag]string
+  Inbox InboxConfig
+  // Whether StatusSignals from nodes of this type include the old and new values of changed fields
+  StatusDiffs bool
+  // How long to collect changes into a single StatusSignal, 0 to send one for every signal that changes the node
+  StatusCoalesce time.Duration
+  // Number of NodeVersions to keep in the DB for each node of this type
+  History int
+  Quota Quota
+  // Whether new nodes of this type are added to the auto-load set
+  AutoLoad bool
+  // Whether nodes of this type write their changes and outgoing messages to the DB before sending them
+  Outbox bool
 }
 
 type InterfaceInfo struct {
@@ -107,9 +146,46 @@ type Context struct {
   // Map between database node type hashes and the registered info
   NodeTypes map[NodeType]NodeInfo
 
-  nodesLock sync.Mutex
+  // Runs nodes on a worker pool when set, otherwise each node gets its own goroutine
+  Scheduler *Scheduler
+
+  // Stores node private keys when set, so node records only have the public key
+  Keyring Keyring
+
+  // Receives the processing time of each signal when set
+  Metrics MetricsSink
+
+  // Checks signals sent into the context over GQL when set
+  Firewall *Firewall
+
+  // Reports nodes with stuck inboxes to the /health endpoint when set
+  Watchdog *Watchdog
+
+  // Approximate memory usage of loaded nodes is tracked when TrackMemory is set,
+  // and if MemoryLimit is positive the least recently active nodes are unloaded to the DB to stay under it
+  TrackMemory bool
+  MemoryLimit int
+  evictLock sync.Mutex
+
+  // SerializedValues larger than this many bytes, like diffs of big requirement maps, are compressed. 0 to never compress.
+  CompressAbove int
+
+  // Internal notifications like node loads and DB writes, see SubscribeEvents
+  events *eventBus
+
+  // Policies that create nodes the first time they're sent to, see AddAutoCreate
+  autoCreate []AutoCreatePolicy
+  // Encrypts node data in the DB when set with SetMasterKey
+  masterKey cipher.AEAD
+
+  nodesLock sync.RWMutex
   nodes map[NodeID]ContextNode
 
+  referenceIndex *referenceIndex
+
+  maintenance atomic.Bool
+  maintenanceAllowed map[reflect.Type]bool
+
   running atomic.Bool
 }
 
@@ -301,9 +377,9 @@ func BuildSchema(ctx *Context, query, mutation *graphql.Object) (graphql.Schema,
           return nil, err
         }
 
-        c, err := ctx.Ext.AddSubscription(ctx.ID, ctx)
-        if err != nil {
-          return nil, err
+        c := ctx.Subscription
+        if c == nil {
+          return nil, fmt.Errorf("Subscriptions are only supported over a websocket")
         }
 
         c <- nil
@@ -313,12 +389,25 @@ func BuildSchema(ctx *Context, query, mutation *graphql.Object) (graphql.Schema,
     })
   }
 
-  return graphql.NewSchema(graphql.SchemaConfig{
+  schema, err := graphql.NewSchema(graphql.SchemaConfig{
     Types: types,
     Query: query,
     Subscription: subscription,
     Mutation: mutation,
   })
+  if err != nil {
+    return schema, err
+  }
+
+  // Enums build their value lookups the first time they're used, which races when subscriptions resolve concurrently
+  for _, schema_type := range(schema.TypeMap()) {
+    enum, is_enum := schema_type.(*graphql.Enum)
+    if is_enum && len(enum.Values()) > 0 {
+      enum.Serialize(enum.Values()[0].Value)
+      enum.ParseValue(enum.Values()[0].Name)
+    }
+  }
+  return schema, nil
 }
 
 func RegisterExtension[E any, T interface { *E; Extension}](ctx *Context, data interface{}) error {
@@ -331,23 +420,66 @@ func RegisterExtension[E any, T interface { *E; Extension}](ctx *Context, data i
 
   fields := map[Tag]ExtensionFieldInfo{}
 
-  for _, field := range(reflect.VisibleFields(reflect_type)) {
-    gv_tag, tagged_gv := field.Tag.Lookup("gv")
-    node_tag := field.Tag.Get("node")
-    if tagged_gv {
-      fields[Tag(gv_tag)] = ExtensionFieldInfo{
-        Index: field.Index,
-        Type: field.Type,
-        NodeTag: node_tag,
-      }
+  gv_fields, err := gvFields(reflect_type)
+  if err != nil {
+    return fmt.Errorf("Cannot register extension %+v: %w", reflect_type, err)
+  }
+  for _, field := range(gv_fields) {
+    gv_tag := field.Tag.Get("gv")
+    fields[Tag(gv_tag)] = ExtensionFieldInfo{
+      Index: field.Index,
+      Type: field.Type,
+      NodeTag: field.Tag.Get("node"),
+      FieldTag: GetFieldTag(gv_tag),
+      GQLName: field.Tag.Get("gql"),
+      HoldsNodeIDs: holdsNodeIDs(field.Type),
     }
   }
 
+  var zero T = new(E)
+
+  parallel := false
+  parallel_ext, ok := any(zero).(ParallelExtension)
+  if ok {
+    parallel = parallel_ext.Parallel()
+  }
+
+  var process_after []ExtType = nil
+  ordered_ext, ok := any(zero).(OrderedExtension)
+  if ok {
+    process_after = ordered_ext.ProcessAfter()
+  }
+
+  if parallel && len(process_after) != 0 {
+    return fmt.Errorf("Cannot register extension %+v, parallel extensions cannot declare a process order", reflect_type)
+  }
+
+  var requires []ExtType = nil
+  dependent_ext, ok := any(zero).(DependentExtension)
+  if ok {
+    requires = dependent_ext.Requires()
+  }
+
+  _, auto_attach := any(zero).(AutoAttachExtension)
+
+  var version uint64 = 0
+  versioned_ext, ok := any(zero).(VersionedExtension)
+  if ok {
+    version = versioned_ext.Version()
+  }
+
   ctx.Extensions[ext_type] = ExtensionInfo{
     ExtType: ext_type,
     Type: reflect_type,
     Data: data,
     Fields: fields,
+    Computed: map[Tag]ComputedFieldInfo{},
+
+    Parallel: parallel,
+    ProcessAfter: process_after,
+    Requires: requires,
+    AutoAttach: auto_attach,
+    Version: version,
   }
 
   return nil
@@ -358,6 +490,63 @@ type FieldMapping struct {
   Tag Tag
 }
 
+// Get the node field mappings for every field of the extensions that has a gql tag, named by the tag.
+// Fields tagged gql:"-" are hidden and can't be mapped to a node field.
+func TagMappings(ctx *Context, extensions ...ExtType) (map[string]FieldMapping, error) {
+  mappings := map[string]FieldMapping{}
+  for _, ext_type := range(extensions) {
+    ext_info, exists := ctx.Extensions[ext_type]
+    if exists == false {
+      return nil, fmt.Errorf("Cannot get mappings for unknown extension %s", ext_type)
+    }
+
+    for tag, field_info := range(ext_info.Fields) {
+      if field_info.GQLName == "" || field_info.GQLName == "-" {
+        continue
+      }
+
+      _, duplicate := mappings[field_info.GQLName]
+      if duplicate {
+        return nil, fmt.Errorf("Multiple extension fields tagged gql:\"%s\"", field_info.GQLName)
+      }
+
+      mappings[field_info.GQLName] = FieldMapping{
+        Extension: ext_type,
+        Tag: tag,
+      }
+    }
+
+    for tag, computed_info := range(ext_info.Computed) {
+      if computed_info.GQLName == "" {
+        continue
+      }
+
+      _, duplicate := mappings[computed_info.GQLName]
+      if duplicate {
+        return nil, fmt.Errorf("Multiple extension fields named %s", computed_info.GQLName)
+      }
+
+      mappings[computed_info.GQLName] = FieldMapping{
+        Extension: ext_type,
+        Tag: tag,
+      }
+    }
+  }
+  return mappings, nil
+}
+
+// Get the GQL name of a struct field from its gql tag, defaulting to the gv tag.
+// Returns false if the field is hidden with gql:"-"
+func gqlFieldName(field reflect.StructField, gv_tag string) (string, bool) {
+  gql_tag := field.Tag.Get("gql")
+  if gql_tag == "-" {
+    return "", false
+  } else if gql_tag == "" {
+    return gv_tag, true
+  }
+  return gql_tag, true
+}
+
 func RegisterNodeInterface(ctx *Context, name string, fields map[string]graphql.Type) error {
   _, exists := ctx.Interfaces[name]
   if exists {
@@ -418,6 +607,11 @@ func RegisterNodeInterface(ctx *Context, name string, fields map[string]graphql.
 }
 
 func RegisterNodeType(ctx *Context, name string, mappings map[string]FieldMapping) error {
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("register node type %s", name))
+  if err != nil {
+    return err
+  }
+
   node_type := NodeTypeFor(name)
   _, exists := ctx.NodeTypes[node_type]
   if exists {
@@ -453,13 +647,44 @@ func RegisterNodeType(ctx *Context, name string, mappings map[string]FieldMappin
     if exists == false {
       reverse_fields[mapping.Extension] = map[Tag]string{}
     }
-    reverse_fields[mapping.Extension][mapping.Tag] = field_name
 
+    computed_info, computed := ext_info.Computed[mapping.Tag]
+    if computed {
+      gql_type, err := ctx.GQLType(computed_info.Type, "")
+      if err != nil {
+        return fmt.Errorf("Cannot register node type %s, GQLType error: %w", name, err)
+      }
+      gql_resolve := ctx.GQLResolve(computed_info.Type, "")
+
+      fields[field_name] = NodeFieldInfo{
+        Extension: mapping.Extension,
+        Type: gql_type,
+        Compute: computed_info.Compute,
+      }
+
+      gql_fields[field_name] = &graphql.Field{
+        Type: gql_type,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          node, ok := p.Source.(NodeResult)
+          if ok == false {
+            return nil, fmt.Errorf("Can't resolve Node field on non-Node %s", reflect.TypeOf(p.Source))
+          }
+
+          return gql_resolve(node.Data[field_name], p)
+        },
+      }
+      continue
+    }
+
+    reverse_fields[mapping.Extension][mapping.Tag] = field_name
 
     ext_field, exists := ext_info.Fields[mapping.Tag]
     if exists == false {
       return fmt.Errorf("Cannot register node type %s, extension %s has no field %s", name, mapping.Extension, mapping.Tag)
     }
+    if ext_field.GQLName == "-" {
+      return fmt.Errorf("Cannot register node type %s, field %s of extension %s is hidden from GQL", name, mapping.Tag, mapping.Extension)
+    }
 
     gql_type, err := ctx.GQLType(ext_field.Type, ext_field.NodeTag)
     if err != nil {
@@ -511,8 +736,85 @@ func RegisterNodeType(ctx *Context, name string, mappings map[string]FieldMappin
     Fields: fields,
     ReverseFields: reverse_fields,
     RequiredExtensions: extensions,
+    Inbox: DefaultInboxConfig,
+  }
+
+  return nil
+}
+
+// Node goroutines read ctx.NodeTypes without a lock, so node types can only be registered or changed while no nodes are loaded
+func (ctx *Context) checkNoNodesLoaded(what string) error {
+  ctx.nodesLock.RLock()
+  defer ctx.nodesLock.RUnlock()
+
+  if len(ctx.nodes) != 0 {
+    return fmt.Errorf("Cannot %s with %d nodes loaded", what, len(ctx.nodes))
+  }
+  return nil
+}
+
+// Set whether StatusSignals from nodes of a registered type include FieldDiffs.
+// Enabling it serializes every mapped field of a node before it processes each signal.
+func SetStatusDiffs(ctx *Context, name string, enabled bool) error {
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set status diffs for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set status diffs for unregistered node type %s", name)
+  }
+
+  node_info.StatusDiffs = enabled
+  ctx.NodeTypes[node_type] = node_info
+  return nil
+}
+
+// Set how long nodes of a registered type wait to send a StatusSignal after they change,
+// so that changes from a burst of signals are sent in one StatusSignal
+func SetStatusCoalesce(ctx *Context, name string, window time.Duration) error {
+  if window < 0 {
+    return fmt.Errorf("Cannot coalesce status for %s over negative window %s", name, window)
+  }
+
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set status coalescing for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set status coalescing for unregistered node type %s", name)
   }
 
+  node_info.StatusCoalesce = window
+  ctx.NodeTypes[node_type] = node_info
+  return nil
+}
+
+// Set the inbox config for nodes of a registered type
+func SetInboxConfig(ctx *Context, name string, config InboxConfig) error {
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set inbox config for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set inbox config for unregistered node type %s", name)
+  }
+
+  err = config.Validate()
+  if err != nil {
+    return fmt.Errorf("Cannot set inbox config for node type %s: %w", name, err)
+  }
+
+  node_info.Inbox = config
+  ctx.NodeTypes[node_type] = node_info
   return nil
 }
 
@@ -570,50 +872,62 @@ func RegisterObject[T any](ctx *Context) error {
     post_deserialize_index = post_deserialize.Index
   }
   
-  for _, field := range(reflect.VisibleFields(reflect_type)) {
-    gv_tag, tagged_gv := field.Tag.Lookup("gv")
-    if tagged_gv {
-      node_tag := field.Tag.Get("node")
-      field_infos[GetFieldTag(gv_tag)] = StructFieldInfo{
-        Type: field.Type,
-        Index: field.Index,
-      }
+  gv_fields, err := gvFields(reflect_type)
+  if err != nil {
+    return err
+  }
+  for _, field := range(gv_fields) {
+    gv_tag := field.Tag.Get("gv")
+    node_tag := field.Tag.Get("node")
+    field_infos[GetFieldTag(gv_tag)] = StructFieldInfo{
+      Type: field.Type,
+      Index: field.Index,
+    }
 
-      gql_type, err := ctx.GQLType(field.Type, node_tag)
-      if err != nil {
-        return err
-      }
+    gql_field_name, exposed := gqlFieldName(field, gv_tag)
+    if exposed == false {
+      continue
+    }
 
-      gql_resolve := ctx.GQLResolve(field.Type, node_tag)
-      gql.AddFieldConfig(gv_tag, &graphql.Field{
-        Type: gql_type,
-        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-          val, ok := p.Source.(T)
-          if ok == false {
-            return nil, fmt.Errorf("%s is not %s", reflect.TypeOf(p.Source), reflect_type)
-          }
+    gql_type, err := ctx.GQLType(field.Type, node_tag)
+    if err != nil {
+      return err
+    }
 
-          value, err := reflect.ValueOf(val).FieldByIndexErr(field.Index)
-          if err != nil {
-            return nil, err
-          }
+    gql_resolve := ctx.GQLResolve(field.Type, node_tag)
+    gql.AddFieldConfig(gql_field_name, &graphql.Field{
+      Type: gql_type,
+      Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+        val, ok := p.Source.(T)
+        if ok == false {
+          return nil, fmt.Errorf("%s is not %s", reflect.TypeOf(p.Source), reflect_type)
+        }
 
-          if gql_resolve == nil {
-            return value.Interface(), nil
-          } else {
-            return gql_resolve(value.Interface(), p)
-          }
-        },
-      })
-    }
+        value, set := structField(reflect.ValueOf(val), field.Index)
+        if set == false {
+          return nil, nil
+        }
+
+        if gql_resolve == nil {
+          return value.Interface(), nil
+        } else {
+          return gql_resolve(value.Interface(), p)
+        }
+      },
+    })
   }
 
+  serialize, size, deserialize := staticSerializeFns(reflect_type, post_deserialize_index)
   ctx.Types[reflect_type] = &TypeInfo{
     PostDeserializeIndex: post_deserialize_index,
     Serialized: serialized_type,
     Reflect: reflect_type,
     Fields: field_infos,
     Type: gql,
+
+    Serialize: serialize,
+    SerializedSize: size,
+    Deserialize: deserialize,
   }
   ctx.TypesReverse[serialized_type] = ctx.Types[reflect_type]
 
@@ -637,22 +951,28 @@ func RegisterObjectNoGQL[T any](ctx *Context) error {
     post_deserialize_index = post_deserialize.Index
   }
   
-  for _, field := range(reflect.VisibleFields(reflect_type)) {
-    gv_tag, tagged_gv := field.Tag.Lookup("gv")
-    if tagged_gv {
-      field_infos[GetFieldTag(gv_tag)] = StructFieldInfo{
-        Type: field.Type,
-        Index: field.Index,
-      }
+  gv_fields, err := gvFields(reflect_type)
+  if err != nil {
+    return err
+  }
+  for _, field := range(gv_fields) {
+    field_infos[GetFieldTag(field.Tag.Get("gv"))] = StructFieldInfo{
+      Type: field.Type,
+      Index: field.Index,
     }
   }
 
+  serialize, size, deserialize := staticSerializeFns(reflect_type, post_deserialize_index)
   ctx.Types[reflect_type] = &TypeInfo{
     PostDeserializeIndex: post_deserialize_index,
     Serialized: serialized_type,
     Reflect: reflect_type,
     Fields: field_infos,
     Type: nil,
+
+    Serialize: serialize,
+    SerializedSize: size,
+    Deserialize: deserialize,
   }
   ctx.TypesReverse[serialized_type] = ctx.Types[reflect_type]
 
@@ -705,10 +1025,43 @@ func unstringifyAST[T any, E interface { *T; encoding.TextUnmarshaler}](value as
   return *tmp
 }
 
+// Durations are strings like "1m30s" in GQL
+func stringifyDuration(value interface{}) interface{} {
+  duration, ok := value.(time.Duration)
+  if ok == false {
+    return nil
+  }
+  return duration.String()
+}
+
+func parseDuration(value interface{}) interface{} {
+  str, ok := value.(string)
+  if ok == false {
+    return nil
+  }
+
+  duration, err := time.ParseDuration(str)
+  if err != nil {
+    return nil
+  }
+  return duration
+}
+
+func astDuration(value ast.Value) interface{} {
+  str, ok := value.(*ast.StringValue)
+  if ok == false {
+    return nil
+  }
+  return parseDuration(str.Value)
+}
+
 func coerce[T any](value interface{}) interface{} {
   t := reflect.TypeFor[T]()
-  if reflect.TypeOf(value).ConvertibleTo(t) {
-    return value.(T)
+  if value == nil {
+    return nil
+  } else if reflect.TypeOf(value).ConvertibleTo(t) {
+    // JSON variables are decoded as float64, so numbers are converted to the kind of T
+    return reflect.ValueOf(value).Convert(t).Interface()
   } else {
     return nil
   }
@@ -748,6 +1101,64 @@ func astBool[T ~bool](value ast.Value) interface{} {
   }
 }
 
+func astFloat[T constraints.Float](value ast.Value) interface{} {
+  switch value := value.(type) {
+  case *ast.FloatValue:
+    f, err := strconv.ParseFloat(value.Value, 64)
+    if err != nil {
+      return nil
+    }
+    return T(f)
+  case *ast.IntValue:
+    i, err := strconv.Atoi(value.Value)
+    if err != nil {
+      return nil
+    }
+    return T(i)
+  case *ast.StringValue:
+    f, err := strconv.ParseFloat(value.Value, 64)
+    if err != nil {
+      return nil
+    }
+    return T(f)
+  default:
+    return nil
+  }
+}
+
+// Complex numbers are strings in GQL, like "(1+2i)"
+func stringifyComplex(value interface{}) interface{} {
+  switch value := value.(type) {
+  case complex64:
+    return strconv.FormatComplex(complex128(value), 'g', -1, 64)
+  case complex128:
+    return strconv.FormatComplex(value, 'g', -1, 128)
+  default:
+    return nil
+  }
+}
+
+func parseComplex[T constraints.Complex](value interface{}) interface{} {
+  str, ok := value.(string)
+  if ok == false {
+    return nil
+  }
+
+  c, err := strconv.ParseComplex(str, reflect.TypeFor[T]().Bits())
+  if err != nil {
+    return nil
+  }
+  return T(c)
+}
+
+func astComplex[T constraints.Complex](value ast.Value) interface{} {
+  str, ok := value.(*ast.StringValue)
+  if ok == false {
+    return nil
+  }
+  return parseComplex[T](str.Value)
+}
+
 func astInt[T constraints.Integer](value ast.Value) interface{} {
   switch value := value.(type) {
   case *ast.BooleanValue:
@@ -829,6 +1240,7 @@ func RegisterScalar[S any](ctx *Context, to_json func(interface{})interface{}, f
     Serialized: serialized_type,
     Reflect: reflect_type,
     Type: gql,
+    PostDeserializeIndex: -1,
 
     Serialize: serialize,
     SerializedSize: sizefn,
@@ -840,17 +1252,35 @@ func RegisterScalar[S any](ctx *Context, to_json func(interface{})interface{}, f
 }
 
 func (ctx *Context) NewNode(key ed25519.PrivateKey, type_name string, extensions ...Extension) (*Node, error) {
+  return ctx.newNode(key, NodeTypeFor(type_name), extensions...)
+}
+
+func (ctx *Context) newNode(key ed25519.PrivateKey, node_type NodeType, extensions ...Extension) (*Node, error) {
+  err := ctx.checkMaintenance("create nodes")
+  if err != nil {
+    return nil, err
+  }
+
   ctx.nodesLock.Lock()
-  defer ctx.nodesLock.Unlock()
+  node, err := ctx.createNode(key, node_type, extensions...)
+  ctx.nodesLock.Unlock()
+  if err != nil {
+    return nil, err
+  }
+
+  ctx.evictColdNodes(node.ID)
+  return node, nil
+}
 
-  node_type := NodeTypeFor(type_name)
+// Must be called with ctx.nodesLock held.
+func (ctx *Context) createNode(key ed25519.PrivateKey, node_type NodeType, extensions ...Extension) (*Node, error) {
   node_info, known_type := ctx.NodeTypes[node_type]
   if known_type == false {
-    return nil, fmt.Errorf("%s is not a known node type", type_name)
+    return nil, fmt.Errorf("%s is not a known node type", node_type)
   }
 
-  var err error
   var public ed25519.PublicKey
+  var err error
   if key == nil {
     public, key, err = ed25519.GenerateKey(rand.Reader)
     if err != nil {
@@ -891,22 +1321,57 @@ func (ctx *Context) NewNode(key ed25519.PrivateKey, type_name string, extensions
     }
   }
 
-  node := &Node{
-    Key: key,
-    ID: id,
-    Type: node_type,
-    Extensions: ext_map,
-    SignalQueue: []QueuedSignal{},
-    writeSignalQueue: false,
+  _, err = LoadOrder(ctx, ext_map)
+  if err != nil {
+    return nil, err
   }
 
-  node.SendChan, node.RecvChan = NewMessageQueue(NODE_INITIAL_QUEUE_SIZE)
-
-  err = ctx.DB.WriteNodeInit(ctx, node)
+  err = node_info.Quota.checkNode(node_type, ext_map)
   if err != nil {
     return nil, err
   }
 
+  err = ctx.DB.CountNode(ctx, node_type, node_info.Quota.Nodes)
+  if err != nil {
+    return nil, err
+  }
+
+  node := &Node{
+    Key: key,
+    Public: public,
+    ID: id,
+    Type: node_type,
+    Extensions: ext_map,
+    SignalQueue: []QueuedSignal{},
+    writeSignalQueue: false,
+  }
+
+  if ctx.Scheduler == nil {
+    node.SendChan, node.RecvChan = NewInbox(node_info.Inbox)
+  }
+
+  err = ctx.writeNodeInit(node)
+  if err != nil {
+    // The node was never written, so it shouldn't count against the quota
+    uncount_err := ctx.DB.UncountNode(ctx, node_type)
+    if uncount_err != nil {
+      ctx.Log.Logf("db", "Failed to uncount %s node %s: %s", node_type, id, uncount_err)
+    }
+    return nil, err
+  }
+
+  err = node.writeVersion(ctx, nil, VersionTrigger{})
+  if err != nil {
+    return nil, err
+  }
+
+  if node_info.AutoLoad {
+    err = ctx.DB.WriteAutoLoad(ctx, id, true)
+    if err != nil {
+      return nil, err
+    }
+  }
+
   err = ctx.addNode(id, node)
   if err != nil {
     return nil, err
@@ -915,46 +1380,167 @@ func (ctx *Context) NewNode(key ed25519.PrivateKey, type_name string, extensions
   return node, nil
 }
 
+// Run nodes on a pool of workers instead of a goroutine per node, must be called before any nodes are loaded
+func (ctx *Context) UseScheduler(workers int, batch_size int) error {
+  ctx.nodesLock.Lock()
+  defer ctx.nodesLock.Unlock()
+
+  if len(ctx.nodes) != 0 {
+    return fmt.Errorf("Cannot start scheduler with %d nodes already loaded", len(ctx.nodes))
+  } else if ctx.Scheduler != nil {
+    return fmt.Errorf("Context already has a scheduler")
+  }
+
+  scheduler, err := NewScheduler(ctx, workers, batch_size)
+  if err != nil {
+    return err
+  }
+
+  ctx.Scheduler = scheduler
+  return nil
+}
+
+// Must be called with ctx.nodesLock held.
 func (ctx *Context) addNode(id NodeID, node *Node) error {
+  ctx.indexNode(node)
+
+  context_node, err := ctx.startNode(node)
+  if err != nil {
+    return err
+  }
+
+  ctx.nodes[id] = context_node
+  ctx.publishNodeEvent(EventNodeLoaded, node)
+  ctx.resendOutbox(node)
+  return nil
+}
+
+// Start running a node on the scheduler if the context has one, or on it's own goroutine
+func (ctx *Context) startNode(node *Node) (ContextNode, error) {
+  if ctx.Scheduler != nil {
+    err := ctx.Scheduler.Add(node)
+    if err != nil {
+      return ContextNode{}, err
+    }
+    return ContextNode{
+      Node: node,
+    }, nil
+  }
+
   status := make(chan string, 0)
   command := make(chan string, 0)
   go runNode(ctx, node, status, command)
 
   returned := <- status
   if returned != "active" {
-    return fmt.Errorf(returned)
+    return ContextNode{}, fmt.Errorf(returned)
   }
 
-  ctx.nodes[id] = ContextNode{
+  return ContextNode{
     Node: node,
     Status: status,
     Command: command,
+  }, nil
+}
+
+// Wait for a running node to finish processing and stop it
+func (ctx *Context) stopNode(node ContextNode) error {
+  if node.Node.inbox != nil {
+    ctx.Scheduler.Remove(node.Node)
+    return nil
+  }
+
+  node.Command <- "stop"
+  returned := <- node.Status
+  if returned != "stopped" {
+    return fmt.Errorf("Node returned %s when commanded to stop", returned)
   }
   return nil
 }
 
-func (ctx *Context) Stop() error {
-  ctx.nodesLock.Lock()
-  defer ctx.nodesLock.Unlock()
+// Stop a loaded node and write it's current state to the DB so it can be loaded again later.
+// If the write fails the node is started again with it's changes still unwritten, so they're written by the next flush or unload.
+// Must be called with ctx.nodesLock held.
+func (ctx *Context) unloadNode(id NodeID) error {
+  context_node, loaded := ctx.nodes[id]
+  if loaded == false {
+    return fmt.Errorf("%s is not loaded", id)
+  } else if ctx.persistent() == false {
+    return fmt.Errorf("Cannot unload %s: %w", id, NoPersistenceError)
+  }
 
-  for _, node := range(ctx.nodes) {
-    node.Command <- "stop"
-    returned := <- node.Status
+  // The node has to be stopped first so it's changes don't change while they're written
+  err := ctx.stopNode(context_node)
+  if err != nil {
+    return err
+  }
+  return ctx.writeStoppedNode(context_node)
+}
+
+// Write a stopped node's changes and remove it from the context, or start it again if the write fails.
+// Must be called with ctx.nodesLock held.
+func (ctx *Context) writeStoppedNode(context_node ContextNode) error {
+  node := context_node.Node
+  id := node.ID
 
-    if returned != "stopped" {
-      return fmt.Errorf("Node returned %s when commanded to stop", returned)
+  // Every field was written when the node was created, so only the ones that changed since need to be rewritten
+  err := ctx.DB.WriteNodeChanges(ctx, node, node.unwritten)
+  if err != nil {
+    restarted, start_err := ctx.startNode(node)
+    if start_err != nil {
+      delete(ctx.nodes, id)
+      ctx.unindexNode(node)
+      ctx.publishNodeEvent(EventNodeUnloaded, node)
+      return fmt.Errorf("Failed to write %s(%s), and failed to restart it: %w", id, err, start_err)
     }
+    ctx.nodes[id] = restarted
+    return fmt.Errorf("Failed to write %s, keeping it loaded: %w", id, err)
   }
-  ctx.nodes = map[NodeID]ContextNode{}
+  node.unwritten = nil
+
+  delete(ctx.nodes, id)
+  ctx.unindexNode(node)
+  ctx.publishNodeEvent(EventNodeUnloaded, node)
+  ctx.publishNodeEvent(EventWriteFlushed, node)
   return nil
 }
 
-func (ctx *Context) GetNode(id NodeID) (*Node, error) {
+// Stop every loaded node, then the scheduler if there is one.
+// The nodes lock isn't held while waiting for nodes to stop, since a node may be waiting on it to send a signal.
+func (ctx *Context) Stop() error {
+  // Evictions stop nodes outside the nodes lock, so wait for any running one to finish before taking the nodes
+  ctx.evictLock.Lock()
+  defer ctx.evictLock.Unlock()
+
   ctx.nodesLock.Lock()
-  defer ctx.nodesLock.Unlock()
-  return ctx.getNode(id)
+  nodes := ctx.nodes
+  ctx.nodes = map[NodeID]ContextNode{}
+  ctx.nodesLock.Unlock()
+
+  var stop_err error
+  for _, node := range(nodes) {
+    err := ctx.stopNode(node)
+    if err != nil && stop_err == nil {
+      stop_err = err
+    }
+  }
+
+  if ctx.Scheduler != nil {
+    ctx.Scheduler.Stop()
+  }
+  return stop_err
 }
 
+func (ctx *Context) GetNode(id NodeID) (*Node, error) {
+  node, err := ctx.lookupNode(id)
+  if err == nil {
+    ctx.evictColdNodes(id)
+  }
+  return node, err
+}
+
+// Get a node, loading it from the DB if it isn't loaded.
+// Must be called with ctx.nodesLock held.
 func (ctx *Context) getNode(id NodeID) (*Node, error) {
   target, exists := ctx.nodes[id]
 
@@ -964,6 +1550,11 @@ func (ctx *Context) getNode(id NodeID) (*Node, error) {
       return nil, err
     }
 
+    err = ctx.loadNodeKey(node)
+    if err != nil {
+      return nil, err
+    }
+
     err = ctx.addNode(id, node)
     if err != nil {
       return nil, err
@@ -975,6 +1566,21 @@ func (ctx *Context) getNode(id NodeID) (*Node, error) {
   }
 }
 
+// Get a node like getNode, taking the nodes lock.
+// Loaded nodes are found under the read lock, the write lock is only taken to load one.
+func (ctx *Context) lookupNode(id NodeID) (*Node, error) {
+  ctx.nodesLock.RLock()
+  target, exists := ctx.nodes[id]
+  ctx.nodesLock.RUnlock()
+  if exists {
+    return target.Node, nil
+  }
+
+  ctx.nodesLock.Lock()
+  defer ctx.nodesLock.Unlock()
+  return ctx.getNode(id)
+}
+
 // Route Messages to dest. Currently only local context routing is supported
 func (ctx *Context) Send(node *Node, messages []Message) error {
   for _, msg := range(messages) {
@@ -982,9 +1588,12 @@ func (ctx *Context) Send(node *Node, messages []Message) error {
     if msg.Node == ZeroID {
       panic("Can't send to null ID")
     }
-    target, err := ctx.getNode(msg.Node)
+    target, err := ctx.getOrCreateNode(node.ID, msg.Node)
     if err == nil {
-      target.SendChan <- Message{node.ID, msg.Signal}
+      node.touch()
+      target.touch()
+      ctx.evictColdNodes(node.ID, target.ID)
+      ctx.deliver(node, target, msg.Signal)
     } else if errors.Is(err, NodeNotFoundError) {
       // TODO: Handle finding nodes in other contexts
       return err
@@ -995,19 +1604,213 @@ func (ctx *Context) Send(node *Node, messages []Message) error {
   return nil
 }
 
+// Deliver a signal from a node to itself only if it's still loaded, instead of loading it again like Send.
+// For goroutines started by extensions that can finish after the node they belong to is unloaded.
+// Returns whether the signal was delivered.
+func (ctx *Context) sendLoaded(node *Node, signal Signal) bool {
+  ctx.nodesLock.RLock()
+  loaded, exists := ctx.nodes[node.ID]
+  ctx.nodesLock.RUnlock()
+  if exists == false || loaded.Node != node || node.Active.Load() == false {
+    return false
+  }
+
+  ctx.Log.Logf("signal", "Sending %s to %s", signal, node.ID)
+  return ctx.deliver(node, node, signal)
+}
+
+type SendStatus uint8
+
+const (
+  // The signal was put in the target's inbox
+  SendDelivered = SendStatus(0)
+  // The target's inbox was full and its inbox config dropped the signal
+  SendOverflowed = SendStatus(1)
+  // The target couldn't be found or loaded
+  SendFailed = SendStatus(2)
+)
+
+func (status SendStatus) String() string {
+  switch status {
+  case SendDelivered:
+    return "delivered"
+  case SendOverflowed:
+    return "overflowed"
+  case SendFailed:
+    return "failed"
+  default:
+    return fmt.Sprintf("SendStatus(%d)", uint8(status))
+  }
+}
+
+// Completion of a single message sent with SendAsync
+type SendResult struct {
+  Message
+  Status SendStatus
+  Err error
+}
+
+// Send the messages without waiting on their destinations.
+// Each destination gets its messages in order on its own goroutine, so a slow or full inbox only delays its own messages.
+// Returns a channel that gets a SendResult for every message, closed once all of them have completed.
+// There are no remote contexts yet, so a delivered message is also as acknowledged as it gets.
+func (ctx *Context) SendAsync(node *Node, messages []Message) <-chan SendResult {
+  results := make(chan SendResult, len(messages))
+
+  destinations := map[NodeID][]Message{}
+  order := []NodeID{}
+  for _, msg := range(messages) {
+    if msg.Node == ZeroID {
+      panic("Can't send to null ID")
+    }
+    _, exists := destinations[msg.Node]
+    if exists == false {
+      order = append(order, msg.Node)
+    }
+    destinations[msg.Node] = append(destinations[msg.Node], msg)
+  }
+
+  var wg sync.WaitGroup
+  for _, id := range(order) {
+    wg.Add(1)
+    go func(id NodeID, msgs []Message) {
+      defer wg.Done()
+      target, err := ctx.getOrCreateNode(node.ID, id)
+      for _, msg := range(msgs) {
+        if err != nil {
+          results <- SendResult{msg, SendFailed, err}
+          continue
+        }
+
+        ctx.Log.Logf("signal", "Sending %s to %s", msg.Signal, msg.Node)
+        node.touch()
+        target.touch()
+        ctx.evictColdNodes(node.ID, target.ID)
+        if ctx.deliver(node, target, msg.Signal) {
+          results <- SendResult{msg, SendDelivered, nil}
+        } else {
+          results <- SendResult{msg, SendOverflowed, nil}
+        }
+      }
+    }(id, destinations[id])
+  }
+
+  go func() {
+    wg.Wait()
+    close(results)
+  }()
+
+  return results
+}
+
+// Put the signal in the target's inbox, nacking the source if the target's inbox config drops it or it's rejected by maintenance mode.
+// Returns whether the signal was delivered.
+func (ctx *Context) deliver(source *Node, target *Node, signal Signal) bool {
+  maintenance_err := ctx.checkMaintenanceSignal(signal)
+  if maintenance_err != nil {
+    ctx.Log.Logf("signal", "MAINTENANCE: rejected %s from %s to %s", signal, source.ID, target.ID)
+    ctx.nack(source, target, signal, "maintenance")
+    return false
+  }
+
+  size_err := ctx.NodeTypes[target.Type].Quota.checkSignalSize(ctx, signal)
+  if size_err != nil {
+    ctx.Log.Logf("signal", "SIZE_LIMIT: rejected %s from %s to %s - %s", signal, source.ID, target.ID, size_err)
+    ctx.nack(source, target, signal, "signal_size")
+    return false
+  }
+
+  msg := Message{source.ID, signal}
+  config := ctx.NodeTypes[target.Type].Inbox
+
+  target.enqueued()
+  delivered := true
+  if target.inbox != nil {
+    delivered = ctx.Scheduler.Deliver(target, msg, config)
+  } else {
+    switch config.Strategy {
+    case InboxGrow:
+      target.SendChan <- msg
+    case InboxBlock:
+      select {
+      case target.SendChan <- msg:
+      case <-time.After(config.Deadline):
+        delivered = false
+      }
+    case InboxDrop:
+      select {
+      case target.SendChan <- msg:
+      default:
+        delivered = false
+      }
+    }
+  }
+
+  if delivered == false {
+    target.queued.Add(-1)
+    ctx.Log.Logf("signal", "INBOX_FULL: dropped %s from %s to %s", signal, source.ID, target.ID)
+    if source.ID != target.ID {
+      ctx.nack(source, target, signal, "inbox_full")
+    }
+  }
+  return delivered
+}
+
+// Send an ErrorSignal for a dropped signal back to its source from the target
+func (ctx *Context) nack(source *Node, target *Node, signal Signal, reason string) {
+  _, is_error := signal.(*ErrorSignal)
+  if is_error {
+    return
+  }
+
+  // Don't block on a bounded inbox for the nack, the source is likely the node currently sending
+  nack := Message{target.ID, NewErrorSignal(signal.ID(), reason)}
+  source_config := ctx.NodeTypes[source.Type].Inbox
+  source.enqueued()
+  if source.inbox != nil {
+    if ctx.Scheduler.Deliver(source, nack, source_config) == false {
+      source.queued.Add(-1)
+    }
+  } else if source_config.Strategy == InboxGrow {
+    source.SendChan <- nack
+  } else {
+    select {
+    case source.SendChan <- nack:
+    default:
+      source.queued.Add(-1)
+      ctx.Log.Logf("signal", "INBOX_FULL: dropped %s nack for %s to %s", reason, signal, source.ID)
+    }
+  }
+}
+
 func resolveNodeID(val interface{}, p graphql.ResolveParams) (interface{}, error) {
   id, ok := val.(NodeID)
   if ok == false {
     return nil, fmt.Errorf("%+v is not NodeID", p.Source)
   }
 
-  return ResolveNode(id, p)
+  return ResolveNodeBatched(id, p)
 }
 
 // TODO: Cache these functions so they're not duplicated when called with the same t
 func (ctx *Context)GQLResolve(t reflect.Type, node_type string) (func(interface{},graphql.ResolveParams)(interface{},error)) {
   if t == reflect.TypeFor[NodeID]() {
     return resolveNodeID
+  } else if isLazy(t) {
+    elem_resolve := ctx.GQLResolve(reflect.New(t).Interface().(lazyValue).lazyType(), node_type)
+    return func(v interface{}, p graphql.ResolveParams) (interface{}, error) {
+      val := reflect.ValueOf(v)
+      if val.Type() == reflect.PointerTo(t) {
+        val = val.Elem()
+      } else if val.Type() != t {
+        return nil, fmt.Errorf("%s is not %s", reflect.TypeOf(v), t)
+      }
+      value, err := asLazy(val).lazyDecode(ctx)
+      if err != nil {
+        return nil, err
+      }
+      return elem_resolve(value.Interface(), p)
+    }
   } else {
     switch t.Kind() {
     case reflect.Map:
@@ -1030,7 +1833,14 @@ func (ctx *Context)GQLResolve(t reflect.Type, node_type string) (func(interface{
         }
       }
     case reflect.Pointer:
-      return ctx.GQLResolve(t.Elem(), node_type)
+      elem_resolve := ctx.GQLResolve(t.Elem(), node_type)
+      return func(v interface{}, p graphql.ResolveParams) (interface{}, error) {
+        val := reflect.ValueOf(v)
+        if val.IsValid() == false || val.IsNil() {
+          return nil, nil
+        }
+        return elem_resolve(val.Elem().Interface(), p)
+      }
     default:
       return func(v interface{}, p graphql.ResolveParams) (interface{}, error) {
         return v, nil
@@ -1039,9 +1849,12 @@ func (ctx *Context)GQLResolve(t reflect.Type, node_type string) (func(interface{
   }
 }
 
+// uuid.EnableRandPool isn't safe to call while other contexts' nodes are generating IDs, so it's only called once
+var enableRandPool sync.Once
+
 // Create a new Context with the base library content added
 func NewContext(db Database, log Logger) (*Context, error) {
-  uuid.EnableRandPool()
+  enableRandPool.Do(uuid.EnableRandPool)
 
   ctx := &Context{
     DB: db,
@@ -1054,13 +1867,23 @@ func NewContext(db Database, log Logger) (*Context, error) {
     NodeTypes: map[NodeType]NodeInfo{},
 
     nodes: map[NodeID]ContextNode{},
+    referenceIndex: newReferenceIndex(),
+    maintenanceAllowed: map[reflect.Type]bool{},
+    CompressAbove: SERIALIZED_VALUE_COMPRESS_SIZE,
+    events: newEventBus(),
+  }
+
+  for _, signal_type := range(maintenanceSignals) {
+    ctx.maintenanceAllowed[signal_type] = true
   }
 
   var err error
  
   err = RegisterScalar[NodeID](ctx, stringify, unstringify[NodeID], unstringifyAST[NodeID],
   func(ctx *Context, value reflect.Value, data []byte) (int, error) {
-    copy(data, value.Bytes())
+    // Arrays in interfaces aren't addressable, so Bytes can't be used
+    id := value.Interface().(NodeID)
+    copy(data, id[:])
     return 16, nil
   }, func(ctx *Context, value reflect.Value) (int, error) {
     return 16, nil
@@ -1083,7 +1906,8 @@ func NewContext(db Database, log Logger) (*Context, error) {
 
   err = RegisterScalar[uuid.UUID](ctx, stringify, unstringify[uuid.UUID], unstringifyAST[uuid.UUID],
   func(ctx *Context, value reflect.Value, data []byte) (int, error) {
-    copy(data, value.Bytes())
+    id := value.Interface().(uuid.UUID)
+    copy(data, id[:])
     return 16, nil
   }, func(ctx *Context, value reflect.Value) (int, error) {
     return 16, nil
@@ -1144,10 +1968,70 @@ func NewContext(db Database, log Logger) (*Context, error) {
     return nil, fmt.Errorf("Failed to register uint8: %w", err)
   }
 
-  err = RegisterScalar[time.Time](ctx, stringify, unstringify[time.Time], unstringifyAST[time.Time], nil, nil, nil)
+  err = RegisterScalar[int8](ctx, identity, coerce[int8], astInt[int8], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register int8: %w", err)
+  }
+
+  err = RegisterScalar[int16](ctx, identity, coerce[int16], astInt[int16], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register int16: %w", err)
+  }
+
+  err = RegisterScalar[int32](ctx, identity, coerce[int32], astInt[int32], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register int32: %w", err)
+  }
+
+  err = RegisterScalar[int64](ctx, identity, coerce[int64], astInt[int64], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register int64: %w", err)
+  }
+
+  err = RegisterScalar[uint](ctx, identity, coerce[uint], astInt[uint], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register uint: %w", err)
+  }
+
+  err = RegisterScalar[uint16](ctx, identity, coerce[uint16], astInt[uint16], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register uint16: %w", err)
+  }
+
+  err = RegisterScalar[uint64](ctx, identity, coerce[uint64], astInt[uint64], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register uint64: %w", err)
+  }
+
+  err = RegisterScalar[float32](ctx, identity, coerce[float32], astFloat[float32], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register float32: %w", err)
+  }
+
+  err = RegisterScalar[float64](ctx, identity, coerce[float64], astFloat[float64], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register float64: %w", err)
+  }
+
+  err = RegisterScalar[complex64](ctx, stringifyComplex, parseComplex[complex64], astComplex[complex64], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register complex64: %w", err)
+  }
+
+  err = RegisterScalar[complex128](ctx, stringifyComplex, parseComplex[complex128], astComplex[complex128], nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register complex128: %w", err)
+  }
+
+  err = RegisterScalar[time.Time](ctx, stringify, unstringify[time.Time], unstringifyAST[time.Time], serializeTime, serializedTimeSize, deserializeTime)
   if err != nil {
     return nil, fmt.Errorf("Failed to register time.Time: %w", err)
   }
+
+  err = RegisterScalar[time.Duration](ctx, stringifyDuration, parseDuration, astDuration, nil, nil, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register time.Duration: %w", err)
+  }
   
   err = RegisterScalar[string](ctx, identity, coerce[string], astString[string], nil, nil, nil)
   if err != nil {
@@ -1175,11 +2059,215 @@ func NewContext(db Database, log Logger) (*Context, error) {
     return nil, fmt.Errorf("Failed to register TimeoutSignal: %w", err)
   }
 
+  err = RegisterObjectNoGQL[Message](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register Message: %w", err)
+  }
+
+  err = RegisterSignal[OutboxSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register OutboxSignal: %w", err)
+  }
+
+  err = RegisterSignal[FreezeSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register FreezeSignal: %w", err)
+  }
+
+  err = RegisterSignal[ThawSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register ThawSignal: %w", err)
+  }
+
+  err = RegisterEnum[ChangeOp](ctx, ChangeOpStrings)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register ChangeOp: %w", err)
+  }
+
+  err = RegisterObject[Change](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register Change: %w", err)
+  }
+
+  err = RegisterObject[FieldDiff](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register FieldDiff: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[VersionTrigger](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register VersionTrigger: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[NodeVersion](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NodeVersion: %w", err)
+  }
+
+  err = RegisterObject[LinkRequest](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LinkRequest: %w", err)
+  }
+
+  err = RegisterSignal[LinkSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LinkSignal: %w", err)
+  }
+
+  err = RegisterSignal[LockSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LockSignal: %w", err)
+  }
+
+  err = RegisterSignal[UnlockSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register UnlockSignal: %w", err)
+  }
+
+  err = RegisterSignal[DependencySignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register DependencySignal: %w", err)
+  }
+
+  // Not exposed to GQL, since only the lockable can force itself to unlock
+  err = RegisterObjectNoGQL[ForceUnlockSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register ForceUnlockSignal: %w", err)
+  }
+
+  err = RegisterSignal[LockLostSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LockLostSignal: %w", err)
+  }
+
+  // PrepareSignal holds a Signal, which has no GQL type
+  err = RegisterObjectNoGQL[PrepareSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register PrepareSignal: %w", err)
+  }
+
+  err = RegisterSignal[CommitSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register CommitSignal: %w", err)
+  }
+
+  err = RegisterSignal[AbortSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register AbortSignal: %w", err)
+  }
+
+  err = RegisterSignal[SuccessSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register SuccessSignal: %w", err)
+  }
+
+  err = RegisterSignal[ErrorSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register ErrorSignal: %w", err)
+  }
+
+  // IdempotentSignal and IdempotentResult hold Signals, which have no GQL type
+  err = RegisterObjectNoGQL[IdempotentSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register IdempotentSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[IdempotentResult](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register IdempotentResult: %w", err)
+  }
+
+  err = RegisterSignal[HeartbeatSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register HeartbeatSignal: %w", err)
+  }
+
+  // PresenceSignal and Presence have int64 timestamps, which have no GQL type
+  err = RegisterObjectNoGQL[PresenceSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register PresenceSignal: %w", err)
+  }
+
+  err = RegisterSignal[PresenceCheckSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register PresenceCheckSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[Presence](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register Presence: %w", err)
+  }
+
+  err = RegisterSignal[AliasSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register AliasSignal: %w", err)
+  }
+
+  err = RegisterSignal[LocaleSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LocaleSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[NotificationPreference](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NotificationPreference: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[NotificationDelivery](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NotificationDelivery: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[NotificationPreferenceSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NotificationPreferenceSignal: %w", err)
+  }
+
+  err = RegisterSignal[NotificationSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NotificationSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[NotificationDeliveredSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NotificationDeliveredSignal: %w", err)
+  }
+
+  err = RegisterSignal[SessionStartedSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register SessionStartedSignal: %w", err)
+  }
+
+  err = RegisterSignal[SessionEndedSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register SessionEndedSignal: %w", err)
+  }
+
   err = RegisterSignal[StatusSignal](ctx)
   if err != nil {
     return nil, fmt.Errorf("Failed to register StatusSignal: %w", err)
   }
 
+  err = RegisterSignal[AckSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register AckSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[RedeliverSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register RedeliverSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[WatchdogAlertSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register WatchdogAlertSignal: %w", err)
+  }
+
+  err = RegisterObjectNoGQL[DBHealthSignal](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register DBHealthSignal: %w", err)
+  }
+
   err = RegisterObject[Node](ctx)
   if err != nil {
     return nil, fmt.Errorf("Failed to register Node: %w", err)
@@ -1195,11 +2283,36 @@ func NewContext(db Database, log Logger) (*Context, error) {
     return nil, fmt.Errorf("Failed to register ListenerExt extension: %w", err)
   }
 
+  err = RegisterExtension[AckListenerExt](ctx, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register AckListenerExt extension: %w", err)
+  }
+
+  err = RegisterExtension[PresenceExt](ctx, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register PresenceExt extension: %w", err)
+  }
+
+  err = RegisterExtension[IdempotencyExt](ctx, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register IdempotencyExt extension: %w", err)
+  }
+
   err = RegisterExtension[GQLExt](ctx, nil)
   if err != nil {
     return nil, fmt.Errorf("Failed to register GQLExt extension: %w", err)
   }
 
+  err = RegisterExtension[LocaleExt](ctx, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LocaleExt extension: %w", err)
+  }
+
+  err = RegisterExtension[NotificationExt](ctx, nil)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NotificationExt extension: %w", err)
+  }
+
   err = RegisterNodeInterface(ctx, "Lockable", map[string]graphql.Type{
     "LockableState": gqltype(ctx, reflect.TypeFor[ReqState](), ""),
     "Requirements": gqltype(ctx, reflect.TypeFor[map[NodeID]ReqState](), ":Lockable"),
@@ -1208,20 +2321,31 @@ func NewContext(db Database, log Logger) (*Context, error) {
     return nil, fmt.Errorf("Failed to register NodeInterface Lockable: %w", err)
   }
 
-  err = RegisterNodeType(ctx, "LockableNode", map[string]FieldMapping{
-    "LockableState": {
-      Extension: ExtTypeFor[LockableExt](),
-      Tag: "state",
-    },
-    "Requirements": {
-      Extension: ExtTypeFor[LockableExt](),
-      Tag: "requirements",      
-    },
-  })
+  err = RegisterComputedField(ctx, "locked_count", "LockedCount", lockableLockedCount)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LockableExt locked_count: %w", err)
+  }
+
+  lockable_mappings, err := TagMappings(ctx, ExtTypeFor[LockableExt]())
+  if err != nil {
+    return nil, fmt.Errorf("Failed to get LockableExt mappings: %w", err)
+  }
+
+  err = RegisterNodeType(ctx, "LockableNode", lockable_mappings)
   if err != nil {
     return nil, fmt.Errorf("Failed to register NodeType LockableNode: %w", err)
   }
 
+  presence_mappings, err := TagMappings(ctx, ExtTypeFor[PresenceExt]())
+  if err != nil {
+    return nil, fmt.Errorf("Failed to get PresenceExt mappings: %w", err)
+  }
+
+  err = RegisterNodeType(ctx, "PresenceNode", presence_mappings)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register NodeType PresenceNode: %w", err)
+  }
+
   err = RegisterObject[LockableExt](ctx)
   if err != nil {
     return nil, fmt.Errorf("Failed to register LockableExt object: %w", err)
@@ -1236,7 +2360,14 @@ func NewContext(db Database, log Logger) (*Context, error) {
   if err != nil {
     return nil, fmt.Errorf("Failed to register GQLExt object: %w", err)
   }
+
+  err = RegisterObject[LocaleExt](ctx)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to register LocaleExt object: %w", err)
+  }
   
+  path_fields := pathGQLFields(ctx, PATH_QUERY_MAX_DEPTH, PATH_QUERY_MAX_VISITED)
+
   schema, err := BuildSchema(ctx, graphql.NewObject(graphql.ObjectConfig{
     Name: "Query",
     Fields: graphql.Fields{
@@ -1257,8 +2388,26 @@ func NewContext(db Database, log Logger) (*Context, error) {
           "id": &graphql.ArgumentConfig{
             Type: ctx.Types[reflect.TypeFor[NodeID]()].Type,
           },
+          "alias": &graphql.ArgumentConfig{
+            Type: graphql.String,
+          },
         },
         Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          _, has_alias := p.Args["alias"]
+          if has_alias {
+            alias, err := ExtractParam[string](p, "alias")
+            if err != nil {
+              return nil, err
+            }
+
+            id, err := ctx.ResolveAlias(alias)
+            if err != nil {
+              return nil, err
+            }
+
+            return ResolveNode(id, p)
+          }
+
           id, err := ExtractParam[NodeID](p, "id")
           if err != nil {
             return nil, err
@@ -1267,6 +2416,11 @@ func NewContext(db Database, log Logger) (*Context, error) {
           return ResolveNode(id, p)
         },
       },
+      "History": historyGQLField(ctx),
+      "Reachable": path_fields["Reachable"],
+      "Path": path_fields["Path"],
+      "LockGraph": lockGraphGQLField(ctx, LOCK_GRAPH_MAX_DEPTH),
+      "Stats": statsGQLField(ctx),
     },
   }), graphql.NewObject(graphql.ObjectConfig{
     Name: "Mutation",
@@ -1277,6 +2431,7 @@ func NewContext(db Database, log Logger) (*Context, error) {
           return "TEST", nil
         },
       },
+      "SendSignal": sendSignalGQLField(ctx),
     },
   }))
   if err != nil {
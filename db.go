@@ -1,18 +1,60 @@
 package graphvent
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
+  "slices"
   "sync"
+  "sync/atomic"
 
 	badger "github.com/dgraph-io/badger/v3"
+  "github.com/google/uuid"
 )
 
 type Database interface {
   WriteNodeInit(*Context, *Node) error
-  WriteNodeChanges(*Context, *Node, map[ExtType]Changes) error
+  WriteNodeChanges(*Context, *Node, Changes) error
   LoadNode(*Context, NodeID) (*Node, error)
+
+  // Write/Load a single extension without touching the rest of the node
+  WriteExtension(*Context, NodeID, Extension) error
+  LoadExtension(*Context, NodeID, ExtType) (Extension, error)
+
+  // Append a version to the node's history with the next version number, keeping at most the last keep versions
+  WriteNodeVersion(*Context, NodeID, NodeVersion, int) error
+  LoadNodeHistory(*Context, NodeID) ([]NodeVersion, error)
+
+  // Add one to the number of nodes of the type, failing with QuotaExceededError if the count is already at a positive limit
+  CountNode(*Context, NodeType, int) error
+  // Subtract one from the number of nodes of the type, for a node that was counted but couldn't be written
+  UncountNode(*Context, NodeType) error
+
+  // Assign an alias to a node, failing with AliasTakenError if it's assigned to a different node
+  WriteAlias(*Context, string, NodeID) error
+  // Remove an alias, returning the node it was assigned to
+  RemoveAlias(*Context, string) (NodeID, error)
+  LoadAlias(*Context, string) (NodeID, error)
+
+  // Record whether a transaction commits, unless a decision was already recorded. Returns the recorded decision.
+  WriteTxDecision(*Context, uuid.UUID, bool) (bool, error)
+  // Remove a transaction's decision once every node that prepared it has ended it
+  RemoveTxDecision(*Context, uuid.UUID) error
+
+  // Get the fields that held the node's ID when their nodes were last written
+  LoadReferences(*Context, NodeID) ([]Reference, error)
+
+  // Add or remove a node from the set of nodes loaded by LoadAutoNodes
+  WriteAutoLoad(*Context, NodeID, bool) error
+  LoadAutoLoad(*Context) ([]NodeID, error)
+
+  // Get the IDs of every node in the DB from the node index
+  LoadNodeIDs(*Context) ([]NodeID, error)
+
+  // Count the nodes, extensions, and references in the DB
+  LoadStats(*Context) (DBStats, error)
 }
 
 const WRITE_BUFFER_SIZE = 1000000
@@ -20,6 +62,9 @@ type BadgerDB struct {
   *badger.DB
   sync.Mutex
   buffer [WRITE_BUFFER_SIZE]byte
+
+  // Number of extension fields written, to measure write amplification
+  FieldWrites atomic.Int64
 }
 
 func (db *BadgerDB) WriteNodeInit(ctx *Context, node *Node) error {
@@ -27,9 +72,11 @@ func (db *BadgerDB) WriteNodeInit(ctx *Context, node *Node) error {
     return fmt.Errorf("Cannot serialize nil *Node")
   }
 
+  // The lock is held until the transaction commits, since the values set from db.buffer aren't copied until then
+  db.Lock()
+  defer db.Unlock()
+
   return db.Update(func(tx *badger.Txn) error {
-    db.Lock()
-    defer db.Unlock()
 
     // Get the base key bytes
     id_ser, err := node.ID.MarshalBinary()
@@ -40,26 +87,42 @@ func (db *BadgerDB) WriteNodeInit(ctx *Context, node *Node) error {
     cur := 0
 
     // Write Node value
-    written, err := Serialize(ctx, node, db.buffer[cur:])
+    record, err := writeNodeRecord(ctx, node, db.buffer[cur:])
     if err != nil {
       return err
     }
 
-    err = tx.Set(id_ser, db.buffer[cur:cur+written])
+    err = tx.Set(id_ser, record)
     if err != nil {
       return err
     }
 
-    cur += written
+    cur += len(record)
+
+    err = tx.Set(nodeIndexKey(node.ID), nil)
+    if err != nil {
+      return err
+    }
+
+    if node.initKey != nil {
+      err = tx.Set(node.initKey.key, node.initKey.value)
+      if err != nil {
+        return err
+      }
+    }
     
     // Write empty signal queue
     sigqueue_id := append(id_ser, []byte(" - SIGQUEUE")...)
-    written, err = Serialize(ctx, node.SignalQueue, db.buffer[cur:])
+    written, err := Serialize(ctx, node.SignalQueue, db.buffer[cur:])
     if err != nil {
       return err
     }
 
-    err = tx.Set(sigqueue_id, db.buffer[cur:cur+written])
+    sealed, err := sealAtRest(ctx, sigqueue_id, db.buffer[cur:cur+written])
+    if err != nil {
+      return err
+    }
+    err = tx.Set(sigqueue_id, sealed)
     if err != nil {
       return err
     }
@@ -84,45 +147,35 @@ func (db *BadgerDB) WriteNodeInit(ctx *Context, node *Node) error {
 
     // For each extension:
     for ext_type, ext := range(node.Extensions) {
-      ext_info, exists := ctx.Extensions[ext_type]
-      if exists == false {
-        return fmt.Errorf("Cannot serialize node with unknown extension %s", reflect.TypeOf(ext))
+      written, err := db.writeExtension(ctx, tx, id_ser, ctx.NodeTypes[node.Type].Quota, ext_type, ext, db.buffer[cur:])
+      if err != nil {
+        return err
       }
+      cur += written
 
       ext_value := reflect.ValueOf(ext).Elem()
-      ext_id := binary.BigEndian.AppendUint64(id_ser, uint64(ext_type))
-
-      // Write each field to a seperate key
-      for field_tag, field_info := range(ext_info.Fields) {
-        field_value := ext_value.FieldByIndex(field_info.Index)
-
-        field_id := make([]byte, len(ext_id) + 8)
-        tmp := binary.BigEndian.AppendUint64(ext_id, uint64(GetFieldTag(string(field_tag))))
-        copy(field_id, tmp)
-
-        written, err := SerializeValue(ctx, field_value, db.buffer[cur:])
-        if err != nil {
-          return fmt.Errorf("Extension serialize err: %s, %w", reflect.TypeOf(ext), err)
-        }
-
-        err = tx.Set(field_id, db.buffer[cur:cur+written])
-        if err != nil {
-          return fmt.Errorf("Extension set err: %s, %w", reflect.TypeOf(ext), err)
+      for tag, field_info := range(ctx.Extensions[ext_type].Fields) {
+        if field_info.HoldsNodeIDs {
+          err := db.writeReferences(tx, id_ser, ext_type, tag, NodeIDsIn(fieldOrZero(ext_value, field_info.Index)))
+          if err != nil {
+            return err
+          }
         }
-        cur += written
       }
     }
     return nil
   })
 }
 
-func (db *BadgerDB) WriteNodeChanges(ctx *Context, node *Node, changes map[ExtType]Changes) error {
+func (db *BadgerDB) WriteNodeChanges(ctx *Context, node *Node, changes Changes) error {
+  db.Lock()
+  defer db.Unlock()
+
   return db.Update(func(tx *badger.Txn) error {
-    db.Lock()
-    defer db.Unlock()
 
     // Get the base key bytes
     id_bytes := ([16]byte)(node.ID)
+    quota := ctx.NodeTypes[node.Type].Quota
 
     cur := 0
 
@@ -135,15 +188,114 @@ func (db *BadgerDB) WriteNodeChanges(ctx *Context, node *Node, changes map[ExtTy
       if err != nil {
         return fmt.Errorf("SignalQueue Serialize Error: %+v, %w", node.SignalQueue, err)
       }
-      err = tx.Set(sigqueue_id, db.buffer[cur:cur+written])
+      sealed, err := sealAtRest(ctx, sigqueue_id, db.buffer[cur:cur+written])
+      if err != nil {
+        return err
+      }
+      err = tx.Set(sigqueue_id, sealed)
       if err != nil {
         return fmt.Errorf("SignalQueue set error: %+v, %w", node.SignalQueue, err)
       }
       cur += written
     }
 
+    // Write the outbox if it changed, removing the key once it's empty
+    if node.writeOutbox {
+      node.writeOutbox = false
+
+      outbox_id := append(id_bytes[:], []byte(" - OUTBOX")...)
+      if len(node.outbox) == 0 {
+        err := tx.Delete(outbox_id)
+        if err != nil {
+          return fmt.Errorf("Outbox delete error: %w", err)
+        }
+      } else {
+        written, err := Serialize(ctx, node.outbox, db.buffer[cur:])
+        if err != nil {
+          return fmt.Errorf("Outbox Serialize Error: %+v, %w", node.outbox, err)
+        }
+        sealed, err := sealAtRest(ctx, outbox_id, db.buffer[cur:cur+written])
+        if err != nil {
+          return err
+        }
+        err = tx.Set(outbox_id, sealed)
+        if err != nil {
+          return fmt.Errorf("Outbox set error: %+v, %w", node.outbox, err)
+        }
+        cur += written
+      }
+    }
+
+    // Write the signal the node prepared for a transaction, removing the key once the transaction ends
+    if node.writePrepared {
+      node.writePrepared = false
+
+      prepared_id := append(id_bytes[:], []byte(" - PREPARED")...)
+      if node.prepared == nil {
+        err := tx.Delete(prepared_id)
+        if err != nil {
+          return fmt.Errorf("Prepared delete error: %w", err)
+        }
+      } else {
+        prepared := Message{node.prepared.Source, node.prepared.Signal}
+        written, err := Serialize(ctx, prepared, db.buffer[cur:])
+        if err != nil {
+          return fmt.Errorf("Prepared Serialize Error: %+v, %w", prepared, err)
+        }
+        sealed, err := sealAtRest(ctx, prepared_id, db.buffer[cur:cur+written])
+        if err != nil {
+          return err
+        }
+        err = tx.Set(prepared_id, sealed)
+        if err != nil {
+          return fmt.Errorf("Prepared set error: %+v, %w", prepared, err)
+        }
+        cur += written
+      }
+    }
+
+    // Write the extension list and every field of the extensions attached since the last write
+    if len(node.attached) != 0 {
+      attached := node.attached
+      node.attached = nil
+
+      ext_list := slices.Clone(node.unknownExtensions)
+      for ext_type := range(node.Extensions) {
+        ext_list = append(ext_list, ext_type)
+      }
+      written, err := Serialize(ctx, ext_list, db.buffer[cur:])
+      if err != nil {
+        return err
+      }
+      ext_list_id := append(id_bytes[:], []byte(" - EXTLIST")...)
+      err = tx.Set(ext_list_id, db.buffer[cur:cur+written])
+      if err != nil {
+        return err
+      }
+      cur += written
+
+      for _, ext_type := range(attached) {
+        ext := node.Extensions[ext_type]
+        written, err := db.writeExtension(ctx, tx, id_bytes[:], quota, ext_type, ext, db.buffer[cur:])
+        if err != nil {
+          return err
+        }
+        cur += written
+
+        ext_value := reflect.ValueOf(ext).Elem()
+        for tag, field_info := range(ctx.Extensions[ext_type].Fields) {
+          if field_info.HoldsNodeIDs {
+            err := db.writeReferences(tx, id_bytes[:], ext_type, tag, NodeIDsIn(fieldOrZero(ext_value, field_info.Index)))
+            if err != nil {
+              return err
+            }
+          }
+        }
+      }
+    }
+
     // For each ext in changes
-    for ext_type, changes := range(changes) {
+    for ext_type, fields := range(changes.ByExtension()) {
       ext_info, exists := ctx.Extensions[ext_type]
       if exists == false {
         return fmt.Errorf("%s is not an extension in ctx", ext_type)
@@ -154,19 +306,23 @@ func (db *BadgerDB) WriteNodeChanges(ctx *Context, node *Node, changes map[ExtTy
         return fmt.Errorf("%s is not an extension in %s", ext_type, node.ID)
       }
       ext_id := binary.BigEndian.AppendUint64(id_bytes[:], uint64(ext_type))
-      ext_value := reflect.ValueOf(ext)
+      ext_value := reflect.ValueOf(ext).Elem()
 
       // Write each field
-      for _, tag := range(changes) {
+      for _, tag := range(fields) {
         field_info, exists := ext_info.Fields[tag]
         if exists == false {
           return fmt.Errorf("Cannot serialize field %s of extension %s, does not exist", tag, ext_type)
         }
 
-        field_value := ext_value.FieldByIndex(field_info.Index)
+        field_value := fieldOrZero(ext_value, field_info.Index)
+        err := quota.checkFieldSize(ctx, ext_type, tag, field_value)
+        if err != nil {
+          return err
+        }
 
         field_id := make([]byte, len(ext_id) + 8)
-        tmp := binary.BigEndian.AppendUint64(ext_id, uint64(GetFieldTag(string(tag))))
+        tmp := binary.BigEndian.AppendUint64(ext_id, uint64(field_info.FieldTag))
         copy(field_id, tmp)
 
         written, err := SerializeValue(ctx, field_value, db.buffer[cur:])
@@ -174,11 +330,23 @@ func (db *BadgerDB) WriteNodeChanges(ctx *Context, node *Node, changes map[ExtTy
           return fmt.Errorf("Extension serialize err: %s, %w", reflect.TypeOf(ext), err)
         }
 
-        err = tx.Set(field_id, db.buffer[cur:cur+written])
+        sealed, err := sealAtRest(ctx, field_id, db.buffer[cur:cur+written])
+        if err != nil {
+          return err
+        }
+        err = tx.Set(field_id, sealed)
         if err != nil {
           return fmt.Errorf("Extension set err: %s, %w", reflect.TypeOf(ext), err)
         }
         cur += written
+        db.FieldWrites.Add(1)
+
+        if field_info.HoldsNodeIDs {
+          err = db.writeReferences(tx, id_bytes[:], ext_type, tag, NodeIDsIn(field_value))
+          if err != nil {
+            return err
+          }
+        }
       }
     }
     return nil
@@ -203,7 +371,11 @@ func (db *BadgerDB) LoadNode(ctx *Context, id NodeID) (*Node, error) {
 
     err = node_item.Value(func(val []byte) error {
       ctx.Log.Logf("db", "DESERIALIZE_NODE(%d bytes): %+v", len(val), val)
-      node, err = Deserialize[*Node](ctx, val)
+      payload, err := openNodeRecord(ctx, id, val)
+      if err != nil {
+        return err
+      }
+      node, err = Deserialize[*Node](ctx, payload)
       return err
     })
 
@@ -218,6 +390,10 @@ func (db *BadgerDB) LoadNode(ctx *Context, id NodeID) (*Node, error) {
       return fmt.Errorf("Failed to get sigqueue_id: %w", err)
     }
     err = sigqueue_item.Value(func(val []byte) error {
+      val, err := openAtRest(ctx, sigqueue_id, val)
+      if err != nil {
+        return err
+      }
       node.SignalQueue, err = Deserialize[[]QueuedSignal](ctx, val)
       return err
     })
@@ -225,6 +401,55 @@ func (db *BadgerDB) LoadNode(ctx *Context, id NodeID) (*Node, error) {
       return fmt.Errorf("Failed to deserialize []QueuedSignal for %s: %w", id, err)
     }
 
+    // Get the outbox, which only exists while it has messages in it
+    outbox_id := append(id_ser, []byte(" - OUTBOX")...)
+    outbox_item, err := tx.Get(outbox_id)
+    if err == nil {
+      err = outbox_item.Value(func(val []byte) error {
+        val, err := openAtRest(ctx, outbox_id, val)
+        if err != nil {
+          return err
+        }
+        node.outbox, err = Deserialize[[]Message](ctx, val)
+        return err
+      })
+      if err != nil {
+        return fmt.Errorf("Failed to deserialize outbox for %s: %w", id, err)
+      }
+    } else if err != badger.ErrKeyNotFound {
+      return fmt.Errorf("Failed to get outbox for %s: %w", id, err)
+    }
+
+    // Get the signal prepared for a transaction, which only exists until the transaction ends
+    prepared_id := append(id_ser, []byte(" - PREPARED")...)
+    prepared_item, err := tx.Get(prepared_id)
+    if err == nil {
+      err = prepared_item.Value(func(val []byte) error {
+        val, err := openAtRest(ctx, prepared_id, val)
+        if err != nil {
+          return err
+        }
+        prepared, err := Deserialize[Message](ctx, val)
+        if err != nil {
+          return err
+        }
+        prepare_signal, is_prepare := prepared.Signal.(*PrepareSignal)
+        if is_prepare == false {
+          return fmt.Errorf("Prepared signal is %s, not a PrepareSignal", prepared.Signal)
+        }
+        node.prepared = &preparedTx{
+          Source: prepared.Node,
+          Signal: prepare_signal,
+        }
+        return nil
+      })
+      if err != nil {
+        return fmt.Errorf("Failed to deserialize prepared signal for %s: %w", id, err)
+      }
+    } else if err != badger.ErrKeyNotFound {
+      return fmt.Errorf("Failed to get prepared signal for %s: %w", id, err)
+    }
+
     // Get the extension list
     ext_list_id := append(id_ser, []byte(" - EXTLIST")...)
     ext_list_item, err := tx.Get(ext_list_id)
@@ -238,47 +463,779 @@ func (db *BadgerDB) LoadNode(ctx *Context, id NodeID) (*Node, error) {
       return err
     })
 
-    // Get the extensions
+    // Get the extensions, skipping extensions that were registered by the binary that wrote the node but aren't registered now
     for _, ext_type := range(ext_list) {
-      ext_id := binary.BigEndian.AppendUint64(id_ser, uint64(ext_type))
-      ext_info, exists := ctx.Extensions[ext_type]
-      if exists == false {
-        return fmt.Errorf("Extension %s not in context", ext_type)
+      _, known := ctx.Extensions[ext_type]
+      if known == false {
+        ctx.Log.Logf("db", "Skipping unknown extension %s on %s", ext_type, id)
+        node.unknownExtensions = append(node.unknownExtensions, ext_type)
+        continue
+      }
+
+      ext, migrated, err := db.loadExtension(ctx, tx, id_ser, ext_type)
+      if err != nil {
+        return err
+      }
+      node.Extensions[ext_type] = ext
+      if migrated {
+        node.attached = append(node.attached, ext_type)
+      }
+    }
+
+    return nil
+  })
+
+  if err != nil {
+    return nil, err
+  } else if node == nil {
+    return nil, fmt.Errorf("Tried to return nil *Node from BadgerDB.LoadNode without error")
+  }
+
+  return node, nil
+}
+
+// Write every field of ext to it's own key, using buffer to serialize
+func (db *BadgerDB) writeExtension(ctx *Context, tx *badger.Txn, id_ser []byte, quota Quota, ext_type ExtType, ext Extension, buffer []byte) (int, error) {
+  ext_info, exists := ctx.Extensions[ext_type]
+  if exists == false {
+    return 0, fmt.Errorf("Cannot serialize node with unknown extension %s", reflect.TypeOf(ext))
+  }
+
+  ext_value := reflect.ValueOf(ext).Elem()
+  ext_id := binary.BigEndian.AppendUint64(id_ser, uint64(ext_type))
+
+  cur := 0
+  // Write each field to a seperate key
+  for tag, field_info := range(ext_info.Fields) {
+    // Every field has a key, so fields promoted through nil embedded pointers are written as zero values
+    field_value := fieldOrZero(ext_value, field_info.Index)
+    err := quota.checkFieldSize(ctx, ext_type, tag, field_value)
+    if err != nil {
+      return 0, err
+    }
+
+    field_id := make([]byte, len(ext_id) + 8)
+    tmp := binary.BigEndian.AppendUint64(ext_id, uint64(field_info.FieldTag))
+    copy(field_id, tmp)
+
+    written, err := SerializeValue(ctx, field_value, buffer[cur:])
+    if err != nil {
+      return 0, fmt.Errorf("Extension serialize err: %s, %w", reflect.TypeOf(ext), err)
+    }
+
+    sealed, err := sealAtRest(ctx, field_id, buffer[cur:cur+written])
+    if err != nil {
+      return 0, err
+    }
+    err = tx.Set(field_id, sealed)
+    if err != nil {
+      return 0, fmt.Errorf("Extension set err: %s, %w", reflect.TypeOf(ext), err)
+    }
+    cur += written
+    db.FieldWrites.Add(1)
+  }
+
+  // Unversioned extensions don't have a version key, so it's read as 0
+  if ext_info.Version != 0 {
+    err := tx.Set(slices.Clone(ext_id), binary.BigEndian.AppendUint64(nil, ext_info.Version))
+    if err != nil {
+      return 0, fmt.Errorf("Extension version set err: %s, %w", reflect.TypeOf(ext), err)
+    }
+  }
+
+  return cur, nil
+}
+
+// Read every field of the extension from it's own key, migrating it if it was written with an older version
+func (db *BadgerDB) loadExtension(ctx *Context, tx *badger.Txn, id_ser []byte, ext_type ExtType) (Extension, bool, error) {
+  ext_id := binary.BigEndian.AppendUint64(id_ser, uint64(ext_type))
+  ext_info, exists := ctx.Extensions[ext_type]
+  if exists == false {
+    return nil, false, fmt.Errorf("Extension %s not in context", ext_type)
+  }
+
+  var version uint64 = 0
+  version_item, err := tx.Get(ext_id)
+  if err == nil {
+    err = version_item.Value(func(val []byte) error {
+      if len(val) != 8 {
+        return fmt.Errorf("Version of %s is %d bytes", ext_info.Type, len(val))
+      }
+      version = binary.BigEndian.Uint64(val)
+      return nil
+    })
+    if err != nil {
+      return nil, false, err
+    }
+  } else if err != badger.ErrKeyNotFound {
+    return nil, false, err
+  }
+
+  if version != ext_info.Version {
+    ext, err := db.migrateExtension(ctx, tx, ext_id, ext_info, version)
+    return ext, err == nil, err
+  }
+
+  ext := reflect.New(ext_info.Type)
+  for field_tag, field_info := range(ext_info.Fields) {
+    field_id := binary.BigEndian.AppendUint64(ext_id, uint64(field_info.FieldTag))
+    field_item, err := tx.Get(field_id)
+    if err == badger.ErrKeyNotFound {
+      // Fields added since the extension was written are left as zero values
+      ctx.Log.Logf("db", "Field %s:%s not stored, leaving zero value", ext_type, field_tag)
+      continue
+    } else if err != nil {
+      return nil, false, fmt.Errorf("Failed to find key for %s:%s(%x) - %w", ext_type, field_tag, field_id, err)
+    }
+    err = field_item.Value(func(val []byte) error {
+      val, err := openAtRest(ctx, field_id, val)
+      if err != nil {
+        return err
       }
 
-      ext := reflect.New(ext_info.Type)
-      for field_tag, field_info := range(ext_info.Fields) {
-        field_id := binary.BigEndian.AppendUint64(ext_id, uint64(GetFieldTag(string(field_tag))))        
-        field_item, err := tx.Get(field_id)
+      field := settableField(ext.Elem(), field_info.Index)
+      lazy, is_lazy := field.Addr().Interface().(lazyField)
+      if is_lazy {
+        lazy.setEncoded(val)
+        return nil
+      }
+
+      value, _, err := DeserializeValue(ctx, val, field_info.Type)
+      if err != nil {
+        return err
+      }
+
+      field.Set(value)
+
+      return nil
+    })
+    if err != nil {
+      return nil, false, err
+    }
+  }
+
+  return ext.Interface().(Extension), false, nil
+}
+
+// Load an extension written with an older version, loading the fields that still exist then passing every field that was written to MigrateFrom
+func (db *BadgerDB) migrateExtension(ctx *Context, tx *badger.Txn, ext_id []byte, ext_info ExtensionInfo, version uint64) (Extension, error) {
+  if version > ext_info.Version {
+    return nil, fmt.Errorf("%s was written by version %d, which is newer than %d", ext_info.Type, version, ext_info.Version)
+  }
+
+  ext := reflect.New(ext_info.Type)
+  versioned, is_versioned := ext.Interface().(VersionedExtension)
+  if is_versioned == false {
+    return nil, fmt.Errorf("%s was written by version %d, but isn't a VersionedExtension", ext_info.Type, version)
+  }
+
+  fields := map[FieldTag][]byte{}
+  options := badger.DefaultIteratorOptions
+  options.Prefix = ext_id
+  iter := tx.NewIterator(options)
+  defer iter.Close()
+  for iter.Rewind(); iter.Valid(); iter.Next() {
+    key := iter.Item().Key()
+    // Skip the version key
+    if len(key) != len(ext_id) + 8 {
+      continue
+    }
+    value, err := iter.Item().ValueCopy(nil)
+    if err != nil {
+      return nil, err
+    }
+    value, err = openAtRest(ctx, key, value)
+    if err != nil {
+      return nil, err
+    }
+    fields[FieldTag(binary.BigEndian.Uint64(key[len(ext_id):]))] = value
+  }
+
+  for tag, field_info := range(ext_info.Fields) {
+    data, written := fields[field_info.FieldTag]
+    if written == false {
+      continue
+    }
+    value, _, err := DeserializeValue(ctx, data, field_info.Type)
+    if err != nil {
+      return nil, fmt.Errorf("Failed to load %s:%s for migration from version %d - %w", ext_info.Type, tag, version, err)
+    }
+    settableField(ext.Elem(), field_info.Index).Set(value)
+  }
+
+  err := versioned.MigrateFrom(ctx, version, fields)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to migrate %s from version %d - %w", ext_info.Type, version, err)
+  }
+
+  return ext.Interface().(Extension), nil
+}
+
+func (db *BadgerDB) WriteExtension(ctx *Context, id NodeID, ext Extension) error {
+  if ext == nil {
+    return fmt.Errorf("Cannot serialize nil Extension")
+  }
+
+  ext_type := ExtTypeOf(reflect.TypeOf(ext))
+
+  // The read lock keeps the node from being loaded with the old extension while the new one is written
+  ctx.nodesLock.RLock()
+  defer ctx.nodesLock.RUnlock()
+  _, loaded := ctx.nodes[id]
+  if loaded {
+    return fmt.Errorf("Cannot write %s to %s: %w", ext_type, id, NodeLoadedError)
+  }
+
+  db.Lock()
+  defer db.Unlock()
+
+  return db.Update(func(tx *badger.Txn) error {
+
+    id_ser, err := id.MarshalBinary()
+    if err != nil {
+      return err
+    }
+
+    // Make sure the extension is one of the node's, so LoadNode will find it
+    ext_list_id := append(id_ser, []byte(" - EXTLIST")...)
+    ext_list_item, err := tx.Get(ext_list_id)
+    if err != nil {
+      return fmt.Errorf("Failed to get extension list for %s: %w", id, NodeNotFoundError)
+    }
+
+    var ext_list []ExtType
+    err = ext_list_item.Value(func(val []byte) error {
+      ext_list, err = Deserialize[[]ExtType](ctx, val)
+      return err
+    })
+    if err != nil {
+      return err
+    }
+
+    if slices.Contains(ext_list, ext_type) == false {
+      return fmt.Errorf("%s is not an extension in %s", ext_type, id)
+    }
+
+    // The node type isn't loaded here, so field sizes are only checked on WriteNodeInit and WriteNodeChanges
+    _, err = db.writeExtension(ctx, tx, id_ser, Quota{}, ext_type, ext, db.buffer[:])
+    return err
+  })
+}
+
+func (db *BadgerDB) LoadExtension(ctx *Context, id NodeID, ext_type ExtType) (Extension, error) {
+  var ext Extension = nil
+  err := db.View(func(tx *badger.Txn) error {
+    id_ser, err := id.MarshalBinary()
+    if err != nil {
+      return fmt.Errorf("Failed to serialize node_id: %w", err)
+    }
+
+    ext, _, err = db.loadExtension(ctx, tx, id_ser, ext_type)
+    return err
+  })
+
+  if err != nil {
+    return nil, err
+  }
+
+  return ext, nil
+}
+
+func (db *BadgerDB) loadNodeHistory(ctx *Context, tx *badger.Txn, history_id []byte) ([]NodeVersion, error) {
+  history_item, err := tx.Get(history_id)
+  if err == badger.ErrKeyNotFound {
+    return []NodeVersion{}, nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  var history []NodeVersion
+  err = history_item.Value(func(val []byte) error {
+    val, err := openAtRest(ctx, history_id, val)
+    if err != nil {
+      return err
+    }
+    history, err = Deserialize[[]NodeVersion](ctx, val)
+    return err
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  return history, nil
+}
+
+func (db *BadgerDB) WriteNodeVersion(ctx *Context, id NodeID, version NodeVersion, keep int) error {
+  db.Lock()
+  defer db.Unlock()
+
+  return db.Update(func(tx *badger.Txn) error {
+
+    id_ser, err := id.MarshalBinary()
+    if err != nil {
+      return err
+    }
+
+    history_id := append(id_ser, []byte(" - HISTORY")...)
+    history, err := db.loadNodeHistory(ctx, tx, history_id)
+    if err != nil {
+      return fmt.Errorf("Failed to load history for %s: %w", id, err)
+    }
+
+    history, err = appendVersion(history, version, keep)
+    if err != nil {
+      return fmt.Errorf("Failed to add version to history for %s: %w", id, err)
+    }
+
+    written, err := Serialize(ctx, history, db.buffer[:])
+    if err != nil {
+      return fmt.Errorf("Failed to serialize history for %s: %w", id, err)
+    }
+
+    sealed, err := sealAtRest(ctx, history_id, db.buffer[:written])
+    if err != nil {
+      return err
+    }
+    return tx.Set(history_id, sealed)
+  })
+}
+
+func (db *BadgerDB) LoadNodeHistory(ctx *Context, id NodeID) ([]NodeVersion, error) {
+  var history []NodeVersion
+  err := db.View(func(tx *badger.Txn) error {
+    id_ser, err := id.MarshalBinary()
+    if err != nil {
+      return err
+    }
+
+    history, err = db.loadNodeHistory(ctx, tx, append(id_ser, []byte(" - HISTORY")...))
+    return err
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  return history, nil
+}
+
+func (db *BadgerDB) CountNode(ctx *Context, node_type NodeType, limit int) error {
+  return db.Update(func(tx *badger.Txn) error {
+    count_id := binary.BigEndian.AppendUint64(slices.Clone(nodeCountPrefix), uint64(node_type))
+
+    var count uint64 = 0
+    count_item, err := tx.Get(count_id)
+    if err == nil {
+      err = count_item.Value(func(val []byte) error {
+        if len(val) != 8 {
+          return fmt.Errorf("Node count for %s is %d bytes", node_type, len(val))
+        }
+        count = binary.BigEndian.Uint64(val)
+        return nil
+      })
+      if err != nil {
+        return err
+      }
+    } else if err != badger.ErrKeyNotFound {
+      return err
+    }
+
+    if limit > 0 && count >= uint64(limit) {
+      return fmt.Errorf("Cannot create more than %d %s nodes: %w", limit, node_type, QuotaExceededError)
+    }
+
+    return tx.Set(count_id, binary.BigEndian.AppendUint64(nil, count + 1))
+  })
+}
+
+func (db *BadgerDB) UncountNode(ctx *Context, node_type NodeType) error {
+  return db.Update(func(tx *badger.Txn) error {
+    count_id := binary.BigEndian.AppendUint64(slices.Clone(nodeCountPrefix), uint64(node_type))
+
+    count_item, err := tx.Get(count_id)
+    if err == badger.ErrKeyNotFound {
+      return nil
+    } else if err != nil {
+      return err
+    }
+
+    var count uint64 = 0
+    err = count_item.Value(func(val []byte) error {
+      if len(val) != 8 {
+        return fmt.Errorf("Node count for %s is %d bytes", node_type, len(val))
+      }
+      count = binary.BigEndian.Uint64(val)
+      return nil
+    })
+    if err != nil {
+      return err
+    } else if count == 0 {
+      return nil
+    }
+
+    return tx.Set(count_id, binary.BigEndian.AppendUint64(nil, count - 1))
+  })
+}
+
+func aliasKey(alias string) []byte {
+  return append([]byte("ALIAS - "), []byte(alias)...)
+}
+
+func (db *BadgerDB) loadAlias(tx *badger.Txn, alias string) (NodeID, error) {
+  alias_item, err := tx.Get(aliasKey(alias))
+  if err == badger.ErrKeyNotFound {
+    return ZeroID, fmt.Errorf("%s: %w", alias, AliasNotFoundError)
+  } else if err != nil {
+    return ZeroID, err
+  }
+
+  var id NodeID
+  err = alias_item.Value(func(val []byte) error {
+    return id.UnmarshalBinary(val)
+  })
+  return id, err
+}
+
+func (db *BadgerDB) WriteAlias(ctx *Context, alias string, id NodeID) error {
+  return db.Update(func(tx *badger.Txn) error {
+    existing, err := db.loadAlias(tx, alias)
+    if err == nil && existing != id {
+      return fmt.Errorf("%s is assigned to %s: %w", alias, existing, AliasTakenError)
+    } else if err != nil && errors.Is(err, AliasNotFoundError) == false {
+      return err
+    }
+
+    id_ser, err := id.MarshalBinary()
+    if err != nil {
+      return err
+    }
+
+    return tx.Set(aliasKey(alias), id_ser)
+  })
+}
+
+func (db *BadgerDB) RemoveAlias(ctx *Context, alias string) (NodeID, error) {
+  var id NodeID
+  err := db.Update(func(tx *badger.Txn) error {
+    var err error
+    id, err = db.loadAlias(tx, alias)
+    if err != nil {
+      return err
+    }
+
+    return tx.Delete(aliasKey(alias))
+  })
+  return id, err
+}
+
+func (db *BadgerDB) LoadAlias(ctx *Context, alias string) (NodeID, error) {
+  var id NodeID
+  err := db.View(func(tx *badger.Txn) error {
+    var err error
+    id, err = db.loadAlias(tx, alias)
+    return err
+  })
+  return id, err
+}
+
+func txDecisionKey(tx_id uuid.UUID) []byte {
+  return append([]byte("TX - "), tx_id[:]...)
+}
+
+func (db *BadgerDB) WriteTxDecision(ctx *Context, tx_id uuid.UUID, commit bool) (bool, error) {
+  decision := commit
+  err := db.Update(func(tx *badger.Txn) error {
+    item, err := tx.Get(txDecisionKey(tx_id))
+    if err == nil {
+      return item.Value(func(val []byte) error {
+        decision = bytes.Equal(val, []byte{1})
+        return nil
+      })
+    } else if err != badger.ErrKeyNotFound {
+      return err
+    }
+
+    value := []byte{0}
+    if commit {
+      value[0] = 1
+    }
+    return tx.Set(txDecisionKey(tx_id), value)
+  })
+  return decision, err
+}
+
+func (db *BadgerDB) RemoveTxDecision(ctx *Context, tx_id uuid.UUID) error {
+  return db.Update(func(tx *badger.Txn) error {
+    return tx.Delete(txDecisionKey(tx_id))
+  })
+}
+
+var autoLoadPrefix = []byte("AUTOLOAD - ")
+
+func (db *BadgerDB) WriteAutoLoad(ctx *Context, id NodeID, enabled bool) error {
+  return db.Update(func(tx *badger.Txn) error {
+    id_ser, err := id.MarshalBinary()
+    if err != nil {
+      return err
+    }
+
+    key := append(slices.Clone(autoLoadPrefix), id_ser...)
+    if enabled {
+      return tx.Set(key, []byte{})
+    } else {
+      return tx.Delete(key)
+    }
+  })
+}
+
+func (db *BadgerDB) LoadAutoLoad(ctx *Context) ([]NodeID, error) {
+  ids := []NodeID{}
+  err := db.View(func(tx *badger.Txn) error {
+    options := badger.DefaultIteratorOptions
+    options.PrefetchValues = false
+    iter := tx.NewIterator(options)
+    defer iter.Close()
+
+    for iter.Seek(autoLoadPrefix); iter.ValidForPrefix(autoLoadPrefix); iter.Next() {
+      var id NodeID
+      err := id.UnmarshalBinary(iter.Item().Key()[len(autoLoadPrefix):])
+      if err != nil {
+        return err
+      }
+      ids = append(ids, id)
+    }
+    return nil
+  })
+  return ids, err
+}
+
+var referencePrefix = []byte("BACKREF - ")
+
+// Key for a reference from the source's field to the target, prefixed by the target so its references can be iterated
+func referenceKey(target []byte, source []byte, ext_type ExtType, tag Tag) []byte {
+  key := append(slices.Clone(referencePrefix), target...)
+  key = append(key, source...)
+  key = binary.BigEndian.AppendUint64(key, uint64(ext_type))
+  return append(key, []byte(tag)...)
+}
+
+// Update the reference index for a field, using the IDs it held when last written to remove stale references
+func (db *BadgerDB) writeReferences(tx *badger.Txn, id_ser []byte, ext_type ExtType, tag Tag, ids []NodeID) error {
+  refs_id := binary.BigEndian.AppendUint64(append(slices.Clone(id_ser), []byte(" - REFS")...), uint64(ext_type))
+  refs_id = append(refs_id, []byte(tag)...)
+
+  old := map[NodeID]bool{}
+  refs_item, err := tx.Get(refs_id)
+  if err == nil {
+    err = refs_item.Value(func(val []byte) error {
+      for i := 0; i + 16 <= len(val); i += 16 {
+        old[NodeID(val[i:i+16])] = true
+      }
+      return nil
+    })
+    if err != nil {
+      return err
+    }
+  } else if err != badger.ErrKeyNotFound {
+    return err
+  }
+
+  refs := make([]byte, 0, 16*len(ids))
+  for _, id := range(ids) {
+    refs = append(refs, id[:]...)
+    if old[id] {
+      delete(old, id)
+    } else {
+      err := tx.Set(referenceKey(id[:], id_ser, ext_type, tag), []byte{})
+      if err != nil {
+        return err
+      }
+    }
+  }
+
+  for id := range(old) {
+    err := tx.Delete(referenceKey(id[:], id_ser, ext_type, tag))
+    if err != nil {
+      return err
+    }
+  }
+
+  if len(refs) == 0 {
+    if err == badger.ErrKeyNotFound {
+      return nil
+    }
+    return tx.Delete(refs_id)
+  }
+  return tx.Set(refs_id, refs)
+}
+
+func (db *BadgerDB) LoadReferences(ctx *Context, id NodeID) ([]Reference, error) {
+  references := []Reference{}
+  err := db.View(func(tx *badger.Txn) error {
+    prefix := append(slices.Clone(referencePrefix), id[:]...)
+    options := badger.DefaultIteratorOptions
+    options.PrefetchValues = false
+    iter := tx.NewIterator(options)
+    defer iter.Close()
+
+    for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+      key := iter.Item().Key()[len(prefix):]
+      if len(key) < 24 {
+        return fmt.Errorf("Reference key for %s is too short: %x", id, key)
+      }
+      references = append(references, Reference{
+        Source: NodeID(key[:16]),
+        Extension: ExtType(binary.BigEndian.Uint64(key[16:24])),
+        Field: Tag(key[24:]),
+      })
+    }
+    return nil
+  })
+  return references, err
+}
+
+// Every node record has a key under this prefix, since other keys like aliases can be the same length as a node ID
+var nodeIndexPrefix = []byte("NODE - ")
+
+func nodeIndexKey(id NodeID) []byte {
+  return append(slices.Clone(nodeIndexPrefix), id[:]...)
+}
+
+func (db *BadgerDB) LoadNodeIDs(ctx *Context) ([]NodeID, error) {
+  ids := []NodeID{}
+  err := db.View(func(tx *badger.Txn) error {
+    options := badger.DefaultIteratorOptions
+    options.PrefetchValues = false
+    options.Prefix = nodeIndexPrefix
+    iter := tx.NewIterator(options)
+    defer iter.Close()
+
+    for iter.Rewind(); iter.Valid(); iter.Next() {
+      key := iter.Item().Key()
+      if len(key) != len(nodeIndexPrefix) + 16 {
+        return fmt.Errorf("Invalid node index key %x", key)
+      }
+      ids = append(ids, NodeID(key[len(nodeIndexPrefix):]))
+    }
+    return nil
+  })
+  return ids, err
+}
+
+// Add the nodes in a DB written before the node index to it, returning how many were added.
+// A key is only taken to be a node record if it has an extension list and loads as a node with that ID.
+func (db *BadgerDB) IndexNodes(ctx *Context) (int, error) {
+  candidates := []NodeID{}
+  err := db.View(func(tx *badger.Txn) error {
+    options := badger.DefaultIteratorOptions
+    options.PrefetchValues = false
+    iter := tx.NewIterator(options)
+    defer iter.Close()
+
+    for iter.Rewind(); iter.Valid(); iter.Next() {
+      key := iter.Item().Key()
+      if len(key) != 16 {
+        continue
+      }
+
+      _, err := tx.Get(append(slices.Clone(key), []byte(" - EXTLIST")...))
+      if err == nil {
+        candidates = append(candidates, NodeID(key))
+      } else if errors.Is(err, badger.ErrKeyNotFound) == false {
+        return err
+      }
+    }
+    return nil
+  })
+  if err != nil {
+    return 0, err
+  }
+
+  indexed := 0
+  for _, id := range(candidates) {
+    node, err := db.LoadNode(ctx, id)
+    if err != nil || node.ID != id {
+      ctx.Log.Logf("db", "Not indexing %x, it isn't a node record", id[:])
+      continue
+    }
+
+    err = db.Update(func(tx *badger.Txn) error {
+      return tx.Set(nodeIndexKey(id), nil)
+    })
+    if err != nil {
+      return indexed, err
+    }
+    indexed += 1
+  }
+  return indexed, nil
+}
+
+var nodeCountPrefix = []byte("NODECOUNT - ")
+
+func (db *BadgerDB) LoadStats(ctx *Context) (DBStats, error) {
+  stats := DBStats{
+    Nodes: map[NodeType]int{},
+    Extensions: map[ExtType]int{},
+    Edges: map[ExtType]map[Tag]int{},
+  }
+
+  ext_list_suffix := []byte(" - EXTLIST")
+  err := db.View(func(tx *badger.Txn) error {
+    options := badger.DefaultIteratorOptions
+    options.PrefetchValues = false
+    iter := tx.NewIterator(options)
+    defer iter.Close()
+
+    for iter.Rewind(); iter.Valid(); iter.Next() {
+      item := iter.Item()
+      key := item.Key()
+      if bytes.HasPrefix(key, nodeCountPrefix) {
+        if len(key) != len(nodeCountPrefix) + 8 {
+          return fmt.Errorf("Node count key is %d bytes", len(key))
+        }
+        node_type := NodeType(binary.BigEndian.Uint64(key[len(nodeCountPrefix):]))
+        err := item.Value(func(val []byte) error {
+          if len(val) != 8 {
+            return fmt.Errorf("Node count for %s is %d bytes", node_type, len(val))
+          }
+          stats.Nodes[node_type] = int(binary.BigEndian.Uint64(val))
+          return nil
+        })
         if err != nil {
-          return fmt.Errorf("Failed to find key for %s:%s(%x) - %w", ext_type, field_tag, field_id, err)
+          return err
+        }
+      } else if bytes.HasPrefix(key, referencePrefix) {
+        ref := key[len(referencePrefix):]
+        if len(ref) < 40 {
+          return fmt.Errorf("Reference key is too short: %x", ref)
+        }
+        ext_type := ExtType(binary.BigEndian.Uint64(ref[32:40]))
+        if stats.Edges[ext_type] == nil {
+          stats.Edges[ext_type] = map[Tag]int{}
         }
-        err = field_item.Value(func(val []byte) error {
-          value, _, err := DeserializeValue(ctx, val, field_info.Type)
+        stats.Edges[ext_type][Tag(ref[40:])] += 1
+      } else if len(key) == 16 + len(ext_list_suffix) && bytes.HasSuffix(key, ext_list_suffix) {
+        err := item.Value(func(val []byte) error {
+          ext_list, err := Deserialize[[]ExtType](ctx, val)
           if err != nil {
             return err
           }
-
-          ext.Elem().FieldByIndex(field_info.Index).Set(value)
-
+          for _, ext_type := range(ext_list) {
+            stats.Extensions[ext_type] += 1
+          }
           return nil
         })
         if err != nil {
           return err
         }
       }
-
-      node.Extensions[ext_type] = ext.Interface().(Extension)
     }
-
     return nil
   })
-
   if err != nil {
-    return nil, err
-  } else if node == nil {
-    return nil, fmt.Errorf("Tried to return nil *Node from BadgerDB.LoadNode without error")
+    return DBStats{}, err
   }
 
-  return node, nil
-} 
+  lsm, vlog := db.Size()
+  stats.Size = lsm + vlog
+  return stats, nil
+}
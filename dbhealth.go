@@ -0,0 +1,401 @@
+package graphvent
+
+import (
+  "errors"
+  "fmt"
+  "slices"
+  "sync"
+  "time"
+)
+
+var DBUnavailableError = errors.New("DB is unavailable")
+
+// Most DBHealthSignals waiting to be sent to DegradedDBConfig.Target, more are dropped
+const DB_HEALTH_QUEUE = 16
+
+// Sent by a DegradedDB when its writes start failing, and again when they succeed
+type DBHealthSignal struct {
+  SignalHeader
+  Healthy bool `gv:"healthy"`
+  // Number of writes waiting for the DB
+  Queued int `gv:"queued"`
+  // The last error from the DB, empty once it's healthy
+  Error string `gv:"error"`
+}
+func (signal DBHealthSignal) String() string {
+  return fmt.Sprintf("DBHealthSignal(%s, %t, %d, %s)", signal.SignalHeader, signal.Healthy, signal.Queued, signal.Error)
+}
+func NewDBHealthSignal(healthy bool, queued int, err string) *DBHealthSignal {
+  return &DBHealthSignal{
+    NewSignalHeader(),
+    healthy,
+    queued,
+    err,
+  }
+}
+
+type DegradedDBConfig struct {
+  // Most writes queued while the DB is failing, writes past it fail with DBUnavailableError
+  MaxQueued int
+  // Once the DB has been failing for this long new writes fail instead of being queued, 0 to queue until MaxQueued
+  MaxDuration time.Duration
+  // How often the queued writes are retried
+  RetryInterval time.Duration
+  // Sent a DBHealthSignal when the DB becomes unhealthy or recovers, the signal is only logged if ZeroID
+  Target NodeID
+}
+
+type queuedWrite struct {
+  node NodeID
+  write func() error
+}
+
+type healthNotice struct {
+  ctx *Context
+  signal *DBHealthSignal
+}
+
+// Wraps a Database so failing writes are queued in memory and retried in order instead of failing the signal that made them.
+// While writes are queued every new write is queued behind them, and nodes with queued writes can't be loaded from the DB
+// since it has an old version of them. Reads and alias writes go directly to the wrapped DB.
+type DegradedDB struct {
+  Database
+  config DegradedDBConfig
+
+  lock sync.Mutex
+  ctx *Context
+  queue []queuedWrite
+  pending map[NodeID]int
+  since time.Time
+  err error
+
+  // Writes can be made with ctx.nodesLock held, so health signals are sent from the retry goroutine instead
+  health chan healthNotice
+  stop chan struct{}
+  done chan struct{}
+}
+
+// Wrap db and start retrying queued writes every config.RetryInterval
+func NewDegradedDB(db Database, config DegradedDBConfig) (*DegradedDB, error) {
+  if config.MaxQueued <= 0 || config.RetryInterval <= 0 {
+    return nil, fmt.Errorf("DegradedDB needs a positive max queued and retry interval, got %d and %s", config.MaxQueued, config.RetryInterval)
+  }
+
+  degraded := &DegradedDB{
+    Database: db,
+    config: config,
+    pending: map[NodeID]int{},
+    health: make(chan healthNotice, DB_HEALTH_QUEUE),
+    stop: make(chan struct{}),
+    done: make(chan struct{}),
+  }
+
+  go func() {
+    defer close(degraded.done)
+    ticker := time.NewTicker(config.RetryInterval)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-degraded.stop:
+        return
+      case notice := <-degraded.health:
+        degraded.sendHealth(notice.ctx, notice.signal)
+      case <-ticker.C:
+        degraded.Flush()
+      }
+    }
+  }()
+
+  return degraded, nil
+}
+
+func (db *DegradedDB) Stop() {
+  close(db.stop)
+  <-db.done
+}
+
+// Whether the DB is healthy, how many writes are queued, and the last error if it isn't
+func (db *DegradedDB) Health() (bool, int, error) {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+  return len(db.queue) == 0, len(db.queue), db.err
+}
+
+// Whether the context's DB is queueing writes
+func (ctx *Context) dbDegraded() bool {
+  db, degradable := ctx.DB.(*DegradedDB)
+  if degradable == false {
+    return false
+  }
+  healthy, _, _ := db.Health()
+  return healthy == false
+}
+
+// Try write, queueing the write from snapshot if it fails or other writes are already queued.
+// snapshot copies anything the write reads that could change before it's retried.
+func (db *DegradedDB) write(ctx *Context, id NodeID, write func() error, snapshot func() (func() error, error)) error {
+  db.lock.Lock()
+  if len(db.queue) == 0 {
+    err := write()
+    if err == nil {
+      db.lock.Unlock()
+      return nil
+    } else if errors.Is(err, NodeLoadedError) {
+      // Refused because of the node, not because the DB is failing
+      db.lock.Unlock()
+      return err
+    }
+    db.ctx = ctx
+    db.since = time.Now()
+    db.err = err
+  } else if len(db.queue) >= db.config.MaxQueued {
+    defer db.lock.Unlock()
+    return fmt.Errorf("Cannot queue write to %s, %d writes are already queued: %w(%s)", id, len(db.queue), DBUnavailableError, db.err)
+  } else if db.config.MaxDuration > 0 && time.Since(db.since) > db.config.MaxDuration {
+    defer db.lock.Unlock()
+    return fmt.Errorf("Cannot queue write to %s, DB has been failing since %s: %w(%s)", id, db.since, DBUnavailableError, db.err)
+  }
+
+  queued, err := snapshot()
+  if err != nil {
+    defer db.lock.Unlock()
+    return fmt.Errorf("Failed to queue write to %s: %w", id, err)
+  }
+
+  db.queue = append(db.queue, queuedWrite{id, queued})
+  db.pending[id] += 1
+  became_unhealthy := len(db.queue) == 1
+  depth := len(db.queue)
+  cause := db.err
+  db.lock.Unlock()
+
+  if became_unhealthy {
+    ctx.Log.Logf("db", "DB_UNHEALTHY: queueing writes after %s", cause)
+    db.notify(ctx, false, depth, cause)
+  }
+  return nil
+}
+
+// Retry the queued writes in order, stopping at the first one that fails
+func (db *DegradedDB) Flush() error {
+  db.lock.Lock()
+  if len(db.queue) == 0 {
+    db.lock.Unlock()
+    return nil
+  }
+
+  for len(db.queue) > 0 {
+    err := db.queue[0].write()
+    if err != nil {
+      db.err = err
+      db.lock.Unlock()
+      return fmt.Errorf("%d writes still queued: %w", len(db.queue), err)
+    }
+
+    id := db.queue[0].node
+    db.pending[id] -= 1
+    if db.pending[id] == 0 {
+      delete(db.pending, id)
+    }
+    db.queue = db.queue[1:]
+  }
+
+  ctx := db.ctx
+  down := time.Since(db.since)
+  db.queue = nil
+  db.err = nil
+  db.lock.Unlock()
+
+  ctx.Log.Logf("db", "DB_RECOVERED: after %s", down)
+  db.notify(ctx, true, 0, nil)
+  return nil
+}
+
+func (db *DegradedDB) notify(ctx *Context, healthy bool, queued int, cause error) {
+  event_type := EventDBRecovered
+  if healthy == false {
+    event_type = EventDBUnhealthy
+  }
+  ctx.publishEvent(ContextEvent{
+    Type: event_type,
+  })
+
+  if db.config.Target == ZeroID {
+    return
+  }
+
+  err_str := ""
+  if cause != nil {
+    err_str = cause.Error()
+  }
+
+  signal := NewDBHealthSignal(healthy, queued, err_str)
+  select {
+  case db.health <- healthNotice{ctx, signal}:
+  default:
+    ctx.Log.Logf("db", "DB_HEALTH_SEND_ERR: %d signals already queued, dropping %s", DB_HEALTH_QUEUE, signal)
+  }
+}
+
+// Send a health signal to the target, must be called without ctx.nodesLock held
+func (db *DegradedDB) sendHealth(ctx *Context, signal *DBHealthSignal) {
+  ctx.nodesLock.RLock()
+  target, loaded := ctx.nodes[db.config.Target]
+  ctx.nodesLock.RUnlock()
+  if loaded == false {
+    ctx.Log.Logf("db", "DB_HEALTH_SEND_ERR: %s is not loaded", db.config.Target)
+    return
+  }
+
+  // There's no node that caused the change, so the target sends it to itself
+  err := ctx.Send(target.Node, []Message{{db.config.Target, signal}})
+  if err != nil {
+    ctx.Log.Logf("db", "DB_HEALTH_SEND_ERR: %s", err)
+  }
+}
+
+// Copy the parts of a node the DB writes, so it can be written after the node has changed
+func snapshotNode(ctx *Context, node *Node) (*Node, error) {
+  snapshot := &Node{
+    Key: node.Key,
+    Public: node.Public,
+    ID: node.ID,
+    Type: node.Type,
+    Extensions: map[ExtType]Extension{},
+    writeSignalQueue: node.writeSignalQueue,
+    SignalQueue: slices.Clone(node.SignalQueue),
+    outbox: slices.Clone(node.outbox),
+    writeOutbox: node.writeOutbox,
+    writePrepared: node.writePrepared,
+    initKey: node.initKey,
+    attached: slices.Clone(node.attached),
+    unknownExtensions: node.unknownExtensions,
+  }
+
+  if node.prepared != nil {
+    snapshot.prepared = &preparedTx{
+      Source: node.prepared.Source,
+      Signal: node.prepared.Signal,
+    }
+  }
+
+  for ext_type, ext := range(node.Extensions) {
+    clone, err := CloneExtension(ctx, ext)
+    if err != nil {
+      return nil, err
+    }
+    snapshot.Extensions[ext_type] = clone
+  }
+  return snapshot, nil
+}
+
+// The DB clears the write flags of a node as it writes, so they're put back if the write fails
+type nodeWriteFlags struct {
+  writeSignalQueue bool
+  writeOutbox bool
+  writePrepared bool
+  attached []ExtType
+}
+
+func saveWriteFlags(node *Node) nodeWriteFlags {
+  return nodeWriteFlags{node.writeSignalQueue, node.writeOutbox, node.writePrepared, node.attached}
+}
+
+func (flags nodeWriteFlags) restore(node *Node) {
+  node.writeSignalQueue = flags.writeSignalQueue
+  node.writeOutbox = flags.writeOutbox
+  node.writePrepared = flags.writePrepared
+  node.attached = flags.attached
+}
+
+func (db *DegradedDB) writeNode(ctx *Context, node *Node, write func(*Node) error) error {
+  return db.write(ctx, node.ID, func() error {
+    flags := saveWriteFlags(node)
+    err := write(node)
+    if err != nil {
+      flags.restore(node)
+    }
+    return err
+  }, func() (func() error, error) {
+    snapshot, err := snapshotNode(ctx, node)
+    if err != nil {
+      return nil, err
+    }
+    return func() error {
+      return write(snapshot)
+    }, nil
+  })
+}
+
+func (db *DegradedDB) WriteNodeInit(ctx *Context, node *Node) error {
+  if node == nil {
+    return fmt.Errorf("Cannot serialize nil *Node")
+  }
+  return db.writeNode(ctx, node, func(node *Node) error {
+    return db.Database.WriteNodeInit(ctx, node)
+  })
+}
+
+func (db *DegradedDB) WriteNodeChanges(ctx *Context, node *Node, changes Changes) error {
+  changes = slices.Clone(changes)
+  return db.writeNode(ctx, node, func(node *Node) error {
+    return db.Database.WriteNodeChanges(ctx, node, changes)
+  })
+}
+
+func (db *DegradedDB) WriteExtension(ctx *Context, id NodeID, ext Extension) error {
+  return db.write(ctx, id, func() error {
+    return db.Database.WriteExtension(ctx, id, ext)
+  }, func() (func() error, error) {
+    clone, err := CloneExtension(ctx, ext)
+    if err != nil {
+      return nil, err
+    }
+    return func() error {
+      return db.Database.WriteExtension(ctx, id, clone)
+    }, nil
+  })
+}
+
+func (db *DegradedDB) WriteNodeVersion(ctx *Context, id NodeID, version NodeVersion, keep int) error {
+  write := func() error {
+    return db.Database.WriteNodeVersion(ctx, id, version, keep)
+  }
+  return db.write(ctx, id, write, func() (func() error, error) {
+    return write, nil
+  })
+}
+
+func (db *DegradedDB) WriteAutoLoad(ctx *Context, id NodeID, enabled bool) error {
+  write := func() error {
+    return db.Database.WriteAutoLoad(ctx, id, enabled)
+  }
+  return db.write(ctx, id, write, func() (func() error, error) {
+    return write, nil
+  })
+}
+
+func (db *DegradedDB) checkPending(id NodeID) error {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+  if db.pending[id] > 0 {
+    return fmt.Errorf("%s has %d writes queued: %w(%s)", id, db.pending[id], DBUnavailableError, db.err)
+  }
+  return nil
+}
+
+func (db *DegradedDB) LoadNode(ctx *Context, id NodeID) (*Node, error) {
+  err := db.checkPending(id)
+  if err != nil {
+    return nil, err
+  }
+  return db.Database.LoadNode(ctx, id)
+}
+
+func (db *DegradedDB) LoadExtension(ctx *Context, id NodeID, ext_type ExtType) (Extension, error) {
+  err := db.checkPending(id)
+  if err != nil {
+    return nil, err
+  }
+  return db.Database.LoadExtension(ctx, id, ext_type)
+}
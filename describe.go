@@ -0,0 +1,236 @@
+package graphvent
+
+import (
+  "encoding/binary"
+  "encoding/hex"
+  "fmt"
+  "reflect"
+  "strings"
+)
+
+// Most bytes of undecoded data shown after an error
+const DESCRIBE_HEX_LIMIT = 64
+
+// Walks binary data like DeserializeValue, writing a line for every value it decodes
+type describer struct {
+  ctx *Context
+  data []byte
+  offset int
+  out strings.Builder
+}
+
+// Describe the value in a human readable tree of type names, field names, and decoded primitives, for debugging serialization.
+// If the data can't be decoded the tree up to the failure is returned along with the error, and the tree ends with the offset and the bytes that were left.
+func Describe(ctx *Context, value SerializedValue) (string, error) {
+  data, err := value.decompress()
+  if err != nil {
+    return "", err
+  }
+
+  d := &describer{
+    ctx: ctx,
+    data: data,
+  }
+  err = d.value(reflect.TypeFor[any](), "value", 0)
+  if err == nil && len(d.data) != 0 {
+    err = fmt.Errorf("%d bytes left after value", len(d.data))
+  }
+  if err != nil {
+    d.line(0, "!! at byte %d: %s", d.offset, err)
+    left := d.data
+    if len(left) > DESCRIBE_HEX_LIMIT {
+      left = left[:DESCRIBE_HEX_LIMIT]
+    }
+    d.line(0, "!! left(%d bytes): %s", len(d.data), hex.EncodeToString(left))
+  }
+  return d.out.String(), err
+}
+
+func (d *describer) line(depth int, format string, args ...any) {
+  d.out.WriteString(strings.Repeat("  ", depth))
+  d.out.WriteString(fmt.Sprintf(format, args...))
+  d.out.WriteString("\n")
+}
+
+func (d *describer) take(n int) ([]byte, error) {
+  if n < 0 || n > len(d.data) {
+    return nil, fmt.Errorf("need %d bytes, only %d left", n, len(d.data))
+  }
+  used := d.data[:n]
+  d.data = d.data[n:]
+  d.offset += n
+  return used, nil
+}
+
+func (d *describer) uint64() (uint64, error) {
+  used, err := d.take(8)
+  if err != nil {
+    return 0, err
+  }
+  return binary.BigEndian.Uint64(used), nil
+}
+
+// Decode a value without walking into it, recovering if the data ends before the value does since deserializers don't check lengths
+func (d *describer) leaf(t reflect.Type) (value reflect.Value, err error) {
+  defer func() {
+    if r := recover(); r != nil {
+      err = fmt.Errorf("data ended while decoding %s: %v", t, r)
+    }
+  }()
+
+  value, left, err := DeserializeValue(d.ctx, d.data, t)
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  d.offset += len(d.data) - len(left)
+  d.data = left
+  return value, nil
+}
+
+// Read a type stack, recovering if the data ends during it
+func (d *describer) stack() (t reflect.Type, err error) {
+  defer func() {
+    if r := recover(); r != nil {
+      err = fmt.Errorf("data ended during type stack: %v", r)
+    }
+  }()
+
+  t, left, err := UnwrapStack(d.ctx, d.data)
+  if err != nil {
+    return nil, err
+  }
+  d.offset += len(d.data) - len(left)
+  d.data = left
+  return t, nil
+}
+
+func (d *describer) value(t reflect.Type, label string, depth int) error {
+  info, registered := d.ctx.Types[t]
+  // Structs with generated deserializers are still walked, since they write the same fields
+  walk_struct := registered && t.Kind() == reflect.Struct && len(info.Fields) > 0
+  if (registered && info.Deserialize != nil && walk_struct == false) || basicKind(t.Kind()) {
+    value, err := d.leaf(t)
+    if err != nil {
+      return err
+    }
+    if t.Kind() == reflect.String {
+      d.line(depth, "%s: %s = %q", label, t, value.String())
+    } else {
+      d.line(depth, "%s: %s = %+v", label, t, value.Interface())
+    }
+    return nil
+  }
+
+  switch t.Kind() {
+  case reflect.Pointer, reflect.Slice, reflect.Map:
+    flag, err := d.take(1)
+    if err != nil {
+      return err
+    } else if flag[0] == 0x00 {
+      d.line(depth, "%s: %s = nil", label, t)
+      return nil
+    }
+
+    if t.Kind() == reflect.Pointer {
+      return d.value(t.Elem(), label, depth)
+    }
+
+    length, err := d.uint64()
+    if err != nil {
+      return err
+    }
+    d.line(depth, "%s: %s len %d", label, t, length)
+    for i := uint64(0); i < length; i++ {
+      if t.Kind() == reflect.Slice {
+        err = d.value(t.Elem(), fmt.Sprintf("[%d]", i), depth + 1)
+      } else {
+        err = d.value(t.Key(), fmt.Sprintf("key %d", i), depth + 1)
+        if err == nil {
+          err = d.value(t.Elem(), fmt.Sprintf("value %d", i), depth + 1)
+        }
+      }
+      if err != nil {
+        return err
+      }
+    }
+    return nil
+
+  case reflect.Array:
+    d.line(depth, "%s: %s", label, t)
+    for i := 0; i < t.Len(); i++ {
+      err := d.value(t.Elem(), fmt.Sprintf("[%d]", i), depth + 1)
+      if err != nil {
+        return err
+      }
+    }
+    return nil
+
+  case reflect.Interface:
+    if len(d.data) >= 8 && SerializedType(binary.BigEndian.Uint64(d.data[0:8])) == NilInterfaceType {
+      d.take(8)
+      d.line(depth, "%s: %s = nil", label, t)
+      return nil
+    }
+
+    elem_type, err := d.stack()
+    if err != nil {
+      return err
+    }
+    return d.value(elem_type, label, depth)
+
+  case reflect.Struct:
+    if registered == false {
+      return fmt.Errorf("%s is not a registered struct", t)
+    }
+
+    num_fields, err := d.uint64()
+    if err != nil {
+      return err
+    }
+    lengths := num_fields & StructFieldLengths != 0
+    num_fields &^= StructFieldLengths
+    d.line(depth, "%s: %s", label, t)
+
+    for i := uint64(0); i < num_fields; i++ {
+      tag, err := d.uint64()
+      if err != nil {
+        return err
+      }
+      field_tag := FieldTag(tag)
+
+      var length uint64
+      if lengths {
+        length, err = d.uint64()
+        if err != nil {
+          return err
+        }
+      }
+
+      field_info, mapped := info.Fields[field_tag]
+      if mapped == false {
+        if lengths == false {
+          return fmt.Errorf("Unknown field %s on struct %s", field_tag, t)
+        }
+        _, err = d.take(int(length))
+        if err != nil {
+          return err
+        }
+        d.line(depth + 1, "%s: unknown field, %d bytes skipped", field_tag, length)
+        continue
+      }
+
+      field_name := t.FieldByIndex(field_info.Index).Name
+      start := len(d.data)
+      err = d.value(field_info.Type, field_name, depth + 1)
+      if err != nil {
+        return err
+      } else if lengths && uint64(start - len(d.data)) != length {
+        return fmt.Errorf("Field %s on struct %s is %d bytes, but %d were decoded", field_name, t, length, start - len(d.data))
+      }
+    }
+    return nil
+
+  default:
+    return fmt.Errorf("Don't know how to describe %s", t)
+  }
+}
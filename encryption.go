@@ -0,0 +1,67 @@
+package graphvent
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "fmt"
+)
+
+// Encrypt node data written to the DB with AES-GCM keyed by secret, which has to be a 32 byte AES-256 key.
+// Node records, signal queues, outboxes, extension fields, and history are encrypted, while extension lists,
+// aliases, counts, and references stay readable so stats and the reference index don't need the key.
+//
+// Node records written without a master key are still read after it's set, but the other values aren't marked,
+// so the master key has to be set before the DB is first written or the DB moved over with DumpJSON and LoadJSON.
+// Should be set before any nodes are loaded.
+func (ctx *Context) SetMasterKey(secret []byte) error {
+  if len(secret) != 32 {
+    return fmt.Errorf("Master key must be 32 bytes, got %d", len(secret))
+  }
+
+  block, err := aes.NewCipher(secret)
+  if err != nil {
+    return err
+  }
+
+  aead, err := cipher.NewGCM(block)
+  if err != nil {
+    return err
+  }
+
+  ctx.masterKey = aead
+  return nil
+}
+
+// Encrypt a value stored under key if the context has a master key. The DB key is authenticated with the value
+// so encrypted values can't be moved between keys.
+func sealAtRest(ctx *Context, key []byte, value []byte) ([]byte, error) {
+  if ctx.masterKey == nil {
+    return value, nil
+  }
+
+  nonce := make([]byte, ctx.masterKey.NonceSize(), ctx.masterKey.NonceSize() + len(value) + ctx.masterKey.Overhead())
+  _, err := rand.Read(nonce)
+  if err != nil {
+    return nil, err
+  }
+  return ctx.masterKey.Seal(nonce, nonce, value, key), nil
+}
+
+// Decrypt a value sealed with sealAtRest, returning it unchanged if the context has no master key
+func openAtRest(ctx *Context, key []byte, value []byte) ([]byte, error) {
+  if ctx.masterKey == nil {
+    return value, nil
+  }
+
+  nonce_size := ctx.masterKey.NonceSize()
+  if len(value) < nonce_size {
+    return nil, fmt.Errorf("Encrypted value of %x is too short(%d bytes)", key, len(value))
+  }
+
+  opened, err := ctx.masterKey.Open(nil, value[:nonce_size], value[nonce_size:], key)
+  if err != nil {
+    return nil, fmt.Errorf("Failed to decrypt value of %x, the master key is wrong or the value is corrupt: %w", key, err)
+  }
+  return opened, nil
+}
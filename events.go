@@ -0,0 +1,116 @@
+package graphvent
+
+import (
+  "sync"
+  "time"
+)
+
+// Kind of internal event published on the context's event bus
+type ContextEventType string
+
+const (
+  // A node was added to the context, either newly created or loaded from the DB
+  EventNodeLoaded = ContextEventType("node_loaded")
+  // A node was stopped and removed from the context
+  EventNodeUnloaded = ContextEventType("node_unloaded")
+  // A node's changes were written to the DB
+  EventWriteFlushed = ContextEventType("write_flushed")
+  // A client connected to a long-lived transport like a GQL websocket
+  EventTransportConnected = ContextEventType("transport_connected")
+  // A client's transport connection was closed
+  EventTransportDisconnected = ContextEventType("transport_disconnected")
+  // A DegradedDB started queueing writes because the DB is failing
+  EventDBUnhealthy = ContextEventType("db_unhealthy")
+  // A DegradedDB wrote all of its queued writes
+  EventDBRecovered = ContextEventType("db_recovered")
+)
+
+// Notification from a context subsystem, separate from the signals sent between nodes.
+// Node is the node the event is about, or the server node for transport events.
+type ContextEvent struct {
+  Type ContextEventType
+  Time time.Time
+  Node NodeID
+  NodeType NodeType
+  // Client node for transport events
+  Client NodeID
+  // Transport name for transport events, e.g. "websocket"
+  Transport string
+}
+
+type eventSubscriber struct {
+  types map[ContextEventType]bool
+  channel chan ContextEvent
+}
+
+// Fans events out to subscribers, dropping them for subscribers that aren't keeping up so publishers never block
+type eventBus struct {
+  lock sync.RWMutex
+  next int
+  subscribers map[int]*eventSubscriber
+}
+
+func newEventBus() *eventBus {
+  return &eventBus{
+    subscribers: map[int]*eventSubscriber{},
+  }
+}
+
+// Subscribe to context events of the given types, or every type if none are given.
+// Events are dropped when the channel's buffer is full, since they're published from node goroutines and DB writes.
+// The returned function unsubscribes and closes the channel.
+func (ctx *Context) SubscribeEvents(buffer int, types ...ContextEventType) (<-chan ContextEvent, func()) {
+  subscriber := &eventSubscriber{
+    channel: make(chan ContextEvent, buffer),
+  }
+  if len(types) > 0 {
+    subscriber.types = map[ContextEventType]bool{}
+    for _, event_type := range(types) {
+      subscriber.types[event_type] = true
+    }
+  }
+
+  ctx.events.lock.Lock()
+  id := ctx.events.next
+  ctx.events.next += 1
+  ctx.events.subscribers[id] = subscriber
+  ctx.events.lock.Unlock()
+
+  var once sync.Once
+  return subscriber.channel, func() {
+    once.Do(func() {
+      ctx.events.lock.Lock()
+      defer ctx.events.lock.Unlock()
+      delete(ctx.events.subscribers, id)
+      close(subscriber.channel)
+    })
+  }
+}
+
+func (ctx *Context) publishEvent(event ContextEvent) {
+  if ctx.events == nil {
+    return
+  }
+  event.Time = time.Now()
+
+  ctx.events.lock.RLock()
+  defer ctx.events.lock.RUnlock()
+  for _, subscriber := range(ctx.events.subscribers) {
+    if subscriber.types != nil && subscriber.types[event.Type] == false {
+      continue
+    }
+
+    select {
+    case subscriber.channel <- event:
+    default:
+    }
+  }
+}
+
+func (ctx *Context) publishNodeEvent(event_type ContextEventType, node *Node) {
+  ctx.publishEvent(ContextEvent{
+    Type: event_type,
+    Node: node.ID,
+    NodeType: node.Type,
+  })
+}
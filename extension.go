@@ -1,7 +1,82 @@
 package graphvent
 
+//go:generate go run ./cmd/gvgen -type Change
+
 type Tag string
-type Changes []Tag
+
+type ChangeOp uint8
+const (
+  // The field was replaced with a new value
+  ChangeSet = ChangeOp(0)
+  // Entries were added to a collection field
+  ChangeAdd = ChangeOp(1)
+  // Entries were removed from a collection field
+  ChangeRemove = ChangeOp(2)
+)
+
+var ChangeOpStrings = map[ChangeOp]string{
+  ChangeSet: "Set",
+  ChangeAdd: "Add",
+  ChangeRemove: "Remove",
+}
+
+func (op ChangeOp) String() string {
+  str, mapped := ChangeOpStrings[op]
+  if mapped == false {
+    return "UNKNOWN_CHANGEOP"
+  } else {
+    return str
+  }
+}
+
+// A Change records an operation on a single field of an extension
+type Change struct {
+  Extension ExtType `gv:"extension"`
+  Field Tag `gv:"field"`
+  Op ChangeOp `gv:"op"`
+}
+
+// Changes are returned from Extension.Process to list the fields that were modified.
+// Each extension builds it's own Changes, so parallel extensions don't share them.
+type Changes []Change
+
+// Add a change to each of the fields, skipping any that are already in changes with the same op
+func (changes *Changes) Add(ext ExtType, op ChangeOp, fields ...Tag) {
+  for _, field := range(fields) {
+    change := Change{ext, field, op}
+    duplicate := false
+    for _, existing := range(*changes) {
+      if existing == change {
+        duplicate = true
+        break
+      }
+    }
+
+    if duplicate == false {
+      *changes = append(*changes, change)
+    }
+  }
+}
+
+// Return the fields changed for each extension
+func (changes Changes) ByExtension() map[ExtType][]Tag {
+  fields := map[ExtType][]Tag{}
+  for _, change := range(changes) {
+    ext_fields := fields[change.Extension]
+    found := false
+    for _, field := range(ext_fields) {
+      if field == change.Field {
+        found = true
+        break
+      }
+    }
+
+    if found == false {
+      fields[change.Extension] = append(ext_fields, change.Field)
+    }
+  }
+  return fields
+}
 
 // Extensions are data attached to nodes that process signals
 type Extension interface {
@@ -14,3 +89,41 @@ type Extension interface {
   // Called when the node is unloaded from a context(deletion or move), so extension data can be cleaned up
   Unload(*Context, *Node)
 }
+
+// Extensions that only read node state(or only modify their own unserialized state) can implement ParallelExtension
+// to have Process run concurrently with the rest of the node's extensions
+type ParallelExtension interface {
+  Parallel() bool
+}
+
+// Extensions that implement OrderedExtension are processed after the listed extensions when both are on a node
+type OrderedExtension interface {
+  ProcessAfter() []ExtType
+}
+
+// Extensions that implement DependentExtension can only be added to nodes that have the listed extensions,
+// which are loaded before it and unloaded after it
+type DependentExtension interface {
+  Requires() []ExtType
+}
+
+// Extensions that implement AutoAttachExtension are attached to nodes that don't have them by GetOrAttachExt,
+// using the extension returned by AutoAttach
+type AutoAttachExtension interface {
+  AutoAttach() Extension
+}
+
+// Extensions that implement ClonedExtension are reset by CloneNode after they're copied, before the NodeIDs in remap are replaced,
+// so state that belongs to the original node isn't shared with the clone
+type ClonedExtension interface {
+  Cloned(ctx *Context, remap map[NodeID]NodeID)
+}
+
+// Extensions that implement VersionedExtension have their version written with their fields. When a node is loaded with
+// an older version, the fields whose gv tags still exist are loaded as-is, then MigrateFrom is called with every field
+// that was written keyed by its FieldTag, so added, removed, and renamed fields can be converted. A field whose type
+// changes needs a new gv tag. Migrated extensions are written in full the next time the node is written.
+type VersionedExtension interface {
+  Version() uint64
+  MigrateFrom(ctx *Context, version uint64, fields map[FieldTag][]byte) error
+}
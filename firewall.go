@@ -0,0 +1,160 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+  "sync"
+  "time"
+)
+
+type FirewallAction uint8
+const (
+  // Matching signals are rejected
+  FirewallDrop = FirewallAction(0)
+  // Each source can send Limit matching signals every Interval, the rest are rejected
+  FirewallRateLimit = FirewallAction(1)
+)
+
+// A rule matches signals that match all of its set fields
+type FirewallRule struct {
+  Name string
+  // Name of the signal type, empty matches every type
+  SignalType string
+  // ID of the client sending the signal, ZeroID matches every client including unauthenticated ones
+  Source NodeID
+  // Signals that serialize to at least this many bytes, 0 matches every size
+  MinSize int
+
+  Action FirewallAction
+  Limit int
+  Interval time.Duration
+}
+
+// Returned when a signal is rejected by a firewall rule
+type FirewallError struct {
+  Rule string
+  SignalType string
+}
+
+func (err FirewallError) Error() string {
+  return fmt.Sprintf("%s rejected by firewall rule %s", err.SignalType, err.Rule)
+}
+
+// Number of rate limit windows kept before expired ones are removed
+const FIREWALL_MAX_WINDOWS = 1024
+
+type firewallWindow struct {
+  start time.Time
+  count int
+}
+
+type firewallKey struct {
+  rule int
+  source NodeID
+}
+
+// Checks signals arriving from outside the context against a list of rules, the first matching rule applies.
+// Signals that don't match any rule are allowed.
+type Firewall struct {
+  rules []FirewallRule
+
+  lock sync.Mutex
+  windows map[firewallKey]*firewallWindow
+}
+
+func NewFirewall(rules ...FirewallRule) (*Firewall, error) {
+  for _, rule := range(rules) {
+    switch rule.Action {
+    case FirewallDrop:
+    case FirewallRateLimit:
+      if rule.Limit <= 0 || rule.Interval <= 0 {
+        return nil, fmt.Errorf("Rate limit rule %s needs a positive limit and interval", rule.Name)
+      }
+    default:
+      return nil, fmt.Errorf("Unknown action %d for firewall rule %s", rule.Action, rule.Name)
+    }
+
+    if rule.MinSize < 0 {
+      return nil, fmt.Errorf("Firewall rule %s has negative MinSize %d", rule.Name, rule.MinSize)
+    }
+  }
+
+  return &Firewall{
+    rules: rules,
+    windows: map[firewallKey]*firewallWindow{},
+  }, nil
+}
+
+// Check whether source is allowed to send signal into the context, returning a FirewallError if it isn't
+func (firewall *Firewall) Check(ctx *Context, source NodeID, signal Signal) error {
+  signal_type := signalTypeName(signal)
+  size := -1
+
+  for i, rule := range(firewall.rules) {
+    if rule.SignalType != "" && rule.SignalType != signal_type {
+      continue
+    } else if rule.Source != ZeroID && rule.Source != source {
+      continue
+    }
+
+    if rule.MinSize > 0 {
+      if size == -1 {
+        var err error
+        size, err = SerializedSize(ctx, reflect.ValueOf(signal))
+        if err != nil {
+          return err
+        }
+      }
+
+      if size < rule.MinSize {
+        continue
+      }
+    }
+
+    switch rule.Action {
+    case FirewallDrop:
+      ctx.Log.Logf("firewall", "DROP: %s from %s by %s", signal, source, rule.Name)
+      return FirewallError{rule.Name, signal_type}
+
+    case FirewallRateLimit:
+      if firewall.allow(i, rule, source) == false {
+        ctx.Log.Logf("firewall", "RATE_LIMIT: %s from %s by %s", signal, source, rule.Name)
+        return FirewallError{rule.Name, signal_type}
+      }
+    }
+    return nil
+  }
+
+  return nil
+}
+
+// Count a signal from source against the rate limit rule, returning false if it's over the limit
+func (firewall *Firewall) allow(i int, rule FirewallRule, source NodeID) bool {
+  firewall.lock.Lock()
+  defer firewall.lock.Unlock()
+
+  now := time.Now()
+  key := firewallKey{i, source}
+  window, exists := firewall.windows[key]
+  if exists == false || now.Sub(window.start) >= rule.Interval {
+    if len(firewall.windows) >= FIREWALL_MAX_WINDOWS {
+      firewall.pruneWindows(now)
+    }
+    window = &firewallWindow{start: now}
+    firewall.windows[key] = window
+  }
+
+  if window.count >= rule.Limit {
+    return false
+  }
+  window.count += 1
+  return true
+}
+
+func (firewall *Firewall) pruneWindows(now time.Time) {
+  for key, window := range(firewall.windows) {
+    if now.Sub(window.start) >= firewall.rules[key.rule].Interval {
+      delete(firewall.windows, key)
+    }
+  }
+}
@@ -0,0 +1,73 @@
+package graphvent
+
+import (
+  "fmt"
+)
+
+// Sent to a node to stop it processing signals after the one it's processing, it responds with a SuccessSignal once it's stopped.
+// Signals a frozen node receives are held until it's sent a ThawSignal, except ReadSignals so it's state can still be inspected.
+type FreezeSignal struct {
+  SignalHeader
+}
+func (signal FreezeSignal) String() string {
+  return fmt.Sprintf("FreezeSignal(%s)", signal.SignalHeader)
+}
+func NewFreezeSignal() *FreezeSignal {
+  return &FreezeSignal{
+    NewSignalHeader(),
+  }
+}
+
+// Sent to a frozen node to process the signals it's holding and resume processing
+type ThawSignal struct {
+  SignalHeader
+}
+func (signal ThawSignal) String() string {
+  return fmt.Sprintf("ThawSignal(%s)", signal.SignalHeader)
+}
+func NewThawSignal() *ThawSignal {
+  return &ThawSignal{
+    NewSignalHeader(),
+  }
+}
+
+// Handle freezing and thawing, and hold the signal if the node is frozen. Returns true if the signal was handled.
+func (node *Node) handleFreezeSignal(ctx *Context, source NodeID, signal Signal) bool {
+  switch signal.(type) {
+  case *FreezeSignal:
+    if node.frozen.CompareAndSwap(false, true) {
+      ctx.Log.Logf("node", "FROZEN: %s", node.ID)
+    }
+    ctx.Send(node, []Message{{source, NewSuccessSignal(signal.ID())}})
+    return true
+
+  case *ThawSignal:
+    if node.frozen.CompareAndSwap(true, false) == false {
+      ctx.Send(node, []Message{{source, NewErrorSignal(signal.ID(), "not_frozen")}})
+      return true
+    }
+
+    held := node.held
+    node.held = nil
+    ctx.Log.Logf("node", "THAWED: %s, processing %d held signals", node.ID, len(held))
+    ctx.Send(node, []Message{{source, NewSuccessSignal(signal.ID())}})
+
+    // Signals after another FreezeSignal are held again
+    for _, msg := range(held) {
+      node.handleSignal(ctx, msg.Node, msg.Signal)
+    }
+    return true
+
+  case *ReadSignal:
+    return false
+
+  default:
+    if node.frozen.Load() == false {
+      return false
+    }
+
+    ctx.Log.Logf("node", "FROZEN: %s holding %s from %s", node.ID, signal, source)
+    node.held = append(node.held, Message{source, signal})
+    return true
+  }
+}
@@ -5,9 +5,11 @@ go 1.22.0
 require (
 	github.com/dgraph-io/badger/v3 v3.2103.5
 	github.com/gobwas/ws v1.2.1
+	github.com/golang/snappy v0.0.3
 	github.com/google/uuid v1.3.0
 	github.com/graphql-go/graphql v0.8.1
 	github.com/rs/zerolog v1.29.1
+	golang.org/x/exp v0.0.0-20240222234643-814bf88cf225
 	golang.org/x/net v0.7.0
 )
 
@@ -23,7 +25,6 @@ require (
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b // indirect
 	github.com/golang/groupcache v0.0.0-20190702054246-869f871628b6 // indirect
 	github.com/golang/protobuf v1.3.1 // indirect
-	github.com/golang/snappy v0.0.3 // indirect
 	github.com/google/flatbuffers v1.12.1 // indirect
 	github.com/klauspost/compress v1.12.3 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
@@ -31,6 +32,5 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/stretchr/testify v1.8.2 // indirect
 	go.opencensus.io v0.22.5 // indirect
-	golang.org/x/exp v0.0.0-20240222234643-814bf88cf225 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 )
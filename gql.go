@@ -5,7 +5,9 @@ import (
   "crypto/ecdh"
   "crypto/ecdsa"
   "crypto/elliptic"
+  "crypto/ed25519"
   "crypto/rand"
+  "crypto/tls"
   "crypto/x509"
   "encoding/json"
   "fmt"
@@ -226,11 +228,104 @@ type ResolveContext struct {
   // The state data for the node processing this request
   Ext *GQLExt
 
-  // Cache of resolved nodes
+  // Cache of resolved nodes, subscriptions check it from the session goroutine while they resolve so it's accessed with nodeCacheLock held
   NodeCache map[NodeID]NodeResult
+  nodeCacheLock sync.RWMutex
+
+  // ID of the client that made the request, ZeroID if the client didn't authenticate
+  Client NodeID
+
+  // Channel of updates for a subscription, set when the request is a subscription on a websocket session
+  Subscription chan interface{}
+
+  // Node reads waiting to be sent together, created by the first batched resolve
+  reads *gqlReadBatch
 }
 
-func NewResolveContext(ctx *Context, server *Node, gql_ext *GQLExt) (*ResolveContext, error) {
+// Get the cached result for id
+func (ctx *ResolveContext) cachedNode(id NodeID) (NodeResult, bool) {
+  ctx.nodeCacheLock.RLock()
+  defer ctx.nodeCacheLock.RUnlock()
+  result, cached := ctx.NodeCache[id]
+  return result, cached
+}
+
+// Replace the cached result for id
+func (ctx *ResolveContext) cacheNode(id NodeID, result NodeResult) {
+  ctx.nodeCacheLock.Lock()
+  defer ctx.nodeCacheLock.Unlock()
+  ctx.NodeCache[id] = result
+}
+
+// Check that a client certificate is a current ed25519 certificate signed by it's own key, for client authentication.
+// Used as tls.Config.VerifyPeerCertificate, since RequireAnyClientCert doesn't verify anything itself.
+func verifyClientCert(raw_certs [][]byte, _ [][]*x509.Certificate) error {
+  if len(raw_certs) == 0 {
+    return fmt.Errorf("no client certificate")
+  }
+
+  cert, err := x509.ParseCertificate(raw_certs[0])
+  if err != nil {
+    return fmt.Errorf("failed to parse client certificate: %w", err)
+  }
+
+  _, ok := cert.PublicKey.(ed25519.PublicKey)
+  if ok == false {
+    return fmt.Errorf("client certificate key is %s, not ed25519", reflect.TypeOf(cert.PublicKey))
+  }
+
+  now := time.Now()
+  if now.Before(cert.NotBefore) {
+    return fmt.Errorf("client certificate isn't valid until %s", cert.NotBefore)
+  } else if now.After(cert.NotAfter) {
+    return fmt.Errorf("client certificate expired at %s", cert.NotAfter)
+  }
+
+  err = cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+  if err != nil {
+    return fmt.Errorf("client certificate isn't signed by it's own key: %w", err)
+  }
+
+  if len(cert.ExtKeyUsage) > 0 {
+    client_auth := false
+    for _, usage := range(cert.ExtKeyUsage) {
+      if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+        client_auth = true
+      }
+    }
+    if client_auth == false {
+      return fmt.Errorf("client certificate isn't for client authentication")
+    }
+  }
+
+  return nil
+}
+
+// Get the NodeID of the ed25519 key in the request's client certificate.
+// The TLS handshake has already checked that the client holds the private key.
+func ClientCertID(r *http.Request) (NodeID, error) {
+  if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+    return ZeroID, fmt.Errorf("no client certificate")
+  }
+
+  pub, ok := r.TLS.PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+  if ok == false {
+    return ZeroID, fmt.Errorf("client certificate key is %s, not ed25519", reflect.TypeOf(r.TLS.PeerCertificates[0].PublicKey))
+  }
+
+  return KeyID(pub), nil
+}
+
+func NewResolveContext(ctx *Context, server *Node, gql_ext *GQLExt, r *http.Request) (*ResolveContext, error) {
+  client := ZeroID
+  if gql_ext.ClientCerts {
+    var err error
+    client, err = ClientCertID(r)
+    if err != nil {
+      return nil, err
+    }
+  }
+
   return &ResolveContext{
     ID: uuid.New(),
     Ext: gql_ext,
@@ -238,6 +333,7 @@ func NewResolveContext(ctx *Context, server *Node, gql_ext *GQLExt) (*ResolveCon
     Context: ctx,
     NodeCache: map[NodeID]NodeResult{},
     Server: server,
+    Client: client,
   }, nil
 }
 
@@ -250,9 +346,10 @@ func GQLHandler(ctx *Context, server *Node, gql_ext *GQLExt) func(http.ResponseW
       header_map[header] = value
     }
 
-    resolve_context, err := NewResolveContext(ctx, server, gql_ext)
+    resolve_context, err := NewResolveContext(ctx, server, gql_ext, r)
     if err != nil {
       ctx.Log.Logf("gql", "GQL_AUTH_ERR: %s", err)
+      w.WriteHeader(http.StatusUnauthorized)
       json.NewEncoder(w).Encode(GQLUnauthorized(""))
       return
     } else {
@@ -285,6 +382,11 @@ func GQLHandler(ctx *Context, server *Node, gql_ext *GQLExt) func(http.ResponseW
       params.VariableValues = query.Variables
     }
 
+    if gql_ext.shouldShed(ctx, server, getOperationTypeOfReq(params)) {
+      writeGQLOverloaded(ctx, w, server)
+      return
+    }
+
     result := graphql.Do(params)
     if len(result.Errors) > 0 {
       extra_fields := map[string]interface{}{}
@@ -339,17 +441,15 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
       header_map[header] = value
     }
 
-    resolve_context, err := NewResolveContext(ctx, server, gql_ext)
+    resolve_context, err := NewResolveContext(ctx, server, gql_ext, r)
     if err != nil {
       ctx.Log.Logf("gql", "GQL_AUTH_ERR: %s", err)
+      w.WriteHeader(http.StatusUnauthorized)
       return
     } else {
       ctx.Log.Logf("gql", "New Subscription: %s", resolve_context.ID)
     }
 
-    req_ctx := context.Background()
-    req_ctx = context.WithValue(req_ctx, "resolve", resolve_context)
-
     u := ws.HTTPUpgrader{
       Protocol: func(protocol string) bool {
         ctx.Log.Logf("gqlws", "UPGRADE_PROTOCOL: %s", string(protocol))
@@ -362,6 +462,26 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
     conn, _, _, err := u.Upgrade(r, w)
     if err == nil {
       defer conn.Close()
+
+      session, err := newGQLSession(ctx, gql_ext, conn, resolve_context)
+      if err != nil {
+        ctx.Log.Logf("gqlws", "SESSION_ERR: %s", err)
+        return
+      }
+      defer session.close()
+
+      if resolve_context.Client != ZeroID {
+        err := ctx.Send(server, []Message{{server.ID, NewSessionStartedSignal(resolve_context.ID, resolve_context.Client, "websocket", r.RemoteAddr)}})
+        if err != nil {
+          ctx.Log.Logf("gqlws", "SESSION_START_ERR: %s", err)
+        }
+        defer func() {
+          err := ctx.Send(server, []Message{{server.ID, NewSessionEndedSignal(resolve_context.ID, resolve_context.Client, "websocket", r.RemoteAddr)}})
+          if err != nil {
+            ctx.Log.Logf("gqlws", "SESSION_END_ERR: %s", err)
+          }
+        }()
+      }
       conn_state := "init"
       for {
         msg_raw, op, err := wsutil.ReadClientData(conn)
@@ -391,24 +511,50 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
           }
 
           conn_state = "ready"
-          err = wsutil.WriteServerMessage(conn, 1, []byte("{\"type\": \"connection_ack\"}"))
+          err = session.write(GQLWSMsg{Type: "connection_ack"})
           if err != nil {
             ctx.Log.Logf("gqlws", "WS_SERVER_ERROR: FAILED TO SEND connection_ack")
             break
           }
         } else if msg.Type == "ping" {
           ctx.Log.Logf("gqlws_hb", "PING FROM %s", r.RemoteAddr)
-          err = wsutil.WriteServerMessage(conn, 1, []byte("{\"type\": \"pong\"}"))
+          err = session.write(GQLWSMsg{Type: "pong"})
           if err != nil {
             ctx.Log.Logf("gqlws", "WS_SERVER_ERROR: FAILED TO SEND PONG")
             break
           }
+        } else if msg.Type == "complete" {
+          ctx.Log.Logf("gqlws", "COMPLETE: %s", msg.ID)
+          session.unsubscribe(msg.ID)
         } else if msg.Type == "subscribe" {
           ctx.Log.Logf("gqlws", "SUBSCRIBE: %+v", msg.Payload)
+          operation := getOperationTypeOfReq(graphql.Params{RequestString: msg.Payload.Query, OperationName: msg.Payload.OperationName})
+          if gql_ext.shouldShed(ctx, server, operation) {
+            ctx.Log.Logf("gqlws", "GQL_SHED: %s", msg.ID)
+            err := session.write(GQLWSMsg{
+              ID: msg.ID,
+              Type: "error",
+              Payload: GQLPayload{
+                Extensions: GQLOverloaded("").Extensions(),
+              },
+            })
+            if err != nil {
+              ctx.Log.Logf("gqlws", "WS_SERVER_ERROR: FAILED TO SEND SHED ERROR")
+              break
+            }
+            continue
+          }
+
+          sub_context, err := session.subscribe(msg.ID)
+          if err != nil {
+            ctx.Log.Logf("gqlws", "WS_CLIENT_ERROR: %s", err)
+            break
+          }
+
           schema := ctx.Extensions[ExtTypeFor[GQLExt]()].Data.(graphql.Schema)
           params := graphql.Params{
             Schema: schema,
-            Context: req_ctx,
+            Context: context.WithValue(context.Background(), "resolve", sub_context),
             RequestString: msg.Payload.Query,
           }
           if msg.Payload.OperationName != "" {
@@ -419,7 +565,6 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
           }
 
           var res_chan chan *graphql.Result
-          operation := getOperationTypeOfReq(params)
 
           if operation == ast.OperationTypeSubscription {
             res_chan = graphql.Subscribe(params)
@@ -428,11 +573,16 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
             res_chan = sendOneResultAndClose(res)
           }
 
-          go func(res_chan chan *graphql.Result) {
+          go func(msg GQLWSMsg, res_chan chan *graphql.Result) {
+            defer session.unsubscribe(msg.ID)
             for {
               next, ok := <-res_chan
               if ok == false {
                 ctx.Log.Logf("gqlws", "response channel was closed")
+                err := session.write(GQLWSMsg{ID: msg.ID, Type: "complete"})
+                if err != nil {
+                  ctx.Log.Logf("gqlws", "ERROR: %+v", err)
+                }
                 return
               }
               if next == nil {
@@ -451,7 +601,7 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
                 ctx.Log.Logf("gqlws", "ERROR: %+v", err)
                 continue
               }
-              msg, err := json.Marshal(GQLWSMsg{
+              err = session.write(GQLWSMsg{
                 ID: msg.ID,
                 Type: "next",
                 Payload: GQLPayload{
@@ -462,14 +612,8 @@ func GQLWSHandler(ctx * Context, server *Node, gql_ext *GQLExt) func(http.Respon
                 ctx.Log.Logf("gqlws", "ERROR: %+v", err)
                 continue
               }
-
-              err = wsutil.WriteServerMessage(conn, 1, msg)
-              if err != nil {
-                ctx.Log.Logf("gqlws", "ERROR: %+v", err)
-                continue
-              }
             }
-          }(res_chan)
+          }(msg, res_chan)
         } else {
         }
       }
@@ -518,7 +662,11 @@ type SelfField struct {
 
 type SubscriptionInfo struct {
   ID uuid.UUID
-  NodeCache *map[NodeID]NodeResult
+  // Shown in Subscriptions to tell subscriptions apart
+  Name string
+  Owner SubscriptionOwner
+  // Returns whether StatusSignals from the node should be sent to the subscription
+  Filter func(NodeID) bool
   Channel chan interface{}
 }
 
@@ -529,26 +677,39 @@ type GQLExt struct {
 
   subscriptions []SubscriptionInfo
   subscriptions_lock sync.RWMutex
+  // Stops removing the subscriptions of unloaded nodes
+  stopOwners func()
 
   // map of read request IDs to response channels
   resolver_response map[uuid.UUID]chan Signal
   resolver_response_lock sync.RWMutex
 
   State string `gv:"state"`
-  TLSKey []byte `gv:"tls_key"`
+  TLSKey []byte `gv:"tls_key" gql:"-"`
   TLSCert []byte `gv:"tls_cert"`
   Listen string `gv:"listen" gql:"GQLListen"`
+  // Serve over TLS and require clients to authenticate with an ed25519 certificate
+  ClientCerts bool `gv:"client_certs"`
+  // Percent of the inbox capacity that can be queued before queries and new subscriptions are rejected, 0 to never reject.
+  // Only used when the node type's inbox is bounded, since an InboxGrow inbox never fills.
+  ShedPercent int `gv:"shed_percent"`
+  // Names of the signal types clients with certificates can send with SendSignal, none if empty
+  SignalTypes []string `gv:"signal_types"`
 }
 
 func (ext *GQLExt) Load(ctx *Context, node *Node) error {
   ctx.Log.Logf("gql", "Loading GQL server extension on %s", node.ID)
   ext.resolver_response = map[uuid.UUID]chan Signal{}
   ext.subscriptions = []SubscriptionInfo{}
+  ext.stopOwners = ext.watchOwners(ctx)
   return ext.StartGQLServer(ctx, node)
 }
 
 func (ext *GQLExt) Unload(ctx *Context, node *Node) {
   ctx.Log.Logf("gql", "Unloading GQL server extension on %s", node.ID)
+  if ext.stopOwners != nil {
+    ext.stopOwners()
+  }
   err := ext.StopGQLServer()
   if err != nil {
     ctx.Log.Logf("gql", "Error unloading GQL server extension on %s: %s", node.ID, err)
@@ -557,25 +718,9 @@ func (ext *GQLExt) Unload(ctx *Context, node *Node) {
   }
 }
 
-func (ext *GQLExt) AddSubscription(id uuid.UUID, ctx *ResolveContext) (chan interface{}, error) {
-  ext.subscriptions_lock.Lock()
-  defer ext.subscriptions_lock.Unlock()
-
-  for _, info := range(ext.subscriptions) {
-    if info.ID == id {
-      return nil, fmt.Errorf("%+v already in subscription list", info.ID)
-    }
-  }
-
-  c := make(chan interface{}, 100)
-
-  ext.subscriptions = append(ext.subscriptions, SubscriptionInfo{
-    id,
-    &ctx.NodeCache,
-    c,
-  })
-
-  return c, nil
+// Add an unnamed subscription without an owner, which stays until it's removed with RemoveSubscription
+func (ext *GQLExt) AddSubscription(id uuid.UUID, filter func(NodeID) bool, buffer int) (chan interface{}, error) {
+  return ext.AddNamedSubscription(id, "", SubscriptionOwner{}, filter, buffer)
 }
 
 func (ext *GQLExt) RemoveSubscription(id uuid.UUID) error {
@@ -584,7 +729,7 @@ func (ext *GQLExt) RemoveSubscription(id uuid.UUID) error {
 
   for i, info := range(ext.subscriptions) {
     if info.ID == id {
-      ext.subscriptions[i] = ext.subscriptions[len(ext.subscriptions)]
+      ext.subscriptions[i] = ext.subscriptions[len(ext.subscriptions)-1]
       ext.subscriptions = ext.subscriptions[:len(ext.subscriptions)-1]
       return nil
     }
@@ -661,8 +806,7 @@ func (ext *GQLExt) Process(ctx *Context, node *Node, source NodeID, signal Signa
   case *StatusSignal:
     ext.subscriptions_lock.RLock()
     for _, sub := range(ext.subscriptions) {
-      _, cached := (*sub.NodeCache)[sig.Source]
-      if cached {
+      if sub.Filter(sig.Source) {
         select {
         case sub.Channel <- sig:
           ctx.Log.Logf("gql", "forwarded status signal %+v to subscription: %s", sig, sub.ID)
@@ -778,6 +922,12 @@ func (ext *GQLExt) StartGQLServer(ctx *Context, node *Node) error {
   mux := http.NewServeMux()
   mux.HandleFunc("/gql", GQLHandler(ctx, node, ext))
   mux.HandleFunc("/gqlws", GQLWSHandler(ctx, node, ext))
+  mux.HandleFunc("/signals", SignalCatalogHandler(ctx))
+  mux.HandleFunc("/health", HealthHandler(ctx))
+  mux.HandleFunc("/registry", RegistryHandler(ctx))
+  mux.HandleFunc("/subscriptions", SubscriptionsHandler(ctx, ext))
+  mux.HandleFunc("/signals.proto", ProtoSchemaHandler(ctx))
+  mux.HandleFunc("/timeline", TimelineHandler(ctx))
 
   mux.HandleFunc("/graphiql", GraphiQLHandler())
 
@@ -822,6 +972,22 @@ func (ext *GQLExt) StartGQLServer(ctx *Context, node *Node) error {
     return fmt.Errorf("Failed to start listener for server on %s", http_server.Addr)
   }
 
+  if ext.ClientCerts {
+    cert, err := tls.X509KeyPair(ext.TLSCert, ext.TLSKey)
+    if err != nil {
+      l.Close()
+      return fmt.Errorf("Failed to load TLS keypair: %w", err)
+    }
+
+    // Client certificates are self-signed node keys, so there's no CA to verify them against.
+    // verifyClientCert checks them instead, and ClientCertID maps their key to a NodeID
+    l = tls.NewListener(l, &tls.Config{
+      Certificates: []tls.Certificate{cert},
+      ClientAuth: tls.RequireAnyClientCert,
+      VerifyPeerCertificate: verifyClientCert,
+    })
+  }
+
   ext.http_done.Add(1)
   go func(qql_ext *GQLExt) {
     defer ext.http_done.Done()
@@ -0,0 +1,126 @@
+package graphvent
+
+import (
+  "fmt"
+  "slices"
+  "time"
+
+  "github.com/graphql-go/graphql"
+  "github.com/google/uuid"
+)
+
+// How long a resolver waits for the nodes it read to respond
+const GQL_READ_TIMEOUT = 100*time.Millisecond
+
+// Node reads requested by the resolvers of a GQL request that haven't been sent yet.
+// Resolvers return thunks, and graphql-go calls the thunks of a level of the query after resolving the whole level,
+// so the first thunk sends the reads for every node at that level at once and each node is only read once.
+type gqlReadBatch struct {
+  pending map[NodeID][]string
+  errors map[NodeID]error
+}
+
+func newReadBatch() *gqlReadBatch {
+  return &gqlReadBatch{
+    pending: map[NodeID][]string{},
+    errors: map[NodeID]error{},
+  }
+}
+
+func (batch *gqlReadBatch) add(id NodeID, fields []string) {
+  delete(batch.errors, id)
+  existing := batch.pending[id]
+  for _, field := range(fields) {
+    if slices.Contains(existing, field) == false {
+      existing = append(existing, field)
+    }
+  }
+  batch.pending[id] = existing
+}
+
+type batchedRead struct {
+  node NodeID
+  id uuid.UUID
+  response chan Signal
+}
+
+// Send a ReadSignal to every pending node, then wait for all the responses and add them to the node cache.
+// Errors are recorded per node so one missing node doesn't fail the others.
+func (batch *gqlReadBatch) flush(ctx *ResolveContext) {
+  if len(batch.pending) == 0 {
+    return
+  }
+  pending := batch.pending
+  batch.pending = map[NodeID][]string{}
+
+  ctx.Context.Log.Logf("gql", "Resolving batch of %d nodes", len(pending))
+
+  reads := make([]batchedRead, 0, len(pending))
+  for node, fields := range(pending) {
+    signal := NewReadSignal(fields)
+    response_chan := ctx.Ext.GetResponseChannel(signal.ID())
+    err := ctx.Context.Send(ctx.Server, []Message{{
+      Node: node,
+      Signal: signal,
+    }})
+    if err != nil {
+      ctx.Ext.FreeResponseChannel(signal.ID())
+      batch.errors[node] = err
+      continue
+    }
+    reads = append(reads, batchedRead{node, signal.ID(), response_chan})
+  }
+
+  deadline := time.Now().Add(GQL_READ_TIMEOUT)
+  for _, read := range(reads) {
+    // Responses that arrived before the deadline are still read after it
+    timeout := max(time.Until(deadline), time.Millisecond)
+    response, _, err := WaitForResponse(read.response, timeout, read.id)
+    ctx.Ext.FreeResponseChannel(read.id)
+    if err != nil {
+      batch.errors[read.node] = err
+      continue
+    }
+
+    _, err = cacheReadResult(ctx, read.node, response)
+    if err != nil {
+      batch.errors[read.node] = err
+    }
+  }
+}
+
+// Resolve id like ResolveNode, but return a thunk that reads it together with the other nodes of the request when it isn't cached
+func ResolveNodeBatched(id NodeID, p graphql.ResolveParams) (interface{}, error) {
+  ctx, err := PrepResolve(p)
+  if err != nil {
+    return nil, err
+  }
+
+  updateNodeCache(ctx, p)
+
+  cache, node_cached, not_cached := uncachedFields(ctx, id, p)
+  if (len(not_cached) == 0) && (node_cached == true) {
+    return cache, nil
+  }
+
+  if ctx.reads == nil {
+    ctx.reads = newReadBatch()
+  }
+  batch := ctx.reads
+  batch.add(id, not_cached)
+
+  return func() (interface{}, error) {
+    batch.flush(ctx)
+
+    err, failed := batch.errors[id]
+    if failed {
+      return nil, err
+    }
+
+    result, cached := ctx.cachedNode(id)
+    if cached == false {
+      return nil, fmt.Errorf("%s was not read", id)
+    }
+    return result, nil
+  }, nil
+}
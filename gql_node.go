@@ -2,7 +2,6 @@ package graphvent
 import (
   "reflect"
   "fmt"
-  "time"
   "github.com/graphql-go/graphql"
   "github.com/graphql-go/graphql/language/ast"
 )
@@ -61,87 +60,111 @@ func GetResolveFields(p graphql.ResolveParams) []string {
   return fields
 }
 
-func ResolveNode(id NodeID, p graphql.ResolveParams) (NodeResult, error) {
-  ctx, err := PrepResolve(p)
-  if err != nil {
-    return NodeResult{}, err
+// Drop the fields a StatusSignal source changed from the node cache, filling in the new values from its diffs
+func updateNodeCache(ctx *ResolveContext, p graphql.ResolveParams) {
+  source, is_status := p.Source.(*StatusSignal)
+  if is_status == false {
+    return
   }
 
-  switch source := p.Source.(type) {
-  case *StatusSignal:
-    cached_node, cached := ctx.NodeCache[source.Source]
-    if cached {
-      for _, field_name := range(source.Fields) {
-        _, cached := cached_node.Data[field_name]
-        if cached {
-          delete(cached_node.Data, field_name)
-        }
+  cached_node, cached := ctx.cachedNode(source.Source)
+  if cached {
+    for _, field_name := range(source.Fields) {
+      _, cached := cached_node.Data[field_name]
+      if cached {
+        delete(cached_node.Data, field_name)
       }
-      ctx.NodeCache[source.Source] = cached_node
     }
-  }
 
-  cache, node_cached := ctx.NodeCache[id]
-  fields := GetResolveFields(p) 
-  var not_cached []string
-  if node_cached {
-    not_cached = []string{}
-    for _, field := range(fields) {
-      if node_cached {
-        _, field_cached := cache.Data[field]
-        if field_cached {
-          continue
-        }
+    // Update the cache from the diffs so the new values don't need to be read
+    for _, diff := range(source.Diffs) {
+      value, err := diff.New.Deserialize(ctx.Context)
+      if err != nil {
+        ctx.Context.Log.Logf("gql", "Failed to deserialize diff for %s.%s: %s", source.Source, diff.Field, err)
+        continue
+      } else if value.IsValid() == false {
+        continue
       }
+      cached_node.Data[diff.Field] = value.Interface()
+    }
+    ctx.cacheNode(source.Source, cached_node)
+  }
+}
 
+// Returns the cached result for id, whether it was cached, and the fields p needs that aren't cached
+func uncachedFields(ctx *ResolveContext, id NodeID, p graphql.ResolveParams) (NodeResult, bool, []string) {
+  cache, node_cached := ctx.cachedNode(id)
+  fields := GetResolveFields(p)
+  if node_cached == false {
+    return cache, false, fields
+  }
+
+  not_cached := []string{}
+  for _, field := range(fields) {
+    _, field_cached := cache.Data[field]
+    if field_cached == false {
       not_cached = append(not_cached, field)
     }
-  } else {
-    not_cached = fields
   }
+  return cache, true, not_cached
+}
+
+// Merge the fields of a read response for id into the node cache
+func cacheReadResult(ctx *ResolveContext, id NodeID, response ResponseSignal) (NodeResult, error) {
+  switch response := response.(type) {
+  case *ReadResultSignal:
+    cache, node_cached := ctx.cachedNode(id)
+    if node_cached == false {
+      cache = NodeResult{
+        NodeID: id,
+        NodeType: response.NodeType,
+        Data: response.Fields,
+      }
+    } else {
+      for field_name, field_value := range(response.Fields) {
+        cache.Data[field_name] = field_value
+      }
+    }
+
+    ctx.cacheNode(id, cache)
+    return cache, nil
+  default:
+    return NodeResult{}, fmt.Errorf("Bad read response: %+v", response)
+  }
+}
 
+func ResolveNode(id NodeID, p graphql.ResolveParams) (NodeResult, error) {
+  ctx, err := PrepResolve(p)
+  if err != nil {
+    return NodeResult{}, err
+  }
+
+  updateNodeCache(ctx, p)
+
+  cache, node_cached, not_cached := uncachedFields(ctx, id, p)
   if (len(not_cached) == 0) && (node_cached == true) {
     ctx.Context.Log.Logf("gql", "No new fields to resolve for %s", id)
     return cache, nil
-  } else {
-    ctx.Context.Log.Logf("gql", "Resolving fields %+v on node %s", not_cached, id)
-
-    signal := NewReadSignal(not_cached)
-    response_chan := ctx.Ext.GetResponseChannel(signal.ID())
-    // TODO: TIMEOUT DURATION
-    err = ctx.Context.Send(ctx.Server, []Message{{
-      Node: id,
-      Signal: signal,
-    }})
-    if err != nil {
-      ctx.Ext.FreeResponseChannel(signal.ID())
-      return NodeResult{}, err
-    }
+  }
 
-    response, _, err := WaitForResponse(response_chan, 100*time.Millisecond, signal.ID())
-    ctx.Ext.FreeResponseChannel(signal.ID())
-    if err != nil {
-      return NodeResult{}, err
-    }
+  ctx.Context.Log.Logf("gql", "Resolving fields %+v on node %s", not_cached, id)
 
-    switch response := response.(type) {
-    case *ReadResultSignal:
-      if node_cached == false {
-        cache = NodeResult{
-          NodeID: id,
-          NodeType: response.NodeType,
-          Data: response.Fields,
-        }
-      } else {
-        for field_name, field_value := range(response.Fields) {
-          cache.Data[field_name] = field_value
-        }
-      }
+  signal := NewReadSignal(not_cached)
+  response_chan := ctx.Ext.GetResponseChannel(signal.ID())
+  err = ctx.Context.Send(ctx.Server, []Message{{
+    Node: id,
+    Signal: signal,
+  }})
+  if err != nil {
+    ctx.Ext.FreeResponseChannel(signal.ID())
+    return NodeResult{}, err
+  }
 
-      ctx.NodeCache[id] = cache
-      return ctx.NodeCache[id], nil
-    default:
-      return NodeResult{}, fmt.Errorf("Bad read response: %+v", response)
-    }
+  response, _, err := WaitForResponse(response_chan, GQL_READ_TIMEOUT, signal.ID())
+  ctx.Ext.FreeResponseChannel(signal.ID())
+  if err != nil {
+    return NodeResult{}, err
   }
+
+  return cacheReadResult(ctx, id, response)
 }
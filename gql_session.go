@@ -0,0 +1,171 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "fmt"
+  "net"
+  "sync"
+
+  "github.com/gobwas/ws/wsutil"
+  "github.com/google/uuid"
+)
+
+// Number of StatusSignals buffered for each websocket connection, and for each subscription on it
+const GQL_SESSION_BUFFER = 100
+
+// A subscription on a websocket connection, with its own resolve context so its node cache only filters its own updates
+type gqlSubscription struct {
+  ID string
+  Context *ResolveContext
+  Channel chan interface{}
+}
+
+// Multiplexes the subscriptions on a websocket connection over a single subscription on the GQLExt.
+// StatusSignals are only forwarded to the subscriptions that have the source cached,
+// and a subscription with a full channel drops its updates instead of delaying the others.
+type gqlSession struct {
+  ctx *Context
+  ext *GQLExt
+  conn net.Conn
+  base *ResolveContext
+  signals chan interface{}
+
+  write_lock sync.Mutex
+
+  subs_lock sync.RWMutex
+  subs map[string]*gqlSubscription
+}
+
+func newGQLSession(ctx *Context, ext *GQLExt, conn net.Conn, base *ResolveContext) (*gqlSession, error) {
+  session := &gqlSession{
+    ctx: ctx,
+    ext: ext,
+    conn: conn,
+    base: base,
+    subs: map[string]*gqlSubscription{},
+  }
+
+  signals, err := ext.AddNamedSubscription(base.ID, "websocket", SubscriptionOwner{Session: base.ID}, session.cached, GQL_SESSION_BUFFER)
+  if err != nil {
+    return nil, err
+  }
+  session.signals = signals
+
+  go session.dispatch()
+  ctx.publishEvent(ContextEvent{
+    Type: EventTransportConnected,
+    Node: base.Server.ID,
+    Client: base.Client,
+    Transport: "websocket",
+  })
+  return session, nil
+}
+
+// Whether any subscription on the session has the node cached
+func (session *gqlSession) cached(id NodeID) bool {
+  session.subs_lock.RLock()
+  defer session.subs_lock.RUnlock()
+  for _, sub := range(session.subs) {
+    _, cached := sub.Context.cachedNode(id)
+    if cached {
+      return true
+    }
+  }
+  return false
+}
+
+// Forward each signal from the GQLExt to the subscriptions that have its source cached
+func (session *gqlSession) dispatch() {
+  for signal := range(session.signals) {
+    status, ok := signal.(*StatusSignal)
+    if ok == false {
+      continue
+    }
+
+    session.subs_lock.RLock()
+    for _, sub := range(session.subs) {
+      _, cached := sub.Context.cachedNode(status.Source)
+      if cached == false {
+        continue
+      }
+
+      select {
+      case sub.Channel <- status:
+      default:
+        session.ctx.Log.Logf("gqlws", "SUBSCRIPTION_OVERFLOW: %s on %s", sub.ID, session.base.ID)
+      }
+    }
+    session.subs_lock.RUnlock()
+  }
+}
+
+// Add a subscription with the client's ID for it, returning the resolve context to run it with
+func (session *gqlSession) subscribe(id string) (*ResolveContext, error) {
+  session.subs_lock.Lock()
+  defer session.subs_lock.Unlock()
+
+  _, exists := session.subs[id]
+  if exists {
+    return nil, fmt.Errorf("Subscriber for %s already exists", id)
+  }
+
+  resolve_context := &ResolveContext{
+    ID: uuid.New(),
+    Ext: session.ext,
+    Chans: map[uuid.UUID]chan Signal{},
+    Context: session.ctx,
+    NodeCache: map[NodeID]NodeResult{},
+    Server: session.base.Server,
+    Client: session.base.Client,
+    Subscription: make(chan interface{}, GQL_SESSION_BUFFER),
+  }
+
+  session.subs[id] = &gqlSubscription{
+    ID: id,
+    Context: resolve_context,
+    Channel: resolve_context.Subscription,
+  }
+  return resolve_context, nil
+}
+
+// Remove a subscription and close its channel, which ends its result stream
+func (session *gqlSession) unsubscribe(id string) {
+  session.subs_lock.Lock()
+  defer session.subs_lock.Unlock()
+
+  sub, exists := session.subs[id]
+  if exists {
+    delete(session.subs, id)
+    close(sub.Channel)
+  }
+}
+
+// Send a message to the client, writes from subscriptions are serialized so they don't interleave
+func (session *gqlSession) write(msg GQLWSMsg) error {
+  ser, err := json.Marshal(msg)
+  if err != nil {
+    return err
+  }
+
+  session.write_lock.Lock()
+  defer session.write_lock.Unlock()
+  return wsutil.WriteServerMessage(session.conn, 1, ser)
+}
+
+// Remove the subscriptions the session owns from the GQLExt, which closes its signal channel, and end all of its subscriptions
+func (session *gqlSession) close() {
+  session.ext.RemoveOwnedSubscriptions(SubscriptionOwner{Session: session.base.ID})
+  session.ctx.publishEvent(ContextEvent{
+    Type: EventTransportDisconnected,
+    Node: session.base.Server.ID,
+    Client: session.base.Client,
+    Transport: "websocket",
+  })
+
+  session.subs_lock.Lock()
+  defer session.subs_lock.Unlock()
+  for id, sub := range(session.subs) {
+    delete(session.subs, id)
+    close(sub.Channel)
+  }
+}
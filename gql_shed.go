@@ -0,0 +1,67 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+
+  "github.com/graphql-go/graphql"
+  "github.com/graphql-go/graphql/gqlerrors"
+  "github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+  // Suggested ShedPercent for GQL nodes with a bounded inbox
+  GQL_SHED_PERCENT = 80
+  // Seconds clients are told to wait before retrying a shed request
+  GQL_SHED_RETRY_AFTER = 1
+)
+
+// Returned to clients when the GQL node is too busy to take a request, it's safe to retry later
+type GQLOverloaded string
+
+func (e GQLOverloaded) Error() string {
+  return fmt.Sprintf("GQL_OVERLOADED: %s", string(e))
+}
+
+func (e GQLOverloaded) Extensions() map[string]interface{} {
+  return map[string]interface{}{
+    "code": "OVERLOADED",
+    "retriable": true,
+    "retry_after": GQL_SHED_RETRY_AFTER,
+  }
+}
+
+// Whether the server's inbox is full enough that new operations of the type should be rejected.
+// Queries and subscriptions fan out into reads of other nodes whose responses have to come back through the inbox,
+// so they're shed. Mutations are let through since they're how clients release locks and other resources.
+// An InboxGrow inbox's capacity is only its initial size, so servers with one never shed.
+func (ext *GQLExt) shouldShed(ctx *Context, server *Node, operation string) bool {
+  if ext.ShedPercent <= 0 || operation == ast.OperationTypeMutation {
+    return false
+  }
+
+  inbox := ctx.NodeTypes[server.Type].Inbox
+  if inbox.Strategy == InboxGrow {
+    return false
+  }
+
+  capacity := inbox.Capacity
+  queued := server.queued.Load()
+  return queued * 100 >= int64(ext.ShedPercent * capacity)
+}
+
+func writeGQLOverloaded(ctx *Context, w http.ResponseWriter, server *Node) {
+  err := GQLOverloaded(fmt.Sprintf("%d signals queued on %s", server.queued.Load(), server.ID))
+  ctx.Log.Logf("gql", "GQL_SHED: %s", err)
+
+  w.Header().Set("Content-Type", "application/json")
+  w.Header().Set("Retry-After", fmt.Sprintf("%d", GQL_SHED_RETRY_AFTER))
+  w.WriteHeader(http.StatusServiceUnavailable)
+  json.NewEncoder(w).Encode(&graphql.Result{
+    Errors: []gqlerrors.FormattedError{{
+      Message: err.Error(),
+      Extensions: err.Extensions(),
+    }},
+  })
+}
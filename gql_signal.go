@@ -0,0 +1,279 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+  "slices"
+  "strings"
+
+  "github.com/google/uuid"
+  "github.com/graphql-go/graphql"
+)
+
+// Get the GQL input type for a go type, nil if it can't be input.
+// Unlike GQLType, NodeIDs are input as IDs instead of resolving to nodes.
+func (ctx *Context) GQLInputType(t reflect.Type) graphql.Input {
+  info, registered := ctx.Types[t]
+  if registered {
+    if info.Type == nil || graphql.IsInputType(info.Type) == false {
+      return nil
+    }
+    return info.Type.(graphql.Input)
+  }
+
+  switch t.Kind() {
+  case reflect.Slice, reflect.Array:
+    elem := ctx.GQLInputType(t.Elem())
+    if elem == nil {
+      return nil
+    }
+    return graphql.NewList(elem)
+  case reflect.Pointer:
+    return ctx.GQLInputType(t.Elem())
+  }
+  return nil
+}
+
+// Convert a value parsed from GQL input to the go type
+func convertGQLInput(value any, t reflect.Type) (reflect.Value, error) {
+  if value == nil {
+    return reflect.Zero(t), nil
+  }
+
+  switch t.Kind() {
+  case reflect.Slice:
+    list, ok := value.([]interface{})
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("%+v is not a list", value)
+    }
+    slice := reflect.MakeSlice(t, len(list), len(list))
+    for i, elem := range(list) {
+      converted, err := convertGQLInput(elem, t.Elem())
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      slice.Index(i).Set(converted)
+    }
+    return slice, nil
+  case reflect.Pointer:
+    elem, err := convertGQLInput(value, t.Elem())
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    ptr := reflect.New(t.Elem())
+    ptr.Elem().Set(elem)
+    return ptr, nil
+  }
+
+  reflect_value := reflect.ValueOf(value)
+  if reflect_value.Type().AssignableTo(t) {
+    return reflect_value, nil
+  } else if reflect_value.Type().ConvertibleTo(t) {
+    return reflect_value.Convert(t), nil
+  }
+  return reflect.Value{}, fmt.Errorf("Cannot use %+v as %s", value, t)
+}
+
+type signalInputField struct {
+  Name string
+  Index []int
+  Type reflect.Type
+}
+
+type signalInput struct {
+  Type reflect.Type
+  Fields []signalInputField
+}
+
+// Build the input object for a registered signal type from its gv tagged fields.
+// The id is generated when the signal is sent, and fields that can't be input are left zero.
+func signalInputObject(ctx *Context, signal_type reflect.Type) (*graphql.InputObject, signalInput) {
+  input := signalInput{
+    Type: signal_type,
+    Fields: []signalInputField{},
+  }
+  input_fields := graphql.InputObjectConfigFieldMap{}
+
+  for _, field := range(reflect.VisibleFields(signal_type)) {
+    gv_tag, tagged_gv := field.Tag.Lookup("gv")
+    if tagged_gv == false || gv_tag == "id" {
+      continue
+    }
+
+    name, exposed := gqlFieldName(field, gv_tag)
+    if exposed == false {
+      continue
+    }
+
+    input_type := ctx.GQLInputType(field.Type)
+    if input_type == nil {
+      continue
+    }
+
+    input_fields[name] = &graphql.InputObjectFieldConfig{
+      Type: input_type,
+    }
+    input.Fields = append(input.Fields, signalInputField{name, field.Index, field.Type})
+  }
+
+  gql_name := strings.ReplaceAll(signal_type.String(), ".", "_") + "Input"
+  if len(input_fields) == 0 {
+    // Input objects need at least one field, signals with no input fields take a placeholder
+    input_fields["_"] = &graphql.InputObjectFieldConfig{
+      Type: graphql.Boolean,
+    }
+  }
+
+  return graphql.NewInputObject(graphql.InputObjectConfig{
+    Name: gql_name,
+    Fields: input_fields,
+  }), input
+}
+
+// Construct a signal from the GQL input for it
+func (input signalInput) build(args map[string]interface{}) (Signal, error) {
+  signal := reflect.New(input.Type)
+  for _, field := range(input.Fields) {
+    value, set := args[field.Name]
+    if set == false {
+      continue
+    }
+
+    converted, err := convertGQLInput(value, field.Type)
+    if err != nil {
+      return nil, fmt.Errorf("Bad value for %s: %w", field.Name, err)
+    }
+    signal.Elem().FieldByIndex(field.Index).Set(converted)
+  }
+
+  header := signal.Elem().FieldByName("SignalHeader")
+  if header.IsValid() == false {
+    return nil, fmt.Errorf("%s has no SignalHeader", input.Type)
+  }
+  header.Set(reflect.ValueOf(NewSignalHeader()))
+
+  return signal.Interface().(Signal), nil
+}
+
+// Signals that lock, link, alias, freeze, or run transactions on nodes, or that nodes only send to each other.
+// SendSignal never sends these, even if a server allows them.
+var gqlControlSignals = []reflect.Type{
+  reflect.TypeFor[LockSignal](),
+  reflect.TypeFor[UnlockSignal](),
+  reflect.TypeFor[LockLostSignal](),
+  reflect.TypeFor[LinkSignal](),
+  reflect.TypeFor[DependencySignal](),
+  reflect.TypeFor[FreezeSignal](),
+  reflect.TypeFor[ThawSignal](),
+  reflect.TypeFor[CommitSignal](),
+  reflect.TypeFor[AbortSignal](),
+  reflect.TypeFor[AliasSignal](),
+  reflect.TypeFor[SessionStartedSignal](),
+  reflect.TypeFor[SessionEndedSignal](),
+  reflect.TypeFor[OutboxSignal](),
+  reflect.TypeFor[TimeoutSignal](),
+  reflect.TypeFor[StatusSignal](),
+  reflect.TypeFor[SuccessSignal](),
+  reflect.TypeFor[ErrorSignal](),
+  reflect.TypeFor[AckSignal](),
+  reflect.TypeFor[ReadResultSignal](),
+}
+
+// GQL mutation field that sends a signal for an authenticated client from the server node.
+// Only the signal types named in the server's SignalTypes can be sent, and control signals are never sent.
+// The signal argument takes exactly one field, named for the signal type, with the signal's input object.
+// Returns the ID of the sent signal, responses are sent to the server node.
+func sendSignalGQLField(ctx *Context) *graphql.Field {
+  signal_iface := reflect.TypeFor[Signal]()
+  signal_types := []reflect.Type{}
+  for reflect_type, info := range(ctx.Types) {
+    if slices.Contains(gqlControlSignals, reflect_type) {
+      continue
+    }
+    _, is_object := info.Type.(*graphql.Object)
+    if is_object && reflect_type.Kind() == reflect.Struct && reflect.PointerTo(reflect_type).Implements(signal_iface) {
+      signal_types = append(signal_types, reflect_type)
+    }
+  }
+  slices.SortFunc(signal_types, func(a, b reflect.Type) int {
+    return strings.Compare(a.Name(), b.Name())
+  })
+
+  inputs := map[string]signalInput{}
+  signal_fields := graphql.InputObjectConfigFieldMap{}
+  for _, signal_type := range(signal_types) {
+    input_object, input := signalInputObject(ctx, signal_type)
+    inputs[signal_type.Name()] = input
+    signal_fields[signal_type.Name()] = &graphql.InputObjectFieldConfig{
+      Type: input_object,
+    }
+  }
+
+  return &graphql.Field{
+    Type: ctx.Types[reflect.TypeFor[uuid.UUID]()].Type,
+    Args: graphql.FieldConfigArgument{
+      "node": &graphql.ArgumentConfig{
+        Type: graphql.NewNonNull(ctx.Types[reflect.TypeFor[NodeID]()].Type),
+      },
+      "signal": &graphql.ArgumentConfig{
+        Type: graphql.NewNonNull(graphql.NewInputObject(graphql.InputObjectConfig{
+          Name: "SignalInput",
+          Fields: signal_fields,
+        })),
+      },
+    },
+    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+      ctx, err := PrepResolve(p)
+      if err != nil {
+        return nil, err
+      } else if ctx.Client == ZeroID {
+        return nil, fmt.Errorf("SendSignal requires a client certificate")
+      }
+
+      node, err := ExtractParam[NodeID](p, "node")
+      if err != nil {
+        return nil, err
+      }
+
+      signal_arg, ok := p.Args["signal"].(map[string]interface{})
+      if ok == false || len(signal_arg) != 1 {
+        return nil, fmt.Errorf("signal must set exactly one signal type")
+      }
+
+      var signal Signal
+      for name, args := range(signal_arg) {
+        if slices.Contains(ctx.Ext.SignalTypes, name) == false {
+          return nil, fmt.Errorf("%s is not allowed by this server", name)
+        }
+
+        field_args, ok := args.(map[string]interface{})
+        if ok == false {
+          return nil, fmt.Errorf("Bad input for %s: %+v", name, args)
+        }
+        signal, err = inputs[name].build(field_args)
+        if err != nil {
+          return nil, err
+        }
+      }
+
+      if ctx.Context.Firewall != nil {
+        err = ctx.Context.Firewall.Check(ctx.Context, ctx.Client, signal)
+        if err != nil {
+          return nil, err
+        }
+      }
+
+      err = ctx.Context.checkMaintenanceSignal(signal)
+      if err != nil {
+        return nil, err
+      }
+
+      ctx.Context.Log.Logf("gql", "SEND_SIGNAL: %s sending %s to %s", ctx.Client, signal, node)
+      err = ctx.Context.Send(ctx.Server, []Message{{node, signal}})
+      if err != nil {
+        return nil, err
+      }
+      return signal.ID(), nil
+    },
+  }
+}
@@ -0,0 +1,199 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "slices"
+  "strings"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+// Buffer for the unload events a GQLExt watches to remove the subscriptions of nodes that stopped
+const GQL_OWNER_EVENT_BUFFER = 256
+
+// How often a GQLExt checks for subscriptions owned by nodes that aren't loaded, since unload events are dropped when the buffer is full
+const GQL_OWNER_SWEEP_INTERVAL = 30*time.Second
+
+// What a subscription belongs to, it's removed and its channel closed when the node unloads or the session closes.
+// Subscriptions with a zero owner are only removed by RemoveSubscription.
+type SubscriptionOwner struct {
+  Node NodeID
+  Session uuid.UUID
+}
+
+func (owner SubscriptionOwner) owns(info SubscriptionInfo) bool {
+  return (owner.Node != ZeroID && info.Owner.Node == owner.Node) || (owner.Session != ZeroUUID && info.Owner.Session == owner.Session)
+}
+
+// A subscription on a GQLExt, for debugging subscriptions that aren't being consumed
+type SubscriptionStatus struct {
+  ID uuid.UUID `json:"id"`
+  Name string `json:"name"`
+  Session uuid.UUID `json:"session"`
+  // Signals waiting in the subscription's channel
+  Queued int `json:"queued"`
+  Buffer int `json:"buffer"`
+}
+
+// Add a subscription that's removed when its owner stops, named so it can be told apart in Subscriptions.
+// Names only have to be unique among the subscriptions of an owner.
+func (ext *GQLExt) AddNamedSubscription(id uuid.UUID, name string, owner SubscriptionOwner, filter func(NodeID) bool, buffer int) (chan interface{}, error) {
+  ext.subscriptions_lock.Lock()
+  defer ext.subscriptions_lock.Unlock()
+
+  for _, info := range(ext.subscriptions) {
+    if info.ID == id {
+      return nil, fmt.Errorf("%+v already in subscription list", info.ID)
+    } else if name != "" && info.Name == name && info.Owner == owner {
+      return nil, fmt.Errorf("%+v already has a subscription named %s", owner, name)
+    }
+  }
+
+  c := make(chan interface{}, buffer)
+
+  ext.subscriptions = append(ext.subscriptions, SubscriptionInfo{
+    ID: id,
+    Name: name,
+    Owner: owner,
+    Filter: filter,
+    Channel: c,
+  })
+
+  return c, nil
+}
+
+// Remove every subscription owned by the node or session in owner and close their channels, returning how many were removed
+func (ext *GQLExt) RemoveOwnedSubscriptions(owner SubscriptionOwner) int {
+  ext.subscriptions_lock.Lock()
+  defer ext.subscriptions_lock.Unlock()
+
+  kept := ext.subscriptions[:0]
+  removed := 0
+  for _, info := range(ext.subscriptions) {
+    if owner.owns(info) {
+      close(info.Channel)
+      removed += 1
+    } else {
+      kept = append(kept, info)
+    }
+  }
+  ext.subscriptions = kept
+  return removed
+}
+
+// Current subscriptions by the node that owns them, subscriptions without an owning node are under ZeroID
+func (ext *GQLExt) Subscriptions() map[NodeID][]SubscriptionStatus {
+  ext.subscriptions_lock.RLock()
+  defer ext.subscriptions_lock.RUnlock()
+
+  subscriptions := map[NodeID][]SubscriptionStatus{}
+  for _, info := range(ext.subscriptions) {
+    subscriptions[info.Owner.Node] = append(subscriptions[info.Owner.Node], SubscriptionStatus{
+      ID: info.ID,
+      Name: info.Name,
+      Session: info.Owner.Session,
+      Queued: len(info.Channel),
+      Buffer: cap(info.Channel),
+    })
+  }
+
+  for _, statuses := range(subscriptions) {
+    slices.SortFunc(statuses, func(a, b SubscriptionStatus) int {
+      return strings.Compare(a.Name, b.Name)
+    })
+  }
+  return subscriptions
+}
+
+// Remove the subscriptions of nodes that aren't loaded, returning how many were removed
+func (ext *GQLExt) sweepOwners(ctx *Context) int {
+  owners := map[NodeID]bool{}
+  ext.subscriptions_lock.RLock()
+  for _, info := range(ext.subscriptions) {
+    if info.Owner.Node != ZeroID {
+      owners[info.Owner.Node] = true
+    }
+  }
+  ext.subscriptions_lock.RUnlock()
+
+  ctx.nodesLock.RLock()
+  for owner := range(owners) {
+    _, loaded := ctx.nodes[owner]
+    if loaded {
+      delete(owners, owner)
+    }
+  }
+  ctx.nodesLock.RUnlock()
+
+  removed := 0
+  for owner := range(owners) {
+    removed += ext.RemoveOwnedSubscriptions(SubscriptionOwner{Node: owner})
+  }
+  return removed
+}
+
+// Remove the subscriptions of nodes as they're unloaded, and sweep for any whose events were dropped, until stop is called
+func (ext *GQLExt) watchOwners(ctx *Context) func() {
+  events, stop := ctx.SubscribeEvents(GQL_OWNER_EVENT_BUFFER, EventNodeUnloaded)
+  go func() {
+    ticker := time.NewTicker(GQL_OWNER_SWEEP_INTERVAL)
+    defer ticker.Stop()
+
+    for {
+      select {
+      case event, open := <-events:
+        if open == false {
+          return
+        }
+        removed := ext.RemoveOwnedSubscriptions(SubscriptionOwner{Node: event.Node})
+        if removed > 0 {
+          ctx.Log.Logf("gql", "Removed %d subscriptions owned by unloaded node %s", removed, event.Node)
+        }
+      case <-ticker.C:
+        removed := ext.sweepOwners(ctx)
+        if removed > 0 {
+          ctx.Log.Logf("gql", "Swept %d subscriptions owned by nodes that aren't loaded", removed)
+        }
+      }
+    }
+  }()
+  return stop
+}
+
+// Serve the subscriptions of the GQLExt by owning node, to clients that pass the same authentication as the GQL endpoint.
+// Session IDs are only shown to clients that authenticated with a certificate.
+func SubscriptionsHandler(ctx *Context, ext *GQLExt) func(http.ResponseWriter, *http.Request) {
+  return func(w http.ResponseWriter, r *http.Request) {
+    enableCORS(&w)
+    w.Header().Set("Content-Type", "application/json")
+
+    client := ZeroID
+    if ext.ClientCerts {
+      var err error
+      client, err = ClientCertID(r)
+      if err != nil {
+        ctx.Log.Logf("gql", "SUBSCRIPTIONS_AUTH_ERR: %s", err)
+        w.WriteHeader(http.StatusUnauthorized)
+        json.NewEncoder(w).Encode(GQLUnauthorized(""))
+        return
+      }
+    }
+
+    subscriptions := ext.Subscriptions()
+    if client == ZeroID {
+      for _, statuses := range(subscriptions) {
+        for i := range(statuses) {
+          statuses[i].Session = ZeroUUID
+        }
+      }
+    }
+
+    err := json.NewEncoder(w).Encode(subscriptions)
+    if err != nil {
+      ctx.Log.Logf("gql", "SUBSCRIPTIONS_ERR: %s", err)
+    }
+  }
+}
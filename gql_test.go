@@ -2,17 +2,25 @@ package graphvent
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
 	"golang.org/x/net/websocket"
 )
 
@@ -125,6 +133,77 @@ func TestGQLSubscribe(t *testing.T) {
   SubGQL(sub_1)
 }
 
+func TestGQLMultiplex(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  listener_ext := NewListenerExt(10)
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  gql, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil), gql_ext, listener_ext)
+  fatalErr(t, err)
+
+  port := gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port
+  config, err := websocket.NewConfig(fmt.Sprintf("ws://127.0.0.1:%d/gqlws", port), fmt.Sprintf("http://localhost:%d/gql", port))
+  fatalErr(t, err)
+  config.Protocol = append(config.Protocol, "graphql-transport-ws")
+  ws, err := websocket.DialConfig(config)
+  fatalErr(t, err)
+  defer ws.Close()
+
+  send := func(msg GQLWSMsg) {
+    ser, err := json.Marshal(&msg)
+    fatalErr(t, err)
+    _, err = ws.Write(ser)
+    fatalErr(t, err)
+  }
+
+  resp := make([]byte, 1024)
+  read := func() GQLWSMsg {
+    fatalErr(t, ws.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+    n, err := ws.Read(resp)
+    fatalErr(t, err)
+    var msg GQLWSMsg
+    fatalErr(t, json.Unmarshal(resp[:n], &msg))
+    return msg
+  }
+
+  send(GQLWSMsg{Type: "connection_init"})
+  if ack := read(); ack.Type != "connection_ack" {
+    t.Fatalf("Didn't receive connection_ack: %+v", ack)
+  }
+
+  query := GQLPayload{Query: "subscription { Self { ID ... on Lockable { LockableState } } }"}
+  send(GQLWSMsg{ID: "a", Type: "subscribe", Payload: query})
+  send(GQLWSMsg{ID: "b", Type: "subscribe", Payload: query})
+
+  initial := map[string]bool{}
+  for len(initial) < 2 {
+    msg := read()
+    if msg.Type != "next" {
+      t.Fatalf("Unexpected message before initial results: %+v", msg)
+    }
+    initial[msg.ID] = true
+  }
+  if initial["a"] == false || initial["b"] == false {
+    t.Fatalf("Missing initial results: %+v", initial)
+  }
+
+  send(GQLWSMsg{ID: "a", Type: "complete"})
+  if complete := read(); complete.ID != "a" || complete.Type != "complete" {
+    t.Fatalf("Expected a to complete, got %+v", complete)
+  }
+
+  lock_id, err := LockLockable(ctx, gql)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener_ext.Chan, 100*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  update := read()
+  if update.ID != "b" || update.Type != "next" {
+    t.Fatalf("Expected update for b, got %+v", update)
+  }
+}
+
 func TestGQLQuery(t *testing.T) {
   ctx := logTestContext(t, []string{"test", "lockable"})
 
@@ -221,3 +300,660 @@ func TestGQLDB(t *testing.T) {
   listener_ext, err = GetExt[ListenerExt](gql_loaded)
   fatalErr(t, err)
 }
+
+func testClientCert(t *testing.T) (ed25519.PublicKey, tls.Certificate) {
+  client_pub, client_key, err := ed25519.GenerateKey(rand.Reader)
+  fatalErr(t, err)
+
+  template := x509.Certificate{
+    SerialNumber: big.NewInt(1),
+    NotBefore: time.Now(),
+    NotAfter: time.Now().Add(time.Hour),
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+  }
+  client_cert, err := x509.CreateCertificate(rand.Reader, &template, &template, client_pub, client_key)
+  fatalErr(t, err)
+
+  return client_pub, tls.Certificate{Certificate: [][]byte{client_cert}, PrivateKey: client_key}
+}
+
+func TestGQLClientCert(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "gql"})
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  gql_ext.ClientCerts = true
+
+  _, err = ctx.NewNode(nil, "Node", gql_ext)
+  fatalErr(t, err)
+
+  _, client_cert := testClientCert(t)
+
+  port := gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port
+  url := fmt.Sprintf("https://localhost:%d/gql", port)
+  ser, err := json.Marshal(&GQLPayload{Query: "query { Self { ID } }"})
+  fatalErr(t, err)
+
+  SendGQL := func(certs []tls.Certificate) (*http.Response, error) {
+    client := &http.Client{Transport: &http.Transport{
+      TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs},
+    }}
+    return client.Post(url, "application/json", bytes.NewBuffer(ser))
+  }
+
+  _, err = SendGQL(nil)
+  if err == nil {
+    t.Fatal("Request without client certificate succeeded")
+  }
+
+  resp, err := SendGQL([]tls.Certificate{client_cert})
+  fatalErr(t, err)
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    t.Fatalf("Request with client certificate failed: %s", resp.Status)
+  }
+
+  client_pub, client_key, err := ed25519.GenerateKey(rand.Reader)
+  fatalErr(t, err)
+  _, other_key, err := ed25519.GenerateKey(rand.Reader)
+  fatalErr(t, err)
+
+  expired := x509.Certificate{
+    SerialNumber: big.NewInt(2),
+    NotBefore: time.Now().Add(-2*time.Hour),
+    NotAfter: time.Now().Add(-time.Hour),
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+  }
+  expired_cert, err := x509.CreateCertificate(rand.Reader, &expired, &expired, client_pub, client_key)
+  fatalErr(t, err)
+  _, err = SendGQL([]tls.Certificate{{Certificate: [][]byte{expired_cert}, PrivateKey: client_key}})
+  if err == nil {
+    t.Fatal("Request with expired client certificate succeeded")
+  }
+
+  // Signed by a different key than the one in the certificate
+  current := x509.Certificate{
+    SerialNumber: big.NewInt(3),
+    NotBefore: time.Now(),
+    NotAfter: time.Now().Add(time.Hour),
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+  }
+  forged_cert, err := x509.CreateCertificate(rand.Reader, &current, &current, client_pub, other_key)
+  fatalErr(t, err)
+  _, err = SendGQL([]tls.Certificate{{Certificate: [][]byte{forged_cert}, PrivateKey: client_key}})
+  if err == nil {
+    t.Fatal("Request with a client certificate signed by another key succeeded")
+  }
+}
+
+func TestGQLLoadShedding(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "gql"})
+
+  fatalErr(t, RegisterNodeType(ctx, "BoundedGQLNode", nil))
+  fatalErr(t, SetInboxConfig(ctx, "BoundedGQLNode", InboxConfig{Strategy: InboxDrop, Capacity: 100}))
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  if gql_ext.ShedPercent != 0 {
+    t.Fatalf("Shedding enabled by default: %d", gql_ext.ShedPercent)
+  }
+  gql_ext.ShedPercent = GQL_SHED_PERCENT
+
+  gql, err := ctx.NewNode(nil, "BoundedGQLNode", gql_ext)
+  fatalErr(t, err)
+
+  port := gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port
+  url := fmt.Sprintf("http://localhost:%d/gql", port)
+  ser, err := json.Marshal(&GQLPayload{Query: "query { Self { ID } }"})
+  fatalErr(t, err)
+
+  SendGQL := func() *http.Response {
+    resp, err := http.Post(url, "application/json", bytes.NewBuffer(ser))
+    fatalErr(t, err)
+    return resp
+  }
+
+  // Pretend the inbox is full
+  capacity := int64(ctx.NodeTypes[gql.Type].Inbox.Capacity)
+  gql.queued.Add(capacity)
+
+  resp := SendGQL()
+  result := struct {
+    Errors []struct {
+      Extensions map[string]interface{} `json:"extensions"`
+    } `json:"errors"`
+  }{}
+  err = json.NewDecoder(resp.Body).Decode(&result)
+  resp.Body.Close()
+  fatalErr(t, err)
+  if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+    t.Fatalf("Query wasn't shed with a full inbox: %s", resp.Status)
+  } else if len(result.Errors) != 1 || result.Errors[0].Extensions["code"] != "OVERLOADED" || result.Errors[0].Extensions["retriable"] != true {
+    t.Fatalf("Shed query didn't return a retriable error: %+v", result)
+  }
+
+  gql_ext.ShedPercent = 0
+  resp = SendGQL()
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    t.Fatalf("Query was rejected with shedding disabled: %s", resp.Status)
+  }
+
+  gql_ext.ShedPercent = GQL_SHED_PERCENT
+  gql.queued.Add(-capacity)
+  resp = SendGQL()
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    t.Fatalf("Query was rejected with an empty inbox: %s", resp.Status)
+  }
+
+  // A growing inbox has no real capacity to compare against
+  grow_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  grow_ext.ShedPercent = GQL_SHED_PERCENT
+  grow, err := ctx.NewNode(nil, "Node", grow_ext)
+  fatalErr(t, err)
+  grow.queued.Add(int64(10*ctx.NodeTypes[grow.Type].Inbox.Capacity))
+  if grow_ext.shouldShed(ctx, grow, ast.OperationTypeQuery) {
+    t.Fatal("Shed a query on a server with an InboxGrow inbox")
+  }
+}
+
+func TestGQLSubscriptionOwners(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "gql"})
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  _, err = ctx.NewNode(nil, "Node", gql_ext)
+  fatalErr(t, err)
+
+  owner, err := ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+
+  all := func(NodeID) bool { return true }
+  owned, err := gql_ext.AddNamedSubscription(uuid.New(), "status", SubscriptionOwner{Node: owner.ID}, all, 1)
+  fatalErr(t, err)
+  _, err = gql_ext.AddNamedSubscription(uuid.New(), "status", SubscriptionOwner{Node: owner.ID}, all, 1)
+  if err == nil {
+    t.Fatal("Added two subscriptions with the same name and owner")
+  }
+
+  session := uuid.New()
+  _, err = gql_ext.AddNamedSubscription(uuid.New(), "status", SubscriptionOwner{Session: session}, all, 1)
+  fatalErr(t, err)
+
+  subscriptions := gql_ext.Subscriptions()
+  if len(subscriptions[owner.ID]) != 1 || subscriptions[owner.ID][0].Name != "status" || len(subscriptions[ZeroID]) != 1 {
+    t.Fatalf("Wrong subscriptions: %+v", subscriptions)
+  }
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(owner.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  select {
+  case _, open := <-owned:
+    if open {
+      t.Fatal("Received on the subscription of an unloaded node")
+    }
+  case <-time.After(100*time.Millisecond):
+    t.Fatal("Subscription wasn't removed when its owner unloaded")
+  }
+
+  // Session IDs aren't shown to clients that didn't authenticate, and clients without certificates are refused when they're required
+  handler := SubscriptionsHandler(ctx, gql_ext)
+  recorder := httptest.NewRecorder()
+  handler(recorder, httptest.NewRequest("GET", "/subscriptions", nil))
+  var served map[string][]SubscriptionStatus
+  fatalErr(t, json.NewDecoder(recorder.Body).Decode(&served))
+  if len(served[ZeroID.String()]) != 1 || served[ZeroID.String()][0].Session != ZeroUUID {
+    t.Fatalf("Served session IDs to an anonymous client: %+v", served)
+  }
+
+  gql_ext.ClientCerts = true
+  recorder = httptest.NewRecorder()
+  handler(recorder, httptest.NewRequest("GET", "/subscriptions", nil))
+  gql_ext.ClientCerts = false
+  if recorder.Code != http.StatusUnauthorized {
+    t.Fatalf("Served subscriptions without a client certificate: %d", recorder.Code)
+  }
+
+  if gql_ext.RemoveOwnedSubscriptions(SubscriptionOwner{Session: session}) != 1 || len(gql_ext.Subscriptions()) != 0 {
+    t.Fatalf("Session's subscription wasn't removed: %+v", gql_ext.Subscriptions())
+  }
+
+  // A node whose unload event was missed is found by the sweep
+  loaded, err := ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+  _, err = gql_ext.AddNamedSubscription(uuid.New(), "status", SubscriptionOwner{Node: loaded.ID}, all, 1)
+  fatalErr(t, err)
+  missed, err := gql_ext.AddNamedSubscription(uuid.New(), "status", SubscriptionOwner{Node: RandID()}, all, 1)
+  fatalErr(t, err)
+
+  if removed := gql_ext.sweepOwners(ctx); removed != 1 {
+    t.Fatalf("Sweep removed %d subscriptions, expected 1", removed)
+  } else if _, open := <-missed; open {
+    t.Fatal("Swept subscription's channel wasn't closed")
+  } else if len(gql_ext.Subscriptions()[loaded.ID]) != 1 {
+    t.Fatalf("Sweep removed the subscription of a loaded node: %+v", gql_ext.Subscriptions())
+  }
+}
+
+type readCountSink struct {
+  reads chan NodeID
+}
+
+func (sink *readCountSink) RecordSignal(node NodeID, node_type NodeType, signal_type string, latency time.Duration) {
+  if signal_type == "ReadSignal" {
+    sink.reads <- node
+  }
+}
+
+func TestGQLBatchedReads(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  sink := &readCountSink{make(chan NodeID, 100)}
+  ctx.Metrics = sink
+
+  shared, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  r1, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{shared.ID}))
+  fatalErr(t, err)
+  r2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{shared.ID}))
+  fatalErr(t, err)
+  top, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{r1.ID, r2.ID}))
+  fatalErr(t, err)
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  _, err = ctx.NewNode(nil, "Node", gql_ext)
+  fatalErr(t, err)
+
+  port := gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port
+  payload := GQLPayload{
+    Query: "query Node($id:graphvent_NodeID) { Node(id:$id) { ... on Lockable { Requirements { Key { ID ... on Lockable { LockableState, Requirements { Key { ID ... on Lockable { LockableState } } } } } } } } }",
+    Variables: map[string]interface{}{
+      "id": top.ID.String(),
+    },
+  }
+  ser, err := json.Marshal(&payload)
+  fatalErr(t, err)
+  resp, err := http.Post(fmt.Sprintf("http://localhost:%d/gql", port), "application/json", bytes.NewBuffer(ser))
+  fatalErr(t, err)
+  body, err := io.ReadAll(resp.Body)
+  fatalErr(t, err)
+  resp.Body.Close()
+
+  type requirement struct {
+    Key struct {
+      ID string
+      Requirements []struct {
+        Key struct {
+          ID string
+        }
+      }
+    }
+  }
+  result := struct {
+    Data struct {
+      Node struct {
+        Requirements []requirement
+      }
+    }
+  }{}
+  fatalErr(t, json.Unmarshal(body, &result))
+  requirements := result.Data.Node.Requirements
+  if len(requirements) != 2 {
+    t.Fatalf("Wrong requirements: %s", body)
+  }
+  for _, req := range(requirements) {
+    if len(req.Key.Requirements) != 1 || req.Key.Requirements[0].Key.ID != shared.ID.String() {
+      t.Fatalf("Wrong nested requirements: %s", body)
+    }
+  }
+
+  // Metrics are recorded after the read is processed, so wait for the top node and its three requirements
+  reads := map[NodeID]int{}
+  for i := 0; i < 4; i++ {
+    select {
+    case node := <-sink.reads:
+      reads[node] += 1
+    case <-time.After(100*time.Millisecond):
+      t.Fatalf("Only %d reads recorded: %+v", i, reads)
+    }
+  }
+  for len(sink.reads) > 0 {
+    reads[<-sink.reads] += 1
+  }
+  if reads[shared.ID] != 1 || reads[r1.ID] != 1 || reads[r2.ID] != 1 {
+    t.Fatalf("Nodes weren't each read once: %+v", reads)
+  }
+}
+
+func TestGQLSessionSignals(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "gql"})
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  gql_ext.ClientCerts = true
+
+  listener_ext := NewListenerExt(10)
+  _, err = ctx.NewNode(nil, "Node", gql_ext, listener_ext)
+  fatalErr(t, err)
+
+  client_pub, client_cert := testClientCert(t)
+
+  port := gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port
+  config, err := websocket.NewConfig(fmt.Sprintf("wss://127.0.0.1:%d/gqlws", port), fmt.Sprintf("https://localhost:%d/gql", port))
+  fatalErr(t, err)
+  config.Protocol = append(config.Protocol, "graphql-ws")
+  config.TlsConfig = &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{client_cert}}
+
+  ws, err := websocket.DialConfig(config)
+  fatalErr(t, err)
+
+  started, err := WaitForSignal(listener_ext.Chan, 100*time.Millisecond, func(sig *SessionStartedSignal) bool {
+    return true
+  })
+  fatalErr(t, err)
+  if started.Client != KeyID(client_pub) {
+    t.Fatalf("Session started for %s, expected %s", started.Client, KeyID(client_pub))
+  }
+
+  fatalErr(t, ws.Close())
+
+  _, err = WaitForSignal(listener_ext.Chan, 100*time.Millisecond, func(sig *SessionEndedSignal) bool {
+    return sig.Session == started.Session
+  })
+  fatalErr(t, err)
+}
+
+type testTaggedExt struct {
+  Name string `gv:"name" gql:"TaggedName"`
+  Secret string `gv:"secret" gql:"-"`
+  Count int `gv:"count"`
+}
+
+func (ext *testTaggedExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+func (ext *testTaggedExt) Load(ctx *Context, node *Node) error {
+  return nil
+}
+
+func (ext *testTaggedExt) Unload(ctx *Context, node *Node) {
+}
+
+func TestGQLTags(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  ext_type := ExtTypeFor[testTaggedExt]()
+  fatalErr(t, RegisterExtension[testTaggedExt](ctx, nil))
+
+  mappings, err := TagMappings(ctx, ext_type)
+  fatalErr(t, err)
+  if len(mappings) != 1 || mappings["TaggedName"].Tag != "name" {
+    t.Fatalf("Wrong mappings from gql tags: %+v", mappings)
+  }
+  fatalErr(t, RegisterNodeType(ctx, "TaggedNode", mappings))
+
+  err = RegisterNodeType(ctx, "SecretNode", map[string]FieldMapping{
+    "Secret": {
+      Extension: ext_type,
+      Tag: "secret",
+    },
+  })
+  if err == nil {
+    t.Fatal("Registered node type with hidden field")
+  }
+
+  fatalErr(t, RegisterObject[testTaggedExt](ctx))
+  fields := ctx.Types[reflect.TypeFor[testTaggedExt]()].Type.(*graphql.Object).Fields()
+  if _, exists := fields["TaggedName"]; exists == false {
+    t.Fatalf("Tagged field not renamed: %+v", fields)
+  } else if _, exists := fields["secret"]; exists {
+    t.Fatal("Hidden field exposed")
+  } else if _, exists := fields["count"]; exists == false {
+    t.Fatal("Untagged field not exposed by gv tag")
+  }
+}
+
+func TestGQLSendSignal(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  target_listener := NewListenerExt(10)
+  target, err := ctx.NewNode(nil, "Node", target_listener)
+  fatalErr(t, err)
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  gql_ext.ClientCerts = true
+  gql_ext.SignalTypes = []string{"HeartbeatSignal"}
+  _, err = ctx.NewNode(nil, "Node", gql_ext)
+  fatalErr(t, err)
+
+  anonymous_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  anonymous_ext.SignalTypes = []string{"LocaleSignal"}
+  _, err = ctx.NewNode(nil, "Node", anonymous_ext)
+  fatalErr(t, err)
+
+  _, client_cert := testClientCert(t)
+  client := &http.Client{Transport: &http.Transport{
+    TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{client_cert}},
+  }}
+
+  send := func(url string, query string) []byte {
+    payload := GQLPayload{
+      Query: query,
+      Variables: map[string]interface{}{
+        "node": target.ID.String(),
+      },
+    }
+    ser, err := json.Marshal(&payload)
+    fatalErr(t, err)
+    resp, err := client.Post(url, "application/json", bytes.NewBuffer(ser))
+    fatalErr(t, err)
+    body, err := io.ReadAll(resp.Body)
+    fatalErr(t, err)
+    resp.Body.Close()
+    return body
+  }
+
+  url := fmt.Sprintf("https://localhost:%d/gql", gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port)
+  anonymous_url := fmt.Sprintf("http://localhost:%d/gql", anonymous_ext.tcp_listener.Addr().(*net.TCPAddr).Port)
+  locale_query := "mutation Locale($node:graphvent_NodeID!) { SendSignal(node:$node, signal:{LocaleSignal:{timezone:\"UTC\", locale:\"en\"}}) }"
+
+  body := send(anonymous_url, locale_query)
+  if bytes.Contains(body, []byte("requires a client certificate")) == false {
+    t.Fatalf("SendSignal not rejected without a client certificate: %s", body)
+  }
+
+  body = send(url, locale_query)
+  if bytes.Contains(body, []byte("LocaleSignal is not allowed")) == false {
+    t.Fatalf("SendSignal sent a signal type the server doesn't allow: %s", body)
+  }
+
+  body = send(url, "mutation Link($node:graphvent_NodeID!) { SendSignal(node:$node, signal:{LinkSignal:{node_id:$node, action:\"add\"}}) }")
+  if bytes.Contains(body, []byte("LinkSignal")) == false || bytes.Contains(body, []byte("errors")) == false {
+    t.Fatalf("SendSignal accepted a LinkSignal: %s", body)
+  }
+
+  heartbeat_query := "mutation Heartbeat($node:graphvent_NodeID!) { SendSignal(node:$node, signal:{HeartbeatSignal:{_:true}}) }"
+  body = send(url, heartbeat_query)
+  result := struct {
+    Data struct {
+      SendSignal string
+    }
+  }{}
+  fatalErr(t, json.Unmarshal(body, &result))
+  signal_id, err := uuid.Parse(result.Data.SendSignal)
+  if err != nil {
+    t.Fatalf("Bad SendSignal response: %s", body)
+  }
+
+  _, err = WaitForSignal(target_listener.Chan, 100*time.Millisecond, func(sig *HeartbeatSignal) bool {
+    return sig.ID() == signal_id
+  })
+  fatalErr(t, err)
+
+  ctx.Firewall, err = NewFirewall(FirewallRule{Name: "no_heartbeats", SignalType: "HeartbeatSignal"})
+  fatalErr(t, err)
+  body = send(url, heartbeat_query)
+  if bytes.Contains(body, []byte("rejected by firewall rule no_heartbeats")) == false {
+    t.Fatalf("SendSignal not rejected by the firewall: %s", body)
+  }
+}
+
+func TestFirewall(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  _, err := NewFirewall(FirewallRule{Name: "bad_limit", Action: FirewallRateLimit})
+  if err == nil {
+    t.Fatal("Created a rate limit rule without a limit")
+  }
+
+  client := RandID()
+  firewall, err := NewFirewall(
+    FirewallRule{Name: "no_links", SignalType: "LinkSignal"},
+    FirewallRule{Name: "too_big", MinSize: 1000},
+    FirewallRule{Name: "heartbeats", SignalType: "HeartbeatSignal", Source: client, Action: FirewallRateLimit, Limit: 2, Interval: time.Hour},
+  )
+  fatalErr(t, err)
+
+  var firewall_err FirewallError
+  err = firewall.Check(ctx, client, NewLinkSignal("add", RandID()))
+  if errors.As(err, &firewall_err) == false || firewall_err.Rule != "no_links" {
+    t.Fatalf("LinkSignal not dropped: %s", err)
+  }
+
+  fatalErr(t, firewall.Check(ctx, client, NewLockSignal()))
+
+  err = firewall.Check(ctx, client, NewErrorSignal(uuid.New(), string(make([]byte, 1000))))
+  if errors.As(err, &firewall_err) == false || firewall_err.Rule != "too_big" {
+    t.Fatalf("Large signal not dropped: %s", err)
+  }
+
+  fatalErr(t, firewall.Check(ctx, client, NewHeartbeatSignal()))
+  fatalErr(t, firewall.Check(ctx, client, NewHeartbeatSignal()))
+  err = firewall.Check(ctx, client, NewHeartbeatSignal())
+  if errors.As(err, &firewall_err) == false || firewall_err.Rule != "heartbeats" {
+    t.Fatalf("Heartbeat over the rate limit not dropped: %s", err)
+  }
+  fatalErr(t, firewall.Check(ctx, RandID(), NewHeartbeatSignal()))
+}
+
+func TestSignalCatalog(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  recorder := httptest.NewRecorder()
+  SignalCatalogHandler(ctx)(recorder, httptest.NewRequest("GET", "/signals", nil))
+
+  var catalog SignalCatalog
+  fatalErr(t, json.NewDecoder(recorder.Body).Decode(&catalog))
+
+  entries := map[string]SignalCatalogEntry{}
+  for _, entry := range(catalog.Signals) {
+    entries[entry.Name] = entry
+  }
+
+  link, exists := entries["LinkSignal"]
+  if exists == false {
+    t.Fatalf("LinkSignal not in catalog: %+v", catalog)
+  } else if link.Response {
+    t.Fatal("LinkSignal is not a response")
+  } else if link.Sendable {
+    t.Fatal("LinkSignal can be sent with SendSignal")
+  } else if entries["LocaleSignal"].Sendable == false {
+    t.Fatalf("LocaleSignal can't be sent with SendSignal: %+v", entries["LocaleSignal"])
+  }
+
+  fields := map[string]string{}
+  for _, field := range(link.Fields) {
+    fields[field.Name] = field.Type
+  }
+  if fields["node_id"] != "graphvent.NodeID" || fields["action"] != "string" {
+    t.Fatalf("Wrong LinkSignal fields: %+v", link.Fields)
+  }
+
+  if entries["SuccessSignal"].Response == false {
+    t.Fatalf("SuccessSignal should be a response: %+v", entries["SuccessSignal"])
+  }
+}
+
+func TestGenerateGoClient(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  source, err := GenerateGoClient(ctx.SignalCatalog(), "client")
+  fatalErr(t, err)
+
+  for _, expected := range([]string{
+    "package client",
+    "type LocaleSignal struct",
+    "Timezone string `json:\"timezone,omitempty\"`",
+    "func (client *Client) Send(node string, signal Signal) (string, error)",
+  }) {
+    if bytes.Contains(source, []byte(expected)) == false {
+      t.Fatalf("Generated client is missing %q:\n%s", expected, source)
+    }
+  }
+
+  if bytes.Contains(source, []byte("type LinkSignal struct")) {
+    t.Fatalf("Generated client can send LinkSignals:\n%s", source)
+  }
+}
+
+func TestGQLLockGraph(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  requirement, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  lockable, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{requirement.ID}))
+  fatalErr(t, err)
+
+  gql_ext, err := NewGQLExt(ctx, ":0", nil, nil)
+  fatalErr(t, err)
+  _, err = ctx.NewNode(nil, "Node", gql_ext)
+  fatalErr(t, err)
+
+  port := gql_ext.tcp_listener.Addr().(*net.TCPAddr).Port
+  payload := GQLPayload{
+    Query: "query Graph($id:graphvent_NodeID!) { LockGraph(id:$id, depth:1) { ID, State, Owner, Requirements { ID, State, Node { ID, State } } } }",
+    Variables: map[string]interface{}{
+      "id": lockable.ID.String(),
+    },
+  }
+  ser, err := json.Marshal(&payload)
+  fatalErr(t, err)
+  resp, err := http.Post(fmt.Sprintf("http://localhost:%d/gql", port), "application/json", bytes.NewBuffer(ser))
+  fatalErr(t, err)
+  body, err := io.ReadAll(resp.Body)
+  fatalErr(t, err)
+  resp.Body.Close()
+
+  result := struct {
+    Data struct {
+      LockGraph struct {
+        ID string
+        State string
+        Owner *string
+        Requirements []struct {
+          ID string
+          State string
+          Node *struct {
+            ID string
+            State string
+          }
+        }
+      }
+    }
+  }{}
+  fatalErr(t, json.Unmarshal(body, &result))
+
+  graph := result.Data.LockGraph
+  if graph.ID != lockable.ID.String() || graph.State != "Unlocked" || graph.Owner != nil || len(graph.Requirements) != 1 {
+    t.Fatalf("Wrong lock graph: %s", body)
+  } else if graph.Requirements[0].Node == nil || graph.Requirements[0].Node.ID != requirement.ID.String() {
+    t.Fatalf("Wrong requirement in lock graph: %s", body)
+  }
+}
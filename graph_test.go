@@ -5,6 +5,7 @@ import (
   "runtime/debug"
   "time"
   badger "github.com/dgraph-io/badger/v3"
+  "github.com/rs/zerolog"
 )
 
 func NewSimpleListener(ctx *Context, buffer int) (*Node, *ListenerExt, error) {
@@ -25,6 +26,11 @@ func logTestContext(t * testing.T, components []string) *Context {
   }, NewConsoleLogger(components))
   fatalErr(t, err)
 
+  // Stop the nodes so they aren't left running into the next test
+  t.Cleanup(func() {
+    ctx.Stop()
+  })
+
   return ctx
 }
 
@@ -51,3 +57,32 @@ func testSend(t *testing.T, ctx *Context, signal Signal, source, destination *No
 
   return response, signals
 }
+
+func TestLogLimit(t *testing.T) {
+  sampled := 0
+  sampler := LogLimit{Sample: 2}.sampler()
+  for i := 0; i < 10; i++ {
+    if sampler.Sample(zerolog.NoLevel) {
+      sampled += 1
+    }
+  }
+  if sampled != 5 {
+    t.Fatalf("Sampled %d of 10 lines, expected 5", sampled)
+  }
+
+  sampled = 0
+  sampler = LogLimit{Sample: 2, Rate: 3}.sampler()
+  for i := 0; i < 20; i++ {
+    if sampler.Sample(zerolog.NoLevel) {
+      sampled += 1
+    }
+  }
+  if sampled != 3 {
+    t.Fatalf("Logged %d lines within a second, expected 3", sampled)
+  }
+
+  logger := NewConsoleLogger([]string{"test"})
+  fatalErr(t, logger.SetLimit("test", LogLimit{Rate: 1}))
+  logger.Logf("test", "logged")
+  logger.Logf("test", "dropped")
+}
@@ -0,0 +1,318 @@
+package graphvent
+
+import (
+  "fmt"
+  "maps"
+  "reflect"
+  "slices"
+  "strings"
+  "time"
+
+  "github.com/google/uuid"
+  "github.com/graphql-go/graphql"
+)
+
+// A snapshot of the mapped fields of a node, taken after it was created and after every signal that changed it.
+// Delta versions only hold the fields that changed since the version before them.
+type NodeVersion struct {
+  Version uint64 `gv:"version"`
+  // Unix time in nanoseconds that the version was written
+  Time int64 `gv:"time"`
+  Fields map[string]SerializedValue `gv:"fields"`
+  Delta bool `gv:"delta"`
+  // Node fields the trigger changed, empty for the version written when the node was created or loaded
+  Changed []string `gv:"changed"`
+  Trigger VersionTrigger `gv:"trigger"`
+}
+
+// The signal that was processed to produce a version, zero for the version written when the node was created or loaded
+type VersionTrigger struct {
+  Signal string `gv:"signal"`
+  ID uuid.UUID `gv:"id"`
+  Source NodeID `gv:"source"`
+}
+
+// Most delta versions stored after a full version, the next delta is folded into a full version so reading a version never applies more than this many
+const HISTORY_MAX_DELTAS = 16
+
+// Set how many versions of each node of a registered type are kept in the DB, 0 to disable history
+func SetNodeHistory(ctx *Context, name string, versions int) error {
+  if versions < 0 {
+    return fmt.Errorf("Cannot keep %d versions of %s", versions, name)
+  } else if versions > 0 && ctx.persistent() == false {
+    return fmt.Errorf("Cannot keep history of %s: %w", name, NoPersistenceError)
+  }
+
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set history for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set history for unregistered node type %s", name)
+  }
+
+  node_info.History = versions
+  ctx.NodeTypes[node_type] = node_info
+  return nil
+}
+
+// Write the fields in changes as a new delta version if history is enabled for its type, recording the signal that changed them.
+// The first version written after the node is created or loaded has every field, since the history may have been trimmed or enabled while it was unloaded.
+func (node *Node) writeVersion(ctx *Context, changes Changes, trigger VersionTrigger) error {
+  keep := ctx.NodeTypes[node.Type].History
+  if keep == 0 {
+    return nil
+  }
+
+  changed, err := node.serializeChanges(ctx, changes)
+  if err != nil {
+    return err
+  }
+
+  changed_names := make([]string, 0, len(changed))
+  for field_name := range(changed) {
+    changed_names = append(changed_names, field_name)
+  }
+  slices.Sort(changed_names)
+
+  fields := changed
+  delta := node.versionBase && changes != nil
+  if delta == false {
+    fields, err = node.serializeFields(ctx)
+    if err != nil {
+      return err
+    }
+  }
+
+  err = ctx.DB.WriteNodeVersion(ctx, node.ID, NodeVersion{
+    Time: time.Now().UnixNano(),
+    Fields: fields,
+    Delta: delta,
+    Changed: changed_names,
+    Trigger: trigger,
+  }, keep)
+  if err != nil {
+    // The next version can't be a delta from one that wasn't written
+    node.versionBase = false
+    return err
+  }
+  node.versionBase = true
+  return nil
+}
+
+// Serialize the current value of each node field in changes
+func (node *Node) serializeChanges(ctx *Context, changes Changes) (map[string]SerializedValue, error) {
+  node_info := ctx.NodeTypes[node.Type]
+  values := map[string]SerializedValue{}
+  for ext_type, ext_fields := range(changes.ByExtension()) {
+    ext, has_ext := node.Extensions[ext_type]
+    if has_ext == false {
+      continue
+    }
+
+    for _, ext_tag := range(ext_fields) {
+      field_name, mapped := node_info.ReverseFields[ext_type][ext_tag]
+      if mapped == false || node_info.Fields[field_name].Compute != nil {
+        continue
+      }
+
+      value, err := SerializeAny(ctx, fieldOrZero(reflect.ValueOf(ext).Elem(), node_info.Fields[field_name].Index))
+      if err != nil {
+        return nil, fmt.Errorf("Failed to serialize %s on %s: %w", field_name, node.ID, err)
+      }
+      values[field_name] = value
+    }
+  }
+  return values, nil
+}
+
+// Expand the delta versions of a history into full versions, the history has to start with a full version
+func compactHistory(history []NodeVersion) ([]NodeVersion, error) {
+  compacted := make([]NodeVersion, len(history))
+  var fields map[string]SerializedValue
+  for i, version := range(history) {
+    if version.Delta {
+      if fields == nil {
+        return nil, fmt.Errorf("Version %d is a delta with no full version before it", version.Version)
+      }
+      folded := maps.Clone(fields)
+      for field_name, value := range(version.Fields) {
+        folded[field_name] = value
+      }
+      version.Fields = folded
+      version.Delta = false
+    }
+    fields = version.Fields
+    compacted[i] = version
+  }
+  return compacted, nil
+}
+
+// Append a version to a stored history with the next version number, keeping at most the last keep versions.
+// Versions dropped from the front are folded into the new oldest version, and a delta is stored as a full version once HISTORY_MAX_DELTAS deltas follow the last full one.
+func appendVersion(history []NodeVersion, version NodeVersion, keep int) ([]NodeVersion, error) {
+  version.Version = 1
+  if len(history) > 0 {
+    version.Version = history[len(history)-1].Version + 1
+  }
+
+  if version.Delta {
+    deltas := 0
+    for i := len(history) - 1; i >= 0 && history[i].Delta; i-- {
+      deltas += 1
+    }
+    if deltas >= HISTORY_MAX_DELTAS {
+      folded, err := compactHistory(append(slices.Clone(history), version))
+      if err != nil {
+        return nil, err
+      }
+      version = folded[len(folded)-1]
+    }
+  }
+
+  history = append(history, version)
+  if len(history) > keep {
+    drop := len(history) - keep
+    if history[drop].Delta {
+      folded, err := compactHistory(history[:drop+1])
+      if err != nil {
+        return nil, err
+      }
+      history[drop] = folded[drop]
+    }
+    history = history[drop:]
+  }
+  return history, nil
+}
+
+// Get the stored versions of a node with every field, oldest first
+func ReadNodeHistory(ctx *Context, id NodeID) ([]NodeVersion, error) {
+  history, err := ctx.DB.LoadNodeHistory(ctx, id)
+  if err != nil {
+    return nil, err
+  }
+  return compactHistory(history)
+}
+
+// Get the version of a node that was current at the time
+func ReadNodeAt(ctx *Context, id NodeID, at time.Time) (NodeVersion, error) {
+  history, err := ReadNodeHistory(ctx, id)
+  if err != nil {
+    return NodeVersion{}, err
+  }
+
+  for i := len(history) - 1; i >= 0; i-- {
+    if history[i].Time <= at.UnixNano() {
+      return history[i], nil
+    }
+  }
+
+  return NodeVersion{}, fmt.Errorf("No version of %s stored from before %s", id, at)
+}
+
+// Get a specific version of a node
+func ReadNodeVersion(ctx *Context, id NodeID, version uint64) (NodeVersion, error) {
+  history, err := ReadNodeHistory(ctx, id)
+  if err != nil {
+    return NodeVersion{}, err
+  }
+
+  for _, node_version := range(history) {
+    if node_version.Version == version {
+      return node_version, nil
+    }
+  }
+
+  return NodeVersion{}, fmt.Errorf("Version %d of %s is not stored", version, id)
+}
+
+type historyField struct {
+  Field string
+  Value string
+}
+
+// GQL query field that returns the stored versions of a node, with field values formatted as strings
+func historyGQLField(ctx *Context) *graphql.Field {
+  gql_field := graphql.NewObject(graphql.ObjectConfig{
+    Name: "NodeVersionField",
+    Fields: graphql.Fields{
+      "Field": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(historyField).Field, nil
+        },
+      },
+      "Value": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(historyField).Value, nil
+        },
+      },
+    },
+  })
+
+  gql_version := graphql.NewObject(graphql.ObjectConfig{
+    Name: "NodeVersion",
+    Fields: graphql.Fields{
+      "Version": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return int(p.Source.(NodeVersion).Version), nil
+        },
+      },
+      "Time": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return time.Unix(0, p.Source.(NodeVersion).Time).Format(time.RFC3339Nano), nil
+        },
+      },
+      "Fields": &graphql.Field{
+        Type: graphql.NewList(gql_field),
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          ctx, err := PrepResolve(p)
+          if err != nil {
+            return nil, err
+          }
+
+          fields := []historyField{}
+          for field_name, serialized := range(p.Source.(NodeVersion).Fields) {
+            value, err := serialized.Deserialize(ctx.Context)
+            if err != nil {
+              return nil, err
+            }
+            fields = append(fields, historyField{field_name, fmt.Sprintf("%+v", value)})
+          }
+          slices.SortFunc(fields, func(a, b historyField) int {
+            return strings.Compare(a.Field, b.Field)
+          })
+          return fields, nil
+        },
+      },
+    },
+  })
+
+  return &graphql.Field{
+    Type: graphql.NewList(gql_version),
+    Args: graphql.FieldConfigArgument{
+      "id": &graphql.ArgumentConfig{
+        Type: ctx.Types[reflect.TypeFor[NodeID]()].Type,
+      },
+    },
+    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+      ctx, err := PrepResolve(p)
+      if err != nil {
+        return nil, err
+      }
+
+      id, err := ExtractParam[NodeID](p, "id")
+      if err != nil {
+        return nil, err
+      }
+
+      return ReadNodeHistory(ctx.Context, id)
+    },
+  }
+}
@@ -0,0 +1,165 @@
+package graphvent
+
+import (
+  "fmt"
+  "slices"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+var idempotencyExtType = ExtTypeFor[IdempotencyExt]()
+
+// How long a key without any responses is treated as in progress, after that a retry processes the signal again
+const IDEMPOTENCY_PENDING_TIMEOUT = time.Minute
+
+// Wraps a signal so a node with an IdempotencyExt only processes it once for each key from each source.
+// Retries with the same key get the responses sent the first time instead of being processed again,
+// so they should wrap the same signal for the cached responses to match its ID.
+type IdempotentSignal struct {
+  SignalHeader
+  Key string `gv:"key"`
+  Signal Signal `gv:"signal"`
+}
+func (signal IdempotentSignal) String() string {
+  return fmt.Sprintf("IdempotentSignal(%s, %s, %s)", signal.SignalHeader, signal.Key, signal.Signal)
+}
+func NewIdempotentSignal(key string, signal Signal) *IdempotentSignal {
+  return &IdempotentSignal{
+    NewSignalHeader(),
+    key,
+    signal,
+  }
+}
+
+// The responses a node sent for a signal with an idempotency key
+type IdempotentResult struct {
+  ReqID uuid.UUID `gv:"req_id"`
+  // Unix time in nanoseconds the signal was first received
+  Time int64 `gv:"time"`
+  Responses []Signal `gv:"responses"`
+}
+
+// Stores the results of the most recent signals received with idempotency keys
+type IdempotencyExt struct {
+  // Number of keys to keep, the oldest are dropped first
+  Capacity int `gv:"capacity"`
+  // Keyed by the source and key together, see idempotencyKey
+  Results map[string]IdempotentResult `gv:"results"`
+
+  // Map from request IDs to the key they were received with
+  keys map[uuid.UUID]string
+}
+
+func NewIdempotencyExt(capacity int) *IdempotencyExt {
+  return &IdempotencyExt{
+    Capacity: capacity,
+    Results: map[string]IdempotentResult{},
+  }
+}
+
+func (ext *IdempotencyExt) Load(ctx *Context, node *Node) error {
+  if ext.Results == nil {
+    ext.Results = map[string]IdempotentResult{}
+  }
+
+  ext.keys = map[uuid.UUID]string{}
+  for key, result := range(ext.Results) {
+    ext.keys[result.ReqID] = key
+  }
+  return nil
+}
+
+func (ext *IdempotencyExt) Unload(ctx *Context, node *Node) {
+}
+
+func (ext *IdempotencyExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+// Keys are scoped to the node that sent them, so two sources using the same key don't get each other's responses
+func idempotencyKey(source NodeID, key string) string {
+  return fmt.Sprintf("%s/%s", source, key)
+}
+
+// Add a key for the request, dropping the oldest key if over capacity
+func (ext *IdempotencyExt) add(key string, req_id uuid.UUID) Changes {
+  ext.Results[key] = IdempotentResult{
+    ReqID: req_id,
+    Time: time.Now().UnixNano(),
+    Responses: []Signal{},
+  }
+  ext.keys[req_id] = key
+
+  for len(ext.Results) > ext.Capacity {
+    oldest_key := ""
+    var oldest int64 = 0
+    for key, result := range(ext.Results) {
+      if oldest_key == "" || result.Time < oldest {
+        oldest_key = key
+        oldest = result.Time
+      }
+    }
+    delete(ext.keys, ext.Results[oldest_key].ReqID)
+    delete(ext.Results, oldest_key)
+  }
+
+  var changes Changes = nil
+  changes.Add(idempotencyExtType, ChangeSet, "results")
+  return changes
+}
+
+// Record the responses in messages to requests that had idempotency keys
+func (ext *IdempotencyExt) record(messages []Message) Changes {
+  var changes Changes = nil
+  for _, msg := range(messages) {
+    response, is_response := msg.Signal.(ResponseSignal)
+    if is_response == false {
+      continue
+    }
+
+    key, has_key := ext.keys[response.ResponseID()]
+    if has_key {
+      result := ext.Results[key]
+      result.Responses = append(slices.Clone(result.Responses), response)
+      ext.Results[key] = result
+      changes.Add(idempotencyExtType, ChangeSet, "results")
+    }
+  }
+  return changes
+}
+
+// Process the signal wrapped in an IdempotentSignal if its key hasn't been seen, otherwise resend the responses
+func (node *Node) handleIdempotentSignal(ctx *Context, source NodeID, signal *IdempotentSignal) {
+  ext, has_ext := node.Extensions[idempotencyExtType].(*IdempotencyExt)
+  if has_ext == false {
+    node.handleSignal(ctx, source, signal.Signal)
+    return
+  }
+
+  key := idempotencyKey(source, signal.Key)
+  result, seen := ext.Results[key]
+  if seen && len(result.Responses) == 0 && time.Since(time.Unix(0, result.Time)) < IDEMPOTENCY_PENDING_TIMEOUT {
+    // The first delivery is still being processed, and it's responses go to the same source
+    ctx.Log.Logf("idempotency", "%s is still processing %s from %s, dropping the retry", node.ID, signal.Key, source)
+    return
+  } else if seen && len(result.Responses) == 0 {
+    // The signal didn't respond, so the key would be in progress forever
+    ctx.Log.Logf("idempotency", "%s got no responses to %s from %s after %s, processing the retry", node.ID, signal.Key, source, IDEMPOTENCY_PENDING_TIMEOUT)
+    delete(ext.keys, result.ReqID)
+  } else if seen {
+    ctx.Log.Logf("idempotency", "%s already processed %s, resending %d responses", node.ID, signal.Key, len(result.Responses))
+    messages := make([]Message, len(result.Responses))
+    for i, response := range(result.Responses) {
+      messages[i] = Message{source, response}
+    }
+    ctx.Send(node, messages)
+    return
+  }
+
+  // Changes made outside of Process are written with the node's next changes the same as the ones made in it
+  for _, change := range(ext.add(key, signal.Signal.ID())) {
+    node.unwritten.Add(change.Extension, change.Op, change.Field)
+  }
+  node.handleSignal(ctx, source, signal.Signal)
+}
@@ -0,0 +1,695 @@
+package graphvent
+
+import (
+  "bytes"
+  "encoding"
+  "encoding/binary"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "reflect"
+  "strconv"
+)
+
+// An alternate text encoding for the values Serialize writes, so node state and signals can be exported and re-imported as JSON.
+// Structs are objects keyed by their gv tags, maps with string keys are objects and other maps are lists of [key, value] pairs,
+// byte slices are base64, types with MarshalText are strings, and integers are numbers that never pass through float64.
+// Interfaces and SerializedValues are {"type": [...], "value": ...}, with the type stack spelled out by name like ["slice", "graphvent.NodeID"].
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+var serializedValueType = reflect.TypeFor[SerializedValue]()
+
+type typeName string
+func (name typeName) String() string {
+  return string(name)
+}
+
+type jsonTyped struct {
+  Type []any `json:"type"`
+  Value any `json:"value"`
+}
+
+func SerializeJSON[T any](ctx *Context, value T) ([]byte, error) {
+  return SerializeJSONValue(ctx, reflect.ValueOf(&value).Elem())
+}
+
+func DeserializeJSON[T any](ctx *Context, data []byte) (T, error) {
+  var zero T
+  value, err := DeserializeJSONValue(ctx, data, reflect.TypeFor[T]())
+  if err != nil {
+    return zero, err
+  }
+  return value.Interface().(T), nil
+}
+
+func SerializeJSONValue(ctx *Context, value reflect.Value) ([]byte, error) {
+  tree, err := encodeJSON(ctx, value)
+  if err != nil {
+    return nil, err
+  }
+  return json.Marshal(tree)
+}
+
+func DeserializeJSONValue(ctx *Context, data []byte, t reflect.Type) (reflect.Value, error) {
+  tree, err := parseJSON(data)
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  return decodeJSON(ctx, tree, t)
+}
+
+// SerializationFormat for the JSON encoding
+type JSONFormat struct {}
+
+func (JSONFormat) Encode(ctx *Context, value reflect.Value) ([]byte, error) {
+  return SerializeJSONValue(ctx, value)
+}
+
+func (JSONFormat) Decode(ctx *Context, data []byte, t reflect.Type) (reflect.Value, error) {
+  return DeserializeJSONValue(ctx, data, t)
+}
+
+// Encode the value and its type as JSON
+func (value SerializedValue) JSON(ctx *Context) ([]byte, error) {
+  return SerializeJSONValue(ctx, reflect.ValueOf(value))
+}
+
+// Parse a SerializedValue from the JSON written by SerializedValue.JSON
+func SerializedValueFromJSON(ctx *Context, data []byte) (SerializedValue, error) {
+  return DeserializeJSON[SerializedValue](ctx, data)
+}
+
+func parseJSON(data []byte) (any, error) {
+  decoder := json.NewDecoder(bytes.NewReader(data))
+  decoder.UseNumber()
+
+  var tree any
+  err := decoder.Decode(&tree)
+  if err != nil {
+    return nil, err
+  } else if decoder.More() {
+    return nil, fmt.Errorf("Data left after JSON value")
+  }
+  return tree, nil
+}
+
+// Names of the entries in the type stack for t, with array lengths as uint64
+func typeStackNames(ctx *Context, t reflect.Type) ([]any, error) {
+  info, registered := ctx.Types[t]
+  if registered {
+    return []any{info.Reflect.String()}, nil
+  }
+
+  switch t.Kind() {
+  case reflect.Map:
+    key, err := typeStackNames(ctx, t.Key())
+    if err != nil {
+      return nil, err
+    }
+    elem, err := typeStackNames(ctx, t.Elem())
+    if err != nil {
+      return nil, err
+    }
+    return append(append([]any{reflect.Map.String()}, key...), elem...), nil
+  case reflect.Pointer, reflect.Slice:
+    elem, err := typeStackNames(ctx, t.Elem())
+    if err != nil {
+      return nil, err
+    }
+    return append([]any{t.Kind().String()}, elem...), nil
+  case reflect.Array:
+    elem, err := typeStackNames(ctx, t.Elem())
+    if err != nil {
+      return nil, err
+    }
+    return append([]any{reflect.Array.String(), uint64(t.Len())}, elem...), nil
+  default:
+    return nil, fmt.Errorf("Hit %s, which is not a registered type", t)
+  }
+}
+
+// Convert a type stack written by typeStackNames back to the binary form UnwrapStack reads, array lengths are uint64 or json.Number
+func unwrapStackNames(ctx *Context, names []any) (reflect.Type, error) {
+  stack := []byte{}
+  for _, name := range(names) {
+    switch name := name.(type) {
+    case string:
+      stack = binary.BigEndian.AppendUint64(stack, uint64(SerializeType(typeName(name))))
+    case json.Number:
+      length, err := strconv.ParseUint(string(name), 10, 64)
+      if err != nil {
+        return nil, err
+      }
+      stack = binary.BigEndian.AppendUint64(stack, length)
+    case uint64:
+      stack = binary.BigEndian.AppendUint64(stack, name)
+    default:
+      return nil, fmt.Errorf("Invalid type stack entry %v", name)
+    }
+  }
+
+  t, left, err := UnwrapStack(ctx, stack)
+  if err != nil {
+    return nil, err
+  } else if len(left) != 0 {
+    return nil, fmt.Errorf("Type stack %v has entries left after %s", names, t)
+  }
+  return t, nil
+}
+
+func encodeTypedJSON(ctx *Context, value reflect.Value) (any, error) {
+  stack, err := typeStackNames(ctx, value.Type())
+  if err != nil {
+    return nil, err
+  }
+  encoded, err := encodeJSON(ctx, value)
+  if err != nil {
+    return nil, err
+  }
+  return jsonTyped{stack, encoded}, nil
+}
+
+func decodeTypedJSON(ctx *Context, data any) (reflect.Value, error) {
+  object, ok := data.(map[string]any)
+  if ok == false {
+    return reflect.Value{}, fmt.Errorf("Expected a typed value object, got %T", data)
+  }
+  names, ok := object["type"].([]any)
+  if ok == false {
+    return reflect.Value{}, fmt.Errorf("Typed value is missing its type stack")
+  }
+  t, err := unwrapStackNames(ctx, names)
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  return decodeJSON(ctx, object["value"], t)
+}
+
+func encodeJSON(ctx *Context, value reflect.Value) (any, error) {
+  t := value.Type()
+  if t == serializedValueType {
+    if value.IsNil() {
+      return nil, nil
+    }
+    inner, err := SerializedValue(value.Bytes()).Deserialize(ctx)
+    if err != nil {
+      return nil, err
+    }
+    return encodeTypedJSON(ctx, inner)
+  } else if isLazy(t) {
+    inner, err := asLazy(value).lazyDecode(ctx)
+    if err != nil {
+      return nil, err
+    }
+    return encodeJSON(ctx, inner)
+  } else if t.Implements(textMarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    text, err := value.Interface().(encoding.TextMarshaler).MarshalText()
+    if err != nil {
+      return nil, err
+    }
+    return string(text), nil
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    return value.Bool(), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return json.Number(strconv.FormatInt(value.Int(), 10)), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return json.Number(strconv.FormatUint(value.Uint(), 10)), nil
+  case reflect.Float32, reflect.Float64:
+    return value.Float(), nil
+  case reflect.String:
+    return value.String(), nil
+
+  case reflect.Pointer:
+    if value.IsNil() {
+      return nil, nil
+    }
+    return encodeJSON(ctx, value.Elem())
+
+  case reflect.Slice:
+    if value.IsNil() {
+      return nil, nil
+    } else if t.Elem().Kind() == reflect.Uint8 {
+      return base64.StdEncoding.EncodeToString(value.Bytes()), nil
+    }
+    fallthrough
+  case reflect.Array:
+    list := make([]any, value.Len())
+    for i := 0; i < value.Len(); i++ {
+      elem, err := encodeJSON(ctx, value.Index(i))
+      if err != nil {
+        return nil, err
+      }
+      list[i] = elem
+    }
+    return list, nil
+
+  case reflect.Map:
+    if value.IsNil() {
+      return nil, nil
+    }
+
+    if t.Key().Kind() == reflect.String {
+      object := map[string]any{}
+      iter := value.MapRange()
+      for iter.Next() {
+        elem, err := encodeJSON(ctx, iter.Value())
+        if err != nil {
+          return nil, err
+        }
+        object[iter.Key().String()] = elem
+      }
+      return object, nil
+    }
+
+    pairs := []any{}
+    iter := value.MapRange()
+    for iter.Next() {
+      key, err := encodeJSON(ctx, iter.Key())
+      if err != nil {
+        return nil, err
+      }
+      elem, err := encodeJSON(ctx, iter.Value())
+      if err != nil {
+        return nil, err
+      }
+      pairs = append(pairs, []any{key, elem})
+    }
+    return pairs, nil
+
+  case reflect.Struct:
+    info, registered := ctx.Types[t]
+    if registered == false {
+      return nil, fmt.Errorf("Cannot encode unregistered struct %s", t)
+    }
+    object := map[string]any{}
+    for _, field_info := range(info.Fields) {
+      field_value, set := structField(value, field_info.Index)
+      if set == false {
+        continue
+      }
+      field, err := encodeJSON(ctx, field_value)
+      if err != nil {
+        return nil, err
+      }
+      object[t.FieldByIndex(field_info.Index).Tag.Get("gv")] = field
+    }
+    return object, nil
+
+  case reflect.Interface:
+    if value.IsNil() {
+      return nil, nil
+    }
+    return encodeTypedJSON(ctx, value.Elem())
+
+  default:
+    return nil, fmt.Errorf("Don't know how to encode %s as JSON", t)
+  }
+}
+
+func decodeJSON(ctx *Context, data any, t reflect.Type) (reflect.Value, error) {
+  value := reflect.New(t).Elem()
+
+  if t == serializedValueType {
+    if data == nil {
+      return value, nil
+    }
+    inner, err := decodeTypedJSON(ctx, data)
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    serialized, err := SerializeAny(ctx, inner)
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    value.SetBytes(serialized)
+    return value, nil
+  } else if isLazy(t) {
+    inner, err := decodeJSON(ctx, data, value.Addr().Interface().(lazyValue).lazyType())
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    value.Addr().Interface().(lazyField).setValue(inner)
+    return value, nil
+  } else if t.Implements(textMarshalerType) && reflect.PointerTo(t).Implements(textUnmarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    text, ok := data.(string)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a string for %s, got %T", t, data)
+    }
+    err := value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    return value, nil
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    b, ok := data.(bool)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a bool for %s, got %T", t, data)
+    }
+    value.SetBool(b)
+    return value, nil
+
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    number, ok := data.(json.Number)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a number for %s, got %T", t, data)
+    }
+    i, err := strconv.ParseInt(string(number), 10, t.Bits())
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    value.SetInt(i)
+    return value, nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    number, ok := data.(json.Number)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a number for %s, got %T", t, data)
+    }
+    u, err := strconv.ParseUint(string(number), 10, t.Bits())
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    value.SetUint(u)
+    return value, nil
+  case reflect.Float32, reflect.Float64:
+    number, ok := data.(json.Number)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a number for %s, got %T", t, data)
+    }
+    f, err := number.Float64()
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    value.SetFloat(f)
+    return value, nil
+  case reflect.String:
+    s, ok := data.(string)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a string for %s, got %T", t, data)
+    }
+    value.SetString(s)
+    return value, nil
+
+  case reflect.Pointer:
+    if data == nil {
+      return value, nil
+    }
+    elem, err := decodeJSON(ctx, data, t.Elem())
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    pointer := reflect.New(t.Elem())
+    pointer.Elem().Set(elem)
+    value.Set(pointer)
+    return value, nil
+
+  case reflect.Slice:
+    if data == nil {
+      return value, nil
+    } else if t.Elem().Kind() == reflect.Uint8 {
+      encoded, ok := data.(string)
+      if ok == false {
+        return reflect.Value{}, fmt.Errorf("Expected a base64 string for %s, got %T", t, data)
+      }
+      b, err := base64.StdEncoding.DecodeString(encoded)
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      value.SetBytes(b)
+      return value, nil
+    }
+
+    list, ok := data.([]any)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a list for %s, got %T", t, data)
+    }
+    value.Set(reflect.MakeSlice(t, len(list), len(list)))
+    for i, elem_data := range(list) {
+      elem, err := decodeJSON(ctx, elem_data, t.Elem())
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      value.Index(i).Set(elem)
+    }
+    return value, nil
+
+  case reflect.Array:
+    list, ok := data.([]any)
+    if ok == false || len(list) != t.Len() {
+      return reflect.Value{}, fmt.Errorf("Expected a list of %d for %s, got %v", t.Len(), t, data)
+    }
+    for i, elem_data := range(list) {
+      elem, err := decodeJSON(ctx, elem_data, t.Elem())
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      value.Index(i).Set(elem)
+    }
+    return value, nil
+
+  case reflect.Map:
+    if data == nil {
+      return value, nil
+    }
+    value.Set(reflect.MakeMap(t))
+
+    if t.Key().Kind() == reflect.String {
+      object, ok := data.(map[string]any)
+      if ok == false {
+        return reflect.Value{}, fmt.Errorf("Expected an object for %s, got %T", t, data)
+      }
+      for key, elem_data := range(object) {
+        elem, err := decodeJSON(ctx, elem_data, t.Elem())
+        if err != nil {
+          return reflect.Value{}, err
+        }
+        value.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+      }
+      return value, nil
+    }
+
+    pairs, ok := data.([]any)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected a list of pairs for %s, got %T", t, data)
+    }
+    for _, pair_data := range(pairs) {
+      pair, ok := pair_data.([]any)
+      if ok == false || len(pair) != 2 {
+        return reflect.Value{}, fmt.Errorf("Expected a [key, value] pair for %s, got %v", t, pair_data)
+      }
+      key, err := decodeJSON(ctx, pair[0], t.Key())
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      elem, err := decodeJSON(ctx, pair[1], t.Elem())
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      value.SetMapIndex(key, elem)
+    }
+    return value, nil
+
+  case reflect.Struct:
+    info, registered := ctx.Types[t]
+    if registered == false {
+      return reflect.Value{}, fmt.Errorf("Cannot decode unregistered struct %s", t)
+    }
+    object, ok := data.(map[string]any)
+    if ok == false {
+      return reflect.Value{}, fmt.Errorf("Expected an object for %s, got %T", t, data)
+    }
+    for gv_tag, field_data := range(object) {
+      // Fields that have been removed from the struct are skipped
+      field_info, mapped := info.Fields[GetFieldTag(gv_tag)]
+      if mapped == false {
+        continue
+      }
+      field, err := decodeJSON(ctx, field_data, field_info.Type)
+      if err != nil {
+        return reflect.Value{}, err
+      }
+      settableField(value, field_info.Index).Set(field)
+    }
+    if info.PostDeserializeIndex != -1 {
+      value.Addr().Method(info.PostDeserializeIndex).Call([]reflect.Value{reflect.ValueOf(ctx)})
+    }
+    return value, nil
+
+  case reflect.Interface:
+    if data == nil {
+      return value, nil
+    }
+    elem, err := decodeTypedJSON(ctx, data)
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    value.Set(elem)
+    return value, nil
+
+  default:
+    return reflect.Value{}, fmt.Errorf("Don't know how to decode %s from JSON", t)
+  }
+}
+
+// A node's DB record in JSON, as written by DumpJSON
+type NodeJSON struct {
+  Node any `json:"node"`
+  SignalQueue any `json:"signal_queue"`
+  // Fields of each extension by gv tag, keyed by the extension's type name
+  Extensions map[string]map[string]any `json:"extensions"`
+}
+
+func EncodeNodeJSON(ctx *Context, node *Node) (NodeJSON, error) {
+  record, err := encodeJSON(ctx, reflect.ValueOf(node))
+  if err != nil {
+    return NodeJSON{}, err
+  }
+  signal_queue, err := encodeJSON(ctx, reflect.ValueOf(node.SignalQueue))
+  if err != nil {
+    return NodeJSON{}, err
+  }
+
+  extensions := map[string]map[string]any{}
+  for ext_type, ext := range(node.Extensions) {
+    ext_info, known := ctx.Extensions[ext_type]
+    if known == false {
+      return NodeJSON{}, fmt.Errorf("Cannot encode node with unknown extension %s", reflect.TypeOf(ext))
+    }
+
+    ext_value := reflect.ValueOf(ext).Elem()
+    fields := map[string]any{}
+    for tag, field_info := range(ext_info.Fields) {
+      field, err := encodeJSON(ctx, ext_value.FieldByIndex(field_info.Index))
+      if err != nil {
+        return NodeJSON{}, fmt.Errorf("%s.%s: %w", ext_info.Type, tag, err)
+      }
+      fields[string(tag)] = field
+    }
+    extensions[ext_info.Type.String()] = fields
+  }
+
+  return NodeJSON{record, signal_queue, extensions}, nil
+}
+
+// Rebuild the node from its JSON record, without loading it into the context
+func (record NodeJSON) Decode(ctx *Context) (*Node, error) {
+  node_value, err := decodeJSON(ctx, record.Node, reflect.TypeFor[*Node]())
+  if err != nil {
+    return nil, err
+  }
+  node := node_value.Interface().(*Node)
+  if node == nil {
+    return nil, fmt.Errorf("Node record is null")
+  }
+
+  signal_queue, err := decodeJSON(ctx, record.SignalQueue, reflect.TypeFor[[]QueuedSignal]())
+  if err != nil {
+    return nil, err
+  }
+  node.SignalQueue = signal_queue.Interface().([]QueuedSignal)
+  node.NextSignal, node.TimeoutChan = SoonestSignal(node.SignalQueue)
+
+  for name, fields := range(record.Extensions) {
+    ext_type := ExtType(SerializeType(typeName(name)))
+    ext_info, known := ctx.Extensions[ext_type]
+    if known == false {
+      return nil, fmt.Errorf("Unknown extension %s", name)
+    }
+
+    ext := reflect.New(ext_info.Type)
+    for tag, field_info := range(ext_info.Fields) {
+      // Fields added since the record was exported are left as zero values
+      field_data, present := fields[string(tag)]
+      if present == false {
+        continue
+      }
+      field, err := decodeJSON(ctx, field_data, field_info.Type)
+      if err != nil {
+        return nil, fmt.Errorf("%s.%s: %w", name, tag, err)
+      }
+      settableField(ext.Elem(), field_info.Index).Set(field)
+    }
+    node.Extensions[ext_type] = ext.Interface().(Extension)
+  }
+
+  return node, nil
+}
+
+// Write every node in the DB to w as a NodeJSON per line. Nodes that are loaded are written as they were last persisted.
+func (ctx *Context) DumpJSON(w io.Writer) error {
+  ids, err := ctx.DB.LoadNodeIDs(ctx)
+  if err != nil {
+    return err
+  }
+
+  encoder := json.NewEncoder(w)
+  for _, id := range(ids) {
+    node, err := ctx.DB.LoadNode(ctx, id)
+    if err != nil {
+      return fmt.Errorf("%s: %w", id, err)
+    }
+
+    record, err := EncodeNodeJSON(ctx, node)
+    if err != nil {
+      return fmt.Errorf("%s: %w", id, err)
+    }
+
+    err = encoder.Encode(record)
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Write the nodes from a DumpJSON to the DB, replacing any already in it, and return how many were written.
+// The nodes aren't loaded, so nodes with the same IDs that are already loaded should be unloaded first.
+func (ctx *Context) LoadJSON(r io.Reader) (int, error) {
+  // The nodes are only written to the DB, not loaded
+  if ctx.persistent() == false {
+    return 0, fmt.Errorf("Cannot load nodes from JSON: %w", NoPersistenceError)
+  }
+
+  decoder := json.NewDecoder(r)
+  decoder.UseNumber()
+
+  written := 0
+  for {
+    var record NodeJSON
+    err := decoder.Decode(&record)
+    if err == io.EOF {
+      return written, nil
+    } else if err != nil {
+      return written, err
+    }
+
+    node, err := record.Decode(ctx)
+    if err != nil {
+      return written, err
+    }
+
+    _, err = ctx.DB.LoadNode(ctx, node.ID)
+    if errors.Is(err, NodeNotFoundError) {
+      err = ctx.DB.CountNode(ctx, node.Type, 0)
+      if err != nil {
+        return written, err
+      }
+    } else if err != nil {
+      return written, fmt.Errorf("%s: %w", node.ID, err)
+    }
+
+    err = ctx.DB.WriteNodeInit(ctx, node)
+    if err != nil {
+      return written, fmt.Errorf("%s: %w", node.ID, err)
+    }
+    written += 1
+  }
+}
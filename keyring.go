@@ -0,0 +1,200 @@
+package graphvent
+
+import (
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/ed25519"
+  "crypto/rand"
+  "errors"
+  "fmt"
+
+  badger "github.com/dgraph-io/badger/v3"
+)
+
+var KeyNotFoundError = errors.New("Key not found in keyring")
+
+// Stores node private keys outside of the node records when set as Context.Keyring.
+// LoadKey returns an error wrapping KeyNotFoundError for nodes without a stored key.
+// RemoveKey is used to drop a key stored for a node that couldn't be written.
+type Keyring interface {
+  StoreKey(*Context, NodeID, ed25519.PrivateKey) error
+  LoadKey(*Context, NodeID) (ed25519.PrivateKey, error)
+  RemoveKey(*Context, NodeID) error
+}
+
+// A keyring entry written by BadgerDB.WriteNodeInit in the same transaction as the node
+type keyRecord struct {
+  key []byte
+  value []byte
+}
+
+// Keyring that stores keys in a badger DB encrypted with AES-GCM, the DB can be separate from the node DB
+type BadgerKeyring struct {
+  DB *badger.DB
+  aead cipher.AEAD
+}
+
+// Create a BadgerKeyring that encrypts keys with secret, which has to be a 32 byte AES-256 key
+func NewBadgerKeyring(db *badger.DB, secret []byte) (*BadgerKeyring, error) {
+  if len(secret) != 32 {
+    return nil, fmt.Errorf("Keyring secret must be 32 bytes, got %d", len(secret))
+  }
+
+  block, err := aes.NewCipher(secret)
+  if err != nil {
+    return nil, err
+  }
+
+  aead, err := cipher.NewGCM(block)
+  if err != nil {
+    return nil, err
+  }
+
+  return &BadgerKeyring{
+    DB: db,
+    aead: aead,
+  }, nil
+}
+
+func keyringKey(id NodeID) []byte {
+  return append([]byte("KEY - "), id[:]...)
+}
+
+func (keyring *BadgerKeyring) seal(id NodeID, key ed25519.PrivateKey) ([]byte, error) {
+  nonce := make([]byte, keyring.aead.NonceSize())
+  _, err := rand.Read(nonce)
+  if err != nil {
+    return nil, err
+  }
+
+  // The node ID is authenticated with the key so encrypted keys can't be swapped between nodes
+  return keyring.aead.Seal(nonce, nonce, key, id[:]), nil
+}
+
+func (keyring *BadgerKeyring) StoreKey(ctx *Context, id NodeID, key ed25519.PrivateKey) error {
+  sealed, err := keyring.seal(id, key)
+  if err != nil {
+    return err
+  }
+
+  return keyring.DB.Update(func(tx *badger.Txn) error {
+    return tx.Set(keyringKey(id), sealed)
+  })
+}
+
+func (keyring *BadgerKeyring) RemoveKey(ctx *Context, id NodeID) error {
+  return keyring.DB.Update(func(tx *badger.Txn) error {
+    return tx.Delete(keyringKey(id))
+  })
+}
+
+// Seal the key into a record for BadgerDB.WriteNodeInit if the keyring is in the context's node DB, or return nil if it isn't
+func (keyring *BadgerKeyring) nodeKeyRecord(ctx *Context, id NodeID, key ed25519.PrivateKey) (*keyRecord, error) {
+  node_db := ctx.DB
+  degraded, is_degraded := node_db.(*DegradedDB)
+  if is_degraded {
+    node_db = degraded.Database
+  }
+
+  badger_db, is_badger := node_db.(*BadgerDB)
+  if is_badger == false || badger_db.DB != keyring.DB {
+    return nil, nil
+  }
+
+  sealed, err := keyring.seal(id, key)
+  if err != nil {
+    return nil, err
+  }
+  return &keyRecord{keyringKey(id), sealed}, nil
+}
+
+func (keyring *BadgerKeyring) LoadKey(ctx *Context, id NodeID) (ed25519.PrivateKey, error) {
+  var key ed25519.PrivateKey
+  err := keyring.DB.View(func(tx *badger.Txn) error {
+    item, err := tx.Get(keyringKey(id))
+    if errors.Is(err, badger.ErrKeyNotFound) {
+      return fmt.Errorf("%s: %w", id, KeyNotFoundError)
+    } else if err != nil {
+      return err
+    }
+
+    return item.Value(func(sealed []byte) error {
+      nonce_size := keyring.aead.NonceSize()
+      if len(sealed) < nonce_size {
+        return fmt.Errorf("Encrypted key for %s is too short", id)
+      }
+
+      opened, err := keyring.aead.Open(nil, sealed[:nonce_size], sealed[nonce_size:], id[:])
+      if err != nil {
+        return fmt.Errorf("Failed to decrypt key for %s: %w", id, err)
+      }
+      key = ed25519.PrivateKey(opened)
+      return nil
+    })
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  return key, nil
+}
+
+// Write a new node, storing its key in the context's keyring instead of the node record if it has one.
+// Keys in the node DB are written in the node's transaction, and keys in other DBs are removed if the node isn't written.
+func (ctx *Context) writeNodeInit(node *Node) error {
+  if ctx.Keyring == nil {
+    return ctx.DB.WriteNodeInit(ctx, node)
+  }
+
+  key := node.Key
+  node.Key = nil
+  defer func() {
+    node.Key = key
+    node.initKey = nil
+  }()
+
+  badger_keyring, is_badger := ctx.Keyring.(*BadgerKeyring)
+  if is_badger {
+    record, err := badger_keyring.nodeKeyRecord(ctx, node.ID, key)
+    if err != nil {
+      return fmt.Errorf("Failed to seal key for %s: %w", node.ID, err)
+    } else if record != nil {
+      node.initKey = record
+      return ctx.DB.WriteNodeInit(ctx, node)
+    }
+  }
+
+  err := ctx.Keyring.StoreKey(ctx, node.ID, key)
+  if err != nil {
+    return fmt.Errorf("Failed to store key for %s: %w", node.ID, err)
+  }
+
+  err = ctx.DB.WriteNodeInit(ctx, node)
+  if err != nil {
+    remove_err := ctx.Keyring.RemoveKey(ctx, node.ID)
+    if remove_err != nil {
+      ctx.Log.Logf("db", "KEYRING_REMOVE_ERR: %s - %s", node.ID, remove_err)
+    }
+    return err
+  }
+  return nil
+}
+
+// Fill in the key of a node loaded from a record without one from the context's keyring.
+// Records written before the keyring was set keep their keys until they're rewritten.
+func (ctx *Context) loadNodeKey(node *Node) error {
+  if node.Key != nil {
+    return nil
+  } else if ctx.Keyring == nil {
+    return fmt.Errorf("%s was stored without a key and the context has no keyring", node.ID)
+  }
+
+  key, err := ctx.Keyring.LoadKey(ctx, node.ID)
+  if err != nil {
+    return err
+  } else if key == nil {
+    return fmt.Errorf("%s: %w", node.ID, KeyNotFoundError)
+  }
+  node.Key = key
+  return nil
+}
@@ -0,0 +1,159 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+  "slices"
+)
+
+// An extension field that's decoded the first time it's read instead of when its node is loaded,
+// for fields like large maps that most signals don't need. Lazy[T] is written exactly like T,
+// so a field can be changed between T and Lazy[T] without migrating it.
+//
+// Lazy fields aren't walked for the reference index, since that would decode them on every load.
+// Register each Lazy[T] with RegisterLazy before registering the extensions that use it.
+type Lazy[T any] struct {
+  value T
+  // The field as it was stored, nil once it's decoded
+  encoded []byte
+}
+
+func NewLazy[T any](value T) Lazy[T] {
+  return Lazy[T]{value: value}
+}
+
+// Get the value, decoding it if it hasn't been
+func (lazy *Lazy[T]) Get(ctx *Context) (T, error) {
+  if lazy.encoded != nil {
+    value, err := Deserialize[T](ctx, lazy.encoded)
+    if err != nil {
+      var zero T
+      return zero, fmt.Errorf("Failed to decode lazy %s: %w", reflect.TypeFor[T](), err)
+    }
+    lazy.value = value
+    lazy.encoded = nil
+  }
+  return lazy.value, nil
+}
+
+// Replace the value, discarding it without decoding if it hasn't been decoded
+func (lazy *Lazy[T]) Set(value T) {
+  lazy.value = value
+  lazy.encoded = nil
+}
+
+// Whether the value has been decoded since its node was loaded
+func (lazy Lazy[T]) Decoded() bool {
+  return lazy.encoded == nil
+}
+
+// Implemented by *Lazy[T] so the DB, GQL, and JSON encoding can handle it without knowing T.
+// lazyDecode keeps the decoded value, so it has to be called on the field and not a copy of it.
+type lazyValue interface {
+  lazyType() reflect.Type
+  lazyDecode(ctx *Context) (reflect.Value, error)
+}
+
+type lazyField interface {
+  setEncoded(data []byte)
+  setValue(value reflect.Value)
+}
+
+var lazyValueType = reflect.TypeFor[lazyValue]()
+
+// Whether t is a Lazy[T]
+func isLazy(t reflect.Type) bool {
+  return t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(lazyValueType)
+}
+
+// Get the lazyValue of a Lazy[T], pointing at value when it's addressable so the value it decodes is kept
+func asLazy(value reflect.Value) lazyValue {
+  if value.CanAddr() {
+    return value.Addr().Interface().(lazyValue)
+  }
+  copied := reflect.New(value.Type())
+  copied.Elem().Set(value)
+  return copied.Interface().(lazyValue)
+}
+
+func (lazy *Lazy[T]) lazyType() reflect.Type {
+  return reflect.TypeFor[T]()
+}
+
+func (lazy *Lazy[T]) lazyDecode(ctx *Context) (reflect.Value, error) {
+  value, err := lazy.Get(ctx)
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  return reflect.ValueOf(&value).Elem(), nil
+}
+
+func (lazy *Lazy[T]) setEncoded(data []byte) {
+  var zero T
+  lazy.value = zero
+  lazy.encoded = slices.Clone(data)
+}
+
+func (lazy *Lazy[T]) setValue(value reflect.Value) {
+  var v T
+  reflect.ValueOf(&v).Elem().Set(value)
+  lazy.Set(v)
+}
+
+// Register Lazy[T] so extensions can have Lazy[T] fields. T has to be serializable, and have a GQL type for the field to be mapped to a node field.
+func RegisterLazy[T any](ctx *Context) error {
+  reflect_type := reflect.TypeFor[Lazy[T]]()
+  elem_type := reflect.TypeFor[T]()
+  serialized_type := SerializedTypeFor[Lazy[T]]()
+
+  _, exists := ctx.Types[reflect_type]
+  if exists {
+    return fmt.Errorf("%+v already registered in TypeMap", reflect_type)
+  }
+
+  // Not every T can be a GQL field, so fields without a GQL type just can't be mapped
+  gql, err := ctx.GQLType(elem_type, "")
+  if err != nil {
+    ctx.Log.Logf("serialize", "No GQL type for %s: %s", reflect_type, err)
+    gql = nil
+  }
+
+  ctx.Types[reflect_type] = &TypeInfo{
+    Serialized: serialized_type,
+    Reflect: reflect_type,
+    Type: gql,
+    PostDeserializeIndex: -1,
+
+    // Fields that weren't decoded are written back as they were read
+    Serialize: func(ctx *Context, value reflect.Value, data []byte) (int, error) {
+      lazy := value.Interface().(Lazy[T])
+      if lazy.encoded != nil {
+        if len(data) < len(lazy.encoded) {
+          return 0, fmt.Errorf("Not enough space for lazy %s(got %d, want %d)", elem_type, len(data), len(lazy.encoded))
+        }
+        return copy(data, lazy.encoded), nil
+      }
+      return Serialize(ctx, lazy.value, data)
+    },
+    SerializedSize: func(ctx *Context, value reflect.Value) (int, error) {
+      lazy := value.Interface().(Lazy[T])
+      if lazy.encoded != nil {
+        return len(lazy.encoded), nil
+      }
+      return SerializedSize(ctx, reflect.ValueOf(&lazy.value).Elem())
+    },
+    // Lazy values that aren't extension fields loaded from the DB, like the fields in a ReadSignal response, are decoded right away
+    Deserialize: func(ctx *Context, data []byte) (reflect.Value, []byte, error) {
+      value, left, err := DeserializeValue(ctx, data, elem_type)
+      if err != nil {
+        return reflect.Value{}, nil, err
+      }
+      lazy := Lazy[T]{}
+      lazy.setValue(value)
+      return reflect.ValueOf(lazy), left, nil
+    },
+  }
+  ctx.TypesReverse[serialized_type] = ctx.Types[reflect_type]
+
+  return nil
+}
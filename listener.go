@@ -49,6 +49,11 @@ func NewListenerExt(buffer int) *ListenerExt {
   }
 }
 
+// Listener only forwards signals, so it doesn't need to wait on other extensions
+func (ext *ListenerExt) Parallel() bool {
+  return true
+}
+
 // Send the signal to the channel, logging an overflow if it occurs
 func (ext *ListenerExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
   ctx.Log.Logf("listener", "%s - %+v", node.ID, reflect.TypeOf(signal))
@@ -0,0 +1,111 @@
+package graphvent
+
+import (
+  "fmt"
+  "time"
+)
+
+var localeExtType = ExtTypeFor[LocaleExt]()
+
+// Timezone used for nodes that don't have a LocaleExt
+const DEFAULT_TIMEZONE = "UTC"
+
+// Sets the timezone and locale of a node with a LocaleExt, empty fields are left unchanged
+type LocaleSignal struct {
+  SignalHeader
+  Timezone string `gv:"timezone"`
+  Locale string `gv:"locale"`
+}
+func (signal LocaleSignal) String() string {
+  return fmt.Sprintf("LocaleSignal(%s, %s, %s)", signal.SignalHeader, signal.Timezone, signal.Locale)
+}
+func NewLocaleSignal(timezone string, locale string) *LocaleSignal {
+  return &LocaleSignal{
+    NewSignalHeader(),
+    timezone,
+    locale,
+  }
+}
+
+// Timezone and locale of a node, so wall clock times like events and availability windows can be interpreted for it.
+// Attached with the default UTC timezone by GetOrAttachExt. Node types expose the fields over GQL by including
+// TagMappings(ctx, ExtTypeFor[LocaleExt]()) in their mappings.
+type LocaleExt struct {
+  // IANA timezone name, like "America/New_York"
+  Timezone string `gv:"timezone" gql:"Timezone"`
+  // BCP 47 language tag, like "en-US"
+  Locale string `gv:"locale" gql:"Locale"`
+
+  location *time.Location
+}
+
+func NewLocaleExt(timezone string, locale string) (*LocaleExt, error) {
+  _, err := time.LoadLocation(timezone)
+  if err != nil {
+    return nil, err
+  }
+
+  return &LocaleExt{
+    Timezone: timezone,
+    Locale: locale,
+  }, nil
+}
+
+func (ext *LocaleExt) AutoAttach() Extension {
+  return &LocaleExt{
+    Timezone: DEFAULT_TIMEZONE,
+  }
+}
+
+func (ext *LocaleExt) Load(ctx *Context, node *Node) error {
+  location, err := time.LoadLocation(ext.Timezone)
+  if err != nil {
+    return err
+  }
+  ext.location = location
+  return nil
+}
+
+func (ext *LocaleExt) Unload(ctx *Context, node *Node) {
+}
+
+// Location of the node's timezone, only set while the node is loaded
+func (ext *LocaleExt) Location() *time.Location {
+  return ext.location
+}
+
+func (ext *LocaleExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  switch sig := signal.(type) {
+  case *LocaleSignal:
+    if sig.Timezone != "" {
+      location, err := time.LoadLocation(sig.Timezone)
+      if err != nil {
+        messages = append(messages, Message{source, NewErrorSignal(sig.ID(), "unknown_timezone")})
+        break
+      }
+      ext.Timezone = sig.Timezone
+      ext.location = location
+      changes.Add(localeExtType, ChangeSet, "timezone")
+    }
+
+    if sig.Locale != "" {
+      ext.Locale = sig.Locale
+      changes.Add(localeExtType, ChangeSet, "locale")
+    }
+    messages = append(messages, Message{source, NewSuccessSignal(sig.ID())})
+  }
+
+  return messages, changes
+}
+
+// Get the location of the node's timezone, or UTC if it doesn't have a LocaleExt
+func NodeLocation(node *Node) *time.Location {
+  ext, err := GetExt[LocaleExt](node)
+  if err != nil || ext.location == nil {
+    return time.UTC
+  }
+  return ext.location
+}
@@ -1,9 +1,16 @@
 package graphvent
 
 import (
+	"fmt"
+	"slices"
+	"time"
+
 	"github.com/google/uuid"
 )
 
+// How long a lockable waits for a requirement to respond to a link request before failing it
+const LINK_TIMEOUT = time.Second
+
 type ReqState byte
 const (
   Unlocked = ReqState(0)
@@ -31,17 +38,32 @@ func (state ReqState) String() string {
 }
 
 
+var lockableExtType = ExtTypeFor[LockableExt]()
+
 type LockableExt struct{
-  State ReqState `gv:"state"`
+  State ReqState `gv:"state" gql:"LockableState"`
   ReqID *uuid.UUID `gv:"req_id"`
-  Owner *NodeID `gv:"owner"`
-  PendingOwner *NodeID `gv:"pending_owner"`
-  Requirements map[NodeID]ReqState `gv:"requirements" node:"Lockable:"`
+  Owner *NodeID `gv:"owner" gql:"Owner"`
+  PendingOwner *NodeID `gv:"pending_owner" gql:"PendingOwner"`
+  Requirements map[NodeID]ReqState `gv:"requirements" node:"Lockable:" gql:"Requirements"`
 
   Locked map[NodeID]any
   Unlocked map[NodeID]any
 
   Waiting WaitMap `gv:"waiting_locks" node:":Lockable"`
+
+  // Lockables that have this node as a requirement
+  Dependencies []NodeID `gv:"dependencies" node:"Lockable" gql:"Dependencies"`
+  // Link requests waiting for the requirement to record the dependency, keyed by the DependencySignal ID
+  PendingLinks map[uuid.UUID]LinkRequest `gv:"pending_links"`
+}
+
+// A LinkSignal that's waiting for a response from the requirement
+type LinkRequest struct {
+  Source NodeID `gv:"source"`
+  ReqID uuid.UUID `gv:"req_id"`
+  Requirement NodeID `gv:"requirement"`
+  Action string `gv:"action"`
 }
 
 func NewLockableExt(requirements []NodeID) *LockableExt {
@@ -62,6 +84,7 @@ func NewLockableExt(requirements []NodeID) *LockableExt {
     PendingOwner: nil,
     Requirements: reqs,
     Waiting: WaitMap{},
+    PendingLinks: map[uuid.UUID]LinkRequest{},
 
     Locked: map[NodeID]any{},
     Unlocked: unlocked,
@@ -80,7 +103,18 @@ func LockLockable(ctx *Context, node *Node) (uuid.UUID, error) {
   return signal.ID(), ctx.Send(node, messages)
 }
 
+// Reset a lockable that's stuck locking or unlocking, see HandleForceUnlockSignal
+func ForceUnlockLockable(ctx *Context, node *Node) (uuid.UUID, error) {
+  signal := NewForceUnlockSignal()
+  messages := []Message{{node.ID, signal}}
+  return signal.ID(), ctx.Send(node, messages)
+}
+
 func (ext *LockableExt) Load(ctx *Context, node *Node) error {
+  if ext.PendingLinks == nil {
+    ext.PendingLinks = map[uuid.UUID]LinkRequest{}
+  }
+
   ext.Locked = map[NodeID]any{}
   ext.Unlocked = map[NodeID]any{}
 
@@ -98,35 +132,96 @@ func (ext *LockableExt) Unload(ctx *Context, node *Node) {
   return
 }
 
-// Handle link signal by adding/removing the requested NodeID
-// returns an error if the node is not unlocked
+// Clones start unlocked, and only keep the requirements that are remapped to other nodes,
+// since the original requirements are linked to and locked by the original node
+func (ext *LockableExt) Cloned(ctx *Context, remap map[NodeID]NodeID) {
+  ext.State = Unlocked
+  ext.ReqID = nil
+  ext.Owner = nil
+  ext.PendingOwner = nil
+  ext.Waiting = WaitMap{}
+  ext.PendingLinks = map[uuid.UUID]LinkRequest{}
+  ext.Dependencies = nil
+
+  var requirements map[NodeID]ReqState = nil
+  for id := range(ext.Requirements) {
+    _, remapped := remap[id]
+    if remapped {
+      if requirements == nil {
+        requirements = map[NodeID]ReqState{}
+      }
+      requirements[id] = Unlocked
+    }
+  }
+  ext.Requirements = requirements
+}
+
+// Refuse to prepare lock, unlock, and link signals that would be refused in the current state
+func (ext *LockableExt) CheckPrepare(ctx *Context, node *Node, source NodeID, signal Signal) error {
+  switch sig := signal.(type) {
+  case *LockSignal:
+    if ext.State != Unlocked {
+      return fmt.Errorf("not_unlocked: %s", ext.State)
+    }
+  case *UnlockSignal:
+    if ext.State != Locked {
+      return fmt.Errorf("not_locked: %s", ext.State)
+    } else if *ext.Owner != source {
+      return fmt.Errorf("not_owner")
+    }
+  case *LinkSignal:
+    for _, pending := range(ext.PendingLinks) {
+      if pending.Requirement == sig.NodeID {
+        return fmt.Errorf("link_pending")
+      }
+    }
+    if ext.State != Unlocked {
+      return fmt.Errorf("not_unlocked: %s", ext.State)
+    }
+
+    _, exists := ext.Requirements[sig.NodeID]
+    if sig.Action == "add" && exists {
+      return fmt.Errorf("already_requirement")
+    } else if sig.Action == "remove" && exists == false {
+      return fmt.Errorf("not_requirement")
+    }
+  }
+  return nil
+}
+
+// Handle link signal by asking the requested NodeID to add/remove this node as a dependency,
+// the requirement is added/removed when it responds. Returns an error if the node is not unlocked
 func (ext *LockableExt) HandleLinkSignal(ctx *Context, node *Node, source NodeID, signal *LinkSignal) ([]Message, Changes) {
   var messages []Message = nil
   var changes Changes = nil
 
+  for _, pending := range(ext.PendingLinks) {
+    if pending.Requirement == signal.NodeID {
+      messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "link_pending")})
+      return messages, changes
+    }
+  }
+
   switch ext.State {
   case Unlocked:
+    _, exists := ext.Requirements[signal.NodeID]
     switch signal.Action {
     case "add":
-      _, exists := ext.Requirements[signal.NodeID]
+      max_requirements := ctx.NodeTypes[node.Type].Quota.Requirements
       if exists == true {
         messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "already_requirement")})
+      } else if max_requirements > 0 && len(ext.Requirements) >= max_requirements {
+        messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "requirement_quota")})
       } else {
-        if ext.Requirements == nil {
-          ext.Requirements = map[NodeID]ReqState{}
-        }
-        ext.Requirements[signal.NodeID] = Unlocked
-        changes = append(changes, "requirements")
-        messages = append(messages, Message{source, NewSuccessSignal(signal.ID())})
+        messages = append(messages, ext.requestLink(node, source, signal))
+        changes.Add(lockableExtType, ChangeAdd, "pending_links")
       }
     case "remove":
-      _, exists := ext.Requirements[signal.NodeID]
       if exists == false {
         messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "not_requirement")})
       } else {
-        delete(ext.Requirements, signal.NodeID)
-        changes = append(changes, "requirements")
-        messages = append(messages, Message{source, NewSuccessSignal(signal.ID())})
+        messages = append(messages, ext.requestLink(node, source, signal))
+        changes.Add(lockableExtType, ChangeAdd, "pending_links")
       }
     default:
       messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "unknown_action")})
@@ -138,6 +233,84 @@ func (ext *LockableExt) HandleLinkSignal(ctx *Context, node *Node, source NodeID
   return messages, changes
 }
 
+func (ext *LockableExt) requestLink(node *Node, source NodeID, signal *LinkSignal) Message {
+  dependency_signal := NewDependencySignal(signal.Action)
+  ext.PendingLinks[dependency_signal.ID()] = LinkRequest{
+    Source: source,
+    ReqID: signal.ID(),
+    Requirement: signal.NodeID,
+    Action: signal.Action,
+  }
+  // Fail the request if the requirement never responds, like when it isn't lockable, so it doesn't block links to the requirement forever
+  node.QueueSignal(time.Now().Add(LINK_TIMEOUT), NewTimeoutSignal(dependency_signal.ID()))
+  return Message{signal.NodeID, dependency_signal}
+}
+
+// Handle the requirement's response to a DependencySignal by completing the link request
+func (ext *LockableExt) handleLinkResponse(ctx *Context, node *Node, req_id uuid.UUID, err_signal *ErrorSignal) ([]Message, Changes, bool) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  request, pending := ext.PendingLinks[req_id]
+  if pending == false {
+    return nil, nil, false
+  }
+  delete(ext.PendingLinks, req_id)
+  changes.Add(lockableExtType, ChangeRemove, "pending_links")
+
+  if err_signal != nil {
+    messages = append(messages, Message{request.Source, NewErrorSignal(request.ReqID, "link_failed: %s", err_signal.Error)})
+    return messages, changes, true
+  }
+
+  switch request.Action {
+  case "add":
+    if ext.Requirements == nil {
+      ext.Requirements = map[NodeID]ReqState{}
+    }
+    ext.Requirements[request.Requirement] = Unlocked
+    ext.Unlocked[request.Requirement] = nil
+    changes.Add(lockableExtType, ChangeAdd, "requirements")
+  case "remove":
+    delete(ext.Requirements, request.Requirement)
+    delete(ext.Unlocked, request.Requirement)
+    changes.Add(lockableExtType, ChangeRemove, "requirements")
+  }
+  messages = append(messages, Message{request.Source, NewSuccessSignal(request.ReqID)})
+
+  return messages, changes, true
+}
+
+// Handle a DependencySignal from a lockable that's adding/removing this node as a requirement
+func (ext *LockableExt) HandleDependencySignal(ctx *Context, node *Node, source NodeID, signal *DependencySignal) ([]Message, Changes) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  idx := slices.Index(ext.Dependencies, source)
+  switch signal.Action {
+  case "add":
+    max_dependencies := ctx.NodeTypes[node.Type].Quota.Dependencies
+    if idx == -1 && max_dependencies > 0 && len(ext.Dependencies) >= max_dependencies {
+      messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "dependency_quota")})
+      break
+    } else if idx == -1 {
+      ext.Dependencies = append(ext.Dependencies, source)
+      changes.Add(lockableExtType, ChangeAdd, "dependencies")
+    }
+    messages = append(messages, Message{source, NewSuccessSignal(signal.ID())})
+  case "remove":
+    if idx != -1 {
+      ext.Dependencies = slices.Delete(ext.Dependencies, idx, idx+1)
+      changes.Add(lockableExtType, ChangeRemove, "dependencies")
+    }
+    messages = append(messages, Message{source, NewSuccessSignal(signal.ID())})
+  default:
+    messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "unknown_action")})
+  }
+
+  return messages, changes
+}
+
 // Handle an UnlockSignal by either transitioning to Unlocked state,
 // sending unlock signals to requirements, or returning an error signal
 func (ext *LockableExt) HandleUnlockSignal(ctx *Context, node *Node, source NodeID, signal *UnlockSignal) ([]Message, Changes) {
@@ -149,8 +322,9 @@ func (ext *LockableExt) HandleUnlockSignal(ctx *Context, node *Node, source Node
     if source != *ext.Owner {
       messages = append(messages, Message{source, NewErrorSignal(signal.Id, "not_owner")})
     } else {
-      if len(ext.Requirements) == 0 {
-        changes = append(changes, "state", "owner", "pending_owner")
+      // Requirements that lost their lock to a ForceUnlockSignal are already unlocked
+      if len(ext.Locked) == 0 {
+        changes.Add(lockableExtType, ChangeSet, "state", "owner", "pending_owner")
 
         ext.Owner = nil
 
@@ -160,14 +334,14 @@ func (ext *LockableExt) HandleUnlockSignal(ctx *Context, node *Node, source Node
 
         messages = append(messages, Message{source, NewSuccessSignal(signal.Id)})
       } else {
-        changes = append(changes, "state", "waiting", "requirements", "pending_owner")
+        changes.Add(lockableExtType, ChangeSet, "state", "waiting_locks", "requirements", "pending_owner", "req_id")
 
         ext.PendingOwner = nil
 
         ext.ReqID = &signal.Id
 
         ext.State = Unlocking
-        for id := range(ext.Requirements) {
+        for id := range(ext.Locked) {
           unlock_signal := NewUnlockSignal()
 
           ext.Waiting[unlock_signal.Id] = id
@@ -193,7 +367,7 @@ func (ext *LockableExt) HandleLockSignal(ctx *Context, node *Node, source NodeID
   switch ext.State {
   case Unlocked:
     if len(ext.Requirements) == 0 {
-      changes = append(changes, "state", "owner", "pending_owner")
+      changes.Add(lockableExtType, ChangeSet, "state", "owner", "pending_owner")
 
       ext.Owner = &source
 
@@ -202,7 +376,7 @@ func (ext *LockableExt) HandleLockSignal(ctx *Context, node *Node, source NodeID
       ext.State = Locked
       messages = append(messages, Message{source, NewSuccessSignal(signal.Id)})
     } else {
-      changes = append(changes, "state", "requirements", "waiting", "pending_owner")
+      changes.Add(lockableExtType, ChangeSet, "state", "requirements", "waiting_locks", "pending_owner", "req_id")
 
       ext.PendingOwner = &source
 
@@ -225,19 +399,129 @@ func (ext *LockableExt) HandleLockSignal(ctx *Context, node *Node, source NodeID
   return messages, changes
 }
 
-// Handle an error signal by aborting the lock, or retrying the unlock
-func (ext *LockableExt) HandleErrorSignal(ctx *Context, node *Node, source NodeID, signal *ErrorSignal) ([]Message, Changes) {
+// Handle a ForceUnlockSignal by resetting to Unlocked without waiting on requirements or the owner.
+// Requirements that are locked or locking are sent an UnlockSignal, the pending owner of an in-progress request gets an error,
+// and the owner is sent a LockLostSignal so it doesn't try to unlock this node.
+// Only the node itself can force an unlock, so it has to be sent by whoever holds the *Node.
+func (ext *LockableExt) HandleForceUnlockSignal(ctx *Context, node *Node, source NodeID, signal *ForceUnlockSignal) ([]Message, Changes) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  if source != node.ID {
+    messages = append(messages, Message{source, NewErrorSignal(signal.Id, "not_self")})
+    return messages, changes
+  }
+
+  ctx.Log.Logf("lockable", "%s FORCE_UNLOCK: %s owned by %+v", node.ID, ext.State, ext.Owner)
+  changes.Add(lockableExtType, ChangeSet, "state", "owner", "pending_owner", "req_id", "waiting_locks", "requirements")
+
+  if ext.ReqID != nil && ext.PendingOwner != nil {
+    messages = append(messages, Message{*ext.PendingOwner, NewErrorSignal(*ext.ReqID, "force_unlocked")})
+  }
+  if ext.Owner != nil && *ext.Owner != node.ID {
+    messages = append(messages, Message{*ext.Owner, NewLockLostSignal()})
+  }
+
+  ext.Locked = map[NodeID]any{}
+  ext.Unlocked = map[NodeID]any{}
+  for id, state := range(ext.Requirements) {
+    if state == Locked || state == Locking {
+      // Responses aren't waited on, so they're ignored
+      messages = append(messages, Message{id, NewUnlockSignal()})
+    }
+    ext.Requirements[id] = Unlocked
+    ext.Unlocked[id] = nil
+  }
+
+  ext.State = Unlocked
+  ext.Owner = nil
+  ext.PendingOwner = nil
+  ext.ReqID = nil
+  ext.Waiting = WaitMap{}
+
+  messages = append(messages, Message{source, NewSuccessSignal(signal.Id)})
+  return messages, changes
+}
+
+// Handle a LockLostSignal from a requirement that was forced to unlock by marking it Unlocked, so this node doesn't try to unlock it.
+// A lock in progress locks it again, and an unlock or aborted lock finishes if it was the last requirement left.
+func (ext *LockableExt) HandleLockLostSignal(ctx *Context, node *Node, source NodeID, signal *LockLostSignal) ([]Message, Changes) {
   var messages []Message = nil
   var changes Changes = nil
 
+  req_state, is_requirement := ext.Requirements[source]
+  if is_requirement == false || req_state == Unlocked {
+    return messages, changes
+  }
+
+  ctx.Log.Logf("lockable", "%s LOCK_LOST: %s was %s", node.ID, source, req_state)
+  changes.Add(lockableExtType, ChangeSet, "waiting_locks", "requirements")
+  for req_id, id := range(ext.Waiting) {
+    if id == source {
+      delete(ext.Waiting, req_id)
+    }
+  }
+  ext.Requirements[source] = Unlocked
+  ext.Unlocked[source] = nil
+  delete(ext.Locked, source)
+
+  switch ext.State {
+  case Locking:
+    lock_signal := NewLockSignal()
+    ext.Waiting[lock_signal.Id] = source
+    ext.Requirements[source] = Locking
+    messages = append(messages, Message{source, lock_signal})
+
+  case Unlocking:
+    if len(ext.Unlocked) == len(ext.Requirements) {
+      changes.Add(lockableExtType, ChangeSet, "state", "owner", "req_id")
+      messages = append(messages, Message{*ext.Owner, NewSuccessSignal(*ext.ReqID)})
+      ext.State = Unlocked
+      ext.ReqID = nil
+      ext.Owner = nil
+    }
+
+  case AbortingLock:
+    for _, state := range(ext.Requirements) {
+      if state != Unlocked {
+        return messages, changes
+      }
+    }
+    changes.Add(lockableExtType, ChangeSet, "state", "pending_owner", "req_id")
+    messages = append(messages, Message{*ext.PendingOwner, NewErrorSignal(*ext.ReqID, "not_unlocked: %s", ext.State)})
+    ext.State = Unlocked
+    ext.ReqID = nil
+    ext.PendingOwner = nil
+  }
+
+  return messages, changes
+}
+
+// Handle a TimeoutSignal queued by requestLink by failing the link request if the requirement hasn't responded
+func (ext *LockableExt) HandleTimeoutSignal(ctx *Context, node *Node, source NodeID, signal *TimeoutSignal) ([]Message, Changes) {
+  if source != node.ID {
+    return nil, nil
+  }
+
+  messages, changes, _ := ext.handleLinkResponse(ctx, node, signal.ReqID, NewErrorSignal(signal.ReqID, "timeout"))
+  return messages, changes
+}
+
+// Handle an error signal by aborting the lock, or retrying the unlock
+func (ext *LockableExt) HandleErrorSignal(ctx *Context, node *Node, source NodeID, signal *ErrorSignal) ([]Message, Changes) {
+  messages, changes, handled := ext.handleLinkResponse(ctx, node, signal.ReqID, signal)
+  if handled {
+    return messages, changes
+  }
+
   id, waiting := ext.Waiting[signal.ReqID]
   if waiting == true {
     delete(ext.Waiting, signal.ReqID)
-    changes = append(changes, "waiting")
+    changes.Add(lockableExtType, ChangeSet, "waiting_locks", "requirements")
 
     switch ext.State {
     case Locking:
-      changes = append(changes, "state", "requirements")
+      changes.Add(lockableExtType, ChangeSet, "state", "requirements")
 
       ext.Requirements[id] = Unlocked
 
@@ -258,11 +542,11 @@ func (ext *LockableExt) HandleErrorSignal(ctx *Context, node *Node, source NodeI
       }
 
       if unlocked == len(ext.Requirements) {
-        changes = append(changes, "owner", "state")
+        changes.Add(lockableExtType, ChangeSet, "owner", "state")
         ext.State = Unlocked
         ext.Owner = nil
       } else {
-        changes = append(changes, "state")
+        changes.Add(lockableExtType, ChangeSet, "state")
         ext.State = AbortingLock
       }
 
@@ -287,7 +571,7 @@ func (ext *LockableExt) HandleErrorSignal(ctx *Context, node *Node, source NodeI
         }
 
         if unlocked == len(ext.Requirements) {
-          changes = append(changes, "owner", "state")
+          changes.Add(lockableExtType, ChangeSet, "owner", "state")
           ext.State = Unlocked
           ext.Owner = nil
         }
@@ -305,13 +589,15 @@ func (ext *LockableExt) HandleErrorSignal(ctx *Context, node *Node, source NodeI
 
 // Handle a success signal by checking if all requirements have been locked/unlocked
 func (ext *LockableExt) HandleSuccessSignal(ctx *Context, node *Node, source NodeID, signal *SuccessSignal) ([]Message, Changes) {
-  var messages []Message = nil
-  var changes Changes = nil
+  messages, changes, handled := ext.handleLinkResponse(ctx, node, signal.ReqID, nil)
+  if handled {
+    return messages, changes
+  }
 
   id, waiting := ext.Waiting[signal.ReqID]
   if waiting == true {
     delete(ext.Waiting, signal.ReqID)
-    changes = append(changes, "waiting")
+    changes.Add(lockableExtType, ChangeSet, "waiting_locks", "requirements")
 
     switch ext.State {
     case Locking:
@@ -321,7 +607,7 @@ func (ext *LockableExt) HandleSuccessSignal(ctx *Context, node *Node, source Nod
 
       if len(ext.Locked) == len(ext.Requirements) {
         ctx.Log.Logf("lockable", "%s FULL_LOCK: %d", node.ID, len(ext.Locked))
-        changes = append(changes, "state", "owner", "req_id")
+        changes.Add(lockableExtType, ChangeSet, "state", "owner", "req_id")
         ext.State = Locked
 
         ext.Owner = ext.PendingOwner
@@ -353,7 +639,7 @@ func (ext *LockableExt) HandleSuccessSignal(ctx *Context, node *Node, source Nod
         }
 
         if unlocked == len(ext.Requirements) {
-          changes = append(changes, "state", "pending_owner", "req_id")
+          changes.Add(lockableExtType, ChangeSet, "state", "pending_owner", "req_id")
 
           messages = append(messages, Message{*ext.PendingOwner, NewErrorSignal(*ext.ReqID, "not_unlocked: %s", ext.State)})
           ext.State = Unlocked
@@ -369,7 +655,7 @@ func (ext *LockableExt) HandleSuccessSignal(ctx *Context, node *Node, source Nod
       delete(ext.Locked, id)
 
       if len(ext.Unlocked) == len(ext.Requirements) {
-        changes = append(changes, "state", "owner", "req_id")
+        changes.Add(lockableExtType, ChangeSet, "state", "owner", "req_id")
 
         messages = append(messages, Message{*ext.Owner, NewSuccessSignal(*ext.ReqID)})
         ext.State = Unlocked
@@ -396,10 +682,18 @@ func (ext *LockableExt) Process(ctx *Context, node *Node, source NodeID, signal
     }
   case *LinkSignal:
     messages, changes = ext.HandleLinkSignal(ctx, node, source, sig)
+  case *DependencySignal:
+    messages, changes = ext.HandleDependencySignal(ctx, node, source, sig)
   case *LockSignal:
     messages, changes = ext.HandleLockSignal(ctx, node, source, sig)
   case *UnlockSignal:
     messages, changes = ext.HandleUnlockSignal(ctx, node, source, sig)
+  case *ForceUnlockSignal:
+    messages, changes = ext.HandleForceUnlockSignal(ctx, node, source, sig)
+  case *LockLostSignal:
+    messages, changes = ext.HandleLockLostSignal(ctx, node, source, sig)
+  case *TimeoutSignal:
+    messages, changes = ext.HandleTimeoutSignal(ctx, node, source, sig)
   case *ErrorSignal:
     messages, changes = ext.HandleErrorSignal(ctx, node, source, sig)
   case *SuccessSignal:
@@ -1,8 +1,16 @@
 package graphvent
 
 import (
+  "encoding/json"
+  "errors"
+  "net/http/httptest"
+  "reflect"
+  "slices"
+  "strings"
   "testing"
   "time"
+
+  "github.com/google/uuid"
 )
 
 func TestLink(t *testing.T) {
@@ -10,7 +18,8 @@ func TestLink(t *testing.T) {
 
 
   l2_listener := NewListenerExt(10)
-  l2, err := ctx.NewNode(nil, "LockableNode", l2_listener, NewLockableExt(nil))
+  l2_lockable := NewLockableExt(nil)
+  l2, err := ctx.NewNode(nil, "LockableNode", l2_listener, l2_lockable)
   fatalErr(t, err)
 
   l1_lockable := NewLockableExt(nil)
@@ -33,6 +42,10 @@ func TestLink(t *testing.T) {
     t.Fatalf("l2 in bad requirement state in l1: %+v", state)
   }
 
+  if len(l2_lockable.Dependencies) != 1 || l2_lockable.Dependencies[0] != l1.ID {
+    t.Fatalf("l1 not in l2 dependencies: %+v", l2_lockable.Dependencies)
+  }
+
   unlink_signal := NewLinkSignal("remove", l2.ID)
   msgs = []Message{{l1.ID, unlink_signal}}
   err = ctx.Send(l1, msgs)
@@ -40,6 +53,244 @@ func TestLink(t *testing.T) {
 
   _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, unlink_signal.ID())
   fatalErr(t, err)
+
+  if len(l1_lockable.Requirements) != 0 {
+    t.Fatalf("l2 still in l1 requirements: %+v", l1_lockable.Requirements)
+  } else if len(l2_lockable.Dependencies) != 0 {
+    t.Fatalf("l1 still in l2 dependencies: %+v", l2_lockable.Dependencies)
+  }
+}
+
+func TestLinkTimeout(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  // Nodes without a LockableExt never respond to the DependencySignal
+  target, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", target.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  response, _, err := WaitForResponse(l1_listener.Chan, 2*LINK_TIMEOUT, link_signal.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || error_signal.Error != "link_failed: timeout" {
+    t.Fatalf("Expected link timeout, got %s", response)
+  }
+
+  // The timed out request doesn't block new requests to the same node
+  link_signal = NewLinkSignal("add", target.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  response, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  if err == nil {
+    t.Fatalf("Expected no response while the second request is pending, got %s", response)
+  }
+}
+
+func TestMaintenance(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "maintenance"})
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  ctx.SetMaintenance(true)
+
+  _, err = ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  var maintenance_err MaintenanceError
+  if errors.As(err, &maintenance_err) == false {
+    t.Fatalf("Created a node in maintenance mode: %s", err)
+  }
+
+  err = ctx.SetAlias("l1", l1.ID)
+  if errors.As(err, &maintenance_err) == false {
+    t.Fatalf("Set an alias in maintenance mode: %s", err)
+  }
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  err = ctx.Send(l1, []Message{{l1.ID, link_signal}})
+  fatalErr(t, err)
+
+  response, _, err := WaitForResponse(l1_listener.Chan, 10*time.Millisecond, link_signal.ID())
+  fatalErr(t, err)
+  error_signal, is_error := response.(*ErrorSignal)
+  if is_error == false || error_signal.Error != "maintenance" {
+    t.Fatalf("Link wasn't rejected in maintenance mode: %+v", response)
+  }
+
+  read_signal := NewReadSignal([]string{"Requirements"})
+  err = ctx.Send(l1, []Message{{l1.ID, read_signal}})
+  fatalErr(t, err)
+
+  response, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, read_signal.ID())
+  fatalErr(t, err)
+  requirements, err := ReadResultField[map[NodeID]ReqState](response.(*ReadResultSignal), "Requirements")
+  fatalErr(t, err)
+  if len(requirements) != 0 {
+    t.Fatalf("Requirements changed in maintenance mode: %+v", requirements)
+  }
+
+  ctx.SetMaintenance(false)
+
+  link_signal = NewLinkSignal("add", l2.ID)
+  err = ctx.Send(l1, []Message{{l1.ID, link_signal}})
+  fatalErr(t, err)
+
+  response, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, link_signal.ID())
+  fatalErr(t, err)
+  if _, is_success := response.(*SuccessSignal); is_success == false {
+    t.Fatalf("Link failed after leaving maintenance mode: %+v", response)
+  }
+
+  // l3 is locked by itself, so l1's lock fails once l3 is thawed and l1 has to unlock l2 in maintenance mode
+  l3_listener := NewListenerExt(10)
+  l3, err := ctx.NewNode(nil, "LockableNode", l3_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  lock_signal := NewLockSignal()
+  fatalErr(t, ctx.Send(l3, []Message{{l3.ID, lock_signal}}))
+  _, _, err = WaitForResponse(l3_listener.Chan, 10*time.Millisecond, lock_signal.ID())
+  fatalErr(t, err)
+
+  link_signal = NewLinkSignal("add", l3.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, link_signal.ID())
+  fatalErr(t, err)
+
+  freeze_signal := NewFreezeSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l3.ID, freeze_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, freeze_signal.ID())
+  fatalErr(t, err)
+
+  read_owner := func() *NodeID {
+    read_signal := NewReadSignal([]string{"Owner"})
+    fatalErr(t, ctx.Send(l1, []Message{{l2.ID, read_signal}}))
+    response, _, err := WaitForResponse(l1_listener.Chan, 10*time.Millisecond, read_signal.ID())
+    fatalErr(t, err)
+    owner, err := ReadResultField[*NodeID](response.(*ReadResultSignal), "Owner")
+    fatalErr(t, err)
+    return owner
+  }
+
+  lock_signal = NewLockSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, lock_signal}}))
+  for start := time.Now(); read_owner() == nil; time.Sleep(time.Millisecond) {
+    if time.Since(start) > 100*time.Millisecond {
+      t.Fatal("l2 wasn't locked by l1")
+    }
+  }
+
+  ctx.SetMaintenance(true)
+
+  thaw_signal := NewThawSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l3.ID, thaw_signal}}))
+
+  response, _, err = WaitForResponse(l1_listener.Chan, 100*time.Millisecond, lock_signal.ID())
+  fatalErr(t, err)
+  if _, is_error := response.(*ErrorSignal); is_error == false {
+    t.Fatalf("Lock succeeded with l3 locked: %+v", response)
+  }
+
+  if owner := read_owner(); owner != nil {
+    t.Fatalf("l2 still locked by %s after the lock was aborted in maintenance mode", owner)
+  }
+}
+
+func TestForceUnlock(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  l2_listener := NewListenerExt(10)
+  l2_lockable := NewLockableExt(nil)
+  l2, err := ctx.NewNode(nil, "LockableNode", l2_listener, l2_lockable)
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1_lockable := NewLockableExt([]NodeID{l2.ID})
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, l1_lockable)
+  fatalErr(t, err)
+
+  force_signal := NewForceUnlockSignal()
+  fatalErr(t, ctx.Send(l2, []Message{{l1.ID, force_signal}}))
+  response, _, err := WaitForResponse(l2_listener.Chan, 10*time.Millisecond, force_signal.ID())
+  fatalErr(t, err)
+  if error_signal, is_error := response.(*ErrorSignal); is_error == false || error_signal.Error != "not_self" {
+    t.Fatalf("Another node forced an unlock: %+v", response)
+  }
+
+  // Wedge l1 in Locking by freezing it's requirement
+  freeze_signal := NewFreezeSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l2.ID, freeze_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, freeze_signal.ID())
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, l1)
+  fatalErr(t, err)
+
+  force_id, err := ForceUnlockLockable(ctx, l1)
+  fatalErr(t, err)
+  response, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+  if error_signal, is_error := response.(*ErrorSignal); is_error == false || error_signal.Error != "force_unlocked" {
+    t.Fatalf("Pending owner wasn't sent an error: %+v", response)
+  }
+  _, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, force_id)
+  fatalErr(t, err)
+
+  if l1_lockable.State != Unlocked || l1_lockable.Owner != nil || l1_lockable.Requirements[l2.ID] != Unlocked {
+    t.Fatalf("l1 not reset by ForceUnlockSignal: %s %+v", l1_lockable.State, l1_lockable.Requirements)
+  }
+
+  // Once l2 processes the held lock it's unlocked again by l1
+  thaw_signal := NewThawSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l2.ID, thaw_signal}}))
+  _, err = WaitForSignal(l2_listener.Chan, 100*time.Millisecond, func(sig *StatusSignal) bool {
+    return l2_lockable.State == Unlocked && slices.Contains(sig.Fields, "LockableState") && sig.Source == l2.ID && l2_lockable.Owner == nil
+  })
+  fatalErr(t, err)
+}
+
+func TestForceUnlockOwner(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  l2_listener := NewListenerExt(10)
+  l2_lockable := NewLockableExt(nil)
+  l2, err := ctx.NewNode(nil, "LockableNode", l2_listener, l2_lockable)
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1_lockable := NewLockableExt([]NodeID{l2.ID})
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, l1_lockable)
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, l1)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(l1_listener.Chan, 100*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  force_id, err := ForceUnlockLockable(ctx, l2)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(l2_listener.Chan, 10*time.Millisecond, force_id)
+  fatalErr(t, err)
+  _, err = WaitForSignal(l1_listener.Chan, 100*time.Millisecond, func(sig *LockLostSignal) bool {
+    return true
+  })
+  fatalErr(t, err)
+
+  // The owner doesn't wait on the requirement it lost to unlock
+  unlock_id, err := UnlockLockable(ctx, l1)
+  fatalErr(t, err)
+  response, _, err := WaitForResponse(l1_listener.Chan, 100*time.Millisecond, unlock_id)
+  fatalErr(t, err)
+  if _, is_success := response.(*SuccessSignal); is_success == false {
+    t.Fatalf("Owner failed to unlock after losing a requirement: %+v", response)
+  } else if l1_lockable.State != Unlocked || l1_lockable.Requirements[l2.ID] != Unlocked {
+    t.Fatalf("l1 not unlocked: %s %+v", l1_lockable.State, l1_lockable.Requirements)
+  }
 }
 
 func Test10Lock(t *testing.T) {
@@ -146,3 +397,985 @@ func TestLock(t *testing.T) {
   fatalErr(t, err)
   ctx.Log.Logf("test", "l1 lock: %+v", response)
 }
+
+func TestStatusDiffs(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetStatusDiffs(ctx, "LockableNode", true))
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+
+  status, err := WaitForSignal(l1_listener.Chan, time.Millisecond*10, func(sig *StatusSignal) bool {
+    return sig.Source == l1.ID
+  })
+  fatalErr(t, err)
+
+  if len(status.Diffs) != 1 || status.Diffs[0].Field != "Requirements" {
+    t.Fatalf("Expected diff for Requirements, got %+v", status.Diffs)
+  }
+
+  old_value, err := status.Diffs[0].Old.Deserialize(ctx)
+  fatalErr(t, err)
+  if len(old_value.Interface().(map[NodeID]ReqState)) != 0 {
+    t.Fatalf("Expected no old requirements, got %+v", old_value)
+  }
+
+  new_value, err := status.Diffs[0].New.Deserialize(ctx)
+  fatalErr(t, err)
+  if _, exists := new_value.Interface().(map[NodeID]ReqState)[l2.ID]; exists == false {
+    t.Fatalf("Expected l2 in new requirements, got %+v", new_value)
+  }
+}
+
+func TestStatusCoalesce(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetStatusDiffs(ctx, "LockableNode", true))
+  fatalErr(t, SetStatusCoalesce(ctx, "LockableNode", 50*time.Millisecond))
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l3, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  for _, req := range([]NodeID{l2.ID, l3.ID}) {
+    link_signal := NewLinkSignal("add", req)
+    fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+    _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+    fatalErr(t, err)
+  }
+
+  is_l1 := func(sig *StatusSignal) bool {
+    return sig.Source == l1.ID
+  }
+  status, err := WaitForSignal(l1_listener.Chan, 100*time.Millisecond, is_l1)
+  fatalErr(t, err)
+  if len(status.Diffs) != 1 || status.Diffs[0].Field != "Requirements" {
+    t.Fatalf("Expected one diff for Requirements, got %+v", status.Diffs)
+  }
+
+  old_value, err := status.Diffs[0].Old.Deserialize(ctx)
+  fatalErr(t, err)
+  new_value, err := status.Diffs[0].New.Deserialize(ctx)
+  fatalErr(t, err)
+  if len(old_value.Interface().(map[NodeID]ReqState)) != 0 || len(new_value.Interface().(map[NodeID]ReqState)) != 2 {
+    t.Fatalf("Diff not merged across links: %+v -> %+v", old_value, new_value)
+  }
+
+  _, err = WaitForSignal(l1_listener.Chan, 100*time.Millisecond, is_l1)
+  if err == nil {
+    t.Fatal("Got a second StatusSignal after coalescing")
+  }
+}
+
+func TestNodeHistory(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetNodeHistory(ctx, "LockableNode", 2))
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l3, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+  created := time.Now()
+
+  for _, req := range([]NodeID{l2.ID, l3.ID}) {
+    link_signal := NewLinkSignal("add", req)
+    fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+    _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+    fatalErr(t, err)
+  }
+
+  history, err := ReadNodeHistory(ctx, l1.ID)
+  fatalErr(t, err)
+  // Each link writes one version when it's requested and one when the requirement responds
+  if len(history) != 2 || history[0].Version != 4 || history[1].Version != 5 {
+    t.Fatalf("Expected versions 4 and 5, got %+v", history)
+  }
+
+  _, err = ReadNodeAt(ctx, l1.ID, created)
+  if err == nil {
+    t.Fatal("Read version from before the oldest stored version")
+  }
+
+  latest, err := ReadNodeAt(ctx, l1.ID, time.Now())
+  fatalErr(t, err)
+  requirements, err := latest.Fields["Requirements"].Deserialize(ctx)
+  fatalErr(t, err)
+  if len(requirements.Interface().(map[NodeID]ReqState)) != 2 {
+    t.Fatalf("Expected 2 requirements in latest version, got %+v", requirements)
+  }
+}
+
+func TestNodeHistoryWriteFailure(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetNodeHistory(ctx, "LockableNode", 3))
+
+  listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  failing := &failingDB{Database: ctx.DB}
+  ctx.DB = failing
+  failing.failing.Store(true)
+
+  // The node keeps processing signals when it can't write versions
+  lock_id, err := LockLockable(ctx, l1)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  failing.failing.Store(false)
+  before, err := ctx.DB.LoadNodeHistory(ctx, l1.ID)
+  fatalErr(t, err)
+
+  unlock_id, err := UnlockLockable(ctx, l1)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, unlock_id)
+  fatalErr(t, err)
+
+  // The version is written after the response is sent
+  deadline := time.Now().Add(100*time.Millisecond)
+  history := before
+  for len(history) == len(before) && time.Now().Before(deadline) {
+    time.Sleep(time.Millisecond)
+    history, err = ctx.DB.LoadNodeHistory(ctx, l1.ID)
+    fatalErr(t, err)
+  }
+
+  latest := history[len(history)-1]
+  if len(history) == len(before) {
+    t.Fatal("No version was written after the DB recovered")
+  } else if latest.Delta {
+    t.Fatalf("Version after a failed write was a delta: %+v", latest)
+  }
+}
+
+func TestNodeHistoryDeltas(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetNodeHistory(ctx, "LockableNode", 3))
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+
+  stored, err := ctx.DB.LoadNodeHistory(ctx, l1.ID)
+  fatalErr(t, err)
+  if len(stored) != 3 || stored[0].Delta || stored[1].Delta == false || stored[2].Delta == false {
+    t.Fatalf("Expected a full version followed by deltas, got %+v", stored)
+  } else if len(stored[1].Fields) >= len(stored[0].Fields) {
+    t.Fatalf("Delta version has every field: %+v", stored[1].Fields)
+  }
+
+  history, err := ReadNodeHistory(ctx, l1.ID)
+  fatalErr(t, err)
+  for _, version := range(history) {
+    if version.Delta || len(version.Fields) != len(stored[0].Fields) {
+      t.Fatalf("Version %d wasn't expanded: %+v", version.Version, version)
+    }
+  }
+
+  // Trimming folds the dropped versions into the new oldest version, and long runs of deltas are folded into a full version
+  history = []NodeVersion{{Version: 1, Fields: map[string]SerializedValue{"a": {}, "b": {}}}}
+  for i := 0; i < HISTORY_MAX_DELTAS + 1; i++ {
+    history, err = appendVersion(history, NodeVersion{Fields: map[string]SerializedValue{"a": {}}, Delta: true}, HISTORY_MAX_DELTAS + 1)
+    fatalErr(t, err)
+  }
+  if history[0].Delta || len(history[0].Fields) != 2 || history[0].Version != 2 {
+    t.Fatalf("Oldest version wasn't folded when trimmed: %+v", history[0])
+  } else if latest := history[len(history)-1]; latest.Delta || len(latest.Fields) != 2 {
+    t.Fatalf("Delta wasn't folded after %d deltas: %+v", HISTORY_MAX_DELTAS, latest)
+  }
+}
+
+func TestNodeTimeline(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetNodeHistory(ctx, "LockableNode", 10))
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+  created := time.Now()
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+
+  timeline, err := ReadNodeTimeline(ctx, l1.ID, time.Time{}, time.Now())
+  fatalErr(t, err)
+  // Created, then the link request and the requirement's response
+  if len(timeline.States) != 3 || timeline.States[0].Signal != nil {
+    t.Fatalf("Wrong timeline states: %+v", timeline.States)
+  }
+  link := timeline.States[1]
+  if link.Signal == nil || link.Signal.Type != "LinkSignal" || link.Signal.ID != link_signal.ID() || link.Signal.Source != l1.ID {
+    t.Fatalf("Wrong trigger for link version: %+v", link.Signal)
+  }
+  last := timeline.States[2]
+  if slices.Contains(last.Changed, "Requirements") == false || len(last.Fields["Requirements"].([]any)) != 1 {
+    t.Fatalf("Requirement response version didn't change requirements: %+v", last)
+  }
+
+  // A range after the link starts with the state that was current at its start
+  later, err := ReadNodeTimeline(ctx, l1.ID, time.Now(), time.Now())
+  fatalErr(t, err)
+  if len(later.States) != 1 || later.States[0].Version != last.Version {
+    t.Fatalf("Timeline after the link didn't start with the latest state: %+v", later.States)
+  }
+
+  recorder := httptest.NewRecorder()
+  TimelineHandler(ctx)(recorder, httptest.NewRequest("GET", "/timeline?node=" + l1.ID.String() + "&to=" + created.Format(time.RFC3339Nano), nil))
+  var served Timeline
+  fatalErr(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+  if recorder.Code != 200 || len(served.States) != 1 || served.States[0].Version != timeline.States[0].Version {
+    t.Fatalf("Wrong timeline served(%d): %s", recorder.Code, recorder.Body.String())
+  }
+
+  recorder = httptest.NewRecorder()
+  TimelineHandler(ctx)(recorder, httptest.NewRequest("GET", "/timeline?node=bad", nil))
+  if recorder.Code != 400 {
+    t.Fatalf("Bad node ID got status %d", recorder.Code)
+  }
+}
+
+func TestQuota(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetQuota(ctx, "LockableNode", Quota{Nodes: 3, Requirements: 1}))
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  // Nodes that couldn't be written don't count against the quota
+  failing := &failingDB{Database: ctx.DB}
+  ctx.DB = failing
+  failing.failing.Store(true)
+  _, err = ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  if err == nil {
+    t.Fatal("Created a node while the DB was failing")
+  }
+  failing.failing.Store(false)
+
+  _, err = ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{l2.ID, RandID()}))
+  if errors.Is(err, QuotaExceededError) == false {
+    t.Fatalf("Expected requirement quota error, got %s", err)
+  }
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt([]NodeID{l2.ID}))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", RandID())
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  response, _, err := WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || error_signal.Error != "requirement_quota" {
+    t.Fatalf("Expected requirement_quota error, got %s", response)
+  }
+
+  _, err = ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  _, err = ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  if errors.Is(err, QuotaExceededError) == false {
+    t.Fatalf("Expected node quota error, got %s", err)
+  }
+}
+
+func TestSizeLimits(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetQuota(ctx, "LockableNode", Quota{Dependencies: 1, FieldSize: 256}))
+
+  requirements := make([]NodeID, 16)
+  for i := range(requirements) {
+    requirements[i] = RandID()
+  }
+  _, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(requirements))
+  var size_err SizeLimitError
+  if errors.As(err, &size_err) == false || errors.Is(err, QuotaExceededError) == false {
+    t.Fatalf("Expected field size error, got %s", err)
+  }
+
+  l3, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l2_listener := NewListenerExt(10)
+  l2, err := ctx.NewNode(nil, "LockableNode", l2_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", l3.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  response, _, err := WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+  if _, ok := response.(*SuccessSignal); ok == false {
+    t.Fatalf("Expected link success, got %s", response)
+  }
+
+  link_signal = NewLinkSignal("add", l3.ID)
+  fatalErr(t, ctx.Send(l2, []Message{{l2.ID, link_signal}}))
+  response, _, err = WaitForResponse(l2_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || error_signal.Error != "link_failed: dependency_quota" {
+    t.Fatalf("Expected dependency_quota error, got %s", response)
+  }
+
+  // Quotas can only be set before nodes are loaded, so the signal size is checked on a new context
+  ctx = logTestContext(t, []string{"test"})
+  fatalErr(t, SetQuota(ctx, "LockableNode", Quota{SignalSize: 64}))
+
+  l3, err = ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l2_listener = NewListenerExt(10)
+  l2, err = ctx.NewNode(nil, "LockableNode", l2_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal = NewLinkSignal("add", RandID())
+  fatalErr(t, ctx.Send(l2, []Message{{l3.ID, link_signal}}))
+  response, _, err = WaitForResponse(l2_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || error_signal.Error != "signal_size" {
+    t.Fatalf("Expected signal_size error, got %s", response)
+  }
+
+  // Changes over the field size are rejected when they're made, and the field keeps it's old value
+  ctx = logTestContext(t, []string{"test"})
+  fatalErr(t, SetQuota(ctx, "LockableNode", Quota{FieldSize: 256}))
+
+  l3_lockable := NewLockableExt(nil)
+  l3, err = ctx.NewNode(nil, "LockableNode", l3_lockable)
+  fatalErr(t, err)
+
+  var rejected *ErrorSignal = nil
+  for i := 0; i < 32 && rejected == nil; i++ {
+    listener := NewListenerExt(10)
+    linker, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+    fatalErr(t, err)
+
+    link_signal = NewLinkSignal("add", l3.ID)
+    fatalErr(t, ctx.Send(linker, []Message{{linker.ID, link_signal}}))
+    response, _, err = WaitForResponse(listener.Chan, time.Millisecond*100, link_signal.ID())
+    fatalErr(t, err)
+    rejected, _ = response.(*ErrorSignal)
+  }
+
+  if rejected == nil || rejected.Error != "link_failed: field_size" {
+    t.Fatalf("Expected field_size error, got %+v", rejected)
+  }
+
+  size, err := SerializedSize(ctx, reflect.ValueOf(l3_lockable.Dependencies))
+  fatalErr(t, err)
+  if size > 256 {
+    t.Fatalf("Dependencies kept at %d bytes after the change was rejected", size)
+  }
+}
+
+func TestIdempotentLink(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "idempotency"})
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1_idempotency := NewIdempotencyExt(1)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, l1_idempotency, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("link", link_signal)}}))
+  first, _, err := WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+  if _, ok := first.(*SuccessSignal); ok == false {
+    t.Fatalf("Expected link success, got %s", first)
+  }
+
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("link", link_signal)}}))
+  retry, _, err := WaitForResponse(l1_listener.Chan, time.Millisecond*10, link_signal.ID())
+  fatalErr(t, err)
+  if retry.ID() != first.ID() {
+    t.Fatalf("Retry got %s instead of cached %s", retry, first)
+  }
+
+  lock_signal := NewLockSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("lock", lock_signal)}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, lock_signal.ID())
+  fatalErr(t, err)
+
+  if _, exists := l1_idempotency.Results[idempotencyKey(l1.ID, "link")]; exists {
+    t.Fatalf("Oldest key not dropped: %+v", l1_idempotency.Results)
+  } else if len(l1_idempotency.Results[idempotencyKey(l1.ID, "lock")].Responses) != 1 {
+    t.Fatalf("Lock response not recorded: %+v", l1_idempotency.Results)
+  }
+}
+
+func TestIdempotentRetryInProgress(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "idempotency"})
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewIdempotencyExt(10), NewLockableExt(nil))
+  fatalErr(t, err)
+
+  other_listener := NewListenerExt(10)
+  other, err := ctx.NewNode(nil, "Node", other_listener)
+  fatalErr(t, err)
+
+  // Hold the link in progress by freezing the requirement
+  freeze_signal := NewFreezeSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l2.ID, freeze_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, 10*time.Millisecond, freeze_signal.ID())
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("link", link_signal)}}))
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("link", link_signal)}}))
+
+  // The same key from another source isn't a retry, so it's processed on it's own
+  other_link := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(other, []Message{{l1.ID, NewIdempotentSignal("link", other_link)}}))
+  _, _, err = WaitForResponse(other_listener.Chan, 100*time.Millisecond, other_link.ID())
+  fatalErr(t, err)
+
+  thaw_signal := NewThawSignal()
+  fatalErr(t, ctx.Send(l1, []Message{{l2.ID, thaw_signal}}))
+
+  _, _, err = WaitForResponse(l1_listener.Chan, 100*time.Millisecond, link_signal.ID())
+  fatalErr(t, err)
+  duplicate, _, err := WaitForResponse(l1_listener.Chan, 50*time.Millisecond, link_signal.ID())
+  if err == nil {
+    t.Fatalf("Retry while in progress was processed again: %s", duplicate)
+  }
+}
+
+func TestIdempotentNoResponse(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "idempotency"})
+
+  l1_listener := NewListenerExt(10)
+  l1_idempotency := NewIdempotencyExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, l1_idempotency, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  // Responses don't get a response, so the key never gets any
+  ack := NewSuccessSignal(uuid.New())
+  key := idempotencyKey(l1.ID, "ack")
+  sync := func() {
+    read_signal := NewReadSignal([]string{"Requirements"})
+    fatalErr(t, ctx.Send(l1, []Message{{l1.ID, read_signal}}))
+    _, _, err := WaitForResponse(l1_listener.Chan, 100*time.Millisecond, read_signal.ID())
+    fatalErr(t, err)
+  }
+
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("ack", ack)}}))
+  sync()
+  first := l1_idempotency.Results[key]
+
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("ack", ack)}}))
+  sync()
+  if l1_idempotency.Results[key].Time != first.Time {
+    t.Fatalf("Retry before the timeout was processed again: %+v", l1_idempotency.Results[key])
+  }
+
+  first.Time = time.Now().Add(-IDEMPOTENCY_PENDING_TIMEOUT).UnixNano()
+  l1_idempotency.Results[key] = first
+
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, NewIdempotentSignal("ack", ack)}}))
+  sync()
+  if l1_idempotency.Results[key].Time == first.Time {
+    t.Fatalf("Key without responses stayed in progress after the timeout: %+v", l1_idempotency.Results[key])
+  }
+}
+
+func TestPathQuery(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  c, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  b, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{c.ID}))
+  fatalErr(t, err)
+  a, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{b.ID}))
+  fatalErr(t, err)
+
+  listener := NewListenerExt(10)
+  source, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+  read := NodeEdgeReader(ctx, source, listener.Chan, 100*time.Millisecond)
+
+  depths, err := Reachable(read, a.ID, []string{"Requirements"}, 1, 0)
+  fatalErr(t, err)
+  if len(depths) != 2 || depths[b.ID] != 1 {
+    t.Fatalf("Wrong nodes reachable within 1 edge: %+v", depths)
+  }
+
+  path, err := FindPath(read, a.ID, c.ID, []string{"Requirements"}, 2, 0)
+  fatalErr(t, err)
+  if len(path) != 3 || path[0] != a.ID || path[1] != b.ID || path[2] != c.ID {
+    t.Fatalf("Wrong path from a to c: %+v", path)
+  }
+
+  path, err = FindPath(read, c.ID, a.ID, []string{"Requirements"}, 2, 0)
+  fatalErr(t, err)
+  if path != nil {
+    t.Fatalf("Found path from c to a: %+v", path)
+  }
+
+  _, err = Reachable(read, a.ID, []string{"Requirements"}, 2, 2)
+  if errors.Is(err, PathLimitError) == false {
+    t.Fatalf("Reached 3 nodes with a limit of 2: %s", err)
+  }
+
+  _, err = FindPath(read, a.ID, c.ID, []string{"Requirements"}, 2, 2)
+  if errors.Is(err, PathLimitError) == false {
+    t.Fatalf("Found path through 3 nodes with a limit of 2: %s", err)
+  }
+}
+
+func TestTransaction(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  a_lockable := NewLockableExt(nil)
+  a, err := ctx.NewNode(nil, "LockableNode", a_lockable)
+  fatalErr(t, err)
+  b_lockable := NewLockableExt(nil)
+  b, err := ctx.NewNode(nil, "LockableNode", b_lockable)
+  fatalErr(t, err)
+
+  listener := NewListenerExt(100)
+  source, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  // Hold b in another transaction so the first transaction aborts
+  busy := NewPrepareSignal(uuid.New(), time.Now().Add(time.Second), NewLinkSignal("add", req.ID))
+  fatalErr(t, ctx.Send(source, []Message{{b.ID, busy}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, busy.ID())
+  fatalErr(t, err)
+
+  err = RunTransaction(ctx, source, listener.Chan, []Message{
+    {a.ID, NewLinkSignal("add", req.ID)},
+    {b.ID, NewLinkSignal("add", req.ID)},
+  }, 100*time.Millisecond)
+  if err == nil {
+    t.Fatal("Transaction succeeded with b busy")
+  }
+
+  abort := NewAbortSignal(busy.Tx)
+  fatalErr(t, ctx.Send(source, []Message{{b.ID, abort}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, abort.ID())
+  fatalErr(t, err)
+
+  err = RunTransaction(ctx, source, listener.Chan, []Message{
+    {a.ID, NewLinkSignal("add", req.ID)},
+    {b.ID, NewLinkSignal("add", req.ID)},
+  }, 100*time.Millisecond)
+  fatalErr(t, err)
+
+  // The links finish after the transaction, and RunTransaction drops their responses while it waits,
+  // so the requirements are read until they're added
+  for _, node := range([]*Node{a, b}) {
+    deadline := time.Now().Add(time.Second)
+    for {
+      read_signal := NewReadSignal([]string{"Requirements"})
+      fatalErr(t, ctx.Send(source, []Message{{node.ID, read_signal}}))
+      response, _, err := WaitForResponse(listener.Chan, 100*time.Millisecond, read_signal.ID())
+      fatalErr(t, err)
+      requirements, err := ReadResultField[map[NodeID]ReqState](response.(*ReadResultSignal), "Requirements")
+      fatalErr(t, err)
+      if len(requirements) == 1 {
+        break
+      } else if time.Now().After(deadline) {
+        t.Fatalf("Transaction was not applied to %s: %+v", node.ID, requirements)
+      }
+      time.Sleep(time.Millisecond)
+    }
+  }
+}
+
+func TestTransactionChecks(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterNodeType(ctx, "SmallLockableNode", nil))
+  fatalErr(t, SetQuota(ctx, "SmallLockableNode", Quota{FieldSize: 32}))
+
+  req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  locked, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  small, err := ctx.NewNode(nil, "SmallLockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  listener := NewListenerExt(100)
+  source, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  lock := NewLockSignal()
+  fatalErr(t, ctx.Send(source, []Message{{locked.ID, lock}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, lock.ID())
+  fatalErr(t, err)
+
+  // A signal that would fail is refused when it's prepared
+  refused := NewPrepareSignal(uuid.New(), time.Now().Add(time.Second), NewLockSignal())
+  fatalErr(t, ctx.Send(source, []Message{{locked.ID, refused}}))
+  response, _, err := WaitForResponse(listener.Chan, 100*time.Millisecond, refused.ID())
+  fatalErr(t, err)
+  if error_signal, is_error := response.(*ErrorSignal); is_error == false || strings.HasPrefix(error_signal.Error, "tx_refused") == false {
+    t.Fatalf("Prepared a lock of a locked node: %s", response)
+  }
+
+  // The pending link is over the field size limit, which is only found when it's processed
+  link := NewPrepareSignal(uuid.New(), time.Now().Add(time.Second), NewLinkSignal("add", req.ID))
+  fatalErr(t, ctx.Send(source, []Message{{small.ID, link}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, link.ID())
+  fatalErr(t, err)
+
+  commit := NewCommitSignal(link.Tx)
+  fatalErr(t, ctx.Send(source, []Message{{small.ID, commit}}))
+  response, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, commit.ID())
+  fatalErr(t, err)
+  if error_signal, is_error := response.(*ErrorSignal); is_error == false || strings.HasPrefix(error_signal.Error, "commit_failed") == false {
+    t.Fatalf("Commit that failed to process was acknowledged: %s", response)
+  }
+
+  // Signals past the deferred limit are refused instead of held
+  held := NewPrepareSignal(uuid.New(), time.Now().Add(time.Second), NewLockSignal())
+  fatalErr(t, ctx.Send(source, []Message{{req.ID, held}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, held.ID())
+  fatalErr(t, err)
+
+  deferred := make([]Message, TX_MAX_DEFERRED + 1)
+  for i := range(deferred) {
+    deferred[i] = Message{req.ID, NewLinkSignal("add", locked.ID)}
+  }
+  fatalErr(t, ctx.Send(source, deferred))
+
+  last := deferred[TX_MAX_DEFERRED].Signal
+  response, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, last.ID())
+  fatalErr(t, err)
+  if error_signal, is_error := response.(*ErrorSignal); is_error == false || error_signal.Error != "tx_deferred_full" {
+    t.Fatalf("Signal past the deferred limit wasn't refused: %s", response)
+  }
+
+  abort := NewAbortSignal(held.Tx)
+  fatalErr(t, ctx.Send(source, []Message{{req.ID, abort}}))
+}
+
+func TestTransactionDeadline(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  a_lockable := NewLockableExt(nil)
+  a, err := ctx.NewNode(nil, "LockableNode", a_lockable)
+  fatalErr(t, err)
+  b, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  listener := NewListenerExt(100)
+  source, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  // a is never told to end the transaction, so it aborts once the deadline passes
+  expired := NewPrepareSignal(uuid.New(), time.Now().Add(50*time.Millisecond), NewLinkSignal("add", req.ID))
+  fatalErr(t, ctx.Send(source, []Message{{a.ID, expired}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, expired.ID())
+  fatalErr(t, err)
+
+  time.Sleep(100*time.Millisecond)
+  commit := NewCommitSignal(expired.Tx)
+  fatalErr(t, ctx.Send(source, []Message{{a.ID, commit}}))
+  response, _, err := WaitForResponse(listener.Chan, 100*time.Millisecond, commit.ID())
+  fatalErr(t, err)
+  if _, is_error := response.(*ErrorSignal); is_error == false {
+    t.Fatalf("Committed a transaction past its deadline: %s", response)
+  } else if len(a_lockable.Requirements) != 0 {
+    t.Fatalf("Applied a transaction past its deadline: %+v", a_lockable.Requirements)
+  }
+
+  decision, err := ctx.DB.WriteTxDecision(ctx, expired.Tx, true)
+  fatalErr(t, err)
+  if decision {
+    t.Fatal("Recorded a commit after a node aborted at its deadline")
+  }
+
+  // The prepared signal is written to the DB, so b can be unloaded and still commit it
+  persisted := NewPrepareSignal(uuid.New(), time.Now().Add(time.Second), NewLinkSignal("add", req.ID))
+  fatalErr(t, ctx.Send(source, []Message{{b.ID, persisted}}))
+  _, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, persisted.ID())
+  fatalErr(t, err)
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(b.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  commit = NewCommitSignal(persisted.Tx)
+  fatalErr(t, ctx.Send(source, []Message{{b.ID, commit}}))
+  response, _, err = WaitForResponse(listener.Chan, 100*time.Millisecond, commit.ID())
+  fatalErr(t, err)
+  if _, is_success := response.(*SuccessSignal); is_success == false {
+    t.Fatalf("Failed to commit transaction prepared before unloading: %s", response)
+  }
+}
+
+func TestReferences(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewListenerExt(10), NewLockableExt(nil))
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt([]NodeID{l2.ID}))
+  fatalErr(t, err)
+
+  // Changed fields are reindexed once the node is idle, so wait for the index to catch up
+  wait_references := func(id NodeID, expected int) []Reference {
+    for start := time.Now(); ; time.Sleep(time.Millisecond) {
+      references, err := ctx.References(id)
+      fatalErr(t, err)
+      if len(references) == expected {
+        return references
+      } else if time.Since(start) > time.Millisecond*100 {
+        t.Fatalf("Expected %d references to %s, found %+v", expected, id, references)
+      }
+    }
+  }
+
+  references := wait_references(l2.ID, 1)
+  if references[0].Source != l1.ID || references[0].Field != "requirements" {
+    t.Fatalf("Wrong reference to l2: %+v", references[0])
+  }
+
+  unlink_signal := NewLinkSignal("remove", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, unlink_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, time.Millisecond*10, unlink_signal.ID())
+  fatalErr(t, err)
+
+  wait_references(l2.ID, 0)
+}
+
+func TestReferencesUnload(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l1, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{l2.ID}))
+  fatalErr(t, err)
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(l1.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  ctx.referenceIndex.lock.RLock()
+  indexed := len(ctx.referenceIndex.references[l2.ID])
+  source := ctx.referenceIndex.sources[l1.ID]
+  ctx.referenceIndex.lock.RUnlock()
+  if indexed != 0 || source {
+    t.Fatalf("Unloaded node still in the reference index: %d references, source %t", indexed, source)
+  }
+
+  references, err := ctx.References(l2.ID)
+  fatalErr(t, err)
+  if len(references) != 1 || references[0].Source != l1.ID {
+    t.Fatalf("Reference from unloaded node not read from the DB: %+v", references)
+  }
+}
+
+func TestConsistencyChecker(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "consistency"})
+
+  l2_lockable := NewLockableExt(nil)
+  l2, err := ctx.NewNode(nil, "LockableNode", NewListenerExt(10), l2_lockable)
+  fatalErr(t, err)
+
+  // Creating a lockable with requirements doesn't link them back as dependencies
+  l1, err := ctx.NewNode(nil, "LockableNode", NewListenerExt(10), NewLockableExt([]NodeID{l2.ID}))
+  fatalErr(t, err)
+
+  inconsistencies := ctx.CheckConsistency(LockableInvariants)
+  if len(inconsistencies) != 1 || inconsistencies[0].Source != l1.ID || inconsistencies[0].Target != l2.ID {
+    t.Fatalf("Wrong inconsistencies: %+v", inconsistencies)
+  } else if inconsistencies[0].RepairSource != l1.ID || inconsistencies[0].Repair == nil {
+    t.Fatalf("Missing repair: %+v", inconsistencies[0])
+  }
+
+  reports := make(chan []Inconsistency, 10)
+  checker, err := NewConsistencyChecker(ctx, time.Millisecond*5, true, func(found []Inconsistency) {
+    reports <- found
+  }, LockableInvariants...)
+  fatalErr(t, err)
+  defer checker.Stop()
+
+  select {
+  case found := <-reports:
+    if len(found) != 1 || found[0].Invariant != "requirement_has_dependency" {
+      t.Fatalf("Wrong report: %+v", found)
+    }
+  case <-time.After(time.Millisecond*100):
+    t.Fatal("Inconsistency wasn't reported")
+  }
+
+  for start := time.Now(); len(ctx.CheckConsistency(LockableInvariants)) != 0; time.Sleep(time.Millisecond) {
+    if time.Since(start) > time.Millisecond*100 {
+      t.Fatalf("Inconsistency wasn't repaired: %+v", ctx.CheckConsistency(LockableInvariants))
+    }
+  }
+}
+
+func TestConsistencyUnloadedTarget(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "consistency"})
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  link_signal := NewLinkSignal("add", l2.ID)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  _, _, err = WaitForResponse(l1_listener.Chan, 100*time.Millisecond, link_signal.ID())
+  fatalErr(t, err)
+
+  // Wait for both sides of the link to be indexed
+  indexed := func() bool {
+    references, err := ctx.References(l2.ID)
+    fatalErr(t, err)
+    return slices.ContainsFunc(references, func(reference Reference) bool {
+      return reference.Field == "requirements"
+    }) && len(ctx.CheckConsistency(LockableInvariants)) == 0
+  }
+  for start := time.Now(); indexed() == false; time.Sleep(time.Millisecond) {
+    if time.Since(start) > 100*time.Millisecond {
+      t.Fatalf("Link wasn't indexed: %+v", ctx.CheckConsistency(LockableInvariants))
+    }
+  }
+
+  // l1's requirement is written when it's unloaded, and only the DB has it afterwards
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(l1.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  inconsistencies := ctx.CheckConsistency(LockableInvariants)
+  if len(inconsistencies) != 0 {
+    t.Fatalf("Link to unloaded node reported as inconsistent: %+v", inconsistencies)
+  }
+}
+
+func TestLockGraph(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  c_listener := NewListenerExt(10)
+  c, err := ctx.NewNode(nil, "LockableNode", c_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+  b, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{c.ID}))
+  fatalErr(t, err)
+  a, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{b.ID}))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, c)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(c_listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  listener := NewListenerExt(10)
+  source, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+  read := NodeFieldReader(ctx, source, listener.Chan, 100*time.Millisecond)
+
+  graph, err := LoadLockGraph(read, a.ID, 2)
+  fatalErr(t, err)
+  if graph.State != Unlocked || len(graph.Requirements) != 1 || graph.Requirements[0].ID != b.ID {
+    t.Fatalf("Wrong lock graph for a: %+v", graph)
+  }
+
+  b_graph := graph.Requirements[0].Node
+  if b_graph == nil || len(b_graph.Requirements) != 1 || b_graph.Requirements[0].ID != c.ID {
+    t.Fatalf("Wrong lock graph for b: %+v", b_graph)
+  }
+
+  c_graph := b_graph.Requirements[0].Node
+  if c_graph == nil || c_graph.State != Locked || c_graph.Owner == nil || *c_graph.Owner != c.ID {
+    t.Fatalf("Wrong lock graph for c: %+v", c_graph)
+  } else if b_graph.Requirements[0].State != Unlocked {
+    t.Fatalf("b sees c as %s", b_graph.Requirements[0].State)
+  }
+
+  graph, err = LoadLockGraph(read, a.ID, 0)
+  fatalErr(t, err)
+  if graph.Requirements[0].Node != nil {
+    t.Fatalf("Lock graph went past depth 0: %+v", graph.Requirements[0].Node)
+  }
+}
+
+func TestComputedFields(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  err := RegisterComputedField(ctx, "requirements", "", func(ctx *Context, node *Node, ext *LockableExt) int {
+    return 0
+  })
+  if err == nil {
+    t.Fatal("Registered a computed field over a stored field")
+  }
+
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l3, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", l1_listener, NewLockableExt([]NodeID{l2.ID, l3.ID}))
+  fatalErr(t, err)
+
+  ReadLockedCount := func() int {
+    read_signal := NewReadSignal([]string{"LockedCount"})
+    fatalErr(t, ctx.Send(l1, []Message{{l1.ID, read_signal}}))
+    result, err := WaitForSignal(l1_listener.Chan, 10*time.Millisecond, func(sig *ReadResultSignal) bool {
+      return sig.ReqID == read_signal.ID()
+    })
+    fatalErr(t, err)
+
+    count, err := ReadResultField[int](result, "LockedCount")
+    fatalErr(t, err)
+    return count
+  }
+
+  if count := ReadLockedCount(); count != 0 {
+    t.Fatalf("Unlocked lockable has LockedCount %d", count)
+  }
+
+  lock_id, err := LockLockable(ctx, l1)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(l1_listener.Chan, 100*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  if count := ReadLockedCount(); count != 2 {
+    t.Fatalf("Locked lockable has LockedCount %d", count)
+  }
+}
@@ -0,0 +1,268 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+  "slices"
+  "time"
+
+  "github.com/graphql-go/graphql"
+)
+
+// Maximum depth of requirement trees from GQL
+const LOCK_GRAPH_MAX_DEPTH = 16
+
+// Reads node fields by name, used to build lock graphs
+type FieldReader func(id NodeID, fields []string) (map[string]any, error)
+
+// A lockable node and its requirement tree
+type LockGraph struct {
+  ID NodeID
+  State ReqState
+  Owner *NodeID
+  PendingOwner *NodeID
+  Requirements []LockGraphRequirement
+}
+
+// A requirement of a LockGraph node
+type LockGraphRequirement struct {
+  ID NodeID
+  // State of the requirement as seen by the node that requires it, differs from the requirement's own state while a request is pending
+  State ReqState
+  // The requirement's tree, nil if it's past the depth limit or a cycle back to one of it's dependencies
+  Node *LockGraph
+}
+
+var lockGraphFields = []string{"LockableState", "Owner", "PendingOwner", "Requirements"}
+
+func readLockGraphField[T any](id NodeID, fields map[string]any, name string) (T, error) {
+  var zero T
+  value, returned := fields[name]
+  if returned == false {
+    return zero, fmt.Errorf("%s did not return %s", id, name)
+  }
+
+  err, is_err := value.(error)
+  if is_err {
+    return zero, fmt.Errorf("%s is not lockable: %w", id, err)
+  }
+
+  typed, ok := value.(T)
+  if ok == false {
+    return zero, fmt.Errorf("%s.%s is %s, not %s", id, name, reflect.TypeOf(value), reflect.TypeFor[T]())
+  }
+  return typed, nil
+}
+
+// Read the requirement tree of a lockable up to depth levels of requirements below it
+func LoadLockGraph(read FieldReader, id NodeID, depth int) (*LockGraph, error) {
+  return loadLockGraph(read, id, depth, []NodeID{})
+}
+
+func loadLockGraph(read FieldReader, id NodeID, depth int, path []NodeID) (*LockGraph, error) {
+  fields, err := read(id, lockGraphFields)
+  if err != nil {
+    return nil, err
+  }
+
+  graph := &LockGraph{
+    ID: id,
+    Requirements: []LockGraphRequirement{},
+  }
+
+  graph.State, err = readLockGraphField[ReqState](id, fields, "LockableState")
+  if err != nil {
+    return nil, err
+  }
+  graph.Owner, err = readLockGraphField[*NodeID](id, fields, "Owner")
+  if err != nil {
+    return nil, err
+  }
+  graph.PendingOwner, err = readLockGraphField[*NodeID](id, fields, "PendingOwner")
+  if err != nil {
+    return nil, err
+  }
+  requirements, err := readLockGraphField[map[NodeID]ReqState](id, fields, "Requirements")
+  if err != nil {
+    return nil, err
+  }
+
+  path = append(path, id)
+  for _, req_id := range(EdgeIDs(requirements)) {
+    requirement := LockGraphRequirement{
+      ID: req_id,
+      State: requirements[req_id],
+    }
+
+    if depth > 0 && slices.Contains(path, req_id) == false {
+      requirement.Node, err = loadLockGraph(read, req_id, depth - 1, path)
+      if err != nil {
+        return nil, err
+      }
+    }
+
+    graph.Requirements = append(graph.Requirements, requirement)
+  }
+
+  return graph, nil
+}
+
+// Create a FieldReader that sends ReadSignals from source and waits for the results on responses
+func NodeFieldReader(ctx *Context, source *Node, responses chan Signal, timeout time.Duration) FieldReader {
+  return func(id NodeID, fields []string) (map[string]any, error) {
+    read_signal := NewReadSignal(fields)
+    err := ctx.Send(source, []Message{{id, read_signal}})
+    if err != nil {
+      return nil, err
+    }
+
+    response, _, err := WaitForResponse(responses, timeout, read_signal.ID())
+    if err != nil {
+      return nil, err
+    }
+
+    return readResultFields(id, response)
+  }
+}
+
+func readResultFields(id NodeID, response ResponseSignal) (map[string]any, error) {
+  result, ok := response.(*ReadResultSignal)
+  if ok == false {
+    return nil, fmt.Errorf("Bad read response from %s: %+v", id, response)
+  }
+  return result.Fields, nil
+}
+
+// FieldReader that sends ReadSignals from the GQL server node
+func gqlFieldReader(ctx *ResolveContext) FieldReader {
+  return func(id NodeID, fields []string) (map[string]any, error) {
+    read_signal := NewReadSignal(fields)
+    response_chan := ctx.Ext.GetResponseChannel(read_signal.ID())
+    defer ctx.Ext.FreeResponseChannel(read_signal.ID())
+
+    err := ctx.Context.Send(ctx.Server, []Message{{id, read_signal}})
+    if err != nil {
+      return nil, err
+    }
+
+    response, _, err := WaitForResponse(response_chan, 100*time.Millisecond, read_signal.ID())
+    if err != nil {
+      return nil, err
+    }
+
+    return readResultFields(id, response)
+  }
+}
+
+// GQL query field that returns the requirement tree of a lockable, depth is limited to max_depth
+func lockGraphGQLField(ctx *Context, max_depth int) *graphql.Field {
+  id_type := ctx.Types[reflect.TypeFor[NodeID]()].Type
+
+  var graph_type *graphql.Object
+  requirement_type := graphql.NewObject(graphql.ObjectConfig{
+    Name: "LockGraphRequirement",
+    Fields: (graphql.FieldsThunk)(func() graphql.Fields {
+      return graphql.Fields{
+        "ID": &graphql.Field{
+          Type: id_type,
+          Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            return p.Source.(LockGraphRequirement).ID, nil
+          },
+        },
+        "State": &graphql.Field{
+          Type: graphql.String,
+          Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            return p.Source.(LockGraphRequirement).State.String(), nil
+          },
+        },
+        "Node": &graphql.Field{
+          Type: graph_type,
+          Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+            node := p.Source.(LockGraphRequirement).Node
+            if node == nil {
+              return nil, nil
+            }
+            return node, nil
+          },
+        },
+      }
+    }),
+  })
+
+  graph_type = graphql.NewObject(graphql.ObjectConfig{
+    Name: "LockGraph",
+    Fields: graphql.Fields{
+      "ID": &graphql.Field{
+        Type: id_type,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(*LockGraph).ID, nil
+        },
+      },
+      "State": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(*LockGraph).State.String(), nil
+        },
+      },
+      "Owner": &graphql.Field{
+        Type: id_type,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          owner := p.Source.(*LockGraph).Owner
+          if owner == nil {
+            return nil, nil
+          }
+          return *owner, nil
+        },
+      },
+      "PendingOwner": &graphql.Field{
+        Type: id_type,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          pending_owner := p.Source.(*LockGraph).PendingOwner
+          if pending_owner == nil {
+            return nil, nil
+          }
+          return *pending_owner, nil
+        },
+      },
+      "Requirements": &graphql.Field{
+        Type: graphql.NewList(requirement_type),
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(*LockGraph).Requirements, nil
+        },
+      },
+    },
+  })
+
+  return &graphql.Field{
+    Type: graph_type,
+    Args: graphql.FieldConfigArgument{
+      "id": &graphql.ArgumentConfig{
+        Type: graphql.NewNonNull(id_type),
+      },
+      "depth": &graphql.ArgumentConfig{
+        Type: graphql.Int,
+        DefaultValue: max_depth,
+      },
+    },
+    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+      ctx, err := PrepResolve(p)
+      if err != nil {
+        return nil, err
+      }
+
+      id, err := ExtractParam[NodeID](p, "id")
+      if err != nil {
+        return nil, err
+      }
+
+      depth, err := ExtractParam[int](p, "depth")
+      if err != nil {
+        return nil, err
+      } else if depth < 0 || depth > max_depth {
+        return nil, fmt.Errorf("depth must be between 0 and %d", max_depth)
+      }
+
+      return LoadLockGraph(gqlFieldReader(ctx), id, depth)
+    },
+  }
+}
@@ -5,12 +5,15 @@ import (
   "github.com/rs/zerolog"
   "os"
   "sync"
+  "time"
   "encoding/json"
 )
 
 // A Logger is passed around to record events happening to components enabled by SetComponents
 type Logger interface {
   SetComponents(components []string) error
+  // Limit how many lines a component logs, replacing any previous limit
+  SetLimit(component string, limit LogLimit) error
   // Log a formatted string
   Logf(component string, format string, items ... interface{})
   // Log a map of attributes and a format string
@@ -19,9 +22,41 @@ type Logger interface {
   Logj(component string, s interface{}, format string, items ... interface{})
 }
 
+// Limits how often a component logs, so verbose components can stay enabled
+type LogLimit struct {
+  // Only log one of every Sample lines, 0 or 1 to log every line
+  Sample uint32
+  // Maximum lines to log per second after sampling, 0 for no limit
+  Rate uint32
+}
+
+// A zerolog.Sampler that only samples an event if all of its samplers do
+type allSampler []zerolog.Sampler
+
+func (samplers allSampler) Sample(level zerolog.Level) bool {
+  for _, sampler := range(samplers) {
+    if sampler.Sample(level) == false {
+      return false
+    }
+  }
+  return true
+}
+
+func (limit LogLimit) sampler() zerolog.Sampler {
+  samplers := allSampler{}
+  if limit.Sample > 1 {
+    samplers = append(samplers, &zerolog.BasicSampler{N: limit.Sample})
+  }
+  if limit.Rate > 0 {
+    samplers = append(samplers, &zerolog.BurstSampler{Burst: limit.Rate, Period: time.Second})
+  }
+  return samplers
+}
+
 func NewConsoleLogger(components []string) *ConsoleLogger {
   logger := &ConsoleLogger{
     loggers: map[string]zerolog.Logger{},
+    limits: map[string]LogLimit{},
     components: []string{},
   }
 
@@ -33,10 +68,32 @@ func NewConsoleLogger(components []string) *ConsoleLogger {
 // A ConsoleLogger logs to stdout
 type ConsoleLogger struct {
   loggers map[string]zerolog.Logger
-  components_lock sync.Mutex
+  limits map[string]LogLimit
+  components_lock sync.RWMutex
   components []string
 }
 
+func (logger *ConsoleLogger) newLogger(component string) zerolog.Logger {
+  l := zerolog.New(os.Stdout).With().Timestamp().Str("component", component).Logger()
+  limit, limited := logger.limits[component]
+  if limited {
+    l = l.Sample(limit.sampler())
+  }
+  return l
+}
+
+func (logger *ConsoleLogger) SetLimit(component string, limit LogLimit) error {
+  logger.components_lock.Lock()
+  defer logger.components_lock.Unlock()
+
+  logger.limits[component] = limit
+  _, enabled := logger.loggers[component]
+  if enabled {
+    logger.loggers[component] = logger.newLogger(component)
+  }
+  return nil
+}
+
 func (logger * ConsoleLogger) SetComponents(components []string) error {
   logger.components_lock.Lock()
   defer logger.components_lock.Unlock()
@@ -59,16 +116,22 @@ func (logger * ConsoleLogger) SetComponents(components []string) error {
   for _, c := range(components) {
     _, exists := logger.loggers[c]
     if component_enabled(c) == true && exists == false {
-      logger.loggers[c] = zerolog.New(os.Stdout).With().Timestamp().Str("component", c).Logger()
+      logger.loggers[c] = logger.newLogger(c)
     }
   }
   return nil
 }
 
 func (logger * ConsoleLogger) Logm(component string, fields map[string]interface{}, format string, items ... interface{}) {
+  logger.components_lock.RLock()
   l, exists := logger.loggers[component]
+  logger.components_lock.RUnlock()
   if exists == true {
+    // Log returns nil when the line is sampled out
     log := l.Log()
+    if log == nil {
+      return
+    }
     for key, value := range(fields) {
       log = log.Str(key, fmt.Sprintf("%+v", value))
     }
@@ -77,9 +140,12 @@ func (logger * ConsoleLogger) Logm(component string, fields map[string]interface
 }
 
 func (logger * ConsoleLogger) Logf(component string, format string, items ... interface{}) {
+  logger.components_lock.RLock()
   l, exists := logger.loggers[component]
+  logger.components_lock.RUnlock()
   if exists == true {
-    l.Log().Msg(fmt.Sprintf(format, items...))
+    // Msgf doesn't format the line if it was sampled out
+    l.Log().Msgf(format, items...)
   }
 }
 
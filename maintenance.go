@@ -0,0 +1,75 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+)
+
+// Returned by operations that would change the graph while the context is in maintenance mode
+type MaintenanceError struct {
+  Action string
+}
+
+func (err MaintenanceError) Error() string {
+  return fmt.Sprintf("Cannot %s while the context is in maintenance mode", err.Action)
+}
+
+// Signals that are delivered in maintenance mode besides responses, which are always delivered so requests already in flight can finish.
+// Unlocks are allowed so locks that fail in maintenance mode can be released instead of retrying forever.
+var maintenanceSignals = []reflect.Type{
+  reflect.TypeFor[ReadSignal](),
+  reflect.TypeFor[StatusSignal](),
+  reflect.TypeFor[AbortSignal](),
+  reflect.TypeFor[UnlockSignal](),
+  reflect.TypeFor[FreezeSignal](),
+  reflect.TypeFor[ThawSignal](),
+}
+
+// Allow S to be delivered in maintenance mode, for signals that don't change the nodes they're sent to
+func AllowInMaintenance[S Signal](ctx *Context) {
+  ctx.maintenanceAllowed[reflect.TypeFor[S]()] = true
+}
+
+// Put the context in or out of maintenance mode.
+// In maintenance mode nodes can't be created, aliases and the auto-load set can't be changed,
+// and signals that aren't responses or allowed with AllowInMaintenance are nacked with a "maintenance" ErrorSignal.
+// Nodes keep serving reads, so GQL queries still work against the quiescent graph.
+func (ctx *Context) SetMaintenance(enabled bool) {
+  ctx.Log.Logf("maintenance", "Maintenance mode: %t", enabled)
+  ctx.maintenance.Store(enabled)
+}
+
+// Whether the context is in maintenance mode
+func (ctx *Context) InMaintenance() bool {
+  return ctx.maintenance.Load()
+}
+
+// Return a MaintenanceError for action if the context is in maintenance mode
+func (ctx *Context) checkMaintenance(action string) error {
+  if ctx.maintenance.Load() {
+    return MaintenanceError{action}
+  }
+  return nil
+}
+
+// Return a MaintenanceError if signal would be rejected in maintenance mode
+func (ctx *Context) checkMaintenanceSignal(signal Signal) error {
+  if ctx.maintenance.Load() == false {
+    return nil
+  }
+
+  _, is_response := signal.(ResponseSignal)
+  if is_response {
+    return nil
+  }
+
+  signal_type := reflect.TypeOf(signal)
+  if signal_type.Kind() == reflect.Pointer {
+    signal_type = signal_type.Elem()
+  }
+  if ctx.maintenanceAllowed[signal_type] {
+    return nil
+  }
+
+  return MaintenanceError{fmt.Sprintf("send %s", signal_type.Name())}
+}
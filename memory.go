@@ -0,0 +1,169 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+  "slices"
+  "time"
+  "unsafe"
+)
+
+// Approximate memory usage of the nodes loaded in a context
+type MemoryUsage struct {
+  Total int
+  Nodes map[NodeID]int
+}
+
+// Approximate the memory used by a node from the serialized size of it's extensions and signal queue,
+// and the capacity of it's inbox. Must be called from the goroutine running the node.
+func NodeSize(ctx *Context, node *Node) (int, error) {
+  total := int(unsafe.Sizeof(*node))
+
+  queue_size, err := SerializedSize(ctx, reflect.ValueOf(node.SignalQueue))
+  if err != nil {
+    return 0, err
+  }
+  total += queue_size
+
+  total += ctx.NodeTypes[node.Type].Inbox.Capacity * int(unsafe.Sizeof(Message{}))
+
+  for ext_type, ext := range(node.Extensions) {
+    ext_value := reflect.ValueOf(ext).Elem()
+    total += int(ext_value.Type().Size())
+    for _, field_info := range(ctx.Extensions[ext_type].Fields) {
+      field_size, err := SerializedSize(ctx, fieldOrZero(ext_value, field_info.Index))
+      if err != nil {
+        return 0, err
+      }
+      total += field_size
+    }
+  }
+
+  return total, nil
+}
+
+// Update the node's size if the context is tracking memory
+func (node *Node) updateSize(ctx *Context) {
+  if ctx.TrackMemory == false {
+    return
+  }
+
+  size, err := NodeSize(ctx, node)
+  if err != nil {
+    ctx.Log.Logf("memory", "Failed to get size of %s: %s", node.ID, err)
+  } else {
+    node.memorySize.Store(int64(size))
+  }
+}
+
+// Return the approximate memory usage of each loaded node, only populated when TrackMemory is set
+func (ctx *Context) MemoryUsage() MemoryUsage {
+  ctx.nodesLock.Lock()
+  defer ctx.nodesLock.Unlock()
+
+  usage := MemoryUsage{
+    Nodes: map[NodeID]int{},
+  }
+  for id, node := range(ctx.nodes) {
+    size := int(node.Node.memorySize.Load())
+    usage.Nodes[id] = size
+    usage.Total += size
+  }
+  return usage
+}
+
+// Unload the least recently active nodes until the loaded nodes fit in ctx.MemoryLimit.
+// Nodes in keep are never unloaded, and callers must include the node they're running on(if any) so it doesn't wait on itself to stop.
+// If another eviction is already running this returns immediately, so two nodes can't wait on each other to stop.
+// Must be called without ctx.nodesLock held, since the nodes being stopped may be waiting on it to send a signal.
+func (ctx *Context) evictColdNodes(keep ...NodeID) {
+  // Evicted nodes couldn't be loaded again without a DB, or until the DB has their queued writes
+  if ctx.TrackMemory == false || ctx.MemoryLimit <= 0 || ctx.persistent() == false || ctx.dbDegraded() {
+    return
+  }
+
+  if ctx.evictLock.TryLock() == false {
+    return
+  }
+  defer ctx.evictLock.Unlock()
+
+  total := 0
+  candidates := []*Node{}
+  ctx.nodesLock.RLock()
+  for id, node := range(ctx.nodes) {
+    total += int(node.Node.memorySize.Load())
+    // Frozen nodes would lose the signals they're holding, and nodes processing or waiting to process a signal aren't cold
+    if slices.Contains(keep, id) == false && node.Node.frozen.Load() == false && node.Node.processing.Load() == nil && node.Node.queued.Load() == 0 {
+      candidates = append(candidates, node.Node)
+    }
+  }
+  ctx.nodesLock.RUnlock()
+
+  if total <= ctx.MemoryLimit {
+    return
+  }
+
+  slices.SortFunc(candidates, func(a, b *Node) int {
+    return int(a.lastActive.Load() - b.lastActive.Load())
+  })
+
+  for _, node := range(candidates) {
+    if total <= ctx.MemoryLimit {
+      break
+    }
+
+    // The node may have started processing a signal since it was collected
+    if node.processing.CompareAndSwap(nil, evictingSignal) == false {
+      continue
+    }
+
+    size := int(node.memorySize.Load())
+    err := ctx.evictNode(node)
+    node.processing.CompareAndSwap(evictingSignal, nil)
+    if err != nil {
+      ctx.Log.Logf("memory", "Failed to evict %s: %s", node.ID, err)
+    } else {
+      ctx.Log.Logf("memory", "Evicted %s(%d bytes) to get under limit %d", node.ID, size, ctx.MemoryLimit)
+      total -= size
+    }
+  }
+}
+
+// Marks a node that's being evicted in it's processing field, so another eviction or the watchdog doesn't treat it as idle.
+// A signal the node takes before it's stopped replaces the mark, and stopping the node waits for it.
+var evictingSignal = &processingSignal{}
+
+// Stop the node without the nodes lock held, then write it and remove it from the context
+func (ctx *Context) evictNode(node *Node) error {
+  ctx.nodesLock.RLock()
+  context_node, loaded := ctx.nodes[node.ID]
+  ctx.nodesLock.RUnlock()
+  if loaded == false || context_node.Node != node {
+    return fmt.Errorf("%s is not loaded", node.ID)
+  }
+
+  err := ctx.stopNode(context_node)
+  if err != nil {
+    return err
+  }
+
+  ctx.nodesLock.Lock()
+  defer ctx.nodesLock.Unlock()
+
+  // Messages delivered while the node was stopping would be lost if it was unloaded, so start it again to process them
+  if node.queued.Load() > 0 {
+    restarted, err := ctx.startNode(node)
+    if err == nil {
+      ctx.nodes[node.ID] = restarted
+      return fmt.Errorf("%s was sent messages while stopping, keeping it loaded", node.ID)
+    }
+    ctx.Log.Logf("memory", "Failed to restart %s with queued messages: %s", node.ID, err)
+  }
+
+  return ctx.writeStoppedNode(context_node)
+}
+
+// Record that the node sent or received a signal, so it isn't evicted before colder nodes
+func (node *Node) touch() {
+  node.lastActive.Store(time.Now().UnixNano())
+}
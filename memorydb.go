@@ -0,0 +1,180 @@
+package graphvent
+
+import (
+  "errors"
+  "fmt"
+  "reflect"
+  "sync"
+
+  "github.com/google/uuid"
+)
+
+var NoPersistenceError = errors.New("Context has no persistent DB")
+
+// A Database that doesn't persist anything, for using graphvent as an in-process coordination library.
+// Node writes are dropped and LoadNode never finds a node, so nodes only exist while they're loaded in the context.
+// Aliases and node counts are kept in memory since they're checked while nodes are running,
+// everything that reads back persisted state fails with NoPersistenceError.
+type MemoryDB struct {
+  lock sync.Mutex
+  aliases map[string]NodeID
+  counts map[NodeType]int
+  decisions map[uuid.UUID]bool
+}
+
+func NewMemoryDB() *MemoryDB {
+  return &MemoryDB{
+    aliases: map[string]NodeID{},
+    counts: map[NodeType]int{},
+    decisions: map[uuid.UUID]bool{},
+  }
+}
+
+// Whether nodes can be unloaded and loaded again later
+func (ctx *Context) persistent() bool {
+  _, in_memory := ctx.DB.(*MemoryDB)
+  return in_memory == false
+}
+
+func (db *MemoryDB) WriteNodeInit(ctx *Context, node *Node) error {
+  if node == nil {
+    return fmt.Errorf("Cannot serialize nil *Node")
+  }
+  return nil
+}
+
+func (db *MemoryDB) WriteNodeChanges(ctx *Context, node *Node, changes Changes) error {
+  return nil
+}
+
+func (db *MemoryDB) LoadNode(ctx *Context, id NodeID) (*Node, error) {
+  return nil, fmt.Errorf("%s is not loaded: %w", id, NodeNotFoundError)
+}
+
+// Every node in a MemoryDB is loaded, so there's nothing to write an extension to
+func (db *MemoryDB) WriteExtension(ctx *Context, id NodeID, ext Extension) error {
+  return fmt.Errorf("Cannot write %s to %s: %w", ExtTypeOf(reflect.TypeOf(ext)), id, NodeLoadedError)
+}
+
+func (db *MemoryDB) LoadExtension(ctx *Context, id NodeID, ext_type ExtType) (Extension, error) {
+  return nil, fmt.Errorf("Cannot load %s of %s: %w", ext_type, id, NoPersistenceError)
+}
+
+func (db *MemoryDB) WriteNodeVersion(ctx *Context, id NodeID, version NodeVersion, keep int) error {
+  return fmt.Errorf("Cannot write history of %s: %w", id, NoPersistenceError)
+}
+
+func (db *MemoryDB) LoadNodeHistory(ctx *Context, id NodeID) ([]NodeVersion, error) {
+  return nil, fmt.Errorf("Cannot load history of %s: %w", id, NoPersistenceError)
+}
+
+func (db *MemoryDB) CountNode(ctx *Context, node_type NodeType, limit int) error {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  count := db.counts[node_type]
+  if limit > 0 && count >= limit {
+    return fmt.Errorf("Cannot create more than %d %s nodes: %w", limit, node_type, QuotaExceededError)
+  }
+  db.counts[node_type] = count + 1
+  return nil
+}
+
+func (db *MemoryDB) UncountNode(ctx *Context, node_type NodeType) error {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  if db.counts[node_type] > 0 {
+    db.counts[node_type] -= 1
+  }
+  return nil
+}
+
+func (db *MemoryDB) WriteAlias(ctx *Context, alias string, id NodeID) error {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  existing, assigned := db.aliases[alias]
+  if assigned && existing != id {
+    return fmt.Errorf("%s is assigned to %s: %w", alias, existing, AliasTakenError)
+  }
+  db.aliases[alias] = id
+  return nil
+}
+
+func (db *MemoryDB) RemoveAlias(ctx *Context, alias string) (NodeID, error) {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  id, assigned := db.aliases[alias]
+  if assigned == false {
+    return ZeroID, fmt.Errorf("%s: %w", alias, AliasNotFoundError)
+  }
+  delete(db.aliases, alias)
+  return id, nil
+}
+
+func (db *MemoryDB) LoadAlias(ctx *Context, alias string) (NodeID, error) {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  id, assigned := db.aliases[alias]
+  if assigned == false {
+    return ZeroID, fmt.Errorf("%s: %w", alias, AliasNotFoundError)
+  }
+  return id, nil
+}
+
+func (db *MemoryDB) WriteTxDecision(ctx *Context, tx_id uuid.UUID, commit bool) (bool, error) {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  decision, decided := db.decisions[tx_id]
+  if decided {
+    return decision, nil
+  }
+  db.decisions[tx_id] = commit
+  return commit, nil
+}
+
+func (db *MemoryDB) RemoveTxDecision(ctx *Context, tx_id uuid.UUID) error {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  delete(db.decisions, tx_id)
+  return nil
+}
+
+// Every node is loaded, so the context's reference index already has every reference
+func (db *MemoryDB) LoadReferences(ctx *Context, id NodeID) ([]Reference, error) {
+  return []Reference{}, nil
+}
+
+// Nothing is loaded when the context starts, so there's nothing to auto-load
+func (db *MemoryDB) WriteAutoLoad(ctx *Context, id NodeID, enabled bool) error {
+  return nil
+}
+
+func (db *MemoryDB) LoadAutoLoad(ctx *Context) ([]NodeID, error) {
+  return []NodeID{}, nil
+}
+
+func (db *MemoryDB) LoadNodeIDs(ctx *Context) ([]NodeID, error) {
+  return nil, fmt.Errorf("Cannot list nodes: %w", NoPersistenceError)
+}
+
+// Only node counts are known, since nothing else is written
+func (db *MemoryDB) LoadStats(ctx *Context) (DBStats, error) {
+  db.lock.Lock()
+  defer db.lock.Unlock()
+
+  stats := DBStats{
+    Nodes: map[NodeType]int{},
+    Extensions: map[ExtType]int{},
+    Edges: map[ExtType]map[Tag]int{},
+  }
+  for node_type, count := range(db.counts) {
+    stats.Nodes[node_type] = count
+  }
+  return stats, nil
+}
@@ -1,8 +1,79 @@
 package graphvent
 
+import (
+  "encoding/binary"
+  "fmt"
+  "reflect"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+//go:generate go run ./cmd/gvgen -type Message
+
+type InboxStrategy uint8
+const (
+  // Queue grows to fit every message sent to the node
+  InboxGrow = InboxStrategy(0)
+  // Senders block until there's space in the queue or the deadline passes, then the message is dropped
+  InboxBlock = InboxStrategy(1)
+  // Messages sent to a full queue are dropped immediately
+  InboxDrop = InboxStrategy(2)
+  // Messages sent to a full queue are written to the DB until there's space.
+  // Not supported yet, Validate rejects it.
+  InboxSpill = InboxStrategy(3)
+)
+
+// InboxConfig controls how a node type's message queue handles messages it doesn't have space for.
+// Dropped messages are nacked by sending an ErrorSignal back to the sender.
+// With a Scheduler, an InboxBlock sender that's running on a worker holds that worker until there's space or the deadline passes.
+type InboxConfig struct {
+  Strategy InboxStrategy
+  // Initial size for InboxGrow, maximum size otherwise
+  Capacity int
+  // How long to wait for space with InboxBlock
+  Deadline time.Duration
+}
+
+var DefaultInboxConfig = InboxConfig{
+  Strategy: InboxGrow,
+  Capacity: NODE_INITIAL_QUEUE_SIZE,
+}
+
+func (config InboxConfig) Validate() error {
+  if config.Capacity <= 0 {
+    return fmt.Errorf("Inbox capacity must be positive, got %d", config.Capacity)
+  }
+
+  switch config.Strategy {
+  case InboxGrow:
+  case InboxDrop:
+  case InboxBlock:
+    if config.Deadline <= 0 {
+      return fmt.Errorf("InboxBlock needs a positive deadline, got %s", config.Deadline)
+    }
+  case InboxSpill:
+    return fmt.Errorf("InboxSpill is not supported")
+  default:
+    return fmt.Errorf("Unknown inbox strategy %d", config.Strategy)
+  }
+
+  return nil
+}
+
+// Create the channels for a node inbox with the given config
+func NewInbox(config InboxConfig) (chan<- Message, <-chan Message) {
+  if config.Strategy == InboxGrow {
+    return NewMessageQueue(config.Capacity)
+  } else {
+    inbox := make(chan Message, config.Capacity)
+    return inbox, inbox
+  }
+}
+
 type Message struct {
-  Node NodeID
-  Signal Signal
+  Node NodeID `gv:"node"`
+  Signal Signal `gv:"signal"`
 }
 
 type MessageQueue struct {
@@ -66,3 +137,137 @@ func NewMessageQueue(initial int) (chan<- Message, <-chan Message) {
 
   return in, out
 }
+
+const (
+  MESSAGE_HEADER_SIZE = 16 + 8 + 16 + 1 + 16
+  messageFlagResponse = byte(0x01)
+)
+
+// MessageHeader is written in front of a serialized Message so routing and rejection
+// decisions can be made without decoding the signal
+type MessageHeader struct {
+  Node NodeID
+  SignalType SignalType
+  ID uuid.UUID
+  // Set if the signal is a ResponseSignal, in which case ReqID is the request it responds to
+  Response bool
+  ReqID uuid.UUID
+}
+
+func signalTypeOf(signal Signal) SignalType {
+  t := reflect.TypeOf(signal)
+  if t.Kind() == reflect.Pointer {
+    t = t.Elem()
+  }
+  return SignalType(SerializeType(t))
+}
+
+// Write the header followed by the serialized signal to data
+func SerializeMessage(ctx *Context, msg Message, data []byte) (int, error) {
+  if msg.Signal == nil {
+    return 0, fmt.Errorf("Cannot serialize message with nil signal")
+  } else if len(data) < MESSAGE_HEADER_SIZE {
+    return 0, fmt.Errorf("Not enough space for message header(got %d, want %d)", len(data), MESSAGE_HEADER_SIZE)
+  }
+
+  writeMessageHeader(msg, data)
+
+  written, err := SerializeValue(ctx, reflect.ValueOf(&msg.Signal).Elem(), data[MESSAGE_HEADER_SIZE:])
+  if err != nil {
+    return 0, err
+  }
+
+  return MESSAGE_HEADER_SIZE + written, nil
+}
+
+func writeMessageHeader(msg Message, data []byte) {
+  copy(data[0:16], msg.Node[:])
+  binary.BigEndian.PutUint64(data[16:24], uint64(signalTypeOf(msg.Signal)))
+  id := msg.Signal.ID()
+  copy(data[24:40], id[:])
+
+  response, is_response := msg.Signal.(ResponseSignal)
+  if is_response {
+    data[40] = messageFlagResponse
+    req_id := response.ResponseID()
+    copy(data[41:57], req_id[:])
+  } else {
+    data[40] = 0x00
+    copy(data[41:57], ZeroUUID[:])
+  }
+}
+
+// Write the header followed by the signal in the format. The signal's type is only in the header, so the body
+// is just the signal's struct, which lets clients of other formats decode it without the type stack.
+func EncodeMessage(ctx *Context, format SerializationFormat, msg Message) ([]byte, error) {
+  if msg.Signal == nil {
+    return nil, fmt.Errorf("Cannot serialize message with nil signal")
+  }
+
+  body, err := format.Encode(ctx, reflect.ValueOf(msg.Signal))
+  if err != nil {
+    return nil, err
+  }
+
+  data := make([]byte, MESSAGE_HEADER_SIZE, MESSAGE_HEADER_SIZE + len(body))
+  writeMessageHeader(msg, data)
+  return append(data, body...), nil
+}
+
+// Decode the signal of a message written by EncodeMessage using the signal type from its header
+func DecodeMessage(ctx *Context, format SerializationFormat, header MessageHeader, body []byte) (Message, error) {
+  info, registered := ctx.TypesReverse[SerializedType(header.SignalType)]
+  if registered == false {
+    return Message{}, fmt.Errorf("Unknown signal type %s", header.SignalType)
+  }
+
+  value, err := format.Decode(ctx, body, reflect.PointerTo(info.Reflect))
+  if err != nil {
+    return Message{}, err
+  }
+
+  signal, is_signal := value.Interface().(Signal)
+  if is_signal == false {
+    return Message{}, fmt.Errorf("%s is not a signal", info.Reflect)
+  } else if signal.ID() != header.ID {
+    return Message{}, fmt.Errorf("Signal ID %s does not match header ID %s", signal.ID(), header.ID)
+  }
+
+  return Message{header.Node, signal}, nil
+}
+
+// Read the header of a serialized message, returning the header and the serialized signal
+func ParseMessageHeader(data []byte) (MessageHeader, []byte, error) {
+  if len(data) < MESSAGE_HEADER_SIZE {
+    return MessageHeader{}, nil, fmt.Errorf("Not enough bytes to decode message header(got %d, want %d)", len(data), MESSAGE_HEADER_SIZE)
+  }
+
+  header := MessageHeader{
+    SignalType: SignalType(binary.BigEndian.Uint64(data[16:24])),
+    Response: data[40] & messageFlagResponse != 0,
+  }
+  copy(header.Node[:], data[0:16])
+  copy(header.ID[:], data[24:40])
+  copy(header.ReqID[:], data[41:57])
+
+  return header, data[MESSAGE_HEADER_SIZE:], nil
+}
+
+// Decode the signal of a message whose header has already been parsed
+func DeserializeMessage(ctx *Context, header MessageHeader, body []byte) (Message, error) {
+  value, left, err := DeserializeValue(ctx, body, reflect.TypeFor[Signal]())
+  if err != nil {
+    return Message{}, err
+  } else if len(left) != 0 {
+    return Message{}, fmt.Errorf("%d bytes left after deserializing signal", len(left))
+  }
+
+  signal := value.Interface().(Signal)
+  if signal.ID() != header.ID {
+    return Message{}, fmt.Errorf("Signal ID %s does not match header ID %s", signal.ID(), header.ID)
+  } else if signalTypeOf(signal) != header.SignalType {
+    return Message{}, fmt.Errorf("Signal type %s does not match header type %s", signalTypeOf(signal), header.SignalType)
+  }
+
+  return Message{header.Node, signal}, nil
+}
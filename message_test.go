@@ -2,7 +2,12 @@ package graphvent
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func sendBatch(start, end uint64, in chan<- Message) {
@@ -33,3 +38,307 @@ func TestMessageQueue(t *testing.T) {
 
   t.Logf("Processed 1M signals through queue")
 }
+
+func TestInboxDrop(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  fatalErr(t, RegisterNodeType(ctx, "DropNode", map[string]FieldMapping{}))
+  fatalErr(t, SetInboxConfig(ctx, "DropNode", InboxConfig{
+    Strategy: InboxDrop,
+    Capacity: 1,
+  }))
+
+  target, err := ctx.NewNode(nil, "DropNode", NewListenerExt(10))
+  fatalErr(t, err)
+
+  source_listener := NewListenerExt(10)
+  source, err := ctx.NewNode(nil, "Node", source_listener)
+  fatalErr(t, err)
+
+  // Pause the target so the first signal stays in it's inbox
+  target_node := ctx.nodes[target.ID]
+  target_node.Command <- "pause"
+  <-target_node.Status
+
+  first := NewLockSignal()
+  second := NewLockSignal()
+  fatalErr(t, ctx.Send(source, []Message{{target.ID, first}, {target.ID, second}}))
+
+  _, err = WaitForSignal(source_listener.Chan, 100*time.Millisecond, func(sig *ErrorSignal) bool {
+    return sig.ReqID == second.ID() && sig.Error == "inbox_full"
+  })
+  fatalErr(t, err)
+
+  target_node.Command <- "resume"
+  <-target_node.Status
+}
+
+func TestWatchdog(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "watchdog"})
+
+  fatalErr(t, RegisterNodeType(ctx, "DropNode", map[string]FieldMapping{}))
+  fatalErr(t, SetInboxConfig(ctx, "DropNode", InboxConfig{
+    Strategy: InboxDrop,
+    Capacity: 1,
+  }))
+
+  target_listener := NewListenerExt(10)
+  target, err := ctx.NewNode(nil, "DropNode", target_listener)
+  fatalErr(t, err)
+  source, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+  alert_listener := NewListenerExt(10)
+  alerts, err := ctx.NewNode(nil, "Node", alert_listener)
+  fatalErr(t, err)
+
+  watchdog, err := NewWatchdog(ctx, WatchdogConfig{
+    Interval: time.Hour,
+    MaxAge: time.Hour,
+    Target: alerts.ID,
+  })
+  fatalErr(t, err)
+  defer watchdog.Stop()
+  ctx.Watchdog = watchdog
+
+  target_node := ctx.nodes[target.ID]
+  target_node.Command <- "pause"
+  <-target_node.Status
+
+  fatalErr(t, ctx.Send(source, []Message{{target.ID, NewLockSignal()}}))
+  watchdog.Check()
+
+  alert, err := WaitForSignal(alert_listener.Chan, 100*time.Millisecond, func(sig *WatchdogAlertSignal) bool {
+    return sig.Node == target.ID
+  })
+  fatalErr(t, err)
+  if alert.Depth != 1 || alert.Full == false {
+    t.Fatalf("Wrong alert for full inbox: %s", alert)
+  }
+
+  recorder := httptest.NewRecorder()
+  HealthHandler(ctx)(recorder, httptest.NewRequest("GET", "/health", nil))
+  if recorder.Code != http.StatusServiceUnavailable {
+    t.Fatalf("Expected 503 from /health with a stuck node, got %d", recorder.Code)
+  }
+
+  target_node.Command <- "resume"
+  <-target_node.Status
+
+  _, err = WaitForSignal(target_listener.Chan, 100*time.Millisecond, func(sig *LockSignal) bool {
+    return true
+  })
+  fatalErr(t, err)
+  watchdog.Check()
+  if stuck := watchdog.Stuck(); len(stuck) != 0 {
+    t.Fatalf("Node still stuck after resuming: %+v", stuck)
+  }
+}
+
+func TestSendAsync(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  fatalErr(t, RegisterNodeType(ctx, "DropNode", map[string]FieldMapping{}))
+  fatalErr(t, SetInboxConfig(ctx, "DropNode", InboxConfig{
+    Strategy: InboxDrop,
+    Capacity: 1,
+  }))
+
+  target, err := ctx.NewNode(nil, "DropNode", NewListenerExt(10))
+  fatalErr(t, err)
+  other_listener := NewListenerExt(10)
+  other, err := ctx.NewNode(nil, "Node", other_listener)
+  fatalErr(t, err)
+  source, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  target_node := ctx.nodes[target.ID]
+  target_node.Command <- "pause"
+  <-target_node.Status
+
+  first := NewLockSignal()
+  second := NewLockSignal()
+  other_signal := NewLockSignal()
+  missing := RandID()
+  results := ctx.SendAsync(source, []Message{{target.ID, first}, {target.ID, second}, {other.ID, other_signal}, {missing, NewLockSignal()}})
+
+  statuses := map[NodeID][]SendStatus{}
+  for result := range(results) {
+    statuses[result.Node] = append(statuses[result.Node], result.Status)
+  }
+
+  if len(statuses[target.ID]) != 2 || statuses[target.ID][0] != SendDelivered || statuses[target.ID][1] != SendOverflowed {
+    t.Fatalf("Wrong results for full target: %+v", statuses[target.ID])
+  } else if len(statuses[other.ID]) != 1 || statuses[other.ID][0] != SendDelivered {
+    t.Fatalf("Wrong results for other target: %+v", statuses[other.ID])
+  } else if len(statuses[missing]) != 1 || statuses[missing][0] != SendFailed {
+    t.Fatalf("Wrong results for missing target: %+v", statuses[missing])
+  }
+
+  target_node.Command <- "resume"
+  <-target_node.Status
+}
+
+func TestMessageHeader(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  buffer := [1024]byte{}
+  msg := Message{RandID(), NewStatusSignal(RandID(), []string{"field"}, Changes{{ExtTypeFor[LockableExt](), "state", ChangeSet}}, nil)}
+  written, err := SerializeMessage(ctx, msg, buffer[:])
+  fatalErr(t, err)
+
+  header, body, err := ParseMessageHeader(buffer[:written])
+  fatalErr(t, err)
+
+  if header.Node != msg.Node || header.ID != msg.Signal.ID() || header.Response {
+    t.Fatalf("Parsed header %+v doesn't match message %+v", header, msg)
+  } else if header.SignalType != SignalTypeFor[StatusSignal]() {
+    t.Fatalf("Parsed signal type %s, expected %s", header.SignalType, SignalTypeFor[StatusSignal]())
+  }
+
+  deserialized, err := DeserializeMessage(ctx, header, body)
+  fatalErr(t, err)
+
+  status, ok := deserialized.Signal.(*StatusSignal)
+  if ok == false {
+    t.Fatalf("Deserialized %+v instead of *StatusSignal", deserialized.Signal)
+  } else if status.Source != msg.Signal.(*StatusSignal).Source {
+    t.Fatalf("Deserialized %+v doesn't match %+v", status, msg.Signal)
+  }
+}
+
+func TestNotifications(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  mappings, err := TagMappings(ctx, ExtTypeFor[LocaleExt]())
+  fatalErr(t, err)
+  fatalErr(t, RegisterNodeType(ctx, "NotifyingNode", mappings))
+
+  webhook := make(chan NotificationPayload, 1)
+  // Each webhook returns once it's released, the first one straight away
+  release := make(chan struct{}, 1)
+  release <- struct{}{}
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    var payload NotificationPayload
+    err := json.NewDecoder(r.Body).Decode(&payload)
+    if err != nil {
+      t.Error(err)
+    }
+    webhook <- payload
+    <-release
+  }))
+  defer server.Close()
+
+  locale, err := NewLocaleExt("UTC", "en-US")
+  fatalErr(t, err)
+  notification_ext := NewNotificationExt()
+  notification_ext.WebhookHosts = []string{server.Listener.Addr().String()}
+  team, err := ctx.NewNode(nil, "NotifyingNode", locale, notification_ext)
+  fatalErr(t, err)
+
+  member_listener := NewListenerExt(10)
+  member, err := ctx.NewNode(nil, "Node", member_listener)
+  fatalErr(t, err)
+  hook_listener := NewListenerExt(10)
+  hook_member, err := ctx.NewNode(nil, "Node", hook_listener)
+  fatalErr(t, err)
+  locale_listener := NewListenerExt(10)
+  locale_member, err := ctx.NewNode(nil, "Node", locale_listener)
+  fatalErr(t, err)
+
+  subscribe := func(node *Node, listener *ListenerExt, preference NotificationPreference) {
+    signal := NewNotificationPreferenceSignal(preference)
+    fatalErr(t, ctx.Send(node, []Message{{team.ID, signal}}))
+    response, _, err := WaitForResponse(listener.Chan, 100*time.Millisecond, signal.ID())
+    fatalErr(t, err)
+    if _, ok := response.(*SuccessSignal); ok == false {
+      t.Fatalf("Failed to set notification preference %+v: %s", preference, response)
+    }
+  }
+  subscribe(member, member_listener, NotificationPreference{Channel: NotifySignal})
+  subscribe(hook_member, hook_listener, NotificationPreference{Channel: NotifyWebhook, URL: server.URL})
+  subscribe(locale_member, locale_listener, NotificationPreference{Channel: NotifySignal, Fields: []string{"Locale"}})
+
+  missing_url := NewNotificationPreferenceSignal(NotificationPreference{Channel: NotifyWebhook})
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, missing_url}}))
+  response, _, err := WaitForResponse(member_listener.Chan, 100*time.Millisecond, missing_url.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || error_signal.Error != "missing_url" {
+    t.Fatalf("Expected missing_url error, got %s", response)
+  }
+
+  internal_url := NewNotificationPreferenceSignal(NotificationPreference{Channel: NotifyWebhook, URL: "http://169.254.169.254/latest/meta-data"})
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, internal_url}}))
+  response, _, err = WaitForResponse(member_listener.Chan, 100*time.Millisecond, internal_url.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || strings.HasPrefix(error_signal.Error, "invalid_url") == false {
+    t.Fatalf("Expected invalid_url error, got %s", response)
+  }
+
+  // Without an allowlist any host can be set, but addresses inside the network are refused when they're dialed
+  open_ext := NewNotificationExt()
+  if open_ext.checkWebhookURL("file:///etc/passwd") == nil {
+    t.Fatal("Accepted a webhook URL that isn't http")
+  }
+  fatalErr(t, open_ext.checkWebhookURL(server.URL))
+  err = notifyWebhook(open_ext.webhookClient(), server.URL, NotificationPayload{})
+  if err == nil || strings.Contains(err.Error(), "not public") == false {
+    t.Fatalf("Sent a webhook to a loopback address: %s", err)
+  }
+
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, NewLocaleSignal("Europe/Paris", "")}}))
+
+  notification, err := WaitForSignal(member_listener.Chan, 100*time.Millisecond, func(sig *NotificationSignal) bool {
+    return sig.Node == team.ID
+  })
+  fatalErr(t, err)
+  if len(notification.Fields) != 1 || notification.Fields[0] != "Timezone" {
+    t.Fatalf("Wrong fields in notification: %+v", notification.Fields)
+  }
+
+  select {
+  case payload := <-webhook:
+    if payload.Node != team.ID || len(payload.Fields) != 1 {
+      t.Fatalf("Wrong webhook payload: %+v", payload)
+    }
+  case <-time.After(time.Second):
+    t.Fatal("Webhook wasn't called")
+  }
+
+  _, err = WaitForSignal(locale_listener.Chan, 20*time.Millisecond, func(sig *NotificationSignal) bool {
+    return true
+  })
+  if err == nil {
+    t.Fatal("Member notified about a field it didn't ask for")
+  }
+
+  // Wait for the webhook result to be recorded before writing the node
+  time.Sleep(50*time.Millisecond)
+  fatalErr(t, ctx.unloadNode(team.ID))
+  notifications, err := LoadExt[NotificationExt](ctx, team.ID)
+  fatalErr(t, err)
+  if notifications.Deliveries[member.ID].Sent != 1 || notifications.Deliveries[hook_member.ID].Sent != 1 || notifications.Deliveries[locale_member.ID].Sent != 0 {
+    t.Fatalf("Wrong deliveries: %+v", notifications.Deliveries)
+  }
+
+  // A webhook that finishes after the node is unloaded doesn't load it again
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, NewLocaleSignal("Europe/Berlin", "")}}))
+  select {
+  case <-webhook:
+  case <-time.After(time.Second):
+    t.Fatal("Webhook wasn't called")
+  }
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(team.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+  close(release)
+
+  time.Sleep(50*time.Millisecond)
+  ctx.nodesLock.RLock()
+  _, loaded := ctx.nodes[team.ID]
+  ctx.nodesLock.RUnlock()
+  if loaded {
+    t.Fatal("Webhook result loaded the node again after it was unloaded")
+  }
+}
@@ -0,0 +1,54 @@
+package graphvent
+
+import (
+  "reflect"
+  "time"
+)
+
+// Name to read a node's SignalMetrics with a ReadSignal, as a map[string]SignalMetrics keyed by signal type
+const SIGNAL_METRICS_FIELD = "SignalMetrics"
+
+// Number of signals of a type a node has processed since it was loaded, and how long it took to process them
+type SignalMetrics struct {
+  Count uint64
+  Total time.Duration
+  Max time.Duration
+}
+
+// Average time taken to process a signal
+func (metrics SignalMetrics) Mean() time.Duration {
+  if metrics.Count == 0 {
+    return 0
+  }
+  return metrics.Total / time.Duration(metrics.Count)
+}
+
+// Receives the processing time of every signal when set as Context.Metrics, called from the processing node's goroutine
+type MetricsSink interface {
+  RecordSignal(node NodeID, node_type NodeType, signal_type string, latency time.Duration)
+}
+
+func signalTypeName(signal Signal) string {
+  signal_type := reflect.TypeOf(signal)
+  if signal_type.Kind() == reflect.Pointer {
+    signal_type = signal_type.Elem()
+  }
+  return signal_type.Name()
+}
+
+// Add the time taken to process signal to the node's metrics and the context's sink
+func (node *Node) recordSignal(ctx *Context, signal Signal, latency time.Duration) {
+  signal_type := signalTypeName(signal)
+
+  metrics := node.signalMetrics[signal_type]
+  metrics.Count += 1
+  metrics.Total += latency
+  if latency > metrics.Max {
+    metrics.Max = latency
+  }
+  node.signalMetrics[signal_type] = metrics
+
+  if ctx.Metrics != nil {
+    ctx.Metrics.RecordSignal(node.ID, node.Type, signal_type, latency)
+  }
+}
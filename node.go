@@ -3,8 +3,12 @@ package graphvent
 import (
 	"crypto/ed25519"
 	"crypto/sha512"
+	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -75,7 +79,9 @@ const NODE_INITIAL_QUEUE_SIZE = 2
 
 // Nodes represent a group of extensions that can be collectively addressed
 type Node struct {
+  // Nil in the node record when the context has a Keyring
   Key ed25519.PrivateKey `gv:"key"`
+  Public ed25519.PublicKey `gv:"public"`
   ID NodeID
   Type NodeType `gv:"type"`
 
@@ -93,15 +99,80 @@ type Node struct {
   writeSignalQueue bool
   SignalQueue []QueuedSignal
   NextSignal *QueuedSignal
+
+  // Order to process signals through extensions, computed on load
+  parallelExtensions []ExtType
+  serialExtensions []ExtType
+  // Order to load extensions in, unloaded in reverse
+  loadOrder []ExtType
+  // StatusSignal waiting in the signal queue for its coalescing window to end
+  pendingStatus *StatusSignal
+
+  // Messages written to the DB with the changes that produced them, cleared once they're sent
+  outbox []Message
+  writeOutbox bool
+  // Extensions attached since the node was last written, written in full with the extension list on the next write
+  attached []ExtType
+  // Extensions in the node's DB record that aren't registered in the context, kept in the extension list when it's rewritten
+  unknownExtensions []ExtType
+  // Set when the node is loaded with messages in it's outbox
+  resendOutbox bool
+
+  // Fields changed since the node was last written to the DB, so unloading it only writes those
+  unwritten Changes
+
+  // Fields changed since the node's references were last flushed to the context index
+  dirtyReferences map[Reference]bool
+  referencesFlushed time.Time
+
+  // Set when the node is run by a Scheduler instead of its own goroutine
+  inbox *nodeInbox
+
+  // Transaction the node is holding a signal for
+  prepared *preparedTx
+  // ID of the prepared signal being processed for a committed transaction, and the response it sent
+  committing uuid.UUID
+  commitResponse ResponseSignal
+  writePrepared bool
+
+  // Keyring entry to write with the node record when the keyring is in the node DB
+  initKey *keyRecord
+
+  // Set by a FreezeSignal, signals received while frozen are held until a ThawSignal
+  frozen atomic.Bool
+  held []Message
+
+  // Set once a full version of the node has been written to its history, later versions only have the changed fields
+  versionBase bool
+
+  // Processing metrics by signal type, reset when the node is loaded
+  signalMetrics map[string]SignalMetrics
+
+  // Messages delivered but not yet taken from the inbox, when the node last took one, and the signal it's processing.
+  // Read by the Watchdog to find stuck nodes.
+  queued atomic.Int64
+  progress atomic.Int64
+  processing atomic.Pointer[processingSignal]
+
+  // Used to pick nodes to evict when the context is over it's memory limit
+  lastActive atomic.Int64
+  memorySize atomic.Int64
 }
 
 func (node *Node) PostDeserialize(ctx *Context) error {
   node.Extensions = map[ExtType]Extension{}
 
-  public := node.Key.Public().(ed25519.PublicKey)
-  node.ID = KeyID(public)
+  // Records written before the public key was stored only have the private key
+  if node.Key != nil {
+    node.Public = node.Key.Public().(ed25519.PublicKey)
+  } else if len(node.Public) != ed25519.PublicKeySize {
+    return fmt.Errorf("Node record has neither a private or public key")
+  }
+  node.ID = KeyID(node.Public)
 
-  node.SendChan, node.RecvChan = NewMessageQueue(NODE_INITIAL_QUEUE_SIZE)
+  if ctx.Scheduler == nil {
+    node.SendChan, node.RecvChan = NewInbox(ctx.NodeTypes[node.Type].Inbox)
+  }
 
   return nil
 }
@@ -183,9 +254,13 @@ func (node *Node) ReadFields(ctx *Context, fields []string)map[string]any {
 
   for _, field_name := range(fields) {
     field_info, mapped := node_info.Fields[field_name]
-    if mapped {
+    if field_name == SIGNAL_METRICS_FIELD && mapped == false {
+      values[field_name] = maps.Clone(node.signalMetrics)
+    } else if mapped && field_info.Compute != nil {
+      values[field_name] = field_info.Compute(ctx, node, node.Extensions[field_info.Extension])
+    } else if mapped {
       ext := node.Extensions[field_info.Extension]
-      values[field_name] = reflect.ValueOf(ext).Elem().FieldByIndex(field_info.Index).Interface()
+      values[field_name] = fieldOrZero(reflect.ValueOf(ext).Elem(), field_info.Index).Interface()
     } else {
       values[field_name] = fmt.Errorf("NodeType %s has no field %s", node.Type, field_name)
     }
@@ -194,8 +269,8 @@ func (node *Node) ReadFields(ctx *Context, fields []string)map[string]any {
   return values
 }
 
-// Main Loop for nodes
-func nodeLoop(ctx *Context, node *Node, status chan string, control chan string) error {
+// Mark the node as active and load its extensions
+func (node *Node) load(ctx *Context) error {
   is_started := node.Active.CompareAndSwap(false, true)
   if is_started == false {
     return fmt.Errorf("%s is already started, will not start again", node.ID)
@@ -205,7 +280,21 @@ func nodeLoop(ctx *Context, node *Node, status chan string, control chan string)
 
   ctx.Log.Logf("node_ext", "Loading extensions for %s", node.ID)
 
-  for _, extension := range(node.Extensions) {
+  var err error
+  node.parallelExtensions, node.serialExtensions, err = ProcessOrder(ctx, node.Extensions)
+  if err != nil {
+    node.Active.Store(false)
+    return err
+  }
+
+  node.loadOrder, err = LoadOrder(ctx, node.Extensions)
+  if err != nil {
+    node.Active.Store(false)
+    return err
+  }
+
+  for _, ext_type := range(node.loadOrder) {
+    extension := node.Extensions[ext_type]
     ctx.Log.Logf("node_ext", "Loading extension %s for %s", reflect.TypeOf(extension), node.ID)
     err := extension.Load(ctx, node)
     if err != nil {
@@ -218,14 +307,127 @@ func nodeLoop(ctx *Context, node *Node, status chan string, control chan string)
   }
 
   ctx.Log.Logf("node_ext", "Loaded extensions for %s", node.ID)
+  node.signalMetrics = map[string]SignalMetrics{}
+  node.resendOutbox = len(node.outbox) != 0
+  node.touch()
+  node.updateSize(ctx)
+  return nil
+}
+
+// Mark the node as inactive and unload its extensions
+func (node *Node) unload(ctx *Context) {
+  stopped := node.Active.CompareAndSwap(true, false)
+  if stopped == false {
+    panic("BAD_STATE: stopping already stopped node")
+  }
+
+  node.flushReferences(ctx, true)
+
+  for i := len(node.loadOrder) - 1; i >= 0; i-- {
+    node.Extensions[node.loadOrder[i]].Unload(ctx, node)
+  }
+}
+
+// Remove node.NextSignal from the signal queue and return it to be processed
+func (node *Node) popNextSignal(ctx *Context) Signal {
+  signal := node.NextSignal.Signal
+
+  t := node.NextSignal.Time
+  i := -1
+  for j, queued := range(node.SignalQueue) {
+    if queued.Signal.ID() == node.NextSignal.Signal.ID() {
+      i = j
+      break
+    }
+  }
+  if i == -1 {
+    ctx.Log.Logf("node", "node.NextSignal not in node.SignalQueue, paniccing")
+    panic("node.NextSignal not in node.SignalQueue")
+  }
+  l := len(node.SignalQueue)
+  node.SignalQueue[i] = node.SignalQueue[l-1]
+  node.SignalQueue = node.SignalQueue[:(l-1)]
+
+  node.NextSignal, node.TimeoutChan = SoonestSignal(node.SignalQueue)
+  node.writeSignalQueue = true
+
+  if signal == Signal(node.pendingStatus) {
+    node.pendingStatus = nil
+  }
+
+  if node.NextSignal == nil {
+    ctx.Log.Logf("node", "NODE_TIMEOUT(%s) - PROCESSING %+v@%s - NEXT_SIGNAL nil@%+v", node.ID, signal, t, node.TimeoutChan)
+  } else {
+    ctx.Log.Logf("node", "NODE_TIMEOUT(%s) - PROCESSING %+v@%s - NEXT_SIGNAL: %s@%s", node.ID, signal, t, node.NextSignal, node.NextSignal.Time)
+  }
+
+  return signal
+}
+
+// Handle a single signal received by the node
+func (node *Node) handleSignal(ctx *Context, source NodeID, signal Signal) {
+  start := time.Now()
+  node.processing.Store(&processingSignal{signal, start})
+  defer func() {
+    node.processing.Store(nil)
+    node.recordSignal(ctx, signal, time.Since(start))
+  }()
+
+  // Clones copy the node as it is, even if it's frozen or waiting on a transaction
+  clone, is_clone := signal.(*cloneSignal)
+  if is_clone {
+    extensions, err := node.cloneExtensions(ctx, clone.skip)
+    clone.result <- cloneResult{extensions, err}
+    return
+  }
+
+  if node.handleFreezeSignal(ctx, source, signal) {
+    node.updateSize(ctx)
+    return
+  }
+
+  if node.handleTxSignal(ctx, source, signal) {
+    node.updateSize(ctx)
+    return
+  }
+
+  switch sig := signal.(type) {
+  case *IdempotentSignal:
+    node.handleIdempotentSignal(ctx, source, sig)
+
+  case *OutboxSignal:
+    node.handleOutboxSignal(ctx)
+
+  case *ReadSignal:
+    result := node.ReadFields(ctx, sig.Fields)
+    msgs := []Message{}
+    msgs = append(msgs, Message{source, NewReadResultSignal(sig.ID(), node.ID, node.Type, result)})
+    ctx.Send(node, msgs)
+
+  default:
+    err := node.Process(ctx, source, signal)
+    if err != nil {
+      ctx.Log.Logf("node", "%s process error %s", node.ID, err)
+      panic(err)
+    }
+  }
+
+  node.updateSize(ctx)
+}
+
+// Main Loop for nodes
+func nodeLoop(ctx *Context, node *Node, status chan string, control chan string) error {
+  err := node.load(ctx)
+  if err != nil {
+    status <- err.Error()
+    return err
+  }
 
   status <- "active"
 
+  var flush_timer <-chan time.Time
   running := true
   for running {
-    var signal Signal
-    var source NodeID
-
     select {
     case command := <-control:
       switch command {
@@ -244,79 +446,60 @@ func nodeLoop(ctx *Context, node *Node, status chan string, control chan string)
         ctx.Log.Logf("node", "Unknown control command %s", command)
       }
     case <-node.TimeoutChan:
-      signal = node.NextSignal.Signal
-      source = node.ID
-
-      t := node.NextSignal.Time
-      i := -1
-      for j, queued := range(node.SignalQueue) {
-        if queued.Signal.ID() == node.NextSignal.Signal.ID() {
-          i = j
-          break
-        }
-      }
-      if i == -1 {
-        ctx.Log.Logf("node", "node.NextSignal not in node.SignalQueue, paniccing")
-        panic("node.NextSignal not in node.SignalQueue")
-      }
-      l := len(node.SignalQueue)
-      node.SignalQueue[i] = node.SignalQueue[l-1]
-      node.SignalQueue = node.SignalQueue[:(l-1)]
-
-      node.NextSignal, node.TimeoutChan = SoonestSignal(node.SignalQueue)
-      node.writeSignalQueue = true
-
-      if node.NextSignal == nil {
-        ctx.Log.Logf("node", "NODE_TIMEOUT(%s) - PROCESSING %+v@%s - NEXT_SIGNAL nil@%+v", node.ID, signal, t, node.TimeoutChan)
-      } else {
-        ctx.Log.Logf("node", "NODE_TIMEOUT(%s) - PROCESSING %+v@%s - NEXT_SIGNAL: %s@%s", node.ID, signal, t, node.NextSignal, node.NextSignal.Time)
-      }
+      signal := node.popNextSignal(ctx)
+      node.handleSignal(ctx, node.ID, signal)
     case msg := <- node.RecvChan:
-      signal = msg.Signal
-      source = msg.Node
-
+      node.dequeued()
+      node.handleSignal(ctx, msg.Node, msg.Signal)
+    case <-flush_timer:
+      flush_timer = nil
     }
 
-    switch sig := signal.(type) {
-    case *ReadSignal:
-      result := node.ReadFields(ctx, sig.Fields)
-      msgs := []Message{}
-      msgs = append(msgs, Message{source, NewReadResultSignal(sig.ID(), node.ID, node.Type, result)})
-      ctx.Send(node, msgs)
-
-    default:
-      err := node.Process(ctx, source, signal)
-      if err != nil {
-        ctx.Log.Logf("node", "%s process error %s", node.ID, err)
-        panic(err)
+    if len(node.RecvChan) == 0 && flush_timer == nil {
+      wait := node.flushReferences(ctx, false)
+      if wait > 0 {
+        flush_timer = time.After(wait)
       }
     }
   }
 
-  stopped := node.Active.CompareAndSwap(true, false)
-  if stopped == false {
-    panic("BAD_STATE: stopping already stopped node")
-  }
-
-  for _, extension := range(node.Extensions) {
-    extension.Unload(ctx, node)
-  }
+  node.unload(ctx)
 
   status <- "stopped"
 
   return nil
 }
 
-func (node *Node) QueueChanges(ctx *Context, changes map[ExtType]Changes) error {
+// Serialize the current value of each node field
+func (node *Node) serializeFields(ctx *Context) (map[string]SerializedValue, error) {
+  values := map[string]SerializedValue{}
+  for field_name, field_info := range(ctx.NodeTypes[node.Type].Fields) {
+    ext, has_ext := node.Extensions[field_info.Extension]
+    // Computed fields aren't stored, so there's no version of them to keep
+    if has_ext == false || field_info.Compute != nil {
+      continue
+    }
+
+    value, err := SerializeAny(ctx, fieldOrZero(reflect.ValueOf(ext).Elem(), field_info.Index))
+    if err != nil {
+      return nil, fmt.Errorf("Failed to serialize %s on %s: %w", field_name, node.ID, err)
+    }
+    values[field_name] = value
+  }
+  return values, nil
+}
+
+// Queue a StatusSignal for the changes. If before is not nil the signal includes diffs from the values in it.
+func (node *Node) QueueChanges(ctx *Context, changes Changes, before map[string]SerializedValue) error {
   node_info, exists := ctx.NodeTypes[node.Type]
   if exists == false {
     return fmt.Errorf("Node type not in context, can't map changes to field names")
   } else {
     fields := []string{}
-    for ext_type, ext_changes := range(changes) {
+    for ext_type, ext_fields := range(changes.ByExtension()) {
       ext_map, ext_mapped := node_info.ReverseFields[ext_type]
       if ext_mapped {
-        for _, ext_tag := range(ext_changes) {
+        for _, ext_tag := range(ext_fields) {
           field_name, tag_mapped := ext_map[ext_tag]
           if tag_mapped {
             fields = append(fields, field_name)
@@ -326,22 +509,229 @@ func (node *Node) QueueChanges(ctx *Context, changes map[ExtType]Changes) error
     }
     ctx.Log.Logf("changes", "Changes to queue from %+v: %+v", node_info.ReverseFields, fields)
     if len(fields) > 0 {
-      node.QueueSignal(time.Time{}, NewStatusSignal(node.ID, fields))
+      var diffs []FieldDiff = nil
+      if before != nil {
+        after, err := node.serializeFields(ctx)
+        if err != nil {
+          return err
+        }
+
+        diffs = make([]FieldDiff, 0, len(fields))
+        for _, field_name := range(fields) {
+          diffs = append(diffs, FieldDiff{
+            Field: field_name,
+            Old: before[field_name],
+            New: after[field_name],
+          })
+        }
+      }
+      if node_info.StatusCoalesce <= 0 {
+        node.QueueSignal(time.Time{}, NewStatusSignal(node.ID, fields, changes, diffs))
+      } else if node.pendingStatus == nil {
+        node.pendingStatus = NewStatusSignal(node.ID, fields, changes, diffs)
+        node.QueueSignal(time.Now().Add(node_info.StatusCoalesce), node.pendingStatus)
+      } else {
+        node.pendingStatus.merge(fields, changes, diffs)
+        node.writeSignalQueue = true
+      }
     }
     return nil
   }
 }
 
+// Split the extensions into those that can be processed in parallel, and the rest sorted so that
+// each extension is processed after the extensions it declares with ProcessAfter.
+// Returns an error if the declared order contains a cycle.
+func ProcessOrder(ctx *Context, extensions map[ExtType]Extension) ([]ExtType, []ExtType, error) {
+  parallel := []ExtType{}
+  remaining := []ExtType{}
+  for ext_type := range(extensions) {
+    ext_info, exists := ctx.Extensions[ext_type]
+    if exists == false {
+      return nil, nil, fmt.Errorf("%s is not an extension in ctx", ext_type)
+    }
+
+    if ext_info.Parallel {
+      parallel = append(parallel, ext_type)
+    } else {
+      remaining = append(remaining, ext_type)
+    }
+  }
+
+  // Sort first so the order is stable between loads
+  slices.Sort(parallel)
+  slices.Sort(remaining)
+
+  serial := make([]ExtType, 0, len(remaining))
+  added := map[ExtType]bool{}
+  for len(remaining) > 0 {
+    next := []ExtType{}
+    for _, ext_type := range(remaining) {
+      ready := true
+      for _, after := range(ctx.Extensions[ext_type].ProcessAfter) {
+        _, on_node := extensions[after]
+        if on_node && added[after] == false {
+          ready = false
+          break
+        }
+      }
+
+      if ready {
+        serial = append(serial, ext_type)
+        added[ext_type] = true
+      } else {
+        next = append(next, ext_type)
+      }
+    }
+
+    if len(next) == len(remaining) {
+      return nil, nil, fmt.Errorf("Cycle in extension process order: %+v", next)
+    }
+    remaining = next
+  }
+
+  return parallel, serial, nil
+}
+
+// Sort the extensions so each is after the extensions it requires.
+// Returns an error if a required extension is missing or the requirements have a cycle.
+func LoadOrder(ctx *Context, extensions map[ExtType]Extension) ([]ExtType, error) {
+  remaining := make([]ExtType, 0, len(extensions))
+  for ext_type := range(extensions) {
+    ext_info, exists := ctx.Extensions[ext_type]
+    if exists == false {
+      return nil, fmt.Errorf("%s is not an extension in ctx", ext_type)
+    }
+
+    for _, required := range(ext_info.Requires) {
+      _, on_node := extensions[required]
+      if on_node == false {
+        return nil, fmt.Errorf("%s requires %s", ext_info.Type, required)
+      }
+    }
+    remaining = append(remaining, ext_type)
+  }
+
+  slices.Sort(remaining)
+
+  order := make([]ExtType, 0, len(remaining))
+  added := map[ExtType]bool{}
+  for len(remaining) > 0 {
+    next := []ExtType{}
+    for _, ext_type := range(remaining) {
+      ready := true
+      for _, required := range(ctx.Extensions[ext_type].Requires) {
+        if added[required] == false {
+          ready = false
+          break
+        }
+      }
+
+      if ready {
+        order = append(order, ext_type)
+        added[ext_type] = true
+      } else {
+        next = append(next, ext_type)
+      }
+    }
+
+    if len(next) == len(remaining) {
+      return nil, fmt.Errorf("Cycle in extension requirements: %+v", next)
+    }
+    remaining = next
+  }
+
+  return order, nil
+}
+
 func (node *Node) Process(ctx *Context, source NodeID, signal Signal) error {
   messages := []Message{}
-  changes := map[ExtType]Changes{}
-  for ext_type, ext := range(node.Extensions) {
-    ext_messages, ext_changes := ext.Process(ctx, node, source, signal)
+  changes := Changes{}
+
+  var before map[string]SerializedValue = nil
+  if ctx.NodeTypes[node.Type].StatusDiffs {
+    var err error
+    before, err = node.serializeFields(ctx)
+    if err != nil {
+      return err
+    }
+  }
+
+  // Field sizes are checked before anything else sees the changes, which needs the fields to restore if they're rejected
+  var field_snapshot map[ExtType]map[Tag][]byte = nil
+  if ctx.NodeTypes[node.Type].Quota.FieldSize > 0 {
+    var err error
+    field_snapshot, err = node.snapshotFields(ctx)
+    if err != nil {
+      return err
+    }
+  }
+
+  parallel_messages := make([][]Message, len(node.parallelExtensions))
+  parallel_changes := make([]Changes, len(node.parallelExtensions))
+  var parallel_done sync.WaitGroup
+  for i, ext_type := range(node.parallelExtensions) {
+    parallel_done.Add(1)
+    go func(i int, ext Extension) {
+      defer parallel_done.Done()
+      parallel_messages[i], parallel_changes[i] = ext.Process(ctx, node, source, signal)
+    }(i, node.Extensions[ext_type])
+  }
+
+  for _, ext_type := range(node.serialExtensions) {
+    ext_messages, ext_changes := node.Extensions[ext_type].Process(ctx, node, source, signal)
     if len(ext_messages) != 0 {
       messages = append(messages, ext_messages...)
     }
     if len(ext_changes) != 0 {
-      changes[ext_type] = ext_changes
+      changes = append(changes, ext_changes...)
+    }
+  }
+
+  parallel_done.Wait()
+  for i := range(node.parallelExtensions) {
+    if len(parallel_messages[i]) != 0 {
+      messages = append(messages, parallel_messages[i]...)
+    }
+    if len(parallel_changes[i]) != 0 {
+      changes = append(changes, parallel_changes[i]...)
+    }
+  }
+
+  if field_snapshot != nil && len(changes) != 0 {
+    size_err := node.checkChangedFieldSizes(ctx, changes, field_snapshot)
+    var limit_err SizeLimitError
+    if errors.As(size_err, &limit_err) {
+      ctx.Log.Logf("node", "FIELD_SIZE: %s rejected %s - %s", node.ID, signal.ID(), size_err)
+      messages = []Message{}
+      changes = Changes{}
+      if _, is_response := signal.(ResponseSignal); is_response == false {
+        messages = append(messages, Message{source, NewErrorSignal(signal.ID(), "field_size")})
+      }
+    } else if size_err != nil {
+      return size_err
+    }
+  }
+
+  if node.committing != ZeroUUID && signal.ID() == node.committing {
+    for _, msg := range(messages) {
+      response, is_response := msg.Signal.(ResponseSignal)
+      if is_response && response.ResponseID() == signal.ID() {
+        node.commitResponse = response
+      }
+    }
+  }
+
+  idempotency_ext, has_idempotency := node.Extensions[idempotencyExtType].(*IdempotencyExt)
+  if has_idempotency {
+    changes = append(changes, idempotency_ext.record(messages)...)
+  }
+
+  outbox := ctx.NodeTypes[node.Type].Outbox
+  if outbox && (len(messages) != 0 || len(changes) != 0) {
+    outbox_err := node.persistOutbox(ctx, changes, messages)
+    if outbox_err != nil {
+      return outbox_err
     }
   }
 
@@ -350,14 +740,34 @@ func (node *Node) Process(ctx *Context, source NodeID, signal Signal) error {
     if send_err != nil {
       return send_err
     }
+
+    if outbox {
+      outbox_err := node.clearOutbox(ctx)
+      if outbox_err != nil {
+        return outbox_err
+      }
+    }
   }
 
   if len(changes) != 0 {
     ctx.Log.Logf("changes", "Changes to %s from %+v: %+v", node.ID, signal, changes)
-    status_err := node.QueueChanges(ctx, changes)
+    status_err := node.QueueChanges(ctx, changes, before)
     if status_err != nil {
       return status_err
     }
+
+    // History is best-effort, failing to record a version doesn't undo the changes that were already made and sent
+    version_err := node.writeVersion(ctx, changes, VersionTrigger{signalTypeName(signal), signal.ID(), source})
+    if version_err != nil {
+      ctx.Log.Logf("history", "HISTORY_ERR: failed to write version of %s - %s", node.ID, version_err)
+    }
+
+    node.markReferences(ctx, changes)
+    if outbox == false {
+      for _, change := range(changes) {
+        node.unwritten.Add(change.Extension, change.Op, change.Field)
+      }
+    }
   }
 
   return nil
@@ -395,6 +805,79 @@ func GetExt[E any, T interface { *E; Extension}](node *Node) (T, error) {
   return ret, nil
 }
 
+// Get the extension from the node, attaching the one returned by AutoAttach if the node doesn't have it
+// and the extension implements AutoAttachExtension. The attached extension is loaded immediately and
+// written to the DB with the node's next changes. Must be called from the goroutine processing the node.
+func GetOrAttachExt[E any, T interface { *E; Extension}](ctx *Context, node *Node) (T, error) {
+  ext_type := ExtTypeFor[E, T]()
+  _, exists := node.Extensions[ext_type]
+  if exists {
+    return GetExt[E, T](node)
+  }
+
+  var zero T
+  ext_info, registered := ctx.Extensions[ext_type]
+  if registered == false {
+    return zero, fmt.Errorf("%+v is not an extension in ctx", ext_type)
+  } else if ext_info.AutoAttach == false {
+    return zero, fmt.Errorf("%+v does not have %+v extension, and it can't be attached automatically", node.ID, ext_type)
+  }
+
+  quota := ctx.NodeTypes[node.Type].Quota
+  if quota.Extensions > 0 && len(node.Extensions) >= quota.Extensions {
+    return zero, fmt.Errorf("%s nodes can have %d extensions: %w", node.Type, quota.Extensions, QuotaExceededError)
+  }
+
+  ext, ok := any(new(E)).(AutoAttachExtension).AutoAttach().(T)
+  if ok == false {
+    return zero, fmt.Errorf("AutoAttach for %+v returned the wrong type", ext_type)
+  }
+
+  extensions := maps.Clone(node.Extensions)
+  extensions[ext_type] = ext
+  parallel, serial, err := ProcessOrder(ctx, extensions)
+  if err != nil {
+    return zero, err
+  }
+  load_order, err := LoadOrder(ctx, extensions)
+  if err != nil {
+    return zero, err
+  }
+
+  if node.Active.Load() {
+    err = ext.Load(ctx, node)
+    if err != nil {
+      return zero, err
+    }
+  }
+
+  node.Extensions = extensions
+  node.parallelExtensions = parallel
+  node.serialExtensions = serial
+  node.loadOrder = load_order
+  node.attached = append(node.attached, ext_type)
+  ctx.Log.Logf("node_ext", "Attached %s to %s", ext_info.Type, node.ID)
+
+  return ext, nil
+}
+
+// Load a single extension of a node from the DB without loading the rest of the node
+func LoadExt[E any, T interface { *E; Extension}](ctx *Context, id NodeID) (T, error) {
+  var zero T
+  ext_type := ExtTypeFor[E, T]()
+  ext, err := ctx.DB.LoadExtension(ctx, id, ext_type)
+  if err != nil {
+    return zero, err
+  }
+
+  ret, ok := ext.(T)
+  if ok == false {
+    return zero, fmt.Errorf("%+v in %+v is wrong type(%+v), expecting %+v", ext_type, id, reflect.TypeOf(ext), reflect.TypeOf(zero))
+  }
+
+  return ret, nil
+}
+
 func KeyID(pub ed25519.PublicKey) NodeID {
   id := uuid.NewHash(sha512.New(), ZeroUUID, pub, 3)
   return NodeID(id)
@@ -1,10 +1,21 @@
 package graphvent
 
 import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "reflect"
+  "slices"
+  "strings"
+  "sync/atomic"
   "testing"
   "time"
   "crypto/rand"
   "crypto/ed25519"
+
+  badger "github.com/dgraph-io/badger/v3"
+  "github.com/google/uuid"
 )
 
 func TestNodeDB(t *testing.T) {
@@ -53,3 +64,1659 @@ func TestNodeRead(t *testing.T) {
   fatalErr(t, err)
   ctx.Log.Logf("test", "READ_RESULT: %+v", res)
 }
+
+func TestReadResultField(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  listener := NewListenerExt(10)
+  reader, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  lockable, err := ctx.NewNode(nil, "LockableNode", NewListenerExt(10), NewLockableExt(nil))
+  fatalErr(t, err)
+
+  read_sig := NewReadSignal([]string{"buffer", "LockableState"})
+  err = ctx.Send(reader, []Message{{lockable.ID, read_sig}})
+  fatalErr(t, err)
+
+  res, err := WaitForSignal(listener.Chan, 10*time.Millisecond, func(sig *ReadResultSignal) bool {
+    return true
+  })
+  fatalErr(t, err)
+
+  state, err := ReadResultField[ReqState](res, "LockableState")
+  fatalErr(t, err)
+  if state != Unlocked {
+    t.Fatalf("Wrong LockableState read: %s", state)
+  }
+
+  _, err = ReadResultField[int](res, "buffer")
+  if err == nil {
+    t.Fatal("Read unmapped field")
+  }
+
+  _, err = ReadResultField[string](res, "LockableState")
+  if err == nil {
+    t.Fatal("Read LockableState as the wrong type")
+  }
+}
+
+type testOrderedExt struct {
+}
+
+func (ext *testOrderedExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+func (ext *testOrderedExt) Load(ctx *Context, node *Node) error {
+  return nil
+}
+
+func (ext *testOrderedExt) Unload(ctx *Context, node *Node) {
+}
+
+func (ext *testOrderedExt) ProcessAfter() []ExtType {
+  return []ExtType{ExtTypeFor[LockableExt]()}
+}
+
+func TestProcessOrder(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterExtension[testOrderedExt](ctx, nil))
+
+  parallel, serial, err := ProcessOrder(ctx, map[ExtType]Extension{
+    ExtTypeFor[testOrderedExt](): &testOrderedExt{},
+    ExtTypeFor[LockableExt](): NewLockableExt(nil),
+    ExtTypeFor[ListenerExt](): NewListenerExt(10),
+  })
+  fatalErr(t, err)
+
+  if len(parallel) != 1 || parallel[0] != ExtTypeFor[ListenerExt]() {
+    t.Fatalf("Wrong parallel extensions: %+v", parallel)
+  }
+
+  if len(serial) != 2 || serial[0] != ExtTypeFor[LockableExt]() || serial[1] != ExtTypeFor[testOrderedExt]() {
+    t.Fatalf("Wrong serial order: %+v", serial)
+  }
+}
+
+type testDependentExt struct {
+  loaded bool
+}
+
+func (ext *testDependentExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+func (ext *testDependentExt) Load(ctx *Context, node *Node) error {
+  listener := node.Extensions[ExtTypeFor[ListenerExt]()].(*ListenerExt)
+  // ListenerExt sends a LoadedSignal when it's loaded
+  if len(listener.Chan) == 0 {
+    return fmt.Errorf("ListenerExt was not loaded first")
+  }
+  ext.loaded = true
+  return nil
+}
+
+func (ext *testDependentExt) Unload(ctx *Context, node *Node) {
+}
+
+func (ext *testDependentExt) Requires() []ExtType {
+  return []ExtType{ExtTypeFor[ListenerExt]()}
+}
+
+func TestLoadOrder(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterExtension[testDependentExt](ctx, nil))
+
+  _, err := ctx.NewNode(nil, "Node", &testDependentExt{})
+  if err == nil {
+    t.Fatal("Created node without required extension")
+  }
+
+  dependent := &testDependentExt{}
+  _, err = ctx.NewNode(nil, "Node", dependent, NewListenerExt(10))
+  fatalErr(t, err)
+  if dependent.loaded == false {
+    t.Fatal("testDependentExt was not loaded")
+  }
+}
+
+type testCounterExt struct {
+  Count int `gv:"count"`
+}
+
+func (ext *testCounterExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+func (ext *testCounterExt) Load(ctx *Context, node *Node) error {
+  return nil
+}
+
+func (ext *testCounterExt) Unload(ctx *Context, node *Node) {
+}
+
+func (ext *testCounterExt) AutoAttach() Extension {
+  return &testCounterExt{Count: 1}
+}
+
+func TestGetOrAttachExt(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterExtension[testCounterExt](ctx, nil))
+
+  node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  _, err = GetExt[testCounterExt](node)
+  if err == nil {
+    t.Fatal("Got extension the node doesn't have")
+  }
+
+  _, err = GetOrAttachExt[LockableExt](ctx, node)
+  if err == nil {
+    t.Fatal("Attached an extension that isn't auto-attachable")
+  }
+
+  counter, err := GetOrAttachExt[testCounterExt](ctx, node)
+  fatalErr(t, err)
+  if counter.Count != 1 {
+    t.Fatalf("Attached extension has count %d, expected 1", counter.Count)
+  }
+  counter.Count = 5
+
+  again, err := GetOrAttachExt[testCounterExt](ctx, node)
+  fatalErr(t, err)
+  if again != counter {
+    t.Fatal("Attached a second extension")
+  }
+
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  loaded, err := LoadExt[testCounterExt](ctx, node.ID)
+  fatalErr(t, err)
+  if loaded.Count != 5 {
+    t.Fatalf("Loaded count %d, expected 5", loaded.Count)
+  }
+}
+
+func TestLoadExt(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "db"})
+
+  node, err := ctx.NewNode(nil, "Node", NewLockableExt(nil), NewListenerExt(10))
+  fatalErr(t, err)
+
+  err = ctx.DB.WriteExtension(ctx, node.ID, &ListenerExt{Buffer: 20})
+  if errors.Is(err, NodeLoadedError) == false {
+    t.Fatalf("Expected NodeLoadedError writing to a loaded node, got %v", err)
+  }
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.DB.WriteExtension(ctx, node.ID, &ListenerExt{Buffer: 20}))
+
+  listener, err := LoadExt[ListenerExt](ctx, node.ID)
+  fatalErr(t, err)
+
+  if listener.Buffer != 20 {
+    t.Fatalf("Loaded listener buffer %d, expected 20", listener.Buffer)
+  }
+}
+
+func TestNodeEviction(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  ctx.TrackMemory = true
+
+  listener := NewListenerExt(10)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  usage := ctx.MemoryUsage()
+  if usage.Nodes[lockable.ID] == 0 {
+    t.Fatalf("Memory usage not tracked for %s: %+v", lockable.ID, usage)
+  }
+
+  // Limit memory to a single node so creating another evicts the lockable
+  ctx.MemoryLimit = usage.Total
+  _, err = ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  _, loaded := ctx.MemoryUsage().Nodes[lockable.ID]
+  if loaded {
+    t.Fatalf("%s was not evicted", lockable.ID)
+  }
+
+  ctx.MemoryLimit = 0
+  reloaded, err := ctx.GetNode(lockable.ID)
+  fatalErr(t, err)
+
+  lockable_ext, err := GetExt[LockableExt](reloaded)
+  fatalErr(t, err)
+  if lockable_ext.State != Locked {
+    t.Fatalf("Evicted node reloaded in state %s", lockable_ext.State)
+  }
+}
+
+func TestEvictionWhileSending(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  ctx.TrackMemory = true
+
+  reqs := make([]NodeID, 8)
+  for i := range(reqs) {
+    req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+    fatalErr(t, err)
+    reqs[i] = req.ID
+  }
+
+  listener := NewListenerExt(100)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(reqs))
+  fatalErr(t, err)
+
+  // Every send evicts, so requirements are stopped while they're sending to each other
+  ctx.MemoryLimit = 1
+
+  done := make(chan error, 1)
+  go func() {
+    lock_id, err := LockLockable(ctx, lockable)
+    if err == nil {
+      _, _, err = WaitForResponse(listener.Chan, 5*time.Second, lock_id)
+    }
+    done <- err
+  }()
+
+  select {
+  case err := <-done:
+    fatalErr(t, err)
+  case <-time.After(10*time.Second):
+    t.Fatal("Lock didn't finish while evicting, eviction may be deadlocked")
+  }
+  ctx.MemoryLimit = 0
+}
+
+func TestUnloadWritesChanges(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  db := ctx.DB.(*BadgerDB)
+
+  listener := NewListenerExt(10)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  before := db.FieldWrites.Load()
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(lockable.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  written := db.FieldWrites.Load() - before
+  total := len(ctx.Extensions[ExtTypeFor[LockableExt]()].Fields) + len(ctx.Extensions[ExtTypeFor[ListenerExt]()].Fields)
+  ctx.Log.Logf("test", "Unloading a locked lockable wrote %d/%d fields", written, total)
+  if written == 0 || written >= int64(total) {
+    t.Fatalf("Unloading wrote %d of %d fields, expected only the ones changed by locking", written, total)
+  }
+
+  reloaded, err := ctx.GetNode(lockable.ID)
+  fatalErr(t, err)
+  lockable_ext, err := GetExt[LockableExt](reloaded)
+  fatalErr(t, err)
+  if lockable_ext.State != Locked || lockable_ext.Owner == nil || *lockable_ext.Owner != lockable.ID {
+    t.Fatalf("Unloaded node reloaded in state %s owned by %+v", lockable_ext.State, lockable_ext.Owner)
+  }
+}
+
+func TestUnloadWriteFailure(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  failing := &failingDB{Database: ctx.DB}
+  ctx.DB = failing
+
+  listener := NewListenerExt(10)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  failing.failing.Store(true)
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(lockable.ID)
+  _, loaded := ctx.nodes[lockable.ID]
+  ctx.nodesLock.Unlock()
+  if err == nil {
+    t.Fatal("Unloading succeeded while the DB was failing")
+  } else if loaded == false {
+    t.Fatalf("%s was removed after failing to write", lockable.ID)
+  }
+
+  // The node keeps running with the changes it couldn't write
+  unlock_id, err := UnlockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, unlock_id)
+  fatalErr(t, err)
+
+  failing.failing.Store(false)
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(lockable.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  reloaded, err := ctx.GetNode(lockable.ID)
+  fatalErr(t, err)
+  lockable_ext, err := GetExt[LockableExt](reloaded)
+  fatalErr(t, err)
+  if lockable_ext.State != Unlocked || lockable_ext.Owner != nil {
+    t.Fatalf("Node reloaded in state %s owned by %+v", lockable_ext.State, lockable_ext.Owner)
+  }
+}
+
+func TestOutbox(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "outbox"})
+  fatalErr(t, SetOutbox(ctx, "LockableNode", true))
+
+  listener := NewListenerExt(10)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(lockable.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+  if len(lockable.outbox) != 0 {
+    t.Fatalf("Outbox not cleared after sending: %+v", lockable.outbox)
+  }
+
+  // Write a message to the outbox as if the node stopped before sending it
+  receiver_listener := NewListenerExt(10)
+  receiver, err := ctx.NewNode(nil, "LockableNode", receiver_listener, NewLockableExt(nil))
+  fatalErr(t, err)
+  undelivered := NewSuccessSignal(lock_id)
+  lockable.outbox = []Message{{receiver.ID, undelivered}}
+  lockable.writeOutbox = true
+  fatalErr(t, ctx.DB.WriteNodeChanges(ctx, lockable, nil))
+
+  reloaded, err := ctx.GetNode(lockable.ID)
+  fatalErr(t, err)
+  _, err = WaitForSignal(receiver_listener.Chan, 100*time.Millisecond, func(sig *SuccessSignal) bool {
+    return sig.ID() == undelivered.ID()
+  })
+  fatalErr(t, err)
+
+  lockable_ext, err := GetExt[LockableExt](reloaded)
+  fatalErr(t, err)
+  if lockable_ext.State != Locked {
+    t.Fatalf("Lock state written with the outbox was lost: %s", lockable_ext.State)
+  }
+}
+
+func TestFreeze(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  listener := NewListenerExt(10)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  // Responses to the frozen node are held too, so it's frozen and inspected from another node
+  inspector_listener := NewListenerExt(10)
+  inspector, err := ctx.NewNode(nil, "Node", inspector_listener)
+  fatalErr(t, err)
+
+  freeze_signal := NewFreezeSignal()
+  fatalErr(t, ctx.Send(inspector, []Message{{lockable.ID, freeze_signal}}))
+  _, _, err = WaitForResponse(inspector_listener.Chan, 10*time.Millisecond, freeze_signal.ID())
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  if err == nil {
+    t.Fatal("Frozen node processed a lock")
+  }
+
+  read_signal := NewReadSignal([]string{"LockableState"})
+  fatalErr(t, ctx.Send(inspector, []Message{{lockable.ID, read_signal}}))
+  response, _, err := WaitForResponse(inspector_listener.Chan, 10*time.Millisecond, read_signal.ID())
+  fatalErr(t, err)
+  state, err := ReadResultField[ReqState](response.(*ReadResultSignal), "LockableState")
+  fatalErr(t, err)
+  if state != Unlocked {
+    t.Fatalf("Frozen node changed state to %s", state)
+  }
+
+  thaw_signal := NewThawSignal()
+  fatalErr(t, ctx.Send(inspector, []Message{{lockable.ID, thaw_signal}}))
+  _, _, err = WaitForResponse(inspector_listener.Chan, 10*time.Millisecond, thaw_signal.ID())
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  thaw_signal = NewThawSignal()
+  fatalErr(t, ctx.Send(inspector, []Message{{lockable.ID, thaw_signal}}))
+  response, _, err = WaitForResponse(inspector_listener.Chan, 10*time.Millisecond, thaw_signal.ID())
+  fatalErr(t, err)
+  if error_signal, is_error := response.(*ErrorSignal); is_error == false || error_signal.Error != "not_frozen" {
+    t.Fatalf("Thawed a node that wasn't frozen: %+v", response)
+  }
+}
+
+func TestKeyring(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  secret := make([]byte, 32)
+  _, err := rand.Read(secret)
+  fatalErr(t, err)
+  ctx.Keyring, err = NewBadgerKeyring(ctx.DB.(*BadgerDB).DB, secret)
+  fatalErr(t, err)
+
+  node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+  key := node.Key
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  keyring := ctx.Keyring
+  ctx.Keyring = nil
+  _, err = ctx.GetNode(node.ID)
+  if err == nil {
+    t.Fatal("Loaded a node stored without it's key without a keyring")
+  }
+
+  ctx.Keyring = keyring
+  reloaded, err := ctx.GetNode(node.ID)
+  fatalErr(t, err)
+  if key.Equal(reloaded.Key) == false {
+    t.Fatal("Key loaded from the keyring doesn't match")
+  } else if key.Public().(ed25519.PublicKey).Equal(reloaded.Public) == false {
+    t.Fatal("Public key not stored in the node record")
+  }
+}
+
+func TestKeyringFailedWrite(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  secret := make([]byte, 32)
+  _, err := rand.Read(secret)
+  fatalErr(t, err)
+  ctx.Keyring, err = NewBadgerKeyring(ctx.DB.(*BadgerDB).DB, secret)
+  fatalErr(t, err)
+
+  // Wrapped so the keyring isn't written in the node's transaction
+  failing := &failingDB{Database: ctx.DB}
+  ctx.DB = failing
+  failing.failing.Store(true)
+
+  public, key, err := ed25519.GenerateKey(rand.Reader)
+  fatalErr(t, err)
+  _, err = ctx.NewNode(key, "Node", NewListenerExt(10))
+  if err == nil {
+    t.Fatal("Created a node when the write failed")
+  }
+
+  _, err = ctx.Keyring.LoadKey(ctx, KeyID(public))
+  if errors.Is(err, KeyNotFoundError) == false {
+    t.Fatalf("Key of a node that wasn't written was left in the keyring: %s", err)
+  }
+
+  failing.failing.Store(false)
+  node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.Keyring.RemoveKey(ctx, node.ID))
+  _, err = ctx.GetNode(node.ID)
+  if errors.Is(err, KeyNotFoundError) == false {
+    t.Fatalf("Loaded a node without its key: %s", err)
+  }
+}
+
+func TestAckListener(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  listener := NewAckListenerExt(10, 20*time.Millisecond, "HeartbeatSignal")
+  node, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  wait_for := func(listener *AckListenerExt, id uuid.UUID) {
+    _, err := WaitForSignal(listener.Chan, 100*time.Millisecond, func(sig *HeartbeatSignal) bool {
+      return sig.ID() == id
+    })
+    fatalErr(t, err)
+  }
+
+  heartbeat := NewHeartbeatSignal()
+  fatalErr(t, ctx.Send(node, []Message{{node.ID, heartbeat}}))
+  wait_for(listener, heartbeat.ID())
+  // Not acked, so it's delivered again after the timeout
+  wait_for(listener, heartbeat.ID())
+
+  fatalErr(t, listener.Ack(ctx, node, heartbeat.ID()))
+  _, err = WaitForSignal(listener.Chan, 50*time.Millisecond, func(sig *HeartbeatSignal) bool {
+    return sig.ID() == heartbeat.ID()
+  })
+  if err == nil {
+    t.Fatal("Acked signal was redelivered")
+  }
+
+  unacked := NewHeartbeatSignal()
+  fatalErr(t, ctx.Send(node, []Message{{node.ID, unacked}}))
+  wait_for(listener, unacked.ID())
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  reloaded, err := ctx.GetNode(node.ID)
+  fatalErr(t, err)
+  reloaded_listener, err := GetExt[AckListenerExt](reloaded)
+  fatalErr(t, err)
+  wait_for(reloaded_listener, unacked.ID())
+}
+
+func TestAckListenerLimits(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  listener := NewAckListenerExt(10, 10*time.Millisecond, "HeartbeatSignal")
+  listener.MaxUnacked = 2
+  listener.MaxRedeliveries = 1
+  node, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  count := func(id uuid.UUID, wait time.Duration) int {
+    n := 0
+    deadline := time.After(wait)
+    for {
+      select {
+      case sig := <-listener.Chan:
+        if sig.ID() == id {
+          n += 1
+        }
+      case <-deadline:
+        return n
+      }
+    }
+  }
+
+  // Untracked signals are delivered once
+  status := NewStatusSignal(node.ID, nil, nil, nil)
+  fatalErr(t, ctx.Send(node, []Message{{node.ID, status}}))
+  if n := count(status.ID(), 50*time.Millisecond); n != 1 {
+    t.Fatalf("StatusSignal delivered %d times", n)
+  }
+
+  // Delivered once and redelivered once before being dropped
+  heartbeat := NewHeartbeatSignal()
+  fatalErr(t, ctx.Send(node, []Message{{node.ID, heartbeat}}))
+  if n := count(heartbeat.ID(), 100*time.Millisecond); n != 2 {
+    t.Fatalf("HeartbeatSignal delivered %d times", n)
+  }
+
+  for i := 0; i < 3; i++ {
+    fatalErr(t, ctx.Send(node, []Message{{node.ID, NewHeartbeatSignal()}}))
+  }
+  read := NewReadSignal([]string{"unacked"})
+  fatalErr(t, ctx.Send(node, []Message{{node.ID, read}}))
+  _, err = WaitForSignal(listener.Chan, 100*time.Millisecond, func(sig *ReadResultSignal) bool {
+    return sig.ReqID == read.ID()
+  })
+  fatalErr(t, err)
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+  if len(listener.Unacked) > 2 {
+    t.Fatalf("Kept %d unacked signals with MaxUnacked 2", len(listener.Unacked))
+  }
+}
+
+type testMetricsSink struct {
+  signals chan string
+}
+
+func (sink *testMetricsSink) RecordSignal(node NodeID, node_type NodeType, signal_type string, latency time.Duration) {
+  sink.signals <- signal_type
+}
+
+func TestSignalMetrics(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  sink := &testMetricsSink{make(chan string, 100)}
+  ctx.Metrics = sink
+
+  node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  reader_listener := NewListenerExt(10)
+  reader, err := ctx.NewNode(nil, "Node", reader_listener)
+  fatalErr(t, err)
+
+  for i := 0; i < 3; i++ {
+    fatalErr(t, ctx.Send(reader, []Message{{node.ID, NewHeartbeatSignal()}}))
+  }
+
+  read_signal := NewReadSignal([]string{SIGNAL_METRICS_FIELD})
+  fatalErr(t, ctx.Send(reader, []Message{{node.ID, read_signal}}))
+  response, _, err := WaitForResponse(reader_listener.Chan, 10*time.Millisecond, read_signal.ID())
+  fatalErr(t, err)
+
+  metrics, err := ReadResultField[map[string]SignalMetrics](response.(*ReadResultSignal), SIGNAL_METRICS_FIELD)
+  fatalErr(t, err)
+  heartbeats := metrics["HeartbeatSignal"]
+  if heartbeats.Count != 3 || heartbeats.Max <= 0 || heartbeats.Mean() > heartbeats.Max {
+    t.Fatalf("Wrong HeartbeatSignal metrics: %+v", metrics)
+  }
+
+  recorded := 0
+  for len(sink.signals) > 0 {
+    if <-sink.signals == "HeartbeatSignal" {
+      recorded += 1
+    }
+  }
+  if recorded != 3 {
+    t.Fatalf("Sink recorded %d HeartbeatSignals", recorded)
+  }
+}
+
+func TestChangesAdd(t *testing.T) {
+  var changes Changes
+  changes.Add(ExtTypeFor[LockableExt](), ChangeSet, "state", "owner")
+  changes.Add(ExtTypeFor[LockableExt](), ChangeSet, "state")
+  changes.Add(ExtTypeFor[LockableExt](), ChangeAdd, "requirements")
+
+  if len(changes) != 3 {
+    t.Fatalf("Expected 3 changes, got %+v", changes)
+  }
+
+  fields := changes.ByExtension()[ExtTypeFor[LockableExt]()]
+  if len(fields) != 3 {
+    t.Fatalf("Expected 3 changed fields, got %+v", fields)
+  }
+}
+
+func TestWriteNodeChanges(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "db"})
+
+  listener := NewListenerExt(10)
+  node, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  listener.Buffer = 100
+  var changes Changes
+  changes.Add(ExtTypeFor[ListenerExt](), ChangeSet, "buffer")
+  fatalErr(t, ctx.DB.WriteNodeChanges(ctx, node, changes))
+
+  loaded, err := LoadExt[ListenerExt](ctx, node.ID)
+  fatalErr(t, err)
+  if loaded.Buffer != 100 {
+    t.Fatalf("Change to buffer not written: %d", loaded.Buffer)
+  }
+}
+
+func TestCloneNode(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  req_clone, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  other_req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  template_listener := NewListenerExt(10)
+  template, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{req.ID, other_req.ID}), template_listener)
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, template)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(template_listener.Chan, 100*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  listener := NewListenerExt(20)
+  clone, err := ctx.CloneNode(template.ID, []Extension{listener}, map[NodeID]NodeID{req.ID: req_clone.ID})
+  fatalErr(t, err)
+
+  if clone.ID == template.ID {
+    t.Fatal("Clone has the same ID as the template")
+  } else if clone.Type != template.Type {
+    t.Fatalf("Clone has type %s, expected %s", clone.Type, template.Type)
+  } else if clone.Extensions[ExtTypeFor[ListenerExt]()] != listener {
+    t.Fatal("Clone did not use the override ListenerExt")
+  }
+
+  lockable := clone.Extensions[ExtTypeFor[LockableExt]()].(*LockableExt)
+  if lockable == template.Extensions[ExtTypeFor[LockableExt]()] {
+    t.Fatal("Clone shares LockableExt with the template")
+  }
+
+  _, has_old := lockable.Requirements[req.ID]
+  _, has_new := lockable.Requirements[req_clone.ID]
+  if has_old || has_new == false {
+    t.Fatalf("Requirements were not remapped: %+v", lockable.Requirements)
+  }
+
+  _, has_other := lockable.Requirements[other_req.ID]
+  if has_other {
+    t.Fatalf("Clone kept a requirement that wasn't remapped: %+v", lockable.Requirements)
+  } else if lockable.State != Unlocked || lockable.Owner != nil {
+    t.Fatalf("Clone of a locked node wasn't reset: %s owned by %v", lockable.State, lockable.Owner)
+  }
+
+  lock_id, err = LockLockable(ctx, clone)
+  fatalErr(t, err)
+  response, _, err := WaitForResponse(listener.Chan, 100*time.Millisecond, lock_id)
+  fatalErr(t, err)
+  switch resp := response.(type) {
+  case *SuccessSignal:
+  default:
+    t.Fatalf("Failed to lock clone: %s", resp)
+  }
+}
+
+func TestAlias(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  listener := NewListenerExt(10)
+  node, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+  other, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.SetAlias("root_event", node.ID))
+  _, err = WaitForSignal(listener.Chan, 10*time.Millisecond, func(sig *AliasSignal) bool {
+    return sig.Action == "add" && sig.Alias == "root_event"
+  })
+  fatalErr(t, err)
+
+  err = ctx.SetAlias("root_event", other.ID)
+  if errors.Is(err, AliasTakenError) == false {
+    t.Fatalf("Expected AliasTakenError, got %s", err)
+  }
+
+  id, err := ctx.ParseNode("root_event")
+  fatalErr(t, err)
+  if id != node.ID {
+    t.Fatalf("root_event resolved to %s, expected %s", id, node.ID)
+  }
+
+  link_signal := NewLinkSignal("add", other.ID)
+  fatalErr(t, ctx.SendAlias(other, "root_event", link_signal))
+  _, err = WaitForSignal(listener.Chan, 10*time.Millisecond, func(sig *LinkSignal) bool {
+    return sig.ID() == link_signal.ID()
+  })
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.RemoveAlias("root_event"))
+  _, err = ctx.ResolveAlias("root_event")
+  if errors.Is(err, AliasNotFoundError) == false {
+    t.Fatalf("Expected AliasNotFoundError, got %s", err)
+  }
+}
+
+func TestPresence(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "presence"})
+
+  listener := NewListenerExt(10)
+  presence := NewPresenceExt(50*time.Millisecond)
+  team, err := ctx.NewNode(nil, "PresenceNode", listener, presence)
+  fatalErr(t, err)
+
+  member_listener := NewListenerExt(10)
+  member, err := ctx.NewNode(nil, "Node", member_listener)
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, NewHeartbeatSignal()}}))
+  online, err := WaitForSignal(listener.Chan, 10*time.Millisecond, func(sig *PresenceSignal) bool {
+    return sig.Member == member.ID
+  })
+  fatalErr(t, err)
+  if online.Online == false {
+    t.Fatalf("Member not online after heartbeat: %s", online)
+  }
+
+  read_sig := NewReadSignal([]string{"Online"})
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, read_sig}}))
+  response, _, err := WaitForResponse(member_listener.Chan, 10*time.Millisecond, read_sig.ID())
+  fatalErr(t, err)
+  online_ids, err := ReadResultField[[]NodeID](response.(*ReadResultSignal), "Online")
+  fatalErr(t, err)
+  if len(online_ids) != 1 || online_ids[0] != member.ID {
+    t.Fatalf("Wrong members read as online: %+v", online_ids)
+  }
+
+  offline, err := WaitForSignal(listener.Chan, 200*time.Millisecond, func(sig *PresenceSignal) bool {
+    return sig.Member == member.ID
+  })
+  fatalErr(t, err)
+  if offline.Online {
+    t.Fatalf("Member still online after timeout: %s", offline)
+  } else if len(presence.Online) != 0 {
+    t.Fatalf("Member still in online list: %+v", presence.Online)
+  }
+}
+
+func TestAutoLoad(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, SetAutoLoad(ctx, "PresenceNode", true))
+
+  team, err := ctx.NewNode(nil, "PresenceNode", NewPresenceExt(50*time.Millisecond))
+  fatalErr(t, err)
+  explicit, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+  fatalErr(t, ctx.SetNodeAutoLoad(explicit.ID, true))
+  lazy, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+
+  member_listener := NewListenerExt(10)
+  member, err := ctx.NewNode(nil, "Node", member_listener)
+  fatalErr(t, err)
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, NewHeartbeatSignal()}}))
+
+  // Wait for the heartbeat to be processed before stopping
+  read_sig := NewReadSignal([]string{"Online"})
+  fatalErr(t, ctx.Send(member, []Message{{team.ID, read_sig}}))
+  _, _, err = WaitForResponse(member_listener.Chan, 10*time.Millisecond, read_sig.ID())
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.Stop())
+
+  loaded, err := ctx.LoadAutoNodes()
+  fatalErr(t, err)
+  if len(loaded) != 2 {
+    t.Fatalf("Expected team and explicit to auto-load, loaded %+v", loaded)
+  } else if _, lazy_loaded := ctx.nodes[lazy.ID]; lazy_loaded {
+    t.Fatal("Loaded node that isn't in the auto-load set")
+  }
+
+  // The presence check queued before stopping should run without the team being messaged
+  time.Sleep(100*time.Millisecond)
+  team, err = ctx.GetNode(team.ID)
+  fatalErr(t, err)
+  presence := team.Extensions[ExtTypeFor[PresenceExt]()].(*PresenceExt)
+  if len(presence.Online) != 0 {
+    t.Fatalf("Queued presence check didn't run after auto-load: %+v", presence.Online)
+  }
+}
+
+func TestPreload(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  ids := []NodeID{}
+  for i := 0; i < 5; i++ {
+    node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+    fatalErr(t, err)
+    ids = append(ids, node.ID)
+  }
+  fatalErr(t, ctx.Stop())
+
+  // Missing and repeated IDs are skipped
+  loaded, err := ctx.Preload(2, append([]NodeID{RandID(), ids[0]}, ids[:3]...)...)
+  fatalErr(t, err)
+  if len(loaded) != 3 {
+    t.Fatalf("Expected 3 nodes to preload, loaded %+v", loaded)
+  }
+  for _, id := range(ids[:3]) {
+    if _, exists := ctx.nodes[id]; exists == false {
+      t.Fatalf("%s wasn't preloaded", id)
+    }
+  }
+
+  loaded, err = ctx.PreloadWhere(0, func(id NodeID) bool {
+    return id != ids[4]
+  })
+  fatalErr(t, err)
+  if len(loaded) != 1 || loaded[0] != ids[3] {
+    t.Fatalf("Expected only %s to preload, loaded %+v", ids[3], loaded)
+  } else if _, exists := ctx.nodes[ids[4]]; exists {
+    t.Fatal("Preloaded node the selector didn't select")
+  }
+}
+
+func TestLoadNodeIDs(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  ids := []NodeID{}
+  for i := 0; i < 2; i++ {
+    node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+    fatalErr(t, err)
+    ids = append(ids, node.ID)
+  }
+
+  // The alias key is 16 bytes, the same as a node ID
+  fatalErr(t, ctx.SetAlias("abcdefgh", ids[0]))
+
+  compare := func(a, b NodeID) int {
+    return bytes.Compare(a[:], b[:])
+  }
+  check := func() {
+    loaded, err := ctx.DB.LoadNodeIDs(ctx)
+    fatalErr(t, err)
+    slices.SortFunc(loaded, compare)
+    slices.SortFunc(ids, compare)
+    if slices.Equal(loaded, ids) == false {
+      t.Fatalf("Expected node IDs %+v, got %+v", ids, loaded)
+    }
+  }
+  check()
+
+  db := ctx.DB.(*BadgerDB)
+  fatalErr(t, db.Update(func(tx *badger.Txn) error {
+    return tx.Delete(nodeIndexKey(ids[0]))
+  }))
+  indexed, err := db.IndexNodes(ctx)
+  fatalErr(t, err)
+  if indexed != 2 {
+    t.Fatalf("Expected IndexNodes to index 2 nodes, indexed %d", indexed)
+  }
+  check()
+}
+
+func TestLocale(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  mappings, err := TagMappings(ctx, ExtTypeFor[LocaleExt]())
+  fatalErr(t, err)
+  fatalErr(t, RegisterNodeType(ctx, "LocaleNode", mappings))
+
+  _, err = NewLocaleExt("Not/A_Timezone", "")
+  if err == nil {
+    t.Fatal("Created LocaleExt with an unknown timezone")
+  }
+
+  plain, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+  if NodeLocation(plain) != time.UTC {
+    t.Fatalf("Node without a LocaleExt has location %s", NodeLocation(plain))
+  }
+
+  locale, err := NewLocaleExt("UTC", "en-US")
+  fatalErr(t, err)
+  node, err := ctx.NewNode(nil, "LocaleNode", locale)
+  fatalErr(t, err)
+
+  listener := NewListenerExt(10)
+  source, err := ctx.NewNode(nil, "Node", listener)
+  fatalErr(t, err)
+
+  bad_signal := NewLocaleSignal("Not/A_Timezone", "")
+  fatalErr(t, ctx.Send(source, []Message{{node.ID, bad_signal}}))
+  response, _, err := WaitForResponse(listener.Chan, 10*time.Millisecond, bad_signal.ID())
+  fatalErr(t, err)
+  if error_signal, ok := response.(*ErrorSignal); ok == false || error_signal.Error != "unknown_timezone" {
+    t.Fatalf("Expected unknown_timezone error, got %s", response)
+  }
+
+  locale_signal := NewLocaleSignal("America/New_York", "")
+  fatalErr(t, ctx.Send(source, []Message{{node.ID, locale_signal}}))
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, locale_signal.ID())
+  fatalErr(t, err)
+
+  read_signal := NewReadSignal([]string{"Timezone", "Locale"})
+  fatalErr(t, ctx.Send(source, []Message{{node.ID, read_signal}}))
+  response, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, read_signal.ID())
+  fatalErr(t, err)
+
+  timezone, err := ReadResultField[string](response.(*ReadResultSignal), "Timezone")
+  fatalErr(t, err)
+  language, err := ReadResultField[string](response.(*ReadResultSignal), "Locale")
+  fatalErr(t, err)
+  if timezone != "America/New_York" || language != "en-US" || NodeLocation(node).String() != "America/New_York" {
+    t.Fatalf("Wrong locale after LocaleSignal: %s, %s, %s", timezone, language, NodeLocation(node))
+  }
+}
+
+func TestStats(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  l1, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{l1.ID}))
+  fatalErr(t, err)
+  _, err = ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+  fatalErr(t, ctx.unloadNode(l2.ID))
+
+  stats, err := ctx.Stats()
+  fatalErr(t, err)
+  ctx.Log.Logf("test", "STATS: %+v", stats)
+
+  counts := map[string]NodeTypeStats{}
+  for _, node_type := range(stats.NodeTypes) {
+    counts[node_type.Name] = node_type
+  }
+  if counts["LockableNode"].Persisted != 2 || counts["LockableNode"].Loaded != 1 || counts["Node"].Persisted != 1 {
+    t.Fatalf("Wrong node counts: %+v", stats.NodeTypes)
+  }
+  if stats.Persisted != 3 || stats.Loaded != 2 {
+    t.Fatalf("Wrong totals: %d persisted, %d loaded", stats.Persisted, stats.Loaded)
+  }
+
+  for _, ext := range(stats.Extensions) {
+    if ext.Type == lockableExtType && ext.Nodes != 2 {
+      t.Fatalf("Wrong LockableExt count: %+v", ext)
+    }
+  }
+
+  found := false
+  for _, edge := range(stats.Edges) {
+    if edge.Extension == lockableExtType && edge.Field == "requirements" {
+      found = edge.Count == 1
+    }
+  }
+  if found == false {
+    t.Fatalf("Requirement edge missing from %+v", stats.Edges)
+  }
+}
+
+// Version 1 split the name field written by unversioned records into first and last
+type testProfileExt struct {
+  First string `gv:"first"`
+  Last string `gv:"last"`
+  Migrations int
+}
+
+func (ext *testProfileExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+func (ext *testProfileExt) Load(ctx *Context, node *Node) error {
+  return nil
+}
+
+func (ext *testProfileExt) Unload(ctx *Context, node *Node) {
+}
+
+func (ext *testProfileExt) Version() uint64 {
+  return 1
+}
+
+func (ext *testProfileExt) MigrateFrom(ctx *Context, version uint64, fields map[FieldTag][]byte) error {
+  name, err := Deserialize[string](ctx, fields[GetFieldTag("name")])
+  if err != nil {
+    return err
+  }
+  ext.First, ext.Last, _ = strings.Cut(name, " ")
+  ext.Migrations += 1
+  return nil
+}
+
+func TestExtensionMigration(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterExtension[testProfileExt](ctx, nil))
+  profile_type := ExtTypeFor[testProfileExt]()
+
+  node, err := ctx.NewNode(nil, "Node", &testProfileExt{First: "unused"})
+  fatalErr(t, err)
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  // Rewrite the extension the way an unversioned record with a single name field would have been written
+  db := ctx.DB.(*BadgerDB)
+  ext_id := binary.BigEndian.AppendUint64(node.ID[:], uint64(profile_type))
+  fatalErr(t, db.Update(func(tx *badger.Txn) error {
+    buffer := [64]byte{}
+    written, err := Serialize(ctx, "Ada Lovelace", buffer[:])
+    if err != nil {
+      return err
+    }
+    err = tx.Set(binary.BigEndian.AppendUint64(slices.Clone(ext_id), uint64(GetFieldTag("name"))), buffer[:written])
+    if err != nil {
+      return err
+    }
+    err = tx.Delete(binary.BigEndian.AppendUint64(slices.Clone(ext_id), uint64(GetFieldTag("first"))))
+    if err != nil {
+      return err
+    }
+    return tx.Delete(ext_id)
+  }))
+
+  loaded, err := ctx.getNode(node.ID)
+  fatalErr(t, err)
+  profile, err := GetExt[testProfileExt](loaded)
+  fatalErr(t, err)
+  if profile.First != "Ada" || profile.Last != "Lovelace" || profile.Migrations != 1 {
+    t.Fatalf("Wrong profile after migration: %+v", profile)
+  }
+
+  // The migrated extension is written with its version, so loading it again doesn't migrate it
+  fatalErr(t, ctx.unloadNode(node.ID))
+  loaded, err = ctx.getNode(node.ID)
+  fatalErr(t, err)
+  profile, err = GetExt[testProfileExt](loaded)
+  fatalErr(t, err)
+  if profile.First != "Ada" || profile.Migrations != 0 {
+    t.Fatalf("Extension was migrated again: %+v", profile)
+  }
+}
+
+func TestFieldEvolution(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterExtension[testProfileExt](ctx, nil))
+  fatalErr(t, RegisterExtension[testCounterExt](ctx, nil))
+  profile_type := ExtTypeFor[testProfileExt]()
+
+  node, err := ctx.NewNode(nil, "Node", &testProfileExt{First: "Ada", Last: "Lovelace"})
+  fatalErr(t, err)
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  // Write the node the way a binary without the last field and with an extra extension would have
+  db := ctx.DB.(*BadgerDB)
+  id_ser, err := node.ID.MarshalBinary()
+  fatalErr(t, err)
+  // Never registered, stands in for an extension registered by a newer binary
+  unknown_type := ExtType(SerializedTypeFor[testUnknownExt]())
+  fatalErr(t, db.Update(func(tx *badger.Txn) error {
+    ext_id := binary.BigEndian.AppendUint64(slices.Clone(id_ser), uint64(profile_type))
+    err := tx.Delete(binary.BigEndian.AppendUint64(ext_id, uint64(GetFieldTag("last"))))
+    if err != nil {
+      return err
+    }
+
+    buffer := [128]byte{}
+    written, err := Serialize(ctx, []ExtType{profile_type, unknown_type}, buffer[:])
+    if err != nil {
+      return err
+    }
+    return tx.Set(append(slices.Clone(id_ser), []byte(" - EXTLIST")...), buffer[:written])
+  }))
+
+  loaded, err := ctx.getNode(node.ID)
+  fatalErr(t, err)
+  profile, err := GetExt[testProfileExt](loaded)
+  fatalErr(t, err)
+  if profile.First != "Ada" || profile.Last != "" {
+    t.Fatalf("Wrong profile after loading without a field: %+v", profile)
+  }
+
+  // The unknown extension is kept when attaching an extension rewrites the extension list
+  _, err = GetOrAttachExt[testCounterExt](ctx, loaded)
+  fatalErr(t, err)
+  fatalErr(t, ctx.unloadNode(node.ID))
+  fatalErr(t, db.View(func(tx *badger.Txn) error {
+    item, err := tx.Get(append(slices.Clone(id_ser), []byte(" - EXTLIST")...))
+    if err != nil {
+      return err
+    }
+    return item.Value(func(val []byte) error {
+      ext_list, err := Deserialize[[]ExtType](ctx, val)
+      if err != nil {
+        return err
+      } else if slices.Contains(ext_list, unknown_type) == false {
+        return fmt.Errorf("Unknown extension was dropped from %+v", ext_list)
+      }
+      return nil
+    })
+  }))
+}
+
+type testUnknownExt struct {}
+
+func TestNodeRecordChecksum(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  node, err := ctx.NewNode(nil, "Node", NewListenerExt(10))
+  fatalErr(t, err)
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  db := ctx.DB.(*BadgerDB)
+  var record []byte
+  fatalErr(t, db.View(func(tx *badger.Txn) error {
+    item, err := tx.Get(node.ID[:])
+    if err != nil {
+      return err
+    }
+    record, err = item.ValueCopy(nil)
+    return err
+  }))
+
+  // Records written before checksums are still read
+  payload, err := openNodeRecord(ctx, node.ID, record)
+  fatalErr(t, err)
+  fatalErr(t, db.Update(func(tx *badger.Txn) error {
+    return tx.Set(node.ID[:], slices.Clone(payload))
+  }))
+  _, err = db.LoadNode(ctx, node.ID)
+  fatalErr(t, err)
+
+  corrupt := slices.Clone(record)
+  corrupt[len(corrupt)/2] ^= 0xFF
+  fatalErr(t, db.Update(func(tx *badger.Txn) error {
+    return tx.Set(node.ID[:], corrupt)
+  }))
+  _, err = db.LoadNode(ctx, node.ID)
+  if errors.Is(err, CorruptNodeError) == false {
+    t.Fatalf("Expected CorruptNodeError loading corrupt record, got %v", err)
+  }
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterExtension[testProfileExt](ctx, nil))
+  secret := make([]byte, 32)
+  _, err := rand.Read(secret)
+  fatalErr(t, err)
+  fatalErr(t, ctx.SetMasterKey(secret))
+
+  node, err := ctx.NewNode(nil, "Node", &testProfileExt{First: "Plaintext", Last: "Surname"})
+  fatalErr(t, err)
+  key := node.Key
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  db := ctx.DB.(*BadgerDB)
+  fatalErr(t, db.View(func(tx *badger.Txn) error {
+    iter := tx.NewIterator(badger.DefaultIteratorOptions)
+    defer iter.Close()
+    for iter.Rewind(); iter.Valid(); iter.Next() {
+      value, err := iter.Item().ValueCopy(nil)
+      if err != nil {
+        return err
+      }
+      if bytes.Contains(value, []byte("Plaintext")) || bytes.Contains(value, key.Seed()) {
+        return fmt.Errorf("%x is stored in plaintext", iter.Item().Key())
+      }
+    }
+    return nil
+  }))
+
+  loaded, err := ctx.getNode(node.ID)
+  fatalErr(t, err)
+  profile, err := GetExt[testProfileExt](loaded)
+  fatalErr(t, err)
+  if profile.First != "Plaintext" || loaded.Key.Equal(key) == false {
+    t.Fatalf("Wrong node after decrypting: %+v", profile)
+  }
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  other, err := NewContext(&BadgerDB{DB: db.DB}, NewConsoleLogger([]string{"test"}))
+  fatalErr(t, err)
+  fatalErr(t, RegisterExtension[testProfileExt](other, nil))
+  other_secret := make([]byte, 32)
+  fatalErr(t, other.SetMasterKey(other_secret))
+  _, err = other.DB.LoadNode(other, node.ID)
+  if errors.Is(err, CorruptNodeError) == false {
+    t.Fatalf("Expected CorruptNodeError loading with the wrong master key, got %v", err)
+  }
+}
+
+type testLazyExt struct {
+  Big Lazy[map[string]int] `gv:"big"`
+}
+
+func (ext *testLazyExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  return nil, nil
+}
+
+func (ext *testLazyExt) Load(ctx *Context, node *Node) error {
+  return nil
+}
+
+func (ext *testLazyExt) Unload(ctx *Context, node *Node) {
+}
+
+func TestLazyFields(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterLazy[map[string]int](ctx))
+  fatalErr(t, RegisterExtension[testLazyExt](ctx, nil))
+
+  big := map[string]int{}
+  for i := 0; i < 100; i++ {
+    big[fmt.Sprintf("key-%d", i)] = i
+  }
+  node, err := ctx.NewNode(nil, "Node", &testLazyExt{Big: NewLazy(big)})
+  fatalErr(t, err)
+  fatalErr(t, ctx.unloadNode(node.ID))
+
+  loaded, err := ctx.getNode(node.ID)
+  fatalErr(t, err)
+  ext, err := GetExt[testLazyExt](loaded)
+  fatalErr(t, err)
+  if ext.Big.Decoded() {
+    t.Fatal("Lazy field was decoded when its node was loaded")
+  }
+
+  stored, err := SerializeJSON(ctx, ext.Big)
+  fatalErr(t, err)
+  from_json, err := DeserializeJSON[Lazy[map[string]int]](ctx, stored)
+  fatalErr(t, err)
+  decoded, err := from_json.Get(ctx)
+  fatalErr(t, err)
+  if len(decoded) != 100 || decoded["key-42"] != 42 {
+    t.Fatalf("Wrong lazy field after JSON round trip: %+v", decoded)
+  }
+
+  fatalErr(t, ctx.unloadNode(node.ID))
+  loaded, err = ctx.getNode(node.ID)
+  fatalErr(t, err)
+  ext, err = GetExt[testLazyExt](loaded)
+  fatalErr(t, err)
+  // Undecoded fields are written as they were stored, which is the same as the value they hold
+  buffer := [4096]byte{}
+  written, err := Serialize(ctx, ext.Big, buffer[:])
+  fatalErr(t, err)
+  if ext.Big.Decoded() {
+    t.Fatal("Lazy field was decoded to serialize it")
+  }
+  plain, err := Deserialize[map[string]int](ctx, buffer[:written])
+  fatalErr(t, err)
+  if len(plain) != 100 || plain["key-7"] != 7 {
+    t.Fatalf("Lazy field wasn't written like its value: %+v", plain)
+  }
+
+  value, err := ext.Big.Get(ctx)
+  fatalErr(t, err)
+  if len(value) != 100 || value["key-99"] != 99 || ext.Big.Decoded() == false {
+    t.Fatalf("Wrong lazy field after decoding: %+v", value)
+  }
+
+  // Encoding the field in place keeps the value it decoded
+  fatalErr(t, ctx.unloadNode(node.ID))
+  loaded, err = ctx.getNode(node.ID)
+  fatalErr(t, err)
+  ext, err = GetExt[testLazyExt](loaded)
+  fatalErr(t, err)
+  _, err = encodeJSON(ctx, reflect.ValueOf(ext).Elem().FieldByName("Big"))
+  fatalErr(t, err)
+  if ext.Big.Decoded() == false {
+    t.Fatal("Lazy field decoded for JSON wasn't kept decoded")
+  }
+}
+
+func TestMemoryDB(t *testing.T) {
+  ctx, err := NewContext(NewMemoryDB(), NewConsoleLogger([]string{"test"}))
+  fatalErr(t, err)
+  t.Cleanup(func() {
+    ctx.Stop()
+  })
+  ctx.TrackMemory = true
+
+  listener := NewListenerExt(10)
+  lockable, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, lockable)
+  fatalErr(t, err)
+  _, _, err = WaitForResponse(listener.Chan, 10*time.Millisecond, lock_id)
+  fatalErr(t, err)
+
+  // Nodes can't be evicted since they couldn't be loaded again
+  ctx.MemoryLimit = 1
+  _, err = ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+  loaded, err := ctx.GetNode(lockable.ID)
+  fatalErr(t, err)
+  if loaded != lockable {
+    t.Fatal("Node was reloaded without a DB")
+  }
+
+  _, err = ctx.GetNode(RandID())
+  if errors.Is(err, NodeNotFoundError) == false {
+    t.Fatalf("Expected NodeNotFoundError, got %s", err)
+  }
+
+  fatalErr(t, ctx.SetAlias("lockable", lockable.ID))
+  id, err := ctx.ResolveAlias("lockable")
+  fatalErr(t, err)
+  if id != lockable.ID {
+    t.Fatalf("lockable resolved to %s, expected %s", id, lockable.ID)
+  }
+
+  err = SetNodeHistory(ctx, "LockableNode", 10)
+  if errors.Is(err, NoPersistenceError) == false {
+    t.Fatalf("Expected NoPersistenceError setting history, got %s", err)
+  }
+  _, err = LoadExt[LockableExt](ctx, lockable.ID)
+  if errors.Is(err, NoPersistenceError) == false {
+    t.Fatalf("Expected NoPersistenceError loading extension, got %s", err)
+  }
+
+  stats, err := ctx.Stats()
+  fatalErr(t, err)
+  if stats.Persisted != 2 || stats.Loaded != 2 {
+    t.Fatalf("Wrong node counts: %+v", stats)
+  }
+}
+
+func TestContextEvents(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  events, unsubscribe := ctx.SubscribeEvents(10, EventNodeLoaded, EventNodeUnloaded, EventWriteFlushed)
+  node, err := ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  _, err = ctx.GetNode(node.ID)
+  fatalErr(t, err)
+
+  expected := []ContextEventType{EventNodeLoaded, EventNodeUnloaded, EventWriteFlushed, EventNodeLoaded}
+  for _, event_type := range(expected) {
+    select {
+    case event := <-events:
+      if event.Type != event_type || event.Node != node.ID {
+        t.Fatalf("Expected %s for %s, got %+v", event_type, node.ID, event)
+      }
+    default:
+      t.Fatalf("Missing %s event", event_type)
+    }
+  }
+
+  unsubscribe()
+  unsubscribe()
+  _, open := <-events
+  if open {
+    t.Fatal("Events channel wasn't closed by unsubscribing")
+  }
+}
+
+// Fails every node write while failing is set
+type failingDB struct {
+  Database
+  failing atomic.Bool
+}
+
+func (db *failingDB) WriteNodeInit(ctx *Context, node *Node) error {
+  if db.failing.Load() {
+    return fmt.Errorf("write failed")
+  }
+  return db.Database.WriteNodeInit(ctx, node)
+}
+
+func (db *failingDB) WriteNodeChanges(ctx *Context, node *Node, changes Changes) error {
+  if db.failing.Load() {
+    return fmt.Errorf("write failed")
+  }
+  return db.Database.WriteNodeChanges(ctx, node, changes)
+}
+
+func (db *failingDB) WriteNodeVersion(ctx *Context, id NodeID, version NodeVersion, keep int) error {
+  if db.failing.Load() {
+    return fmt.Errorf("write failed")
+  }
+  return db.Database.WriteNodeVersion(ctx, id, version, keep)
+}
+
+func TestDegradedDB(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "db"})
+  failing := &failingDB{Database: ctx.DB}
+  db, err := NewDegradedDB(failing, DegradedDBConfig{
+    MaxQueued: 2,
+    RetryInterval: time.Hour,
+  })
+  fatalErr(t, err)
+  defer db.Stop()
+  ctx.DB = db
+
+  events, unsubscribe := ctx.SubscribeEvents(10, EventDBUnhealthy, EventDBRecovered)
+  defer unsubscribe()
+
+  node, err := ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+
+  failing.failing.Store(true)
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  healthy, queued, _ := db.Health()
+  if healthy || queued != 1 {
+    t.Fatalf("DB healthy: %t with %d queued writes after a failed write", healthy, queued)
+  }
+  if event := <-events; event.Type != EventDBUnhealthy {
+    t.Fatalf("Expected %s, got %+v", EventDBUnhealthy, event)
+  }
+
+  _, err = ctx.GetNode(node.ID)
+  if errors.Is(err, DBUnavailableError) == false {
+    t.Fatalf("Loaded a node with queued writes: %s", err)
+  }
+
+  _, err = ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+  _, err = ctx.NewNode(nil, "Node")
+  if errors.Is(err, DBUnavailableError) == false {
+    t.Fatalf("Expected DBUnavailableError once the queue is full, got %s", err)
+  }
+
+  err = db.Flush()
+  if err == nil {
+    t.Fatal("Flushed while the DB is failing")
+  }
+
+  failing.failing.Store(false)
+  fatalErr(t, db.Flush())
+  healthy, queued, _ = db.Health()
+  if healthy == false || queued != 0 {
+    t.Fatalf("DB healthy: %t with %d queued writes after recovering", healthy, queued)
+  }
+  if event := <-events; event.Type != EventDBRecovered {
+    t.Fatalf("Expected %s, got %+v", EventDBRecovered, event)
+  }
+
+  _, err = ctx.GetNode(node.ID)
+  fatalErr(t, err)
+}
+
+func TestDegradedDBTarget(t *testing.T) {
+  ctx := logTestContext(t, []string{"test", "db"})
+
+  target_listener := NewListenerExt(10)
+  target, err := ctx.NewNode(nil, "Node", target_listener)
+  fatalErr(t, err)
+
+  failing := &failingDB{Database: ctx.DB}
+  db, err := NewDegradedDB(failing, DegradedDBConfig{
+    MaxQueued: 2,
+    RetryInterval: time.Hour,
+    Target: target.ID,
+  })
+  fatalErr(t, err)
+  defer db.Stop()
+  ctx.DB = db
+
+  node, err := ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+
+  // Unloading holds the nodes lock while the failed write notifies the target
+  failing.failing.Store(true)
+  ctx.nodesLock.Lock()
+  err = ctx.unloadNode(node.ID)
+  ctx.nodesLock.Unlock()
+  fatalErr(t, err)
+
+  health, err := WaitForSignal(target_listener.Chan, 100*time.Millisecond, func(sig *DBHealthSignal) bool {
+    return true
+  })
+  fatalErr(t, err)
+  if health.Healthy || health.Queued != 1 || health.Error != "write failed" {
+    t.Fatalf("Wrong health signal after a failed write: %s", health)
+  }
+
+  failing.failing.Store(false)
+  fatalErr(t, db.Flush())
+  health, err = WaitForSignal(target_listener.Chan, 100*time.Millisecond, func(sig *DBHealthSignal) bool {
+    return true
+  })
+  fatalErr(t, err)
+  if health.Healthy == false || health.Queued != 0 {
+    t.Fatalf("Wrong health signal after recovering: %s", health)
+  }
+}
+
+func TestAutoCreate(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  secret := make([]byte, 32)
+  _, err := rand.Read(secret)
+  fatalErr(t, err)
+  keys, err := NewDerivedKeys(secret)
+  fatalErr(t, err)
+
+  l1_listener := NewListenerExt(10)
+  l1, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil), l1_listener)
+  fatalErr(t, err)
+  outsider, err := ctx.NewNode(nil, "Node")
+  fatalErr(t, err)
+
+  fatalErr(t, AddAutoCreate(ctx, AutoCreatePolicy{
+    Name: "scratch",
+    NodeType: "LockableNode",
+    Key: keys.Key,
+    Allow: func(ctx *Context, source NodeID, id NodeID) bool {
+      return source == l1.ID
+    },
+    Template: func(ctx *Context, id NodeID) ([]Extension, error) {
+      return []Extension{NewLockableExt(nil)}, nil
+    },
+  }))
+
+  scratch := keys.ID("team-a")
+  err = ctx.Send(outsider, []Message{{scratch, NewStatusSignal(outsider.ID, nil, nil, nil)}})
+  if errors.Is(err, NodeNotFoundError) == false {
+    t.Fatalf("Denied source didn't get NodeNotFoundError: %v", err)
+  }
+
+  unknown := RandID()
+  err = ctx.Send(l1, []Message{{unknown, NewStatusSignal(l1.ID, nil, nil, nil)}})
+  if errors.Is(err, NodeNotFoundError) == false {
+    t.Fatalf("Created node without a key from the policy: %v", err)
+  }
+
+  // Linking sends a DependencySignal to the requirement, which creates it
+  link_signal := NewLinkSignal("add", scratch)
+  fatalErr(t, ctx.Send(l1, []Message{{l1.ID, link_signal}}))
+  response, _, err := WaitForResponse(l1_listener.Chan, 100*time.Millisecond, link_signal.ID())
+  fatalErr(t, err)
+  if _, success := response.(*SuccessSignal); success == false {
+    t.Fatalf("Link to auto created node failed: %s", response)
+  }
+
+  node, err := ctx.getNode(scratch)
+  fatalErr(t, err)
+  if node.Type != NodeTypeFor("LockableNode") {
+    t.Fatalf("Auto created node has type %s", node.Type)
+  }
+}
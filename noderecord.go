@@ -0,0 +1,79 @@
+package graphvent
+
+import (
+  "encoding/binary"
+  "fmt"
+  "hash/crc32"
+)
+
+// First byte of node records written with a checksum. Records written before checksums start with the
+// non-nil flag of the serialized *Node, so they're still read without one.
+// Records written with a master key are encrypted instead, which authenticates them without a checksum.
+const (
+  nodeRecordLegacy = byte(0x01)
+  nodeRecordChecksummed = byte(0x02)
+  nodeRecordEncrypted = byte(0x03)
+)
+
+var nodeRecordTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Get the node record, using data to serialize the node. The record is the checksummed marker, the serialized node, and the
+// CRC-32C of the serialized node, or the encrypted marker and the encrypted node if the context has a master key.
+func writeNodeRecord(ctx *Context, node *Node, data []byte) ([]byte, error) {
+  if len(data) < 1 {
+    return nil, fmt.Errorf("No space for node record of %s", node.ID)
+  }
+
+  written, err := Serialize(ctx, node, data[1:])
+  if err != nil {
+    return nil, err
+  }
+
+  if ctx.masterKey != nil {
+    sealed, err := sealAtRest(ctx, node.ID[:], data[1:1+written])
+    if err != nil {
+      return nil, err
+    }
+    return append([]byte{nodeRecordEncrypted}, sealed...), nil
+  } else if len(data) < 1 + written + 4 {
+    return nil, fmt.Errorf("No space for checksum of %s", node.ID)
+  }
+
+  data[0] = nodeRecordChecksummed
+  binary.BigEndian.PutUint32(data[1+written:], crc32.Checksum(data[1:1+written], nodeRecordTable))
+  return data[:1+written+4], nil
+}
+
+// Check the node record's checksum and return the serialized node in it, failing with CorruptNodeError if it doesn't match
+func openNodeRecord(ctx *Context, id NodeID, record []byte) ([]byte, error) {
+  if len(record) == 0 {
+    return nil, fmt.Errorf("Record of %s is empty: %w", id, CorruptNodeError)
+  }
+
+  switch record[0] {
+  case nodeRecordLegacy:
+    return record, nil
+  case nodeRecordChecksummed:
+    if len(record) < 5 {
+      return nil, fmt.Errorf("Record of %s is too short for a checksum(%d bytes): %w", id, len(record), CorruptNodeError)
+    }
+    payload := record[1:len(record)-4]
+    stored := binary.BigEndian.Uint32(record[len(record)-4:])
+    computed := crc32.Checksum(payload, nodeRecordTable)
+    if stored != computed {
+      return nil, fmt.Errorf("Record of %s has checksum %08x, expected %08x: %w", id, stored, computed, CorruptNodeError)
+    }
+    return payload, nil
+  case nodeRecordEncrypted:
+    if ctx.masterKey == nil {
+      return nil, fmt.Errorf("Record of %s is encrypted and the context has no master key", id)
+    }
+    payload, err := openAtRest(ctx, id[:], record[1:])
+    if err != nil {
+      return nil, fmt.Errorf("Record of %s can't be decrypted(%s): %w", id, err, CorruptNodeError)
+    }
+    return payload, nil
+  default:
+    return nil, fmt.Errorf("Record of %s has unknown format %02x: %w", id, record[0], CorruptNodeError)
+  }
+}
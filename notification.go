@@ -0,0 +1,349 @@
+package graphvent
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net"
+  "net/http"
+  "net/url"
+  "slices"
+  "syscall"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+var notificationExtType = ExtTypeFor[NotificationExt]()
+
+const (
+  // Notifications are sent to the member node as a NotificationSignal, for its ListenerExt or a gateway extension to handle
+  NotifySignal = "signal"
+  // Notifications are POSTed to the member's URL as JSON
+  NotifyWebhook = "webhook"
+
+  NOTIFICATION_WEBHOOK_TIMEOUT = 5*time.Second
+  // Webhooks each NotificationExt delivers at once
+  NOTIFICATION_WEBHOOK_WORKERS = 4
+  // Webhooks waiting for a worker, past this notifications are counted as failed deliveries instead of queued
+  NOTIFICATION_WEBHOOK_QUEUE = 64
+)
+
+// How a member wants to be notified of changes to a node with a NotificationExt
+type NotificationPreference struct {
+  // NotifySignal or NotifyWebhook
+  Channel string `gv:"channel"`
+  // Webhook URL, only used by NotifyWebhook
+  URL string `gv:"url"`
+  // Node fields to be notified about, every field if empty
+  Fields []string `gv:"fields"`
+}
+
+// Deliveries to a member, so failing channels can be found
+type NotificationDelivery struct {
+  Sent int `gv:"sent"`
+  Failed int `gv:"failed"`
+  LastError string `gv:"last_error"`
+  LastSent time.Time `gv:"last_sent"`
+}
+
+// Sent to a NotificationExt to set the source's preference, an empty channel removes the source from the members
+type NotificationPreferenceSignal struct {
+  SignalHeader
+  Preference NotificationPreference `gv:"preference"`
+}
+func (signal NotificationPreferenceSignal) String() string {
+  return fmt.Sprintf("NotificationPreferenceSignal(%s, %s)", signal.SignalHeader, signal.Preference.Channel)
+}
+func NewNotificationPreferenceSignal(preference NotificationPreference) *NotificationPreferenceSignal {
+  return &NotificationPreferenceSignal{
+    NewSignalHeader(),
+    preference,
+  }
+}
+
+// Sent to members that prefer NotifySignal when fields of Node change
+type NotificationSignal struct {
+  SignalHeader
+  Node NodeID `gv:"node"`
+  Fields []string `gv:"fields"`
+}
+func (signal NotificationSignal) String() string {
+  return fmt.Sprintf("NotificationSignal(%s, %s, %+v)", signal.SignalHeader, signal.Node, signal.Fields)
+}
+func NewNotificationSignal(node NodeID, fields []string) *NotificationSignal {
+  return &NotificationSignal{
+    NewSignalHeader(),
+    node,
+    fields,
+  }
+}
+
+// Sent by a NotificationExt to itself once a webhook delivery finishes, Error is empty if it succeeded
+type NotificationDeliveredSignal struct {
+  SignalHeader
+  Member NodeID `gv:"member"`
+  Error string `gv:"error"`
+}
+func (signal NotificationDeliveredSignal) String() string {
+  return fmt.Sprintf("NotificationDeliveredSignal(%s, %s, %s)", signal.SignalHeader, signal.Member, signal.Error)
+}
+func NewNotificationDeliveredSignal(member NodeID, err string) *NotificationDeliveredSignal {
+  return &NotificationDeliveredSignal{
+    NewSignalHeader(),
+    member,
+    err,
+  }
+}
+
+// Body of a webhook notification
+type NotificationPayload struct {
+  Notification uuid.UUID `json:"notification"`
+  Node NodeID `json:"node"`
+  Fields []string `json:"fields"`
+}
+
+// Routes the StatusSignals a node processes, from its own changes or forwarded by its requirements,
+// to the members that asked to be notified of the changed fields
+type NotificationExt struct {
+  Members map[NodeID]NotificationPreference `gv:"members"`
+  Deliveries map[NodeID]NotificationDelivery `gv:"deliveries"`
+  // Hosts webhooks can be sent to, as a hostname or host:port. When empty webhooks can go to any host
+  // that doesn't resolve to a loopback, private, or link-local address.
+  WebhookHosts []string `gv:"webhook_hosts"`
+
+  // ID of the last NotificationSignal sent to each member, so an error response to it counts as a failed delivery
+  last map[NodeID]uuid.UUID
+  webhooks chan webhookDelivery
+}
+
+type webhookDelivery struct {
+  member NodeID
+  url string
+  payload NotificationPayload
+}
+
+func NewNotificationExt() *NotificationExt {
+  return &NotificationExt{
+    Members: map[NodeID]NotificationPreference{},
+    Deliveries: map[NodeID]NotificationDelivery{},
+  }
+}
+
+func (ext *NotificationExt) Load(ctx *Context, node *Node) error {
+  ext.last = map[NodeID]uuid.UUID{}
+  ext.webhooks = make(chan webhookDelivery, NOTIFICATION_WEBHOOK_QUEUE)
+  client := ext.webhookClient()
+  for i := 0; i < NOTIFICATION_WEBHOOK_WORKERS; i++ {
+    go ext.deliverWebhooks(ctx, node, client, ext.webhooks)
+  }
+  return nil
+}
+
+func (ext *NotificationExt) Unload(ctx *Context, node *Node) {
+  close(ext.webhooks)
+}
+
+func (ext *NotificationExt) deliverWebhooks(ctx *Context, node *Node, client *http.Client, webhooks chan webhookDelivery) {
+  for delivery := range(webhooks) {
+    result := ""
+    err := notifyWebhook(client, delivery.url, delivery.payload)
+    if err != nil {
+      ctx.Log.Logf("notification", "WEBHOOK_ERR: %s - %s", delivery.member, err)
+      result = err.Error()
+    }
+    // Sending the result with ctx.Send would load the node again if it was unloaded during the delivery
+    if ctx.sendLoaded(node, NewNotificationDeliveredSignal(delivery.member, result)) == false {
+      ctx.Log.Logf("notification", "DELIVERED_DROPPED: %s unloaded before the delivery to %s finished", node.ID, delivery.member)
+    }
+  }
+}
+
+// Check that a webhook URL is http or https, and goes to one of WebhookHosts if it's set
+func (ext *NotificationExt) checkWebhookURL(raw string) error {
+  parsed, err := url.Parse(raw)
+  if err != nil {
+    return err
+  } else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+    return fmt.Errorf("scheme %s is not http or https", parsed.Scheme)
+  } else if parsed.Hostname() == "" {
+    return fmt.Errorf("no host")
+  } else if parsed.User != nil {
+    return fmt.Errorf("credentials in URL")
+  }
+
+  if len(ext.WebhookHosts) > 0 && slices.Contains(ext.WebhookHosts, parsed.Host) == false && slices.Contains(ext.WebhookHosts, parsed.Hostname()) == false {
+    return fmt.Errorf("host %s is not allowed", parsed.Host)
+  }
+  return nil
+}
+
+// Refuse connections to addresses inside the network, so webhooks can't be pointed at internal services
+func dialPublicOnly(network string, address string, conn syscall.RawConn) error {
+  host, _, err := net.SplitHostPort(address)
+  if err != nil {
+    return err
+  }
+
+  ip := net.ParseIP(host)
+  if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+    return fmt.Errorf("webhook address %s is not public", host)
+  }
+  return nil
+}
+
+// Redirects aren't followed, and without WebhookHosts the addresses are checked when they're dialed so DNS can't point them inside the network
+func (ext *NotificationExt) webhookClient() *http.Client {
+  client := &http.Client{
+    Timeout: NOTIFICATION_WEBHOOK_TIMEOUT,
+    CheckRedirect: func(req *http.Request, via []*http.Request) error {
+      return http.ErrUseLastResponse
+    },
+  }
+
+  if len(ext.WebhookHosts) == 0 {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.DialContext = (&net.Dialer{
+      Timeout: NOTIFICATION_WEBHOOK_TIMEOUT,
+      Control: dialPublicOnly,
+    }).DialContext
+    client.Transport = transport
+  }
+  return client
+}
+
+// Record the result of a delivery to a member, returning whether it changed
+func (ext *NotificationExt) record(member NodeID, err string) bool {
+  if _, is_member := ext.Members[member]; is_member == false {
+    return false
+  }
+
+  delivery := ext.Deliveries[member]
+  if err == "" {
+    delivery.Sent += 1
+    delivery.LastSent = time.Now()
+  } else {
+    delivery.Failed += 1
+    delivery.LastError = err
+  }
+  ext.Deliveries[member] = delivery
+  return true
+}
+
+func notifyWebhook(client *http.Client, url string, payload NotificationPayload) error {
+  body, err := json.Marshal(payload)
+  if err != nil {
+    return err
+  }
+
+  resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+  if err != nil {
+    return err
+  }
+  resp.Body.Close()
+
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return fmt.Errorf("webhook returned %s", resp.Status)
+  }
+  return nil
+}
+
+func (ext *NotificationExt) notify(ctx *Context, node *Node, status *StatusSignal) ([]Message, Changes) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  for member, preference := range(ext.Members) {
+    fields := status.Fields
+    if len(preference.Fields) > 0 {
+      fields = []string{}
+      for _, field := range(status.Fields) {
+        if slices.Contains(preference.Fields, field) {
+          fields = append(fields, field)
+        }
+      }
+      if len(fields) == 0 {
+        continue
+      }
+    }
+
+    switch preference.Channel {
+    case NotifySignal:
+      notification := NewNotificationSignal(status.Source, fields)
+      ext.last[member] = notification.ID()
+      messages = append(messages, Message{member, notification})
+      ext.record(member, "")
+      changes.Add(notificationExtType, ChangeSet, "deliveries")
+
+    case NotifyWebhook:
+      payload := NotificationPayload{uuid.New(), status.Source, fields}
+      select {
+      case ext.webhooks <- webhookDelivery{member, preference.URL, payload}:
+      default:
+        ctx.Log.Logf("notification", "WEBHOOK_QUEUE_FULL: %s - %s", node.ID, member)
+        ext.record(member, "queue_full")
+        changes.Add(notificationExtType, ChangeSet, "deliveries")
+      }
+    }
+  }
+
+  return messages, changes
+}
+
+func (ext *NotificationExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  switch sig := signal.(type) {
+  case *NotificationPreferenceSignal:
+    switch sig.Preference.Channel {
+    case "":
+      delete(ext.Members, source)
+      delete(ext.Deliveries, source)
+    case NotifySignal:
+      ext.Members[source] = sig.Preference
+    case NotifyWebhook:
+      if sig.Preference.URL == "" {
+        messages = append(messages, Message{source, NewErrorSignal(sig.ID(), "missing_url")})
+        return messages, changes
+      } else if err := ext.checkWebhookURL(sig.Preference.URL); err != nil {
+        messages = append(messages, Message{source, NewErrorSignal(sig.ID(), "invalid_url: %s", err)})
+        return messages, changes
+      }
+      ext.Members[source] = sig.Preference
+    default:
+      messages = append(messages, Message{source, NewErrorSignal(sig.ID(), "unknown_channel")})
+      return messages, changes
+    }
+    changes.Add(notificationExtType, ChangeSet, "members", "deliveries")
+    messages = append(messages, Message{source, NewSuccessSignal(sig.ID())})
+
+  case *NotificationDeliveredSignal:
+    if source == node.ID && ext.record(sig.Member, sig.Error) {
+      changes.Add(notificationExtType, ChangeSet, "deliveries")
+    }
+
+  case *ErrorSignal:
+    last, notified := ext.last[source]
+    if notified && last == sig.ReqID {
+      delete(ext.last, source)
+      // The delivery was counted as sent when the notification was queued
+      delivery := ext.Deliveries[source]
+      delivery.Sent -= 1
+      ext.Deliveries[source] = delivery
+      if ext.record(source, sig.Error) {
+        changes.Add(notificationExtType, ChangeSet, "deliveries")
+      }
+    }
+
+  case *StatusSignal:
+    // Changes to the notification state itself aren't notified, so delivery tracking doesn't notify about itself
+    for ext_type := range(sig.Changes.ByExtension()) {
+      if ext_type != notificationExtType {
+        messages, changes = ext.notify(ctx, node, sig)
+        break
+      }
+    }
+  }
+
+  return messages, changes
+}
@@ -0,0 +1,87 @@
+package graphvent
+
+import (
+  "fmt"
+)
+
+// Delivered to a node once it's running if it was loaded with messages in it's outbox that weren't sent before it stopped
+type OutboxSignal struct {
+  SignalHeader
+}
+
+func NewOutboxSignal() *OutboxSignal {
+  return &OutboxSignal{
+    NewSignalHeader(),
+  }
+}
+
+func (signal OutboxSignal) String() string {
+  return fmt.Sprintf("OutboxSignal(%s)", &signal.SignalHeader)
+}
+
+// Set whether nodes of a registered type write their changes and outgoing messages to the DB before sending the messages.
+// Messages that weren't delivered before the node stopped are resent when it's loaded again, so they may be delivered more than once.
+func SetOutbox(ctx *Context, name string, enabled bool) error {
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set outbox for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set outbox for unregistered node type %s", name)
+  }
+
+  node_info.Outbox = enabled
+  ctx.NodeTypes[node_type] = node_info
+  return nil
+}
+
+// Write the changes and the messages to the node's outbox in a single transaction
+func (node *Node) persistOutbox(ctx *Context, changes Changes, messages []Message) error {
+  node.outbox = messages
+  node.writeOutbox = true
+  err := ctx.DB.WriteNodeChanges(ctx, node, changes)
+  if err != nil {
+    return err
+  }
+  ctx.publishNodeEvent(EventWriteFlushed, node)
+  return nil
+}
+
+// Mark the messages in the outbox as delivered
+func (node *Node) clearOutbox(ctx *Context) error {
+  node.outbox = nil
+  node.writeOutbox = true
+  err := ctx.DB.WriteNodeChanges(ctx, node, nil)
+  if err != nil {
+    return err
+  }
+  ctx.publishNodeEvent(EventWriteFlushed, node)
+  return nil
+}
+
+// Wake a node that was loaded with messages in it's outbox, after it's been added to the context so it can send them
+func (ctx *Context) resendOutbox(node *Node) {
+  if node.resendOutbox {
+    node.resendOutbox = false
+    ctx.deliver(node, node, NewOutboxSignal())
+  }
+}
+
+// Resend the messages left in the outbox, and clear it once they're sent
+func (node *Node) handleOutboxSignal(ctx *Context) {
+  if len(node.outbox) == 0 {
+    return
+  }
+
+  ctx.Log.Logf("outbox", "%s resending %d messages from outbox", node.ID, len(node.outbox))
+  err := ctx.Send(node, node.outbox)
+  if err == nil {
+    err = node.clearOutbox(ctx)
+  }
+  if err != nil {
+    ctx.Log.Logf("outbox", "%s failed to resend outbox: %s", node.ID, err)
+  }
+}
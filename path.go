@@ -0,0 +1,373 @@
+package graphvent
+
+import (
+  "errors"
+  "fmt"
+  "reflect"
+  "slices"
+  "time"
+
+  "github.com/graphql-go/graphql"
+  "github.com/google/uuid"
+)
+
+// Maximum depth allowed for path queries from GQL
+const PATH_QUERY_MAX_DEPTH = 16
+
+// Maximum number of nodes a path query from GQL can visit, including the node it starts from
+const PATH_QUERY_MAX_VISITED = 1024
+
+// Returned when a path query reaches more nodes than it's allowed to visit
+var PathLimitError = errors.New("Path query visited too many nodes")
+
+// Reads the NodeIDs in the edge fields of a set of nodes at once, used to follow a level of edges in path queries.
+// Returns the NodeIDs in the fields of each node.
+type EdgeReader func(ids []NodeID, fields []string) (map[NodeID][]NodeID, error)
+
+// Get the NodeIDs from a node field value, which can be a NodeID, *NodeID, a slice/array of NodeIDs, or a map with NodeID keys
+func EdgeIDs(value any) []NodeID {
+  ids := []NodeID{}
+  switch value := value.(type) {
+  case NodeID:
+    return append(ids, value)
+  case *NodeID:
+    if value != nil {
+      ids = append(ids, *value)
+    }
+    return ids
+  }
+
+  reflect_value := reflect.ValueOf(value)
+  id_type := reflect.TypeFor[NodeID]()
+  switch reflect_value.Kind() {
+  case reflect.Slice, reflect.Array:
+    if reflect_value.Type().Elem() == id_type {
+      for i := 0; i < reflect_value.Len(); i++ {
+        ids = append(ids, reflect_value.Index(i).Interface().(NodeID))
+      }
+    }
+  case reflect.Map:
+    if reflect_value.Type().Key() == id_type {
+      for _, key := range(reflect_value.MapKeys()) {
+        ids = append(ids, key.Interface().(NodeID))
+      }
+      // Map order is random, sort so results are the same between queries
+      slices.SortFunc(ids, func(a, b NodeID) int {
+        return slices.Compare(a[:], b[:])
+      })
+    }
+  }
+  return ids
+}
+
+// Create an EdgeReader that sends ReadSignals from source to every node at once and waits up to timeout for all the results on responses
+func NodeEdgeReader(ctx *Context, source *Node, responses chan Signal, timeout time.Duration) EdgeReader {
+  return func(ids []NodeID, fields []string) (map[NodeID][]NodeID, error) {
+    waiting := map[uuid.UUID]NodeID{}
+    for _, id := range(ids) {
+      read_signal := NewReadSignal(fields)
+      err := ctx.Send(source, []Message{{id, read_signal}})
+      if err != nil {
+        return nil, err
+      }
+      waiting[read_signal.ID()] = id
+    }
+
+    edges := map[NodeID][]NodeID{}
+    timeout_chan := time.After(timeout)
+    for len(waiting) > 0 {
+      select {
+      case signal := <-responses:
+        if signal == nil {
+          return nil, fmt.Errorf("LISTENER_CLOSED")
+        }
+
+        response, is_response := signal.(ResponseSignal)
+        if is_response == false {
+          continue
+        }
+        id, expected := waiting[response.ResponseID()]
+        if expected == false {
+          continue
+        }
+        delete(waiting, response.ResponseID())
+
+        targets, err := readResultEdges(id, fields, response)
+        if err != nil {
+          return nil, err
+        }
+        edges[id] = targets
+
+      case <-timeout_chan:
+        return nil, fmt.Errorf("Timed out waiting for the edges of %d nodes", len(waiting))
+      }
+    }
+    return edges, nil
+  }
+}
+
+// Get the NodeIDs in every edge field of a read result
+func readResultEdges(id NodeID, fields []string, response ResponseSignal) ([]NodeID, error) {
+  result, ok := response.(*ReadResultSignal)
+  if ok == false {
+    return nil, fmt.Errorf("Bad read response from %s: %+v", id, response)
+  }
+
+  edges := []NodeID{}
+  for _, field := range(fields) {
+    value, returned := result.Fields[field]
+    if returned == false {
+      return nil, fmt.Errorf("%s did not return %s", id, field)
+    }
+
+    _, is_err := value.(error)
+    if is_err {
+      // Nodes without the field have no edges through it
+      continue
+    }
+
+    edges = append(edges, EdgeIDs(value)...)
+  }
+  return edges, nil
+}
+
+// Record that target was reached, failing if that would visit more than max_visited nodes(unlimited if not positive)
+func visitNode(visited int, max_visited int) error {
+  if max_visited > 0 && visited >= max_visited {
+    return fmt.Errorf("Reached more than %d nodes: %w", max_visited, PathLimitError)
+  }
+  return nil
+}
+
+// Get every node reachable from the node by following the edge fields, up to max_depth edges away.
+// Each level of edges is read at once, and the query fails with PathLimitError if it would reach more than max_visited nodes(unlimited if not positive).
+// Returns the number of edges to each node, including the starting node at 0.
+func Reachable(read EdgeReader, from NodeID, edges []string, max_depth int, max_visited int) (map[NodeID]int, error) {
+  depths := map[NodeID]int{
+    from: 0,
+  }
+
+  frontier := []NodeID{from}
+  for depth := 1; depth <= max_depth && len(frontier) > 0; depth++ {
+    level, err := read(frontier, edges)
+    if err != nil {
+      return nil, err
+    }
+
+    next := []NodeID{}
+    for _, id := range(frontier) {
+      for _, target := range(level[id]) {
+        _, visited := depths[target]
+        if visited == false {
+          err := visitNode(len(depths), max_visited)
+          if err != nil {
+            return nil, err
+          }
+          depths[target] = depth
+          next = append(next, target)
+        }
+      }
+    }
+    frontier = next
+  }
+
+  return depths, nil
+}
+
+// Find a shortest path from one node to another by following the edge fields,
+// returns nil if there isn't a path of at most max_depth edges.
+// Each level of edges is read at once, and the query fails with PathLimitError if it would reach more than max_visited nodes(unlimited if not positive).
+func FindPath(read EdgeReader, from NodeID, to NodeID, edges []string, max_depth int, max_visited int) ([]NodeID, error) {
+  previous := map[NodeID]NodeID{
+    from: from,
+  }
+
+  frontier := []NodeID{from}
+  for depth := 0; depth <= max_depth && len(frontier) > 0; depth++ {
+    if slices.Contains(frontier, to) {
+      id := to
+      path := []NodeID{id}
+      for id != from {
+        id = previous[id]
+        path = append(path, id)
+      }
+      slices.Reverse(path)
+      return path, nil
+    } else if depth == max_depth {
+      break
+    }
+
+    level, err := read(frontier, edges)
+    if err != nil {
+      return nil, err
+    }
+
+    next := []NodeID{}
+    for _, id := range(frontier) {
+      for _, target := range(level[id]) {
+        _, visited := previous[target]
+        if visited == false {
+          err := visitNode(len(previous), max_visited)
+          if err != nil {
+            return nil, err
+          }
+          previous[target] = id
+          next = append(next, target)
+        }
+      }
+    }
+    frontier = next
+  }
+
+  return nil, nil
+}
+
+// EdgeReader that sends ReadSignals from the GQL server node to every node at once
+func gqlEdgeReader(ctx *ResolveContext) EdgeReader {
+  return func(ids []NodeID, fields []string) (map[NodeID][]NodeID, error) {
+    reads := make([]batchedRead, 0, len(ids))
+    defer func() {
+      for _, read := range(reads) {
+        ctx.Ext.FreeResponseChannel(read.id)
+      }
+    }()
+
+    for _, id := range(ids) {
+      read_signal := NewReadSignal(fields)
+      response_chan := ctx.Ext.GetResponseChannel(read_signal.ID())
+      reads = append(reads, batchedRead{id, read_signal.ID(), response_chan})
+
+      err := ctx.Context.Send(ctx.Server, []Message{{id, read_signal}})
+      if err != nil {
+        return nil, err
+      }
+    }
+
+    edges := map[NodeID][]NodeID{}
+    deadline := time.Now().Add(GQL_READ_TIMEOUT)
+    for _, read := range(reads) {
+      // Responses that arrived before the deadline are still read after it
+      timeout := max(time.Until(deadline), time.Millisecond)
+      response, _, err := WaitForResponse(read.response, timeout, read.id)
+      if err != nil {
+        return nil, fmt.Errorf("Failed to read edges of %s: %w", read.node, err)
+      }
+
+      targets, err := readResultEdges(read.node, fields, response)
+      if err != nil {
+        return nil, err
+      }
+      edges[read.node] = targets
+    }
+    return edges, nil
+  }
+}
+
+// GQL query fields for path queries, depth is limited to max_depth and each query can visit at most max_visited nodes
+func pathGQLFields(ctx *Context, max_depth int, max_visited int) graphql.Fields {
+  id_type := ctx.Types[reflect.TypeFor[NodeID]()].Type
+
+  getArgs := func(p graphql.ResolveParams) ([]string, int, error) {
+    edges, err := ExtractList[string](p, "edges")
+    if err != nil {
+      return nil, 0, err
+    }
+
+    depth, err := ExtractParam[int](p, "depth")
+    if err != nil {
+      return nil, 0, err
+    } else if depth < 0 || depth > max_depth {
+      return nil, 0, fmt.Errorf("depth must be between 0 and %d", max_depth)
+    }
+
+    return edges, depth, nil
+  }
+
+  return graphql.Fields{
+    "Reachable": &graphql.Field{
+      Type: graphql.NewList(id_type),
+      Args: graphql.FieldConfigArgument{
+        "id": &graphql.ArgumentConfig{
+          Type: id_type,
+        },
+        "edges": &graphql.ArgumentConfig{
+          Type: graphql.NewList(graphql.String),
+        },
+        "depth": &graphql.ArgumentConfig{
+          Type: graphql.Int,
+          DefaultValue: max_depth,
+        },
+      },
+      Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+        ctx, err := PrepResolve(p)
+        if err != nil {
+          return nil, err
+        }
+
+        id, err := ExtractParam[NodeID](p, "id")
+        if err != nil {
+          return nil, err
+        }
+
+        edges, depth, err := getArgs(p)
+        if err != nil {
+          return nil, err
+        }
+
+        depths, err := Reachable(gqlEdgeReader(ctx), id, edges, depth, max_visited)
+        if err != nil {
+          return nil, err
+        }
+
+        ids := make([]NodeID, 0, len(depths))
+        for reached := range(depths) {
+          if reached != id {
+            ids = append(ids, reached)
+          }
+        }
+        return ids, nil
+      },
+    },
+    "Path": &graphql.Field{
+      Type: graphql.NewList(id_type),
+      Args: graphql.FieldConfigArgument{
+        "from": &graphql.ArgumentConfig{
+          Type: id_type,
+        },
+        "to": &graphql.ArgumentConfig{
+          Type: id_type,
+        },
+        "edges": &graphql.ArgumentConfig{
+          Type: graphql.NewList(graphql.String),
+        },
+        "depth": &graphql.ArgumentConfig{
+          Type: graphql.Int,
+          DefaultValue: max_depth,
+        },
+      },
+      Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+        ctx, err := PrepResolve(p)
+        if err != nil {
+          return nil, err
+        }
+
+        from, err := ExtractParam[NodeID](p, "from")
+        if err != nil {
+          return nil, err
+        }
+
+        to, err := ExtractParam[NodeID](p, "to")
+        if err != nil {
+          return nil, err
+        }
+
+        edges, depth, err := getArgs(p)
+        if err != nil {
+          return nil, err
+        }
+
+        return FindPath(gqlEdgeReader(ctx), from, to, edges, depth, max_visited)
+      },
+    },
+  }
+}
@@ -0,0 +1,100 @@
+package graphvent
+
+import (
+  "errors"
+  "fmt"
+  "sync"
+)
+
+// Most nodes Preload reads from the DB at once when it isn't given a limit
+const PRELOAD_PARALLEL = 8
+
+// Load nodes and start them before traffic arrives, so the first signals sent to them after a deploy don't wait on the DB.
+// Up to parallel nodes are read at once, or PRELOAD_PARALLEL if parallel isn't positive. Nodes that are already loaded or don't exist are skipped.
+// Every node is tried, and the first error from the nodes that couldn't be loaded is returned with the nodes that were.
+func (ctx *Context) Preload(parallel int, ids ...NodeID) ([]NodeID, error) {
+  if parallel <= 0 {
+    parallel = PRELOAD_PARALLEL
+  }
+
+  ctx.nodesLock.RLock()
+  pending := make([]NodeID, 0, len(ids))
+  seen := map[NodeID]bool{}
+  for _, id := range(ids) {
+    _, loaded := ctx.nodes[id]
+    if loaded == false && seen[id] == false {
+      pending = append(pending, id)
+      seen[id] = true
+    }
+  }
+  ctx.nodesLock.RUnlock()
+
+  ctx.Log.Logf("node", "Preloading %d nodes, %d at a time", len(pending), parallel)
+
+  // Read the nodes and their keys without holding the nodes lock, so signals to loaded nodes aren't held up
+  nodes := make([]*Node, len(pending))
+  errs := make([]error, len(pending))
+  slots := make(chan struct{}, parallel)
+  var wg sync.WaitGroup
+  for i, id := range(pending) {
+    wg.Add(1)
+    slots <- struct{}{}
+    go func(i int, id NodeID) {
+      defer wg.Done()
+      defer func() { <-slots }()
+
+      node, err := ctx.DB.LoadNode(ctx, id)
+      if err == nil {
+        err = ctx.loadNodeKey(node)
+      }
+      nodes[i], errs[i] = node, err
+    }(i, id)
+  }
+  wg.Wait()
+
+  ctx.nodesLock.Lock()
+  loaded := make([]NodeID, 0, len(pending))
+  var first_err error
+  for i, id := range(pending) {
+    err := errs[i]
+    if err == nil {
+      // A signal may have loaded the node while it was being read
+      _, exists := ctx.nodes[id]
+      if exists {
+        continue
+      }
+      err = ctx.addNode(id, nodes[i])
+    }
+
+    if errors.Is(err, NodeNotFoundError) {
+      ctx.Log.Logf("node", "Skipping missing node %s in preload", id)
+    } else if err != nil {
+      ctx.Log.Logf("node", "PRELOAD_ERR: %s - %s", id, err)
+      if first_err == nil {
+        first_err = fmt.Errorf("Failed to preload %s: %w", id, err)
+      }
+    } else {
+      loaded = append(loaded, id)
+    }
+  }
+  ctx.nodesLock.Unlock()
+
+  ctx.evictColdNodes(loaded...)
+  return loaded, first_err
+}
+
+// Preload every node in the DB that selector returns true for
+func (ctx *Context) PreloadWhere(parallel int, selector func(NodeID) bool) ([]NodeID, error) {
+  ids, err := ctx.DB.LoadNodeIDs(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  selected := make([]NodeID, 0, len(ids))
+  for _, id := range(ids) {
+    if selector(id) {
+      selected = append(selected, id)
+    }
+  }
+  return ctx.Preload(parallel, selected...)
+}
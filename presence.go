@@ -0,0 +1,151 @@
+package graphvent
+
+import (
+  "fmt"
+  "slices"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+var presenceExtType = ExtTypeFor[PresenceExt]()
+
+// Sent periodically by a member node to a node with a PresenceExt to stay online
+type HeartbeatSignal struct {
+  SignalHeader
+}
+func (signal HeartbeatSignal) String() string {
+  return fmt.Sprintf("HeartbeatSignal(%s)", signal.SignalHeader)
+}
+func NewHeartbeatSignal() *HeartbeatSignal {
+  return &HeartbeatSignal{
+    NewSignalHeader(),
+  }
+}
+
+// Sent by a node with a PresenceExt to itself when a member goes online or offline
+type PresenceSignal struct {
+  SignalHeader
+  Member NodeID `gv:"member"`
+  Online bool `gv:"online"`
+  // Unix time in nanoseconds of the member's last heartbeat
+  LastSeen int64 `gv:"last_seen"`
+}
+func (signal PresenceSignal) String() string {
+  return fmt.Sprintf("PresenceSignal(%s, %s, %t)", signal.SignalHeader, signal.Member, signal.Online)
+}
+func NewPresenceSignal(member NodeID, online bool, last_seen int64) *PresenceSignal {
+  return &PresenceSignal{
+    NewSignalHeader(),
+    member,
+    online,
+    last_seen,
+  }
+}
+
+// Queued by a PresenceExt to check whether a member has missed its heartbeat
+type PresenceCheckSignal struct {
+  SignalHeader
+  Member NodeID `gv:"member"`
+}
+func (signal PresenceCheckSignal) String() string {
+  return fmt.Sprintf("PresenceCheckSignal(%s, %s)", signal.SignalHeader, signal.Member)
+}
+func NewPresenceCheckSignal(member NodeID) *PresenceCheckSignal {
+  return &PresenceCheckSignal{
+    NewSignalHeader(),
+    member,
+  }
+}
+
+type Presence struct {
+  Online bool `gv:"online"`
+  // Unix time in nanoseconds of the last heartbeat
+  LastSeen int64 `gv:"last_seen"`
+}
+
+// Tracks which nodes are sending heartbeats to the node.
+// Members that don't send a heartbeat within Timeout of their last one are marked offline.
+type PresenceExt struct {
+  Timeout time.Duration `gv:"timeout" gql:"-"`
+  Members map[NodeID]Presence `gv:"members" gql:"-"`
+  // Members that are currently online, in the order they came online
+  Online []NodeID `gv:"online" gql:"Online"`
+
+  // Map from members to their pending PresenceCheckSignal
+  checks map[NodeID]uuid.UUID
+}
+
+func NewPresenceExt(timeout time.Duration) *PresenceExt {
+  return &PresenceExt{
+    Timeout: timeout,
+    Members: map[NodeID]Presence{},
+    Online: []NodeID{},
+  }
+}
+
+func (ext *PresenceExt) Load(ctx *Context, node *Node) error {
+  if ext.Members == nil {
+    ext.Members = map[NodeID]Presence{}
+  }
+  // Checks queued before the node was unloaded are still in its signal queue, they just can't be dequeued early
+  ext.checks = map[NodeID]uuid.UUID{}
+  return nil
+}
+
+func (ext *PresenceExt) Unload(ctx *Context, node *Node) {
+}
+
+func (ext *PresenceExt) Process(ctx *Context, node *Node, source NodeID, signal Signal) ([]Message, Changes) {
+  var messages []Message = nil
+  var changes Changes = nil
+
+  switch sig := signal.(type) {
+  case *HeartbeatSignal:
+    now := time.Now()
+    presence := ext.Members[source]
+    presence.LastSeen = now.UnixNano()
+    changes.Add(presenceExtType, ChangeSet, "members")
+
+    if presence.Online == false {
+      ctx.Log.Logf("presence", "%s online on %s", source, node.ID)
+      presence.Online = true
+      ext.Online = append(ext.Online, source)
+      changes.Add(presenceExtType, ChangeSet, "online")
+      messages = append(messages, Message{node.ID, NewPresenceSignal(source, true, presence.LastSeen)})
+    }
+    ext.Members[source] = presence
+
+    check_id, pending := ext.checks[source]
+    if pending {
+      node.DequeueSignal(check_id)
+    }
+    check := NewPresenceCheckSignal(source)
+    ext.checks[source] = check.ID()
+    node.QueueSignal(now.Add(ext.Timeout), check)
+
+  case *PresenceCheckSignal:
+    if source != node.ID {
+      break
+    }
+
+    presence, known := ext.Members[sig.Member]
+    if known == false || presence.Online == false {
+      break
+    } else if time.Now().UnixNano() - presence.LastSeen < int64(ext.Timeout) {
+      break
+    }
+
+    ctx.Log.Logf("presence", "%s offline on %s", sig.Member, node.ID)
+    presence.Online = false
+    ext.Members[sig.Member] = presence
+    ext.Online = slices.DeleteFunc(slices.Clone(ext.Online), func(id NodeID) bool {
+      return id == sig.Member
+    })
+    delete(ext.checks, sig.Member)
+    changes.Add(presenceExtType, ChangeSet, "members", "online")
+    messages = append(messages, Message{node.ID, NewPresenceSignal(sig.Member, false, presence.LastSeen)})
+  }
+
+  return messages, changes
+}
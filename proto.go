@@ -0,0 +1,915 @@
+package graphvent
+
+import (
+  "encoding"
+  "encoding/binary"
+  "fmt"
+  "math"
+  "net/http"
+  "reflect"
+  "slices"
+  "strconv"
+  "strings"
+)
+
+// Protocol buffers(proto3) encoding of the values Serialize writes, so services in other languages can produce and consume
+// signals with code generated from ProtoSchema instead of implementing the TypeStack framing.
+// Registered structs are messages numbered by the hash of their gv tags, so field numbers don't change as fields are added or removed.
+// Pointers are optional fields, slices and arrays are repeated fields, and maps are repeated entry messages with the key as field 1 and the value as field 2.
+// NodeIDs and UUIDs are 16 byte bytes fields, other types with MarshalText like time.Time are strings, and signed integers are zigzag encoded.
+// Interfaces and SerializedValues are TypedValue messages, with the value encoded as a message.
+// Values that aren't messages are field 1 of a message when they're encoded on their own or in a TypedValue.
+// Proto3 can't tell empty slices and maps from nil ones so both decode as nil, and repeated fields of slices or maps aren't supported.
+type ProtoFormat struct {}
+
+const (
+  protoVarint = uint64(0)
+  protoFixed64 = uint64(1)
+  protoBytes = uint64(2)
+  protoFixed32 = uint64(5)
+
+  // Field numbers 19000-19999 are reserved by protobuf
+  protoReservedStart = uint64(19000)
+  protoReservedEnd = uint64(20000)
+  protoMaxField = uint64(1 << 29 - 1)
+)
+
+// Field number of a struct field, from its gv tag hash
+func protoFieldNumber(tag FieldTag) uint64 {
+  number := uint64(tag) % (protoMaxField - (protoReservedEnd - protoReservedStart)) + 1
+  if number >= protoReservedStart {
+    number += protoReservedEnd - protoReservedStart
+  }
+  return number
+}
+
+type protoField struct {
+  Number uint64
+  Name string
+  Index []int
+  Type reflect.Type
+}
+
+// The fields of a registered struct's message, sorted by field number
+func protoMessageFields(ctx *Context, t reflect.Type) ([]protoField, error) {
+  info, registered := ctx.Types[t]
+  if registered == false || t.Kind() != reflect.Struct {
+    return nil, fmt.Errorf("Cannot map unregistered struct %s to a protobuf message", t)
+  }
+
+  fields := make([]protoField, 0, len(info.Fields))
+  numbers := map[uint64]string{}
+  for tag, field_info := range(info.Fields) {
+    name := t.FieldByIndex(field_info.Index).Tag.Get("gv")
+    number := protoFieldNumber(tag)
+    existing, collides := numbers[number]
+    if collides {
+      return nil, fmt.Errorf("Fields %s and %s of %s have the same protobuf field number %d", existing, name, t, number)
+    }
+    numbers[number] = name
+
+    fields = append(fields, protoField{
+      Number: number,
+      Name: name,
+      Index: field_info.Index,
+      Type: field_info.Type,
+    })
+  }
+  slices.SortFunc(fields, func(a, b protoField) int {
+    return int(a.Number) - int(b.Number)
+  })
+  return fields, nil
+}
+
+// Whether values of t are encoded as messages instead of single fields
+func protoMessage(t reflect.Type) bool {
+  switch {
+  case t == serializedValueType:
+    return true
+  case t.Kind() == reflect.Interface:
+    return true
+  case t.Kind() == reflect.Pointer:
+    return protoMessage(t.Elem())
+  case t.Kind() == reflect.Struct:
+    return t.Implements(textMarshalerType) == false
+  default:
+    return false
+  }
+}
+
+// Whether t is encoded as a repeated field, []byte and arrays of bytes like NodeID are single bytes fields
+func protoRepeated(t reflect.Type) bool {
+  switch t.Kind() {
+  case reflect.Slice:
+    return t != serializedValueType && t.Elem().Kind() != reflect.Uint8
+  case reflect.Array:
+    return t != uuidType && t != nodeIDType
+  default:
+    return false
+  }
+}
+
+// Wire type of a single value of t
+func protoWireType(t reflect.Type) uint64 {
+  if t == serializedValueType || t == uuidType || t == nodeIDType {
+    return protoBytes
+  } else if t.Implements(textMarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    return protoBytes
+  }
+
+  switch t.Kind() {
+  case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+       reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return protoVarint
+  case reflect.Float32:
+    return protoFixed32
+  case reflect.Float64:
+    return protoFixed64
+  case reflect.Pointer:
+    return protoWireType(t.Elem())
+  default:
+    return protoBytes
+  }
+}
+
+func appendProtoKey(data []byte, number uint64, wire uint64) []byte {
+  return binary.AppendUvarint(data, number << 3 | wire)
+}
+
+func appendProtoBytes(data []byte, number uint64, value []byte) []byte {
+  data = binary.AppendUvarint(appendProtoKey(data, number, protoBytes), uint64(len(value)))
+  return append(data, value...)
+}
+
+func protoZigzag(i int64) uint64 {
+  return uint64(i << 1) ^ uint64(i >> 63)
+}
+
+func protoUnzigzag(u uint64) int64 {
+  return int64(u >> 1) ^ -int64(u & 1)
+}
+
+func (ProtoFormat) Encode(ctx *Context, value reflect.Value) ([]byte, error) {
+  if protoMessage(value.Type()) {
+    return encodeProtoMessage(ctx, []byte{}, value)
+  }
+  return encodeProtoField(ctx, []byte{}, 1, value)
+}
+
+func (ProtoFormat) Decode(ctx *Context, data []byte, t reflect.Type) (reflect.Value, error) {
+  value := reflect.New(t).Elem()
+  var err error
+  if protoMessage(t) {
+    err = decodeProtoMessage(ctx, data, value)
+  } else {
+    err = decodeProtoWrapped(ctx, data, value)
+  }
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  return value, nil
+}
+
+// Append the fields of the message for value, nil values are empty messages
+func encodeProtoMessage(ctx *Context, data []byte, value reflect.Value) ([]byte, error) {
+  t := value.Type()
+  switch {
+  case t == serializedValueType:
+    if value.IsNil() {
+      return data, nil
+    }
+    inner, err := SerializedValue(value.Bytes()).Deserialize(ctx)
+    if err != nil {
+      return nil, err
+    }
+    return encodeProtoTyped(ctx, data, inner)
+
+  case t.Kind() == reflect.Interface:
+    if value.IsNil() {
+      return data, nil
+    }
+    return encodeProtoTyped(ctx, data, value.Elem())
+
+  case t.Kind() == reflect.Pointer:
+    if value.IsNil() {
+      return data, nil
+    }
+    return encodeProtoMessage(ctx, data, value.Elem())
+
+  default:
+    fields, err := protoMessageFields(ctx, t)
+    if err != nil {
+      return nil, err
+    }
+    for _, field := range(fields) {
+      field_value, set := structField(value, field.Index)
+      if set == false {
+        continue
+      }
+      data, err = encodeProtoField(ctx, data, field.Number, field_value)
+      if err != nil {
+        return nil, err
+      }
+    }
+    return data, nil
+  }
+}
+
+// Append a TypedValue message for value, with its type stack as field 1 and its value as field 2
+func encodeProtoTyped(ctx *Context, data []byte, value reflect.Value) ([]byte, error) {
+  stack, err := typeStackNames(ctx, value.Type())
+  if err != nil {
+    return nil, err
+  }
+
+  for _, name := range(stack) {
+    switch name := name.(type) {
+    case string:
+      data = appendProtoBytes(data, 1, []byte(name))
+    case uint64:
+      data = appendProtoBytes(data, 1, []byte(strconv.FormatUint(name, 10)))
+    }
+  }
+
+  var body []byte
+  if protoMessage(value.Type()) {
+    body, err = encodeProtoMessage(ctx, []byte{}, value)
+  } else {
+    body, err = encodeProtoField(ctx, []byte{}, 1, value)
+  }
+  if err != nil {
+    return nil, err
+  }
+  return appendProtoBytes(data, 2, body), nil
+}
+
+// Append value as the field number, which is repeated for slices, arrays, and maps
+func encodeProtoField(ctx *Context, data []byte, number uint64, value reflect.Value) ([]byte, error) {
+  t := value.Type()
+  if protoRepeated(t) {
+    if protoRepeated(t.Elem()) || t.Elem().Kind() == reflect.Map {
+      return nil, fmt.Errorf("Cannot encode %s as protobuf, repeated fields can't contain slices or maps", t)
+    }
+
+    for i := 0; i < value.Len(); i++ {
+      elem := value.Index(i)
+      switch elem.Kind() {
+      case reflect.Pointer, reflect.Interface:
+        if elem.IsNil() {
+          return nil, fmt.Errorf("Cannot encode nil element %d of %s as protobuf", i, t)
+        }
+      }
+
+      var err error
+      data, err = encodeProtoValue(ctx, data, number, elem)
+      if err != nil {
+        return nil, err
+      }
+    }
+    return data, nil
+  } else if t.Kind() == reflect.Map {
+    if protoRepeated(t.Elem()) || t.Elem().Kind() == reflect.Map {
+      return nil, fmt.Errorf("Cannot encode %s as protobuf, map values can't be slices or maps", t)
+    }
+
+    iter := value.MapRange()
+    for iter.Next() {
+      entry, err := encodeProtoValue(ctx, []byte{}, 1, iter.Key())
+      if err != nil {
+        return nil, err
+      }
+      entry, err = encodeProtoValue(ctx, entry, 2, iter.Value())
+      if err != nil {
+        return nil, err
+      }
+      data = appendProtoBytes(data, number, entry)
+    }
+    return data, nil
+  }
+
+  return encodeProtoValue(ctx, data, number, value)
+}
+
+// Append a single value as the field number, nil values are left out
+func encodeProtoValue(ctx *Context, data []byte, number uint64, value reflect.Value) ([]byte, error) {
+  t := value.Type()
+  switch t {
+  case serializedValueType:
+    if value.IsNil() {
+      return data, nil
+    }
+    body, err := encodeProtoMessage(ctx, []byte{}, value)
+    if err != nil {
+      return nil, err
+    }
+    return appendProtoBytes(data, number, body), nil
+  case uuidType, nodeIDType:
+    id := make([]byte, 16)
+    for i := 0; i < 16; i++ {
+      id[i] = byte(value.Index(i).Uint())
+    }
+    return appendProtoBytes(data, number, id), nil
+  }
+
+  if t.Implements(textMarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    text, err := value.Interface().(encoding.TextMarshaler).MarshalText()
+    if err != nil {
+      return nil, err
+    }
+    return appendProtoBytes(data, number, text), nil
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    data = appendProtoKey(data, number, protoVarint)
+    if value.Bool() {
+      return append(data, 1), nil
+    }
+    return append(data, 0), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return binary.AppendUvarint(appendProtoKey(data, number, protoVarint), protoZigzag(value.Int())), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return binary.AppendUvarint(appendProtoKey(data, number, protoVarint), value.Uint()), nil
+  case reflect.Float32:
+    return binary.LittleEndian.AppendUint32(appendProtoKey(data, number, protoFixed32), math.Float32bits(float32(value.Float()))), nil
+  case reflect.Float64:
+    return binary.LittleEndian.AppendUint64(appendProtoKey(data, number, protoFixed64), math.Float64bits(value.Float())), nil
+  case reflect.String:
+    return appendProtoBytes(data, number, []byte(value.String())), nil
+
+  case reflect.Slice:
+    if t.Elem().Kind() != reflect.Uint8 {
+      return nil, fmt.Errorf("Cannot encode %s as protobuf, repeated fields can't contain slices or maps", t)
+    } else if value.IsNil() {
+      return data, nil
+    }
+    return appendProtoBytes(data, number, value.Bytes()), nil
+
+  case reflect.Pointer:
+    if value.IsNil() {
+      return data, nil
+    }
+    return encodeProtoValue(ctx, data, number, value.Elem())
+
+  case reflect.Struct, reflect.Interface:
+    if t.Kind() == reflect.Interface && value.IsNil() {
+      return data, nil
+    }
+    body, err := encodeProtoMessage(ctx, []byte{}, value)
+    if err != nil {
+      return nil, err
+    }
+    return appendProtoBytes(data, number, body), nil
+
+  default:
+    return nil, fmt.Errorf("Don't know how to encode %s as protobuf", t)
+  }
+}
+
+// A field read from an encoded message, Uint has the value of varint and fixed fields and Bytes has the value of length delimited ones
+type protoItem struct {
+  Number uint64
+  Wire uint64
+  Uint uint64
+  Bytes []byte
+}
+
+func readProtoUint(data []byte, wire uint64) (uint64, []byte, error) {
+  switch wire {
+  case protoVarint:
+    value, length := binary.Uvarint(data)
+    if length <= 0 {
+      return 0, nil, fmt.Errorf("Invalid protobuf varint")
+    }
+    return value, data[length:], nil
+  case protoFixed64:
+    if len(data) < 8 {
+      return 0, nil, fmt.Errorf("Not enough data to decode protobuf fixed64")
+    }
+    return binary.LittleEndian.Uint64(data), data[8:], nil
+  case protoFixed32:
+    if len(data) < 4 {
+      return 0, nil, fmt.Errorf("Not enough data to decode protobuf fixed32")
+    }
+    return uint64(binary.LittleEndian.Uint32(data)), data[4:], nil
+  default:
+    return 0, nil, fmt.Errorf("Protobuf wire type %d is not a number", wire)
+  }
+}
+
+func readProtoItem(data []byte) (protoItem, []byte, error) {
+  key, length := binary.Uvarint(data)
+  if length <= 0 {
+    return protoItem{}, nil, fmt.Errorf("Invalid protobuf field key")
+  }
+  data = data[length:]
+
+  item := protoItem{
+    Number: key >> 3,
+    Wire: key & 0x7,
+  }
+  if item.Number == 0 {
+    return protoItem{}, nil, fmt.Errorf("Invalid protobuf field number 0")
+  }
+
+  var err error
+  switch item.Wire {
+  case protoVarint, protoFixed64, protoFixed32:
+    item.Uint, data, err = readProtoUint(data, item.Wire)
+    if err != nil {
+      return protoItem{}, nil, err
+    }
+  case protoBytes:
+    size, length := binary.Uvarint(data)
+    if length <= 0 || size > uint64(len(data) - length) {
+      return protoItem{}, nil, fmt.Errorf("Not enough data to decode protobuf field %d", item.Number)
+    }
+    item.Bytes = data[length:length + int(size)]
+    data = data[length + int(size):]
+  default:
+    return protoItem{}, nil, fmt.Errorf("Unsupported protobuf wire type %d for field %d", item.Wire, item.Number)
+  }
+  return item, data, nil
+}
+
+// Decode each field of a message into the value returned by target for its number, skipping unknown fields
+func decodeProtoFields(ctx *Context, data []byte, target func(number uint64) (reflect.Value, bool)) error {
+  counts := map[uint64]int{}
+  for len(data) > 0 {
+    item, left, err := readProtoItem(data)
+    if err != nil {
+      return err
+    }
+    data = left
+
+    value, known := target(item.Number)
+    if known == false {
+      continue
+    }
+
+    count := counts[item.Number]
+    err = decodeProtoField(ctx, item, value, &count)
+    if err != nil {
+      return err
+    }
+    counts[item.Number] = count
+  }
+  return nil
+}
+
+// Decode the message for a value that isn't a message, from field 1
+func decodeProtoWrapped(ctx *Context, data []byte, value reflect.Value) error {
+  return decodeProtoFields(ctx, data, func(number uint64) (reflect.Value, bool) {
+    return value, number == 1
+  })
+}
+
+func decodeProtoMessage(ctx *Context, data []byte, value reflect.Value) error {
+  t := value.Type()
+  switch {
+  case t == serializedValueType:
+    inner, err := decodeProtoTyped(ctx, data)
+    if err != nil {
+      return err
+    }
+    serialized, err := SerializeAny(ctx, inner)
+    if err != nil {
+      return err
+    }
+    value.SetBytes(serialized)
+    return nil
+
+  case t.Kind() == reflect.Interface:
+    inner, err := decodeProtoTyped(ctx, data)
+    if err != nil {
+      return err
+    } else if inner.Type().AssignableTo(t) == false {
+      return fmt.Errorf("Cannot assign decoded %s to %s", inner.Type(), t)
+    }
+    value.Set(inner)
+    return nil
+
+  case t.Kind() == reflect.Pointer:
+    elem := reflect.New(t.Elem())
+    err := decodeProtoMessage(ctx, data, elem.Elem())
+    if err != nil {
+      return err
+    }
+    value.Set(elem)
+    return nil
+
+  default:
+    fields, err := protoMessageFields(ctx, t)
+    if err != nil {
+      return err
+    }
+    by_number := map[uint64]protoField{}
+    for _, field := range(fields) {
+      by_number[field.Number] = field
+    }
+    return decodeProtoFields(ctx, data, func(number uint64) (reflect.Value, bool) {
+      field, known := by_number[number]
+      if known == false {
+        return reflect.Value{}, false
+      }
+      return settableField(value, field.Index), true
+    })
+  }
+}
+
+func decodeProtoTyped(ctx *Context, data []byte) (reflect.Value, error) {
+  names := []any{}
+  var body []byte
+  for len(data) > 0 {
+    item, left, err := readProtoItem(data)
+    if err != nil {
+      return reflect.Value{}, err
+    }
+    data = left
+
+    if item.Number != 1 && item.Number != 2 {
+      continue
+    } else if item.Wire != protoBytes {
+      return reflect.Value{}, fmt.Errorf("Expected bytes for TypedValue field %d, got wire type %d", item.Number, item.Wire)
+    }
+
+    if item.Number == 2 {
+      body = item.Bytes
+      continue
+    }
+
+    // Type names never start with a digit, so any name that parses is an array length
+    length, err := strconv.ParseUint(string(item.Bytes), 10, 64)
+    if err == nil {
+      names = append(names, length)
+    } else {
+      names = append(names, string(item.Bytes))
+    }
+  }
+
+  t, err := unwrapStackNames(ctx, names)
+  if err != nil {
+    return reflect.Value{}, err
+  }
+
+  value := reflect.New(t).Elem()
+  if protoMessage(t) {
+    err = decodeProtoMessage(ctx, body, value)
+  } else {
+    err = decodeProtoWrapped(ctx, body, value)
+  }
+  if err != nil {
+    return reflect.Value{}, err
+  }
+  return value, nil
+}
+
+// Decode a field into value, appending to it if it's repeated. count is the number of elements already decoded into arrays.
+func decodeProtoField(ctx *Context, item protoItem, value reflect.Value, count *int) error {
+  t := value.Type()
+  add := func(elem reflect.Value) error {
+    if t.Kind() == reflect.Array {
+      if *count >= t.Len() {
+        return fmt.Errorf("More than %d protobuf elements for %s", t.Len(), t)
+      }
+      value.Index(*count).Set(elem)
+      *count += 1
+    } else {
+      value.Set(reflect.Append(value, elem))
+    }
+    return nil
+  }
+
+  if protoRepeated(t) {
+    wire := protoWireType(t.Elem())
+    if item.Wire == protoBytes && wire != protoBytes {
+      // Packed, which is the default for repeated numbers in proto3
+      for packed := item.Bytes; len(packed) > 0; {
+        element := protoItem{
+          Number: item.Number,
+          Wire: wire,
+        }
+        var err error
+        element.Uint, packed, err = readProtoUint(packed, wire)
+        if err != nil {
+          return err
+        }
+
+        elem := reflect.New(t.Elem()).Elem()
+        err = decodeProtoValue(ctx, element, elem)
+        if err != nil {
+          return err
+        }
+        err = add(elem)
+        if err != nil {
+          return err
+        }
+      }
+      return nil
+    }
+
+    elem := reflect.New(t.Elem()).Elem()
+    err := decodeProtoValue(ctx, item, elem)
+    if err != nil {
+      return err
+    }
+    return add(elem)
+  } else if t.Kind() == reflect.Map {
+    if item.Wire != protoBytes {
+      return fmt.Errorf("Expected bytes for %s entry, got wire type %d", t, item.Wire)
+    }
+
+    key := reflect.New(t.Key()).Elem()
+    elem := reflect.New(t.Elem()).Elem()
+    err := decodeProtoFields(ctx, item.Bytes, func(number uint64) (reflect.Value, bool) {
+      switch number {
+      case 1:
+        return key, true
+      case 2:
+        return elem, true
+      default:
+        return reflect.Value{}, false
+      }
+    })
+    if err != nil {
+      return err
+    }
+
+    if value.IsNil() {
+      value.Set(reflect.MakeMap(t))
+    }
+    value.SetMapIndex(key, elem)
+    return nil
+  }
+
+  return decodeProtoValue(ctx, item, value)
+}
+
+func decodeProtoValue(ctx *Context, item protoItem, value reflect.Value) error {
+  t := value.Type()
+  wire := protoWireType(t)
+  if item.Wire != wire {
+    return fmt.Errorf("Expected protobuf wire type %d for %s, got %d", wire, t, item.Wire)
+  }
+
+  switch t {
+  case serializedValueType:
+    return decodeProtoMessage(ctx, item.Bytes, value)
+  case uuidType, nodeIDType:
+    if len(item.Bytes) != 16 {
+      return fmt.Errorf("%s is %d bytes instead of 16", t, len(item.Bytes))
+    }
+    reflect.Copy(value, reflect.ValueOf(item.Bytes))
+    return nil
+  }
+
+  if t.Implements(textMarshalerType) && reflect.PointerTo(t).Implements(textUnmarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    return value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(item.Bytes)
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    value.SetBool(item.Uint != 0)
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    i := protoUnzigzag(item.Uint)
+    if value.OverflowInt(i) {
+      return fmt.Errorf("Protobuf integer %d overflows %s", i, t)
+    }
+    value.SetInt(i)
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    if value.OverflowUint(item.Uint) {
+      return fmt.Errorf("Protobuf integer %d overflows %s", item.Uint, t)
+    }
+    value.SetUint(item.Uint)
+  case reflect.Float32:
+    value.SetFloat(float64(math.Float32frombits(uint32(item.Uint))))
+  case reflect.Float64:
+    value.SetFloat(math.Float64frombits(item.Uint))
+  case reflect.String:
+    value.SetString(string(item.Bytes))
+
+  case reflect.Slice:
+    if t.Elem().Kind() != reflect.Uint8 {
+      return fmt.Errorf("Cannot decode %s from protobuf, repeated fields can't contain slices or maps", t)
+    }
+    value.SetBytes(append([]byte{}, item.Bytes...))
+
+  case reflect.Pointer:
+    elem := reflect.New(t.Elem())
+    err := decodeProtoValue(ctx, item, elem.Elem())
+    if err != nil {
+      return err
+    }
+    value.Set(elem)
+
+  case reflect.Struct, reflect.Interface:
+    return decodeProtoMessage(ctx, item.Bytes, value)
+
+  default:
+    return fmt.Errorf("Don't know how to decode %s from protobuf", t)
+  }
+  return nil
+}
+
+// Builds the .proto definitions of messages, naming each after its Go type
+type protoSchema struct {
+  ctx *Context
+  names map[string]reflect.Type
+  queue []reflect.Type
+}
+
+// Name of the message for a registered struct, queueing its definition the first time it's used
+func (schema *protoSchema) message(t reflect.Type) (string, error) {
+  existing, named := schema.names[t.Name()]
+  if named && existing != t {
+    return "", fmt.Errorf("%s and %s would both be protobuf message %s", existing, t, t.Name())
+  } else if named == false {
+    schema.names[t.Name()] = t
+    schema.queue = append(schema.queue, t)
+  }
+  return t.Name(), nil
+}
+
+// Protobuf type of a single value of t
+func (schema *protoSchema) scalar(t reflect.Type) (string, error) {
+  switch t {
+  case serializedValueType:
+    return "TypedValue", nil
+  case uuidType, nodeIDType:
+    return "bytes", nil
+  }
+  if t.Implements(textMarshalerType) && t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+    return "string", nil
+  }
+
+  switch t.Kind() {
+  case reflect.Bool:
+    return "bool", nil
+  case reflect.Int8, reflect.Int16, reflect.Int32:
+    return "sint32", nil
+  case reflect.Int, reflect.Int64:
+    return "sint64", nil
+  case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+    return "uint32", nil
+  case reflect.Uint, reflect.Uint64:
+    return "uint64", nil
+  case reflect.Float32:
+    return "float", nil
+  case reflect.Float64:
+    return "double", nil
+  case reflect.String:
+    return "string", nil
+  case reflect.Slice:
+    if t.Elem().Kind() == reflect.Uint8 {
+      return "bytes", nil
+    }
+  case reflect.Interface:
+    return "TypedValue", nil
+  case reflect.Pointer:
+    return schema.scalar(t.Elem())
+  case reflect.Struct:
+    return schema.message(t)
+  }
+  return "", fmt.Errorf("Cannot map %s to a protobuf type", t)
+}
+
+func protoIdentifier(name string) string {
+  identifier := strings.Map(func(r rune) rune {
+    if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+      return r
+    }
+    return '_'
+  }, name)
+  if identifier == "" || (identifier[0] >= '0' && identifier[0] <= '9') {
+    return "f_" + identifier
+  }
+  return identifier
+}
+
+// Write the definition of a message for a registered struct
+func (schema *protoSchema) define(builder *strings.Builder, t reflect.Type) error {
+  fields, err := protoMessageFields(schema.ctx, t)
+  if err != nil {
+    return err
+  }
+
+  lines := []string{}
+  for _, field := range(fields) {
+    name := protoIdentifier(field.Name)
+    switch {
+    case protoRepeated(field.Type):
+      if protoRepeated(field.Type.Elem()) || field.Type.Elem().Kind() == reflect.Map {
+        return fmt.Errorf("Cannot map %s.%s to protobuf, repeated fields can't contain slices or maps", t, field.Name)
+      }
+      elem, err := schema.scalar(field.Type.Elem())
+      if err != nil {
+        return err
+      }
+      lines = append(lines, fmt.Sprintf("  repeated %s %s = %d;", elem, name, field.Number))
+
+    case field.Type.Kind() == reflect.Map:
+      if protoRepeated(field.Type.Elem()) || field.Type.Elem().Kind() == reflect.Map {
+        return fmt.Errorf("Cannot map %s.%s to protobuf, map values can't be slices or maps", t, field.Name)
+      }
+      key, err := schema.scalar(field.Type.Key())
+      if err != nil {
+        return err
+      }
+      elem, err := schema.scalar(field.Type.Elem())
+      if err != nil {
+        return err
+      }
+
+      switch key {
+      case "bool", "string", "sint32", "sint64", "uint32", "uint64":
+        lines = append(lines, fmt.Sprintf("  map<%s, %s> %s = %d;", key, elem, name, field.Number))
+      default:
+        // Other key types aren't allowed in map fields, but entry messages have the same encoding
+        entry := ""
+        for _, part := range(strings.Split(name, "_")) {
+          if part != "" {
+            entry += strings.ToUpper(part[:1]) + part[1:]
+          }
+        }
+        entry += "Entry"
+        lines = append(lines, fmt.Sprintf("  message %s {\n    %s key = 1;\n    %s value = 2;\n  }", entry, key, elem))
+        lines = append(lines, fmt.Sprintf("  repeated %s %s = %d;", entry, name, field.Number))
+      }
+
+    default:
+      scalar, err := schema.scalar(field.Type)
+      if err != nil {
+        return err
+      }
+      label := ""
+      if field.Type.Kind() == reflect.Pointer && protoMessage(field.Type) == false {
+        label = "optional "
+      }
+      lines = append(lines, fmt.Sprintf("  %s%s %s = %d;", label, scalar, name, field.Number))
+    }
+  }
+
+  fmt.Fprintf(builder, "\n// %s\nmessage %s {\n", t, t.Name())
+  for _, line := range(lines) {
+    builder.WriteString(line)
+    builder.WriteString("\n")
+  }
+  builder.WriteString("}\n")
+  return nil
+}
+
+// Generate a proto3 file with a message for every registered signal type and the structs they contain,
+// for generating code that reads and writes signals with ProtoFormat.
+func (ctx *Context) ProtoSchema() (string, error) {
+  signal_iface := reflect.TypeFor[Signal]()
+  signal_types := []reflect.Type{}
+  for reflect_type := range(ctx.Types) {
+    if reflect_type.Kind() == reflect.Struct && reflect.PointerTo(reflect_type).Implements(signal_iface) {
+      signal_types = append(signal_types, reflect_type)
+    }
+  }
+  slices.SortFunc(signal_types, func(a, b reflect.Type) int {
+    return strings.Compare(a.String(), b.String())
+  })
+
+  schema := &protoSchema{
+    ctx: ctx,
+    names: map[string]reflect.Type{},
+  }
+  for _, signal_type := range(signal_types) {
+    _, err := schema.message(signal_type)
+    if err != nil {
+      return "", err
+    }
+  }
+
+  builder := &strings.Builder{}
+  builder.WriteString("syntax = \"proto3\";\n\npackage graphvent;\n\n")
+  builder.WriteString("// An interface or SerializedValue. type is the type stack, with registered type names and array lengths in decimal,\n")
+  builder.WriteString("// and value is the encoded message, or a message with the value as field 1 if it's not a message type.\n")
+  builder.WriteString("message TypedValue {\n  repeated string type = 1;\n  bytes value = 2;\n}\n")
+
+  // Messages queue the structs they use while they're defined
+  for i := 0; i < len(schema.queue); i++ {
+    err := schema.define(builder, schema.queue[i])
+    if err != nil {
+      return "", err
+    }
+  }
+  return builder.String(), nil
+}
+
+// Serve the schema from ProtoSchema
+func ProtoSchemaHandler(ctx *Context) func(http.ResponseWriter, *http.Request) {
+  return func(w http.ResponseWriter, r *http.Request) {
+    enableCORS(&w)
+    schema, err := ctx.ProtoSchema()
+    if err != nil {
+      ctx.Log.Logf("gql", "PROTO_SCHEMA_ERR: %s", err)
+      http.Error(w, err.Error(), http.StatusInternalServerError)
+      return
+    }
+    w.Header().Set("Content-Type", "text/plain")
+    w.Write([]byte(schema))
+  }
+}
@@ -0,0 +1,180 @@
+package graphvent
+
+import (
+  "fmt"
+  "reflect"
+)
+
+// Limits on nodes of a type, a zero limit is unlimited
+type Quota struct {
+  // Number of nodes of the type that can be created in the DB
+  Nodes int
+  // Number of extensions a node of the type can have
+  Extensions int
+  // Number of requirements a node of the type can have if it's lockable
+  Requirements int
+  // Number of lockables that can have a node of the type as a requirement
+  Dependencies int
+  // Serialized size in bytes of signals that can be delivered to a node of the type
+  SignalSize int
+  // Serialized size in bytes of each extension field, signals that change a field past it get a field_size error
+  FieldSize int
+}
+
+// Returned when a signal or extension field is larger than the size allowed by it's node type's quota
+type SizeLimitError struct {
+  What string
+  Size int
+  Limit int
+}
+
+func (err SizeLimitError) Error() string {
+  return fmt.Sprintf("%s is %d bytes, over the limit of %d", err.What, err.Size, err.Limit)
+}
+
+func (err SizeLimitError) Unwrap() error {
+  return QuotaExceededError
+}
+
+// Set the quota for a registered node type
+func SetQuota(ctx *Context, name string, quota Quota) error {
+  if quota.Nodes < 0 || quota.Extensions < 0 || quota.Requirements < 0 || quota.Dependencies < 0 || quota.SignalSize < 0 || quota.FieldSize < 0 {
+    return fmt.Errorf("Invalid quota for %s: %+v", name, quota)
+  }
+
+  err := ctx.checkNoNodesLoaded(fmt.Sprintf("set quota for %s", name))
+  if err != nil {
+    return err
+  }
+
+  node_type := NodeTypeFor(name)
+  node_info, exists := ctx.NodeTypes[node_type]
+  if exists == false {
+    return fmt.Errorf("Cannot set quota for unregistered node type %s", name)
+  }
+
+  node_info.Quota = quota
+  ctx.NodeTypes[node_type] = node_info
+  return nil
+}
+
+// Check the parts of the quota that don't need the DB before creating a node
+func (quota Quota) checkNode(node_type NodeType, extensions map[ExtType]Extension) error {
+  if quota.Extensions > 0 && len(extensions) > quota.Extensions {
+    return fmt.Errorf("%s nodes can have %d extensions, got %d: %w", node_type, quota.Extensions, len(extensions), QuotaExceededError)
+  }
+
+  if quota.Requirements > 0 {
+    lockable, is_lockable := extensions[lockableExtType].(*LockableExt)
+    if is_lockable && len(lockable.Requirements) > quota.Requirements {
+      return fmt.Errorf("%s nodes can have %d requirements, got %d: %w", node_type, quota.Requirements, len(lockable.Requirements), QuotaExceededError)
+    }
+  }
+
+  return nil
+}
+
+// Check the serialized size of a signal being delivered to a node of the type.
+// Responses aren't checked since the node asked for them.
+func (quota Quota) checkSignalSize(ctx *Context, signal Signal) error {
+  if quota.SignalSize <= 0 {
+    return nil
+  } else if _, is_response := signal.(ResponseSignal); is_response {
+    return nil
+  }
+
+  // Signals that can't be serialized never leave the process, so they aren't limited
+  size, err := SerializedSize(ctx, reflect.ValueOf(signal))
+  if err != nil {
+    return nil
+  } else if size > quota.SignalSize {
+    return SizeLimitError{signalTypeName(signal), size, quota.SignalSize}
+  }
+  return nil
+}
+
+// Check the serialized size of an extension field before it's written
+func (quota Quota) checkFieldSize(ctx *Context, ext_type ExtType, tag Tag, value reflect.Value) error {
+  if quota.FieldSize <= 0 {
+    return nil
+  }
+
+  size, err := SerializedSize(ctx, value)
+  if err != nil {
+    return err
+  } else if size > quota.FieldSize {
+    return SizeLimitError{fmt.Sprintf("%s.%s", ext_type, tag), size, quota.FieldSize}
+  }
+  return nil
+}
+
+// Serialize every extension field of the node, so the fields a signal changes can be restored if they're over the FieldSize quota
+func (node *Node) snapshotFields(ctx *Context) (map[ExtType]map[Tag][]byte, error) {
+  snapshot := map[ExtType]map[Tag][]byte{}
+  for ext_type, ext := range(node.Extensions) {
+    ext_value := reflect.ValueOf(ext).Elem()
+    fields := map[Tag][]byte{}
+    for tag, field_info := range(ctx.Extensions[ext_type].Fields) {
+      field_value := fieldOrZero(ext_value, field_info.Index)
+      size, err := SerializedSize(ctx, field_value)
+      if err != nil {
+        return nil, fmt.Errorf("Failed to size %s.%s: %w", ext_type, tag, err)
+      }
+
+      data := make([]byte, size)
+      written, err := SerializeValue(ctx, field_value, data)
+      if err != nil {
+        return nil, fmt.Errorf("Failed to serialize %s.%s: %w", ext_type, tag, err)
+      }
+      fields[tag] = data[:written]
+    }
+    snapshot[ext_type] = fields
+  }
+  return snapshot, nil
+}
+
+// Check the changed fields against the FieldSize quota when they're changed instead of when they're written,
+// restoring every changed field from before if any of them are over it
+func (node *Node) checkChangedFieldSizes(ctx *Context, changes Changes, before map[ExtType]map[Tag][]byte) error {
+  quota := ctx.NodeTypes[node.Type].Quota
+  by_extension := changes.ByExtension()
+
+  var size_err error = nil
+  for ext_type, tags := range(by_extension) {
+    ext_value := reflect.ValueOf(node.Extensions[ext_type]).Elem()
+    for _, tag := range(tags) {
+      field_info, exists := ctx.Extensions[ext_type].Fields[tag]
+      if exists == false {
+        continue
+      }
+      size_err = quota.checkFieldSize(ctx, ext_type, tag, fieldOrZero(ext_value, field_info.Index))
+      if size_err != nil {
+        break
+      }
+    }
+    if size_err != nil {
+      break
+    }
+  }
+  if size_err == nil {
+    return nil
+  }
+
+  for ext_type, tags := range(by_extension) {
+    ext_value := reflect.ValueOf(node.Extensions[ext_type]).Elem()
+    for _, tag := range(tags) {
+      field_info, exists := ctx.Extensions[ext_type].Fields[tag]
+      data, saved := before[ext_type][tag]
+      if exists == false || saved == false {
+        continue
+      }
+
+      value, _, err := DeserializeValue(ctx, data, field_info.Type)
+      if err != nil {
+        return fmt.Errorf("Failed to restore %s.%s after %s: %w", ext_type, tag, size_err, err)
+      }
+      settableField(ext_value, field_info.Index).Set(value)
+    }
+  }
+  return size_err
+}
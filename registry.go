@@ -0,0 +1,232 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "reflect"
+  "slices"
+  "strings"
+)
+
+var RegistryMismatchError = errors.New("Registries don't match")
+
+// A serialized field of a registered struct or extension
+type RegistryField struct {
+  Name string `json:"name"`
+  FieldTag string `json:"field_tag"`
+  Type string `json:"type"`
+}
+
+// A type registered in a context, with the hash it's serialized as
+type RegistryType struct {
+  Name string `json:"name"`
+  SerializedType string `json:"serialized_type"`
+  Kind string `json:"kind"`
+  Signal bool `json:"signal"`
+  // Fields of registered structs, sorted by name
+  Fields []RegistryField `json:"fields,omitempty"`
+}
+
+type RegistryExtension struct {
+  Name string `json:"name"`
+  ExtType string `json:"ext_type"`
+  Version uint64 `json:"version"`
+  Fields []RegistryField `json:"fields"`
+}
+
+type RegistryNodeType struct {
+  Name string `json:"name"`
+  NodeType string `json:"node_type"`
+  // Names of the extensions every node of the type has, sorted
+  Extensions []string `json:"extensions"`
+}
+
+// Everything a context has registered that affects what it can serialize, so two contexts can check they'll
+// understand each other's messages and records before exchanging them. Each list is sorted by name.
+type Registry struct {
+  Types []RegistryType `json:"types"`
+  Extensions []RegistryExtension `json:"extensions"`
+  NodeTypes []RegistryNodeType `json:"node_types"`
+}
+
+func registryFields(fields []reflect.StructField) []RegistryField {
+  registry_fields := []RegistryField{}
+  for _, field := range(fields) {
+    gv_tag := field.Tag.Get("gv")
+    registry_fields = append(registry_fields, RegistryField{gv_tag, GetFieldTag(gv_tag).String(), field.Type.String()})
+  }
+  slices.SortFunc(registry_fields, func(a, b RegistryField) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  return registry_fields
+}
+
+func (ctx *Context) ExportRegistry() Registry {
+  registry := Registry{
+    Types: []RegistryType{},
+    Extensions: []RegistryExtension{},
+    NodeTypes: []RegistryNodeType{},
+  }
+
+  for reflect_type, type_info := range(ctx.Types) {
+    entry := RegistryType{
+      Name: reflect_type.String(),
+      SerializedType: type_info.Serialized.String(),
+      Kind: reflect_type.Kind().String(),
+      Signal: reflect_type.Kind() == reflect.Struct && reflect.PointerTo(reflect_type).Implements(signalType),
+    }
+    if len(type_info.Fields) > 0 {
+      fields := []reflect.StructField{}
+      for _, field_info := range(type_info.Fields) {
+        fields = append(fields, reflect_type.FieldByIndex(field_info.Index))
+      }
+      entry.Fields = registryFields(fields)
+    }
+    registry.Types = append(registry.Types, entry)
+  }
+
+  for ext_type, ext_info := range(ctx.Extensions) {
+    fields := []reflect.StructField{}
+    for _, field_info := range(ext_info.Fields) {
+      fields = append(fields, ext_info.Type.FieldByIndex(field_info.Index))
+    }
+    registry.Extensions = append(registry.Extensions, RegistryExtension{
+      Name: ext_info.Type.String(),
+      ExtType: ext_type.String(),
+      Version: ext_info.Version,
+      Fields: registryFields(fields),
+    })
+  }
+
+  for node_type, node_info := range(ctx.NodeTypes) {
+    extensions := []string{}
+    for _, ext_type := range(node_info.RequiredExtensions) {
+      extensions = append(extensions, ctx.extensionName(ext_type))
+    }
+    slices.Sort(extensions)
+    registry.NodeTypes = append(registry.NodeTypes, RegistryNodeType{ctx.nodeTypeName(node_type), node_type.String(), extensions})
+  }
+
+  slices.SortFunc(registry.Types, func(a, b RegistryType) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  slices.SortFunc(registry.Extensions, func(a, b RegistryExtension) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  slices.SortFunc(registry.NodeTypes, func(a, b RegistryNodeType) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  return registry
+}
+
+// Describe the differences between two field lists
+func compareRegistryFields(what string, local []RegistryField, remote []RegistryField) []string {
+  problems := []string{}
+  remote_fields := map[string]RegistryField{}
+  for _, field := range(remote) {
+    remote_fields[field.Name] = field
+  }
+
+  for _, field := range(local) {
+    remote_field, exists := remote_fields[field.Name]
+    delete(remote_fields, field.Name)
+    if exists == false {
+      problems = append(problems, fmt.Sprintf("%s.%s is only registered locally", what, field.Name))
+    } else if remote_field != field {
+      problems = append(problems, fmt.Sprintf("%s.%s is %s(%s) locally and %s(%s) remotely", what, field.Name, field.Type, field.FieldTag, remote_field.Type, remote_field.FieldTag))
+    }
+  }
+  for name := range(remote_fields) {
+    problems = append(problems, fmt.Sprintf("%s.%s is only registered remotely", what, name))
+  }
+  return problems
+}
+
+// Compare a registry exported by another context with this one's, returning a RegistryMismatchError listing every difference
+func (ctx *Context) ValidateRegistry(remote Registry) error {
+  local := ctx.ExportRegistry()
+  problems := []string{}
+
+  remote_types := map[string]RegistryType{}
+  for _, remote_type := range(remote.Types) {
+    remote_types[remote_type.Name] = remote_type
+  }
+  for _, local_type := range(local.Types) {
+    remote_type, exists := remote_types[local_type.Name]
+    delete(remote_types, local_type.Name)
+    if exists == false {
+      problems = append(problems, fmt.Sprintf("type %s is only registered locally", local_type.Name))
+      continue
+    } else if remote_type.SerializedType != local_type.SerializedType {
+      problems = append(problems, fmt.Sprintf("type %s is serialized as %s locally and %s remotely", local_type.Name, local_type.SerializedType, remote_type.SerializedType))
+    } else if remote_type.Kind != local_type.Kind {
+      problems = append(problems, fmt.Sprintf("type %s is a %s locally and a %s remotely", local_type.Name, local_type.Kind, remote_type.Kind))
+    } else if remote_type.Signal != local_type.Signal {
+      problems = append(problems, fmt.Sprintf("type %s is a signal on only one side", local_type.Name))
+    }
+    problems = append(problems, compareRegistryFields("type " + local_type.Name, local_type.Fields, remote_type.Fields)...)
+  }
+  for name := range(remote_types) {
+    problems = append(problems, fmt.Sprintf("type %s is only registered remotely", name))
+  }
+
+  remote_extensions := map[string]RegistryExtension{}
+  for _, remote_ext := range(remote.Extensions) {
+    remote_extensions[remote_ext.Name] = remote_ext
+  }
+  for _, local_ext := range(local.Extensions) {
+    remote_ext, exists := remote_extensions[local_ext.Name]
+    delete(remote_extensions, local_ext.Name)
+    if exists == false {
+      problems = append(problems, fmt.Sprintf("extension %s is only registered locally", local_ext.Name))
+      continue
+    } else if remote_ext.ExtType != local_ext.ExtType {
+      problems = append(problems, fmt.Sprintf("extension %s is %s locally and %s remotely", local_ext.Name, local_ext.ExtType, remote_ext.ExtType))
+    } else if remote_ext.Version != local_ext.Version {
+      problems = append(problems, fmt.Sprintf("extension %s is version %d locally and %d remotely", local_ext.Name, local_ext.Version, remote_ext.Version))
+    }
+    problems = append(problems, compareRegistryFields("extension " + local_ext.Name, local_ext.Fields, remote_ext.Fields)...)
+  }
+  for name := range(remote_extensions) {
+    problems = append(problems, fmt.Sprintf("extension %s is only registered remotely", name))
+  }
+
+  remote_node_types := map[string]RegistryNodeType{}
+  for _, remote_node_type := range(remote.NodeTypes) {
+    remote_node_types[remote_node_type.Name] = remote_node_type
+  }
+  for _, local_node_type := range(local.NodeTypes) {
+    remote_node_type, exists := remote_node_types[local_node_type.Name]
+    delete(remote_node_types, local_node_type.Name)
+    if exists == false {
+      problems = append(problems, fmt.Sprintf("node type %s is only registered locally", local_node_type.Name))
+    } else if remote_node_type.NodeType != local_node_type.NodeType {
+      problems = append(problems, fmt.Sprintf("node type %s is %s locally and %s remotely", local_node_type.Name, local_node_type.NodeType, remote_node_type.NodeType))
+    } else if slices.Equal(remote_node_type.Extensions, local_node_type.Extensions) == false {
+      problems = append(problems, fmt.Sprintf("node type %s requires %v locally and %v remotely", local_node_type.Name, local_node_type.Extensions, remote_node_type.Extensions))
+    }
+  }
+  for name := range(remote_node_types) {
+    problems = append(problems, fmt.Sprintf("node type %s is only registered remotely", name))
+  }
+
+  if len(problems) > 0 {
+    slices.Sort(problems)
+    return fmt.Errorf("%w: %s", RegistryMismatchError, strings.Join(problems, ", "))
+  }
+  return nil
+}
+
+// Serve the context's Registry as JSON
+func RegistryHandler(ctx *Context) func(http.ResponseWriter, *http.Request) {
+  return func(w http.ResponseWriter, r *http.Request) {
+    enableCORS(&w)
+    w.Header().Set("Content-Type", "application/json")
+    err := json.NewEncoder(w).Encode(ctx.ExportRegistry())
+    if err != nil {
+      ctx.Log.Logf("gql", "REGISTRY_ERR: %s", err)
+    }
+  }
+}
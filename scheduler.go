@@ -0,0 +1,254 @@
+package graphvent
+
+import (
+  "fmt"
+  "sync"
+  "time"
+)
+
+// A nodeInbox holds the messages for a node that is run by a Scheduler
+type nodeInbox struct {
+  messages []Message
+  scheduled bool
+  woken bool
+  // Senders waiting for space with InboxBlock
+  blocked int
+  removed bool
+  timer *time.Timer
+  // Wakes the node to flush it's changed references
+  flush *time.Timer
+}
+
+// A Scheduler runs nodes on a fixed pool of workers instead of a goroutine per node.
+// A node is only queued when it has messages waiting or a queued signal is due,
+// and only one worker runs a node at a time so messages are processed in the order received.
+type Scheduler struct {
+  ctx *Context
+
+  // Maximum number of messages a worker processes for a node before moving on to the next ready node
+  BatchSize int
+
+  lock sync.Mutex
+  cond *sync.Cond
+  ready []*Node
+  stopping bool
+  workers sync.WaitGroup
+}
+
+// Create a new Scheduler and start its workers
+func NewScheduler(ctx *Context, workers int, batch_size int) (*Scheduler, error) {
+  if workers <= 0 {
+    return nil, fmt.Errorf("Scheduler needs at least 1 worker, got %d", workers)
+  }
+  if batch_size <= 0 {
+    return nil, fmt.Errorf("Scheduler batch size must be positive, got %d", batch_size)
+  }
+
+  scheduler := &Scheduler{
+    ctx: ctx,
+    BatchSize: batch_size,
+    ready: []*Node{},
+  }
+  scheduler.cond = sync.NewCond(&scheduler.lock)
+
+  for i := 0; i < workers; i++ {
+    scheduler.workers.Add(1)
+    go scheduler.worker()
+  }
+
+  return scheduler, nil
+}
+
+// Load the node and start scheduling it when it receives messages
+func (scheduler *Scheduler) Add(node *Node) error {
+  scheduler.lock.Lock()
+  messages := []Message{}
+  if node.inbox != nil {
+    // Keep the messages delivered while the node was stopped, when it's started again after failing to unload
+    messages = node.inbox.messages
+  }
+  node.inbox = &nodeInbox{
+    messages: messages,
+  }
+  scheduler.lock.Unlock()
+
+  err := node.load(scheduler.ctx)
+  if err != nil {
+    return err
+  }
+
+  // Run once to pick up any signals that were queued before the node was loaded
+  scheduler.wake(node, true)
+  return nil
+}
+
+// Wait for the node to finish running, then unload it
+func (scheduler *Scheduler) Remove(node *Node) {
+  scheduler.lock.Lock()
+  node.inbox.removed = true
+  // Wake senders blocked on the node's inbox
+  scheduler.cond.Broadcast()
+  for node.inbox.scheduled {
+    scheduler.cond.Wait()
+  }
+  if node.inbox.timer != nil {
+    node.inbox.timer.Stop()
+  }
+  if node.inbox.flush != nil {
+    node.inbox.flush.Stop()
+  }
+  scheduler.lock.Unlock()
+
+  node.unload(scheduler.ctx)
+}
+
+// Add a message to the node's inbox, and queue the node if it isn't already.
+// Returns false if the inbox is full and the config doesn't allow it to grow.
+func (scheduler *Scheduler) Deliver(node *Node, msg Message, config InboxConfig) bool {
+  scheduler.lock.Lock()
+  defer scheduler.lock.Unlock()
+
+  if config.Strategy == InboxBlock && len(node.inbox.messages) >= config.Capacity {
+    // Wait for a worker to take messages from the inbox, the timer wakes the wait at the deadline
+    expired := false
+    timer := time.AfterFunc(config.Deadline, func() {
+      scheduler.lock.Lock()
+      expired = true
+      scheduler.cond.Broadcast()
+      scheduler.lock.Unlock()
+    })
+    node.inbox.blocked += 1
+    for len(node.inbox.messages) >= config.Capacity && expired == false && node.inbox.removed == false && scheduler.stopping == false {
+      scheduler.cond.Wait()
+    }
+    node.inbox.blocked -= 1
+    timer.Stop()
+  }
+
+  if config.Strategy != InboxGrow && len(node.inbox.messages) >= config.Capacity {
+    return false
+  }
+
+  node.inbox.messages = append(node.inbox.messages, msg)
+  scheduler.queue(node, false)
+  return true
+}
+
+// Stop the workers after the nodes they're running have finished, safe to call more than once.
+// Nodes still waiting for a worker are dropped from the queue so Remove doesn't wait on them.
+func (scheduler *Scheduler) Stop() {
+  scheduler.lock.Lock()
+  scheduler.stopping = true
+  scheduler.cond.Broadcast()
+  scheduler.lock.Unlock()
+
+  scheduler.workers.Wait()
+
+  scheduler.lock.Lock()
+  for _, node := range(scheduler.ready) {
+    node.inbox.scheduled = false
+  }
+  scheduler.ready = nil
+  scheduler.cond.Broadcast()
+  scheduler.lock.Unlock()
+}
+
+func (scheduler *Scheduler) wake(node *Node, timeout bool) {
+  scheduler.lock.Lock()
+  scheduler.queue(node, timeout)
+  scheduler.lock.Unlock()
+}
+
+// Must be called with scheduler.lock held
+func (scheduler *Scheduler) queue(node *Node, timeout bool) {
+  if timeout {
+    node.inbox.woken = true
+  }
+
+  if node.inbox.scheduled == false && node.inbox.removed == false {
+    node.inbox.scheduled = true
+    scheduler.ready = append(scheduler.ready, node)
+    scheduler.cond.Broadcast()
+  }
+}
+
+func (scheduler *Scheduler) worker() {
+  defer scheduler.workers.Done()
+
+  scheduler.lock.Lock()
+  for {
+    for len(scheduler.ready) == 0 && scheduler.stopping == false {
+      scheduler.cond.Wait()
+    }
+
+    if scheduler.stopping {
+      scheduler.lock.Unlock()
+      return
+    }
+
+    node := scheduler.ready[0]
+    scheduler.ready = scheduler.ready[1:]
+
+    batch_size := min(len(node.inbox.messages), scheduler.BatchSize)
+    batch := make([]Message, batch_size)
+    copy(batch, node.inbox.messages)
+    node.inbox.messages = node.inbox.messages[batch_size:]
+    node.inbox.woken = false
+    if node.inbox.blocked > 0 && batch_size > 0 {
+      scheduler.cond.Broadcast()
+    }
+    scheduler.lock.Unlock()
+
+    scheduler.run(node, batch)
+
+    scheduler.lock.Lock()
+    node.inbox.scheduled = false
+    if node.inbox.removed {
+      scheduler.cond.Broadcast()
+    } else if len(node.inbox.messages) > 0 || node.inbox.woken {
+      scheduler.queue(node, false)
+    }
+  }
+}
+
+// Process any due queued signals and the batch of messages, then set a timer for the next queued signal
+func (scheduler *Scheduler) run(node *Node, batch []Message) {
+  ctx := scheduler.ctx
+
+  due := true
+  for due && node.NextSignal != nil {
+    select {
+    case <-node.TimeoutChan:
+      signal := node.popNextSignal(ctx)
+      node.handleSignal(ctx, node.ID, signal)
+    default:
+      due = false
+    }
+  }
+
+  for _, msg := range(batch) {
+    node.dequeued()
+    node.handleSignal(ctx, msg.Node, msg.Signal)
+  }
+  flush_wait := node.flushReferences(ctx, false)
+
+  scheduler.lock.Lock()
+  if node.inbox.timer != nil {
+    node.inbox.timer.Stop()
+    node.inbox.timer = nil
+  }
+  if node.NextSignal != nil {
+    node.inbox.timer = time.AfterFunc(time.Until(node.NextSignal.Time), func() {
+      scheduler.wake(node, true)
+    })
+  }
+  if flush_wait > 0 && node.inbox.flush == nil {
+    node.inbox.flush = time.AfterFunc(flush_wait, func() {
+      scheduler.lock.Lock()
+      node.inbox.flush = nil
+      scheduler.queue(node, true)
+      scheduler.lock.Unlock()
+    })
+  }
+  scheduler.lock.Unlock()
+}
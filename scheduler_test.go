@@ -0,0 +1,154 @@
+package graphvent
+
+import (
+  "testing"
+  "time"
+)
+
+func TestSchedulerLock(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, ctx.UseScheduler(4, 16))
+
+  reqs := make([]NodeID, 100)
+  for i := range(reqs) {
+    req, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+    fatalErr(t, err)
+    reqs[i] = req.ID
+  }
+
+  listener := NewListenerExt(1000)
+  node, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(reqs))
+  fatalErr(t, err)
+
+  lock_id, err := LockLockable(ctx, node)
+  fatalErr(t, err)
+
+  response, _, err := WaitForResponse(listener.Chan, time.Second, lock_id)
+  fatalErr(t, err)
+
+  switch resp := response.(type) {
+  case *SuccessSignal:
+  default:
+    t.Fatalf("Unexpected response to lock - %s", resp)
+  }
+
+  fatalErr(t, ctx.Stop())
+  ctx.Scheduler.Stop()
+}
+
+func TestSchedulerQueuedSignal(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, ctx.UseScheduler(1, 1))
+
+  listener := NewListenerExt(10)
+  node, err := ctx.NewNode(nil, "LockableNode", listener, NewLockableExt(nil))
+  fatalErr(t, err)
+
+  _, err = LockLockable(ctx, node)
+  fatalErr(t, err)
+
+  // Changes from the lock are sent as a StatusSignal through the node's signal queue
+  _, err = WaitForSignal(listener.Chan, 100*time.Millisecond, func(sig *StatusSignal) bool {
+    return sig.Source == node.ID
+  })
+  fatalErr(t, err)
+}
+
+func TestSchedulerStoppedWithContext(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, ctx.UseScheduler(2, 1))
+
+  _, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+
+  fatalErr(t, ctx.Stop())
+
+  stopped := make(chan struct{})
+  go func() {
+    ctx.Scheduler.workers.Wait()
+    close(stopped)
+  }()
+
+  select {
+  case <-stopped:
+  case <-time.After(time.Second):
+    t.Fatal("Scheduler workers still running after the context stopped")
+  }
+}
+
+func TestSchedulerInboxBlock(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterNodeType(ctx, "BlockNode", map[string]FieldMapping{}))
+  fatalErr(t, SetInboxConfig(ctx, "BlockNode", InboxConfig{
+    Strategy: InboxBlock,
+    Capacity: 1,
+    Deadline: 50*time.Millisecond,
+  }))
+  fatalErr(t, ctx.UseScheduler(2, 1))
+
+  target, err := ctx.NewNode(nil, "BlockNode", NewListenerExt(10))
+  fatalErr(t, err)
+  config := ctx.NodeTypes[target.Type].Inbox
+
+  // Mark the target as scheduled so no worker takes messages from it's inbox
+  hold := func() {
+    ctx.Scheduler.lock.Lock()
+    for target.inbox.scheduled {
+      ctx.Scheduler.lock.Unlock()
+      time.Sleep(time.Millisecond)
+      ctx.Scheduler.lock.Lock()
+    }
+    target.inbox.scheduled = true
+    ctx.Scheduler.lock.Unlock()
+  }
+  release := func() {
+    ctx.Scheduler.lock.Lock()
+    target.inbox.scheduled = false
+    ctx.Scheduler.queue(target, false)
+    ctx.Scheduler.lock.Unlock()
+  }
+
+  hold()
+  if ctx.Scheduler.Deliver(target, Message{target.ID, NewLockSignal()}, config) == false {
+    t.Fatal("First message wasn't delivered to an empty inbox")
+  }
+
+  delivered := make(chan bool, 1)
+  go func() {
+    delivered <- ctx.Scheduler.Deliver(target, Message{target.ID, NewLockSignal()}, config)
+  }()
+
+  select {
+  case <-delivered:
+    t.Fatal("Second message didn't wait for space in the inbox")
+  case <-time.After(10*time.Millisecond):
+  }
+
+  release()
+  select {
+  case result := <-delivered:
+    if result == false {
+      t.Fatal("Second message was dropped after the inbox was drained")
+    }
+  case <-time.After(time.Second):
+    t.Fatal("Second message was still blocked after the inbox was drained")
+  }
+
+  hold()
+  ctx.Scheduler.lock.Lock()
+  target.inbox.messages = append(target.inbox.messages, Message{target.ID, NewLockSignal()})
+  ctx.Scheduler.lock.Unlock()
+
+  start := time.Now()
+  if ctx.Scheduler.Deliver(target, Message{target.ID, NewLockSignal()}, config) {
+    t.Fatal("Message was delivered to a full inbox")
+  } else if time.Since(start) < config.Deadline {
+    t.Fatalf("Message was dropped after %s, before the %s deadline", time.Since(start), config.Deadline)
+  }
+  release()
+
+  err = InboxConfig{Strategy: InboxSpill, Capacity: 1}.Validate()
+  if err == nil {
+    t.Fatal("InboxSpill config was accepted")
+  }
+}
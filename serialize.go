@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"reflect"
   "math"
+  "strings"
+  "time"
 )
 
 type SerializedType uint64
@@ -77,6 +79,21 @@ func GetFieldTag(tag string) FieldTag {
   return FieldTag(Hash("GRAPHVENT_FIELD_TAG", tag))
 }
 
+// Set in the field count of structs whose fields are each written with their length after their tag,
+// so fields removed from the struct since it was written can be skipped. Structs written before it was added error on unknown fields.
+//
+// Stored structs follow these rules so data written by a newer or older binary can be read:
+//  - fields are identified by their gv tag, so fields can be reordered or renamed as long as the tag is kept
+//  - fields in the data that the struct doesn't have are skipped
+//  - fields the struct has that aren't in the data are left as zero values
+//  - changing the type of a tagged field is not compatible, it has to get a new tag
+// The same rules apply to extension fields in the DB, and extensions a node was stored with that aren't registered are
+// skipped when it's loaded and kept in its extension list when it's written.
+const StructFieldLengths = uint64(1) << 63
+
+// Written in place of a type stack for nil interfaces, since they have no type to write
+var NilInterfaceType = SerializeType(reflect.Invalid)
+
 func TypeStack(ctx *Context, t reflect.Type, data []byte) (int, error) {
   info, registered := ctx.Types[t]
   if registered {
@@ -199,10 +216,142 @@ func Deserialize[T any](ctx *Context, data []byte) (T, error) {
     return zero, fmt.Errorf("Deserialized type %s does not match %s", value.Type(), reflect_type)
   }
 
-  return value.Interface().(T), nil
+  // A nil interface fails the assertion, leaving the zero value of T
+  result, _ := value.Interface().(T)
+  return result, nil
+}
+
+// Returned when a value refers back to itself through pointers, slices, or maps, which would serialize forever
+type CycleError struct {
+  // Type of the value that was reached again
+  Type reflect.Type
+  // Types of the pointers, slices, and maps from the first visit back to it
+  Path []reflect.Type
+}
+
+func (err CycleError) Error() string {
+  path := make([]string, len(err.Path))
+  for i, t := range(err.Path) {
+    path[i] = t.String()
+  }
+  return fmt.Sprintf("Cannot serialize cyclic %s: %s", err.Type, strings.Join(path, " -> "))
+}
+
+func basicKind(kind reflect.Kind) bool {
+  return kind >= reflect.Bool && kind <= reflect.Complex128 || kind == reflect.String
+}
+
+// Get the field at index for reading, false if it's promoted through a nil embedded pointer
+func structField(value reflect.Value, index []int) (reflect.Value, bool) {
+  field, err := value.FieldByIndexErr(index)
+  return field, err == nil
+}
+
+// Get the field at index, or the zero value of its type if it's promoted through a nil embedded pointer
+func fieldOrZero(value reflect.Value, index []int) reflect.Value {
+  field, set := structField(value, index)
+  if set == false {
+    return reflect.Zero(value.Type().FieldByIndex(index).Type)
+  }
+  return field
+}
+
+// Get the field at index for setting, allocating the embedded structs of nil embedded pointers on the way to it
+func settableField(value reflect.Value, index []int) reflect.Value {
+  for i, field_index := range(index) {
+    if i > 0 && value.Kind() == reflect.Pointer {
+      if value.IsNil() {
+        value.Set(reflect.New(value.Type().Elem()))
+      }
+      value = value.Elem()
+    }
+    value = value.Field(field_index)
+  }
+  return value
+}
+
+// Get the gv tagged fields of a struct, including fields promoted from embedded structs.
+// Fails if two fields have the same tag, or a field is promoted through a pointer to an unexported struct since it can't be allocated when deserializing.
+func gvFields(t reflect.Type) ([]reflect.StructField, error) {
+  fields := []reflect.StructField{}
+  tags := map[string]string{}
+  for _, field := range(reflect.VisibleFields(t)) {
+    gv_tag, tagged_gv := field.Tag.Lookup("gv")
+    if tagged_gv == false {
+      continue
+    }
+
+    existing, duplicate := tags[gv_tag]
+    if duplicate {
+      return nil, fmt.Errorf("%s has fields %s and %s with the same gv tag %s", t, existing, field.Name, gv_tag)
+    }
+    tags[gv_tag] = field.Name
+
+    embedding := t
+    for _, field_index := range(field.Index[:len(field.Index)-1]) {
+      embedded := embedding.Field(field_index)
+      if embedded.Type.Kind() == reflect.Pointer && embedded.IsExported() == false {
+        return nil, fmt.Errorf("%s on %s is promoted through %s, a pointer to an unexported struct", field.Name, t, embedded.Type)
+      }
+      embedding = embedded.Type
+      if embedding.Kind() == reflect.Pointer {
+        embedding = embedding.Elem()
+      }
+    }
+
+    fields = append(fields, field)
+  }
+  return fields, nil
+}
+
+type serializeVisit struct {
+  pointer uintptr
+  t reflect.Type
+  length int
+}
+
+// The pointers, slices, and maps being serialized that contain the current value
+type serializePath []serializeVisit
+
+// Add a non-nil pointer, slice, or map to the path, failing if it's already on it.
+// Values can be shared without being cyclic, so only the containing values are checked.
+// Types with their own serializer start a new path, so cycles through them aren't found.
+func (path serializePath) enter(value reflect.Value) (serializePath, error) {
+  // Values that only contain basic types like []byte can't refer to anything
+  t := value.Type()
+  if basicKind(t.Elem().Kind()) && (t.Kind() != reflect.Map || basicKind(t.Key().Kind())) {
+    return path, nil
+  }
+
+  visit := serializeVisit{
+    pointer: value.Pointer(),
+    t: t,
+  }
+  if value.Kind() == reflect.Slice {
+    visit.length = value.Len()
+  }
+
+  for i, visited := range(path) {
+    if visited == visit {
+      cycle := make([]reflect.Type, 0, len(path) - i + 1)
+      for _, step := range(path[i:]) {
+        cycle = append(cycle, step.t)
+      }
+      return nil, CycleError{
+        Type: visit.t,
+        Path: append(cycle, visit.t),
+      }
+    }
+  }
+  // Siblings overwrite each other's entries past the end of the path, which is fine since they're done with them
+  return append(path, visit), nil
 }
 
 func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
+  return serializedSize(ctx, value, nil)
+}
+
+func serializedSize(ctx *Context, value reflect.Value, path serializePath) (int, error) {
   var sizefn SerializedSizeFn = nil
 
   info, registered := ctx.Types[value.Type()]
@@ -242,6 +391,11 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
     case reflect.Float64:
       return 8, nil
 
+    case reflect.Complex64:
+      return 8, nil
+    case reflect.Complex128:
+      return 16, nil
+
     case reflect.String:
       return 8 + value.Len(), nil
 
@@ -249,7 +403,11 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
       if value.IsNil() {
         return 1, nil
       } else {
-        elem_len, err := SerializedSize(ctx, value.Elem())
+        path, err := path.enter(value)
+        if err != nil {
+          return 0, err
+        }
+        elem_len, err := serializedSize(ctx, value.Elem(), path)
         if err != nil {
           return 0, err
         } else {
@@ -261,9 +419,13 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
       if value.IsNil() {
         return 1, nil
       } else {
+        path, err := path.enter(value)
+        if err != nil {
+          return 0, err
+        }
         elem_total := 0
         for i := 0; i < value.Len(); i++ {
-          elem_len, err := SerializedSize(ctx, value.Index(i))
+          elem_len, err := serializedSize(ctx, value.Index(i), path)
           if err != nil {
             return 0, err
           }
@@ -275,7 +437,7 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
     case reflect.Array:
       total := 0
       for i := 0; i < value.Len(); i++ {
-        elem_len, err := SerializedSize(ctx, value.Index(i))
+        elem_len, err := serializedSize(ctx, value.Index(i), path)
         if err != nil {
           return 0, err
         }
@@ -287,6 +449,10 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
       if value.IsNil() {
         return 1, nil
       } else {
+        path, err := path.enter(value)
+        if err != nil {
+          return 0, err
+        }
         key := reflect.New(value.Type().Key()).Elem()
         val := reflect.New(value.Type().Elem()).Elem()
         iter := value.MapRange()
@@ -294,7 +460,7 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
         total := 0
         for iter.Next() {
           key.SetIterKey(iter)
-          k, err := SerializedSize(ctx, key)
+          k, err := serializedSize(ctx, key, path)
           if err != nil {
             return 0, err
           }
@@ -302,7 +468,7 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
           total += k
 
           val.SetIterValue(iter)
-          v, err := SerializedSize(ctx, val)
+          v, err := serializedSize(ctx, val, path)
           if err != nil {
             return 0, err
           }
@@ -319,12 +485,16 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
       } else {
         field_total := 0
         for _, field_info := range(info.Fields) {
-          field_size, err := SerializedSize(ctx, value.FieldByIndex(field_info.Index))
+          field, set := structField(value, field_info.Index)
+          if set == false {
+            continue
+          }
+          field_size, err := serializedSize(ctx, field, path)
           if err != nil {
             return 0, err
           }
 
-          field_total += 8
+          field_total += 16
           field_total += field_size
         }
 
@@ -332,8 +502,12 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
       }
 
     case reflect.Interface:
+      if value.IsNil() {
+        return 8, nil
+      }
+
       // TODO get size of TypeStack instead of just using 128
-      elem_size, err := SerializedSize(ctx, value.Elem())
+      elem_size, err := serializedSize(ctx, value.Elem(), path)
       if err != nil {
         return 0, err
       }
@@ -349,6 +523,10 @@ func SerializedSize(ctx *Context, value reflect.Value) (int, error) {
 }
 
 func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error) {
+  return serializeValue(ctx, value, data, nil)
+}
+
+func serializeValue(ctx *Context, value reflect.Value, data []byte, path serializePath) (int, error) {
   var serialize SerializeFn = nil
 
   info, registered := ctx.Types[value.Type()]
@@ -403,6 +581,16 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
       binary.BigEndian.PutUint64(data, math.Float64bits(value.Float()))
       return 8, nil
 
+    // Complex numbers are written as their real part followed by their imaginary part
+    case reflect.Complex64:
+      binary.BigEndian.PutUint32(data, math.Float32bits(float32(real(value.Complex()))))
+      binary.BigEndian.PutUint32(data[4:], math.Float32bits(float32(imag(value.Complex()))))
+      return 8, nil
+    case reflect.Complex128:
+      binary.BigEndian.PutUint64(data, math.Float64bits(real(value.Complex())))
+      binary.BigEndian.PutUint64(data[8:], math.Float64bits(imag(value.Complex())))
+      return 16, nil
+
     case reflect.String:
       binary.BigEndian.PutUint64(data, uint64(value.Len()))
       copy(data[8:], []byte(value.String()))
@@ -413,8 +601,12 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
         data[0] = 0x00
         return 1, nil
       } else {
+        path, err := path.enter(value)
+        if err != nil {
+          return 0, err
+        }
         data[0] = 0x01
-        written, err := SerializeValue(ctx, value.Elem(), data[1:])
+        written, err := serializeValue(ctx, value.Elem(), data[1:], path)
         if err != nil {
           return 0, err
         }
@@ -424,13 +616,17 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
     case reflect.Slice:
       if value.IsNil() {
         data[0] = 0x00
-        return 8, nil
+        return 1, nil
       } else {
+        path, err := path.enter(value)
+        if err != nil {
+          return 0, err
+        }
         data[0] = 0x01
         binary.BigEndian.PutUint64(data[1:], uint64(value.Len()))
         total_written := 0
         for i := 0; i < value.Len(); i++ {
-          written, err := SerializeValue(ctx, value.Index(i), data[9+total_written:])
+          written, err := serializeValue(ctx, value.Index(i), data[9+total_written:], path)
           if err != nil {
             return 0, err
           }
@@ -442,7 +638,7 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
     case reflect.Array:
       total_written := 0
       for i := 0; i < value.Len(); i++ {
-        written, err := SerializeValue(ctx, value.Index(i), data[total_written:])
+        written, err := serializeValue(ctx, value.Index(i), data[total_written:], path)
         if err != nil {
           return 0, err
         }
@@ -455,6 +651,10 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
         data[0] = 0x00
         return 1, nil
       } else {
+        path, err := path.enter(value)
+        if err != nil {
+          return 0, err
+        }
         data[0] = 0x01
         binary.BigEndian.PutUint64(data[1:], uint64(value.Len()))
 
@@ -466,13 +666,13 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
           key.SetIterKey(iter)
           val.SetIterValue(iter)
 
-          k, err := SerializeValue(ctx, key, data[9+total_written:])
+          k, err := serializeValue(ctx, key, data[9+total_written:], path)
           if err != nil {
             return 0, err
           }
           total_written += k
 
-          v, err := SerializeValue(ctx, val, data[9+total_written:]) 
+          v, err := serializeValue(ctx, val, data[9+total_written:], path) 
           if err != nil {
             return 0, err
           }
@@ -485,25 +685,41 @@ func SerializeValue(ctx *Context, value reflect.Value, data []byte) (int, error)
       if registered == false {
         return 0, fmt.Errorf("Cannot serialize unregistered struct %s", value.Type())
       } else {
-        binary.BigEndian.PutUint64(data, uint64(len(info.Fields)))
-
+        // Fields promoted through nil embedded pointers aren't written, so they stay nil when deserialized
+        num_fields := 0
         total_written := 0
         for field_tag, field_info := range(info.Fields) {
+          field, set := structField(value, field_info.Index)
+          if set == false {
+            continue
+          }
           binary.BigEndian.PutUint64(data[8+total_written:], uint64(field_tag))
-          total_written += 8
-          written, err := SerializeValue(ctx, value.FieldByIndex(field_info.Index), data[8+total_written:])
+          length_offset := 8 + total_written + 8
+          total_written += 16
+          written, err := serializeValue(ctx, field, data[8+total_written:], path)
           if err != nil {
             return 0, err
           }
+          binary.BigEndian.PutUint64(data[length_offset:], uint64(written))
           total_written += written
+          num_fields += 1
         }
+        binary.BigEndian.PutUint64(data, uint64(num_fields) | StructFieldLengths)
         return 8 + total_written, nil
       }
 
     case reflect.Interface:
+      if value.IsNil() {
+        binary.BigEndian.PutUint64(data, uint64(NilInterfaceType))
+        return 8, nil
+      }
+
       type_written, err := TypeStack(ctx, value.Elem().Type(), data)
+      if err != nil {
+        return 0, err
+      }
 
-      elem_written, err := SerializeValue(ctx, value.Elem(), data[type_written:])
+      elem_written, err := serializeValue(ctx, value.Elem(), data[type_written:], path)
       if err != nil {
         return 0, err
       }
@@ -594,6 +810,21 @@ func DeserializeValue(ctx *Context, data []byte, t reflect.Type) (reflect.Value,
       value.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(used)))
       return value, left, nil
 
+    case reflect.Complex64:
+      used, left := split(data, 8)
+      value := reflect.New(t).Elem()
+      r := math.Float32frombits(binary.BigEndian.Uint32(used[0:4]))
+      i := math.Float32frombits(binary.BigEndian.Uint32(used[4:8]))
+      value.SetComplex(complex(float64(r), float64(i)))
+      return value, left, nil
+    case reflect.Complex128:
+      used, left := split(data, 16)
+      value := reflect.New(t).Elem()
+      r := math.Float64frombits(binary.BigEndian.Uint64(used[0:8]))
+      i := math.Float64frombits(binary.BigEndian.Uint64(used[8:16]))
+      value.SetComplex(complex(r, i))
+      return value, left, nil
+
     case reflect.String:
       length, after_len := split(data, 8)
       used, left := split(after_len, int(binary.BigEndian.Uint64(length)))
@@ -688,23 +919,45 @@ func DeserializeValue(ctx *Context, data []byte, t reflect.Type) (reflect.Value,
         value := reflect.New(t).Elem()
 
         num_field_bytes, left := split(data, 8)
-        num_fields := int(binary.BigEndian.Uint64(num_field_bytes))
+        num_fields := binary.BigEndian.Uint64(num_field_bytes)
+        lengths := num_fields & StructFieldLengths != 0
+        num_fields &^= StructFieldLengths
 
-        for i := 0; i < num_fields; i++ {
+        for i := uint64(0); i < num_fields; i++ {
           var tag_bytes []byte
 
           tag_bytes, left = split(left, 8)
           field_tag := FieldTag(binary.BigEndian.Uint64(tag_bytes))
 
           field_info, mapped := info.Fields[field_tag]
-          if mapped {
+          if lengths {
+            var length_bytes, field_data []byte
+            length_bytes, left = split(left, 8)
+            length := binary.BigEndian.Uint64(length_bytes)
+            if length > uint64(len(left)) {
+              return reflect.Value{}, nil, fmt.Errorf("Field %s on struct %s is %d bytes, only %d left", field_tag, t, length, len(left))
+            }
+            field_data, left = split(left, int(length))
+            // Fields that have been removed from the struct are skipped
+            if mapped == false {
+              continue
+            }
+
+            field_val, rest, err := DeserializeValue(ctx, field_data, field_info.Type)
+            if err != nil {
+              return reflect.Value{}, nil, err
+            } else if len(rest) != 0 {
+              return reflect.Value{}, nil, fmt.Errorf("%d bytes left after deserializing field %s on struct %s", len(rest), field_tag, t)
+            }
+            settableField(value, field_info.Index).Set(field_val)
+          } else if mapped {
             var field_val reflect.Value
             var err error
             field_val, left, err = DeserializeValue(ctx, left, field_info.Type)
             if err != nil {
               return reflect.Value{}, nil, err
             }
-            value.FieldByIndex(field_info.Index).Set(field_val)
+            settableField(value, field_info.Index).Set(field_val)
           } else {
             return reflect.Value{}, nil, fmt.Errorf("Unknown field %s on struct %s", field_tag, t)
           }
@@ -719,6 +972,10 @@ func DeserializeValue(ctx *Context, data []byte, t reflect.Type) (reflect.Value,
       }
 
     case reflect.Interface:
+      if len(data) >= 8 && SerializedType(binary.BigEndian.Uint64(data[0:8])) == NilInterfaceType {
+        return reflect.New(t).Elem(), data[8:], nil
+      }
+
       elem_type, rest, err := UnwrapStack(ctx, data)
       if err != nil {
         return reflect.Value{}, nil, err
@@ -742,3 +999,110 @@ func DeserializeValue(ctx *Context, data []byte, t reflect.Type) (reflect.Value,
   }
 }
 
+// An encoding for values, so data exchanged with clients that don't implement the TypeStack framing
+// can use a standard format. Interfaces are written with their type stack in every format.
+type SerializationFormat interface {
+  Encode(ctx *Context, value reflect.Value) ([]byte, error)
+  // Decode a value of type t, failing if any data is left over
+  Decode(ctx *Context, data []byte, t reflect.Type) (reflect.Value, error)
+}
+
+// The format written by SerializeValue and used by the DB
+type BinaryFormat struct {}
+
+func (BinaryFormat) Encode(ctx *Context, value reflect.Value) ([]byte, error) {
+  size, err := SerializedSize(ctx, value)
+  if err != nil {
+    return nil, err
+  }
+
+  data := make([]byte, size)
+  written, err := SerializeValue(ctx, value, data)
+  if err != nil {
+    return nil, err
+  }
+  return data[:written], nil
+}
+
+func (BinaryFormat) Decode(ctx *Context, data []byte, t reflect.Type) (reflect.Value, error) {
+  value, left, err := DeserializeValue(ctx, data, t)
+  if err != nil {
+    return reflect.Value{}, err
+  } else if len(left) != 0 {
+    return reflect.Value{}, fmt.Errorf("%d/%d bytes left after deserializing %s", len(left), len(data), t)
+  }
+  return value, nil
+}
+
+// A value serialized along with its type, so it can be deserialized without knowing the type ahead of time
+type SerializedValue []byte
+
+// Serialize value as an any, including its type
+func SerializeAny(ctx *Context, value reflect.Value) (SerializedValue, error) {
+  wrapped := reflect.New(reflect.TypeFor[any]()).Elem()
+  wrapped.Set(value)
+
+  size, err := SerializedSize(ctx, wrapped)
+  if err != nil {
+    return nil, err
+  }
+
+  data := make([]byte, size)
+  written, err := SerializeValue(ctx, wrapped, data)
+  if err != nil {
+    return nil, err
+  }
+
+  return SerializedValue(compressValue(ctx, data[:written])), nil
+}
+
+// Deserialize the value and its type
+func (value SerializedValue) Deserialize(ctx *Context) (reflect.Value, error) {
+  value, err := value.decompress()
+  if err != nil {
+    return reflect.Value{}, err
+  }
+
+  // Check the type first so corrupt values fail with where the type is wrong instead of somewhere in the value
+  _, err = validateTypeStack(ctx, value)
+  if err != nil {
+    return reflect.Value{}, err
+  }
+
+  wrapped, left, err := DeserializeValue(ctx, value, reflect.TypeFor[any]())
+  if err != nil {
+    return reflect.Value{}, err
+  } else if len(left) != 0 {
+    return reflect.Value{}, fmt.Errorf("%d bytes left after deserializing SerializedValue", len(left))
+  }
+
+  return wrapped.Elem(), nil
+}
+
+// Times are serialized as unix nanoseconds, with the zero time as the minimum int64 since it's outside the range UnixNano supports
+func serializeTime(ctx *Context, value reflect.Value, data []byte) (int, error) {
+  t := value.Interface().(time.Time)
+  nanos := int64(math.MinInt64)
+  if t.IsZero() == false {
+    nanos = t.UnixNano()
+  }
+  binary.BigEndian.PutUint64(data, uint64(nanos))
+  return 8, nil
+}
+
+func serializedTimeSize(ctx *Context, value reflect.Value) (int, error) {
+  return 8, nil
+}
+
+func deserializeTime(ctx *Context, data []byte) (reflect.Value, []byte, error) {
+  if len(data) < 8 {
+    return reflect.Value{}, nil, fmt.Errorf("Not enough data to deserialize time.Time: %d bytes", len(data))
+  }
+
+  nanos := int64(binary.BigEndian.Uint64(data[:8]))
+  var t time.Time
+  if nanos != math.MinInt64 {
+    t = time.Unix(0, nanos)
+  }
+  return reflect.ValueOf(t), data[8:], nil
+}
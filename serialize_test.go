@@ -1,8 +1,15 @@
 package graphvent
 
 import (
+  "bytes"
+  "encoding/binary"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "strings"
   "testing"
   "reflect"
+  "time"
   "github.com/google/uuid"
 )
 
@@ -41,6 +48,9 @@ func TestSerializeTypes(t *testing.T) {
   testTypeStack[[5]int](t, ctx)
   testTypeStack[uuid.UUID](t, ctx)
   testTypeStack[NodeID](t, ctx)
+  testTypeStack[time.Time](t, ctx)
+  testTypeStack[time.Duration](t, ctx)
+  testTypeStack[map[NodeID]time.Duration](t, ctx)
 }
 
 func testSerializeCompare[T comparable](t *testing.T, ctx *Context, value T) {
@@ -143,6 +153,16 @@ func TestSerializeValues(t *testing.T) {
 
   testSerializeCompare[string](t, ctx, "test")
 
+  testSerializeCompare[float32](t, ctx, -1.5)
+  testSerializeCompare[float64](t, ctx, 1e-300)
+  testSerializeCompare[complex64](t, ctx, complex(1.5, -2))
+  testSerializeCompare[complex128](t, ctx, complex(-1e300, 1e-300))
+
+  // Every primitive kind can be serialized in an interface, which needs its type registered
+  for _, value := range([]any{int8(-1), int16(-1), int32(-1), int64(-1), uint(1), uint16(1), uint64(1), float32(0.5), float64(0.5), complex64(1i), complex128(1i)}) {
+    testSerializeCompare[any](t, ctx, value)
+  }
+
   a := 12
   testSerializePointer[*int](t, ctx, &a)
 
@@ -157,8 +177,15 @@ func TestSerializeValues(t *testing.T) {
   testSerializeCompare[int](t, ctx, -1)
   testSerializeCompare[uint](t, ctx, 1)
   testSerializeCompare[NodeID](t, ctx, RandID())
+  testSerializeCompare[time.Time](t, ctx, time.Unix(0, 1234567890))
+  testSerializeCompare[time.Time](t, ctx, time.Time{})
+  testSerializeCompare[time.Duration](t, ctx, 90*time.Second)
+  testSerializeCompare[any](t, ctx, 90*time.Second)
+  testSerializeCompare[any](t, ctx, uuid.New())
   testSerializeCompare[*int](t, ctx, nil)
   testSerializeCompare(t, ctx, "string")
+  testSerializeCompare[Signal](t, ctx, nil)
+  testSerializeCompare[Extension](t, ctx, nil)
 
   testSerialize(t, ctx, map[string]string{
     "Test": "Test",
@@ -174,3 +201,507 @@ func TestSerializeValues(t *testing.T) {
   fatalErr(t, err)
   testSerialize(t, ctx, node)
 }
+
+func TestSerializeNilInterfaces(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  signals := []Signal{nil, NewStatusSignal(RandID(), nil, nil, nil), nil}
+  buffer := [1024]byte{}
+  written, err := Serialize(ctx, signals, buffer[:])
+  fatalErr(t, err)
+
+  size, err := SerializedSize(ctx, reflect.ValueOf(signals))
+  fatalErr(t, err)
+  if size < written {
+    t.Fatalf("SerializedSize %d is less than the %d bytes written", size, written)
+  }
+
+  deserialized, err := Deserialize[[]Signal](ctx, buffer[:written])
+  fatalErr(t, err)
+  if len(deserialized) != 3 || deserialized[0] != nil || deserialized[2] != nil {
+    t.Fatalf("Nil signals not deserialized: %+v", deserialized)
+  }
+  if _, is_status := deserialized[1].(*StatusSignal); is_status == false {
+    t.Fatalf("Wrong signal deserialized: %+v", deserialized[1])
+  }
+}
+
+func TestSerializedValueCompression(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  requirements := map[string]ReqState{}
+  for i := 0; i < 1000; i++ {
+    requirements[fmt.Sprintf("requirement_%d", i)] = Unlocked
+  }
+
+  compressed, err := SerializeAny(ctx, reflect.ValueOf(requirements))
+  fatalErr(t, err)
+  if compressed.Compressed() == false {
+    t.Fatalf("%d byte SerializedValue wasn't compressed", len(compressed))
+  }
+
+  small, err := SerializeAny(ctx, reflect.ValueOf("small"))
+  fatalErr(t, err)
+  if small.Compressed() {
+    t.Fatal("Small SerializedValue was compressed")
+  }
+
+  // Values written without compression still parse once it's enabled
+  ctx.CompressAbove = 0
+  uncompressed, err := SerializeAny(ctx, reflect.ValueOf(requirements))
+  fatalErr(t, err)
+  if uncompressed.Compressed() || len(uncompressed) <= len(compressed) {
+    t.Fatalf("Uncompressed SerializedValue is %d bytes, compressed is %d", len(uncompressed), len(compressed))
+  }
+  ctx.CompressAbove = SERIALIZED_VALUE_COMPRESS_SIZE
+
+  for _, value := range([]SerializedValue{compressed, uncompressed}) {
+    deserialized, err := value.Deserialize(ctx)
+    fatalErr(t, err)
+    if reflect.DeepEqual(deserialized.Interface(), requirements) == false {
+      t.Fatal("Deserialized requirements don't match")
+    }
+  }
+}
+
+type testRecordV1 struct {
+  Name string `gv:"name"`
+  Removed []NodeID `gv:"removed"`
+  Count int `gv:"count"`
+}
+
+type testRecordV2 struct {
+  Count int `gv:"count"`
+  Name string `gv:"name"`
+  Added bool `gv:"added"`
+}
+
+func TestSerializeStructChanges(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterObjectNoGQL[testRecordV1](ctx))
+  fatalErr(t, RegisterObjectNoGQL[testRecordV2](ctx))
+
+  buffer := [1024]byte{}
+  written, err := Serialize(ctx, testRecordV1{"record", []NodeID{RandID(), RandID()}, 3}, buffer[:])
+  fatalErr(t, err)
+
+  // Reordered, removed, and added fields don't stop the record from loading
+  v2, err := Deserialize[testRecordV2](ctx, buffer[:written])
+  fatalErr(t, err)
+  if v2 != (testRecordV2{3, "record", false}) {
+    t.Fatalf("Wrong record deserialized: %+v", v2)
+  }
+
+  // Structs written before field lengths were added still load, but can't skip fields
+  old := binary.BigEndian.AppendUint64(nil, 1)
+  old = binary.BigEndian.AppendUint64(old, uint64(GetFieldTag("count")))
+  old = binary.BigEndian.AppendUint64(old, 7)
+  v2, err = Deserialize[testRecordV2](ctx, old)
+  fatalErr(t, err)
+  if v2.Count != 7 {
+    t.Fatalf("Wrong record deserialized from old format: %+v", v2)
+  }
+
+  old = binary.BigEndian.AppendUint64(nil, 1)
+  old = binary.BigEndian.AppendUint64(old, uint64(GetFieldTag("removed")))
+  old = binary.BigEndian.AppendUint64(old, 0)
+  _, err = Deserialize[testRecordV2](ctx, old)
+  if err == nil {
+    t.Fatal("Unknown field in old format deserialized")
+  }
+}
+
+type testCycleNode struct {
+  Name string `gv:"name"`
+  Next *testCycleNode `gv:"next"`
+  Children []*testCycleNode `gv:"children"`
+}
+
+func TestSerializeCycles(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterObjectNoGQL[testCycleNode](ctx))
+
+  // Shared values aren't cycles
+  leaf := &testCycleNode{Name: "leaf"}
+  shared := &testCycleNode{Name: "shared", Next: leaf, Children: []*testCycleNode{leaf, leaf}}
+  buffer := [1024]byte{}
+  _, err := Serialize(ctx, shared, buffer[:])
+  fatalErr(t, err)
+
+  a := &testCycleNode{Name: "a"}
+  b := &testCycleNode{Name: "b", Next: a}
+  a.Children = []*testCycleNode{b}
+
+  var cycle_err CycleError
+  _, err = SerializedSize(ctx, reflect.ValueOf(a))
+  if errors.As(err, &cycle_err) == false {
+    t.Fatalf("Expected CycleError sizing a cycle, got %s", err)
+  }
+  _, err = Serialize(ctx, a, buffer[:])
+  if errors.As(err, &cycle_err) == false {
+    t.Fatalf("Expected CycleError serializing a cycle, got %s", err)
+  }
+  if cycle_err.Type != reflect.TypeFor[*testCycleNode]() || len(cycle_err.Path) != 4 {
+    t.Fatalf("Wrong cycle: %s", cycle_err)
+  }
+}
+
+func TestStaticSerializers(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  info := ctx.Types[reflect.TypeFor[StatusSignal]()]
+  if info.Serialize == nil || info.SerializedSize == nil || info.Deserialize == nil {
+    t.Fatal("StatusSignal was registered without its generated serializers")
+  }
+
+  var changes Changes
+  changes.Add(lockableExtType, ChangeAdd, "requirements")
+  signal := NewStatusSignal(RandID(), []string{"requirements"}, changes, []FieldDiff{{"requirements", SerializedValue{0x01}, nil}})
+
+  buffer := [1024]byte{}
+  size, err := SerializedSize(ctx, reflect.ValueOf(signal).Elem())
+  fatalErr(t, err)
+  written, err := Serialize(ctx, *signal, buffer[:])
+  fatalErr(t, err)
+  if size != written {
+    t.Fatalf("StaticSize returned %d, but %d bytes were written", size, written)
+  }
+  static := append([]byte{}, buffer[:written]...)
+
+  // Clear the generated functions so the same ctx uses reflection, both should read each other's output
+  generated := *info
+  info.Serialize, info.SerializedSize, info.Deserialize = nil, nil, nil
+  written, err = Serialize(ctx, *signal, buffer[:])
+  fatalErr(t, err)
+  reflected := buffer[:written]
+
+  from_static, err := Deserialize[StatusSignal](ctx, static)
+  fatalErr(t, err)
+  *info = generated
+  from_reflected, err := Deserialize[StatusSignal](ctx, reflected)
+  fatalErr(t, err)
+
+  if reflect.DeepEqual(from_static, *signal) == false {
+    t.Fatalf("Reflection deserialized %+v from generated serializer, expected %+v", from_static, *signal)
+  } else if reflect.DeepEqual(from_reflected, *signal) == false {
+    t.Fatalf("Generated deserializer returned %+v, expected %+v", from_reflected, *signal)
+  }
+}
+
+func TestJSONEncoding(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  old, err := SerializeAny(ctx, reflect.ValueOf(map[NodeID]ReqState{RandID(): Locked}))
+  fatalErr(t, err)
+  var changes Changes
+  changes.Add(lockableExtType, ChangeAdd, "requirements")
+  signal := NewStatusSignal(RandID(), []string{"requirements"}, changes, []FieldDiff{{"requirements", old, nil}})
+
+  data, err := SerializeJSON[Signal](ctx, signal)
+  fatalErr(t, err)
+  ctx.Log.Logf("test", "JSON: %s", data)
+
+  decoded, err := DeserializeJSON[Signal](ctx, data)
+  fatalErr(t, err)
+  if reflect.DeepEqual(decoded, Signal(signal)) == false {
+    t.Fatalf("JSON round trip changed %+v to %+v", signal, decoded)
+  }
+
+  value_json, err := old.JSON(ctx)
+  fatalErr(t, err)
+  value, err := SerializedValueFromJSON(ctx, value_json)
+  fatalErr(t, err)
+  if bytes.Equal(value, old) == false {
+    t.Fatalf("SerializedValue changed after JSON round trip: %s", value_json)
+  }
+
+  l1, err := ctx.NewNode(nil, "LockableNode", NewLockableExt(nil))
+  fatalErr(t, err)
+  l2, err := ctx.NewNode(nil, "LockableNode", NewLockableExt([]NodeID{l1.ID}))
+  fatalErr(t, err)
+
+  dump := bytes.Buffer{}
+  fatalErr(t, ctx.DumpJSON(&dump))
+
+  restored := logTestContext(t, []string{"test"})
+  count, err := restored.LoadJSON(&dump)
+  fatalErr(t, err)
+  if count != 2 {
+    t.Fatalf("Loaded %d nodes from the dump instead of 2", count)
+  }
+
+  node, err := restored.getNode(l2.ID)
+  fatalErr(t, err)
+  lockable, err := GetExt[LockableExt](node)
+  fatalErr(t, err)
+  if _, required := lockable.Requirements[l1.ID]; required == false || node.Type != l2.Type {
+    t.Fatalf("Restored node doesn't match: %+v", lockable)
+  }
+
+  stats, err := restored.Stats()
+  fatalErr(t, err)
+  if stats.Persisted != 2 {
+    t.Fatalf("Restored DB counts %d nodes", stats.Persisted)
+  }
+}
+
+func TestSerializationFormats(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  old, err := SerializeAny(ctx, reflect.ValueOf(map[NodeID]ReqState{RandID(): Locked}))
+  fatalErr(t, err)
+  var changes Changes
+  changes.Add(lockableExtType, ChangeAdd, "requirements")
+  status := NewStatusSignal(RandID(), []string{"requirements"}, changes, []FieldDiff{{"requirements", old, nil}})
+  alert := NewWatchdogAlertSignal(RandID(), -3, true, time.Time{}, "", time.Time{})
+
+  for _, format := range([]SerializationFormat{BinaryFormat{}, JSONFormat{}, CBORFormat{}, ProtoFormat{}}) {
+    for _, signal := range([]Signal{status, alert}) {
+      data, err := format.Encode(ctx, reflect.ValueOf(&signal).Elem())
+      fatalErr(t, err)
+      decoded, err := format.Decode(ctx, data, reflect.TypeFor[Signal]())
+      fatalErr(t, err)
+      if reflect.DeepEqual(decoded.Interface(), signal) == false {
+        t.Fatalf("%T round trip changed %+v to %+v", format, signal, decoded.Interface())
+      }
+
+      msg, err := EncodeMessage(ctx, format, Message{RandID(), signal})
+      fatalErr(t, err)
+      header, body, err := ParseMessageHeader(msg)
+      fatalErr(t, err)
+      decoded_msg, err := DecodeMessage(ctx, format, header, body)
+      fatalErr(t, err)
+      if reflect.DeepEqual(decoded_msg.Signal, signal) == false {
+        t.Fatalf("%T message changed %+v to %+v", format, signal, decoded_msg.Signal)
+      }
+    }
+  }
+
+  // Encodings from RFC 8949 appendix A, and a NodeID as a tagged byte string
+  id := RandID()
+  cbor_values := []struct{
+    value any
+    data []byte
+  }{
+    {-500, []byte{0x39, 0x01, 0xf3}},
+    {uint64(1000000), []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}},
+    {"IETF", []byte{0x64, 0x49, 0x45, 0x54, 0x46}},
+    {[]string{"a"}, []byte{0x81, 0x61, 0x61}},
+    {id, append([]byte{0xd8, 0x25, 0x50}, id[:]...)},
+  }
+  for _, test := range(cbor_values) {
+    data, err := CBORFormat{}.Encode(ctx, reflect.ValueOf(test.value))
+    fatalErr(t, err)
+    if bytes.Equal(data, test.data) == false {
+      t.Fatalf("Encoded %v as %x instead of %x", test.value, data, test.data)
+    }
+  }
+
+  half, err := CBORFormat{}.Decode(ctx, []byte{0xf9, 0x3e, 0x00}, reflect.TypeFor[float64]())
+  fatalErr(t, err)
+  if half.Float() != 1.5 {
+    t.Fatalf("Decoded half float 0x3e00 as %f", half.Float())
+  }
+}
+
+func TestRegistryValidation(t *testing.T) {
+  local := logTestContext(t, []string{"test"})
+  remote := logTestContext(t, []string{"test"})
+
+  data, err := json.Marshal(remote.ExportRegistry())
+  fatalErr(t, err)
+  var registry Registry
+  fatalErr(t, json.Unmarshal(data, &registry))
+  fatalErr(t, local.ValidateRegistry(registry))
+
+  fatalErr(t, RegisterExtension[testCounterExt](remote, nil))
+  registry = remote.ExportRegistry()
+  for i, registry_type := range(registry.Types) {
+    if registry_type.Name == "graphvent.StatusSignal" {
+      registry.Types[i].Fields = registry_type.Fields[1:]
+    }
+  }
+
+  err = local.ValidateRegistry(registry)
+  if errors.Is(err, RegistryMismatchError) == false {
+    t.Fatalf("Expected RegistryMismatchError, got %v", err)
+  } else if strings.Contains(err.Error(), "extension graphvent.testCounterExt is only registered remotely") == false {
+    t.Fatalf("Mismatch doesn't mention the extra extension: %s", err)
+  } else if strings.Contains(err.Error(), "type graphvent.StatusSignal.") == false {
+    t.Fatalf("Mismatch doesn't mention the missing field: %s", err)
+  }
+}
+
+func TestProtoFormat(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  schema, err := ctx.ProtoSchema()
+  fatalErr(t, err)
+  for _, expected := range([]string{"message StatusSignal {", "message FieldDiff {", "message TypedValue {"}) {
+    if strings.Contains(schema, expected) == false {
+      t.Fatalf("Generated schema is missing %s:\n%s", expected, schema)
+    }
+  }
+
+  // Encodings from the protobuf encoding guide, and a repeated number packed like proto3 encoders write it
+  proto_values := []struct{
+    value any
+    data []byte
+  }{
+    {uint64(150), []byte{0x08, 0x96, 0x01}},
+    {int64(-2), []byte{0x08, 0x03}},
+    {"testing", []byte{0x0a, 0x07, 0x74, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x67}},
+    {[]uint32{1, 2}, []byte{0x08, 0x01, 0x08, 0x02}},
+  }
+  for _, test := range(proto_values) {
+    data, err := ProtoFormat{}.Encode(ctx, reflect.ValueOf(test.value))
+    fatalErr(t, err)
+    if bytes.Equal(data, test.data) == false {
+      t.Fatalf("Encoded %v as %x instead of %x", test.value, data, test.data)
+    }
+  }
+
+  packed, err := ProtoFormat{}.Decode(ctx, []byte{0x0a, 0x03, 0x03, 0x8e, 0x02}, reflect.TypeFor[[]uint32]())
+  fatalErr(t, err)
+  if reflect.DeepEqual(packed.Interface(), []uint32{3, 270}) == false {
+    t.Fatalf("Decoded packed field as %+v", packed.Interface())
+  }
+
+  // Fields that aren't in the message are skipped, like ones added by a newer version
+  unknown, err := ProtoFormat{}.Decode(ctx, []byte{0x10, 0x01, 0x08, 0x96, 0x01}, reflect.TypeFor[uint64]())
+  fatalErr(t, err)
+  if unknown.Uint() != 150 {
+    t.Fatalf("Decoded %d with an unknown field", unknown.Uint())
+  }
+}
+
+func TestDescribe(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  source := RandID()
+  signal := NewStatusSignal(source, []string{"LockableState"}, nil, nil)
+  value, err := SerializeAny(ctx, reflect.ValueOf(signal))
+  fatalErr(t, err)
+
+  description, err := Describe(ctx, value)
+  fatalErr(t, err)
+  ctx.Log.Logf("test", "DESCRIBED:\n%s", description)
+  for _, expected := range([]string{"graphvent.StatusSignal", "Source: graphvent.NodeID = " + source.String(), "Fields: []string len 1", "[0]: string = \"LockableState\"", "Diffs: []graphvent.FieldDiff = nil"} ) {
+    if strings.Contains(description, expected) == false {
+      t.Fatalf("Description is missing %s:\n%s", expected, description)
+    }
+  }
+
+  truncated, err := Describe(ctx, value[:len(value)-4])
+  if err == nil {
+    t.Fatalf("Described truncated value without error:\n%s", truncated)
+  } else if strings.Contains(truncated, "graphvent.StatusSignal") == false || strings.Contains(truncated, "!! at byte") == false {
+    t.Fatalf("Truncated description is missing the decoded part or the error:\n%s", truncated)
+  }
+}
+
+func TestValidateTypeStack(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+
+  value, err := SerializeAny(ctx, reflect.ValueOf(map[string][]*int{}))
+  fatalErr(t, err)
+  valid, err := ValidateTypeStack(ctx, value)
+  fatalErr(t, err)
+  if valid != reflect.TypeFor[map[string][]*int]() {
+    t.Fatalf("Validated type stack as %s", valid)
+  }
+
+  nil_value, err := SerializeAny(ctx, reflect.ValueOf(&[]any{nil}).Elem().Index(0))
+  fatalErr(t, err)
+  nil_type, err := ValidateTypeStack(ctx, nil_value)
+  fatalErr(t, err)
+  if nil_type != nil {
+    t.Fatalf("Validated nil interface as %s", nil_type)
+  }
+
+  stack := func(types ...uint64) SerializedValue {
+    data := []byte{}
+    for _, t := range(types) {
+      data = binary.BigEndian.AppendUint64(data, t)
+    }
+    return data
+  }
+  pointer := uint64(SerializeType(reflect.Pointer))
+  deep := []uint64{}
+  for i := 0; i <= TYPE_STACK_MAX_DEPTH; i++ {
+    deep = append(deep, pointer)
+  }
+
+  invalid := map[string]SerializedValue{
+    "Unknown type": stack(uint64(SerializeType(reflect.Slice)), 0x1234),
+    "Type stack ended": stack(uint64(SerializeType(reflect.Map)), uint64(SerializedTypeFor[string]())),
+    "isn't comparable": stack(uint64(SerializeType(reflect.Map)), uint64(SerializeType(reflect.Slice)), uint64(SerializedTypeFor[string]()), uint64(SerializedTypeFor[string]())),
+    "longer than": stack(uint64(SerializeType(reflect.Array)), 1 << 40, uint64(SerializedTypeFor[string]())),
+    "nested deeper": stack(deep...),
+  }
+  for expected, value := range(invalid) {
+    _, err := ValidateTypeStack(ctx, value)
+    if err == nil || strings.Contains(err.Error(), expected) == false {
+      t.Fatalf("Expected %q error, got %v", expected, err)
+    }
+
+    _, err = value.Deserialize(ctx)
+    if err == nil || strings.Contains(err.Error(), expected) == false {
+      t.Fatalf("Expected deserializing to fail with %q, got %v", expected, err)
+    }
+  }
+}
+
+type testEmbeddedHeader struct {
+  Seq uint32 `gv:"seq"`
+}
+
+type TestEmbeddedTrailer struct {
+  Note string `gv:"note"`
+}
+
+type testEmbeddedStruct struct {
+  testEmbeddedHeader
+  *TestEmbeddedTrailer
+  Value int64 `gv:"value"`
+}
+
+type testEmbeddedDuplicate struct {
+  testEmbeddedHeader
+  Other uint32 `gv:"seq"`
+}
+
+type testEmbeddedUnexportedPointer struct {
+  *testEmbeddedHeader
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+  ctx := logTestContext(t, []string{"test"})
+  fatalErr(t, RegisterObjectNoGQL[testEmbeddedStruct](ctx))
+
+  values := []testEmbeddedStruct{
+    {testEmbeddedHeader{7}, &TestEmbeddedTrailer{"trailer"}, -4},
+    {testEmbeddedHeader{8}, nil, 5},
+  }
+  for _, format := range([]SerializationFormat{BinaryFormat{}, JSONFormat{}, CBORFormat{}, ProtoFormat{}}) {
+    for _, value := range(values) {
+      data, err := format.Encode(ctx, reflect.ValueOf(value))
+      fatalErr(t, err)
+      decoded, err := format.Decode(ctx, data, reflect.TypeFor[testEmbeddedStruct]())
+      fatalErr(t, err)
+      if reflect.DeepEqual(decoded.Interface(), value) == false {
+        t.Fatalf("%T round trip changed %+v to %+v", format, value, decoded.Interface())
+      }
+    }
+  }
+
+  err := RegisterObjectNoGQL[testEmbeddedDuplicate](ctx)
+  if err == nil {
+    t.Fatal("Registered struct with a promoted field and a field with the same tag")
+  }
+
+  err = RegisterObjectNoGQL[testEmbeddedUnexportedPointer](ctx)
+  if err == nil {
+    t.Fatal("Registered struct with fields promoted through a pointer to an unexported struct")
+  }
+}
@@ -0,0 +1,394 @@
+package graphvent
+
+import (
+  "bytes"
+  "fmt"
+  "go/ast"
+  "go/format"
+  "go/parser"
+  "go/token"
+  "go/types"
+  "reflect"
+  "strconv"
+  "strings"
+  "text/template"
+)
+
+// Structs that implement StaticSerializer on their pointer type are serialized with those methods instead of reflection
+// once they're registered. The methods are generated by GenerateSerializers, run with `go generate` through cmd/gvgen.
+type StaticSerializer interface {
+  StaticSize(ctx *Context) (int, error)
+  StaticSerialize(ctx *Context, data []byte) (int, error)
+  // Deserialize into the struct, returning the data left after it
+  StaticDeserialize(ctx *Context, data []byte) ([]byte, error)
+}
+
+var staticSerializerType = reflect.TypeFor[StaticSerializer]()
+
+// Get the serializer functions for a struct type that implements StaticSerializer, or nils if it doesn't
+func staticSerializeFns(reflect_type reflect.Type, post_deserialize_index int) (SerializeFn, SerializedSizeFn, DeserializeFn) {
+  if reflect.PointerTo(reflect_type).Implements(staticSerializerType) == false {
+    return nil, nil, nil
+  }
+
+  addr := func(value reflect.Value) StaticSerializer {
+    if value.CanAddr() {
+      return value.Addr().Interface().(StaticSerializer)
+    }
+    ptr := reflect.New(reflect_type)
+    ptr.Elem().Set(value)
+    return ptr.Interface().(StaticSerializer)
+  }
+
+  serialize := func(ctx *Context, value reflect.Value, data []byte) (int, error) {
+    return addr(value).StaticSerialize(ctx, data)
+  }
+
+  size := func(ctx *Context, value reflect.Value) (int, error) {
+    return addr(value).StaticSize(ctx)
+  }
+
+  deserialize := func(ctx *Context, data []byte) (reflect.Value, []byte, error) {
+    ptr := reflect.New(reflect_type)
+    left, err := ptr.Interface().(StaticSerializer).StaticDeserialize(ctx, data)
+    if err != nil {
+      return reflect.Value{}, nil, err
+    }
+
+    if post_deserialize_index != -1 {
+      ptr.Method(post_deserialize_index).Call([]reflect.Value{reflect.ValueOf(ctx)})
+    }
+    return ptr.Elem(), left, nil
+  }
+
+  return serialize, size, deserialize
+}
+
+// Fixed size of the builtin types the generated serializers handle without reflection, strings are handled separately
+var staticKindSizes = map[string]int{
+  "bool": 1,
+  "int8": 1,
+  "uint8": 1,
+  "byte": 1,
+  "int16": 2,
+  "uint16": 2,
+  "int32": 4,
+  "uint32": 4,
+  "rune": 4,
+  "float32": 4,
+  "int": 8,
+  "int64": 8,
+  "uint": 8,
+  "uint64": 8,
+  "float64": 8,
+}
+
+type staticField struct {
+  Tag string
+  // Selector from the struct to the field, through any embedded structs
+  Path string
+  Type string
+  Size string
+  Serialize string
+  Deserialize string
+}
+
+type staticStruct struct {
+  Name string
+  Fields []staticField
+}
+
+// Write the code to size, serialize, and deserialize a field. Builtin types are written directly, everything else through reflection
+// so the generated code doesn't need to import the packages of the field types.
+func (field *staticField) generate(imports map[string]bool) {
+  path := "value." + field.Path
+  size, fixed := staticKindSizes[field.Type]
+
+  switch {
+  case fixed:
+    field.Size = fmt.Sprintf("size += %d", size)
+  case field.Type == "string":
+    field.Size = fmt.Sprintf("size += 8 + len(%s)", path)
+  default:
+    imports["reflect"] = true
+    field.Size = fmt.Sprintf(`field_size, err := SerializedSize(ctx, reflect.ValueOf(&%s).Elem())
+      if err != nil {
+        return 0, err
+      }
+      size += field_size`, path)
+  }
+
+  switch field.Type {
+  case "bool":
+    field.Serialize = fmt.Sprintf(`if %s {
+        data[cur] = 0xFF
+      } else {
+        data[cur] = 0x00
+      }
+      cur += 1`, path)
+    field.Deserialize = fmt.Sprintf(`%s = data[0] != 0x00
+      data = data[1:]`, path)
+  case "int8", "uint8", "byte":
+    field.Serialize = fmt.Sprintf(`data[cur] = byte(%s)
+      cur += 1`, path)
+    field.Deserialize = fmt.Sprintf(`%s = %s(data[0])
+      data = data[1:]`, path, field.Type)
+  case "int16", "uint16":
+    field.Serialize = fmt.Sprintf(`binary.BigEndian.PutUint16(data[cur:], uint16(%s))
+      cur += 2`, path)
+    field.Deserialize = fmt.Sprintf(`%s = %s(binary.BigEndian.Uint16(data))
+      data = data[2:]`, path, field.Type)
+  case "int32", "uint32", "rune":
+    field.Serialize = fmt.Sprintf(`binary.BigEndian.PutUint32(data[cur:], uint32(%s))
+      cur += 4`, path)
+    field.Deserialize = fmt.Sprintf(`%s = %s(binary.BigEndian.Uint32(data))
+      data = data[4:]`, path, field.Type)
+  case "int", "int64", "uint", "uint64":
+    field.Serialize = fmt.Sprintf(`binary.BigEndian.PutUint64(data[cur:], uint64(%s))
+      cur += 8`, path)
+    field.Deserialize = fmt.Sprintf(`%s = %s(binary.BigEndian.Uint64(data))
+      data = data[8:]`, path, field.Type)
+  case "float32":
+    imports["math"] = true
+    field.Serialize = fmt.Sprintf(`binary.BigEndian.PutUint32(data[cur:], math.Float32bits(%s))
+      cur += 4`, path)
+    field.Deserialize = fmt.Sprintf(`%s = math.Float32frombits(binary.BigEndian.Uint32(data))
+      data = data[4:]`, path)
+  case "float64":
+    imports["math"] = true
+    field.Serialize = fmt.Sprintf(`binary.BigEndian.PutUint64(data[cur:], math.Float64bits(%s))
+      cur += 8`, path)
+    field.Deserialize = fmt.Sprintf(`%s = math.Float64frombits(binary.BigEndian.Uint64(data))
+      data = data[8:]`, path)
+  case "string":
+    field.Serialize = fmt.Sprintf(`binary.BigEndian.PutUint64(data[cur:], uint64(len(%s)))
+      copy(data[cur+8:], %s)
+      cur += 8 + len(%s)`, path, path, path)
+    field.Deserialize = fmt.Sprintf(`length := int(binary.BigEndian.Uint64(data))
+      %s = string(data[8:8+length])
+      data = data[8+length:]`, path)
+  default:
+    field.Serialize = fmt.Sprintf(`written, err := SerializeValue(ctx, reflect.ValueOf(&%s).Elem(), data[cur:])
+      if err != nil {
+        return 0, err
+      }
+      cur += written`, path)
+    field.Deserialize = fmt.Sprintf(`field := reflect.ValueOf(&%s).Elem()
+      field_value, left, err := DeserializeValue(ctx, data, field.Type())
+      if err != nil {
+        return nil, err
+      }
+      field.Set(field_value)
+      data = left`, path)
+  }
+}
+
+// Collect the gv tagged fields of a struct, including those promoted from embedded structs in the same package
+func staticFields(structs map[string]*ast.StructType, name string, prefix string) ([]staticField, error) {
+  struct_type, exists := structs[name]
+  if exists == false {
+    return nil, fmt.Errorf("%s is not a struct in the package", name)
+  }
+
+  fields := []staticField{}
+  for _, field := range(struct_type.Fields.List) {
+    gv_tag, tagged_gv := "", false
+    if field.Tag != nil {
+      tag, err := strconv.Unquote(field.Tag.Value)
+      if err != nil {
+        return nil, err
+      }
+      gv_tag, tagged_gv = reflect.StructTag(tag).Lookup("gv")
+    }
+    type_string := types.ExprString(field.Type)
+
+    if len(field.Names) == 0 {
+      ident, is_ident := field.Type.(*ast.Ident)
+      selector, is_selector := field.Type.(*ast.SelectorExpr)
+      if tagged_gv && is_ident {
+        fields = append(fields, staticField{Tag: gv_tag, Path: prefix + ident.Name, Type: type_string})
+      } else if tagged_gv && is_selector {
+        fields = append(fields, staticField{Tag: gv_tag, Path: prefix + selector.Sel.Name, Type: type_string})
+        continue
+      }
+
+      if is_ident == false {
+        return nil, fmt.Errorf("Can't generate a serializer for %s, embedded field %s is not a struct in the package", name, type_string)
+      }
+      embedded, err := staticFields(structs, ident.Name, prefix + ident.Name + ".")
+      if err != nil {
+        return nil, err
+      }
+      fields = append(fields, embedded...)
+    } else if tagged_gv {
+      for _, field_name := range(field.Names) {
+        fields = append(fields, staticField{Tag: gv_tag, Path: prefix + field_name.Name, Type: type_string})
+      }
+    }
+  }
+
+  return fields, nil
+}
+
+var staticSerializerTemplate = template.Must(template.New("serializers").Parse(`// Code generated by graphvent.GenerateSerializers. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+  "{{.}}"
+{{- end}}
+)
+{{range .Structs}}{{$name := .Name}}
+var static{{.Name}}Tags = [...]FieldTag{
+{{- range .Fields}}
+  GetFieldTag("{{.Tag}}"),
+{{- end}}
+}
+
+func (value *{{.Name}}) StaticSize(ctx *Context) (int, error) {
+  size := 8 + 16*len(static{{.Name}}Tags)
+{{- range .Fields}}
+  {
+    {{.Size}}
+  }
+{{- end}}
+  return size, nil
+}
+
+func (value *{{.Name}}) StaticSerialize(ctx *Context, data []byte) (int, error) {
+  binary.BigEndian.PutUint64(data, uint64(len(static{{.Name}}Tags)) | StructFieldLengths)
+  cur := 8
+{{- range $i, $field := .Fields}}
+  {
+    binary.BigEndian.PutUint64(data[cur:], uint64(static{{$name}}Tags[{{$i}}]))
+    length_offset := cur + 8
+    cur += 16
+    {{$field.Serialize}}
+    binary.BigEndian.PutUint64(data[length_offset:], uint64(cur - length_offset - 8))
+  }
+{{- end}}
+  return cur, nil
+}
+
+func (value *{{.Name}}) StaticDeserialize(ctx *Context, data []byte) ([]byte, error) {
+  num_fields := binary.BigEndian.Uint64(data)
+  lengths := num_fields & StructFieldLengths != 0
+  num_fields &^= StructFieldLengths
+  data = data[8:]
+  for i := uint64(0); i < num_fields; i++ {
+    field_tag := FieldTag(binary.BigEndian.Uint64(data))
+    data = data[8:]
+    // With lengths, data is limited to the field while it's deserialized
+    var rest []byte
+    if lengths {
+      field_length := binary.BigEndian.Uint64(data)
+      data = data[8:]
+      if field_length > uint64(len(data)) {
+        return nil, fmt.Errorf("Field %s on struct {{.Name}} is %d bytes, only %d left", field_tag, field_length, len(data))
+      }
+      data, rest = data[:field_length], data[field_length:]
+    }
+    switch field_tag {
+{{- range $i, $field := .Fields}}
+    case static{{$name}}Tags[{{$i}}]:
+      {{$field.Deserialize}}
+{{- end}}
+    default:
+      if lengths == false {
+        return nil, fmt.Errorf("Unknown field %s on struct {{.Name}}", field_tag)
+      }
+      // Fields that have been removed from the struct are skipped
+      data = data[len(data):]
+    }
+    if lengths {
+      if len(data) != 0 {
+        return nil, fmt.Errorf("%d bytes left after deserializing field %s on struct {{.Name}}", len(data), field_tag)
+      }
+      data = rest
+    }
+  }
+  return data, nil
+}
+{{end}}`))
+
+// Generate StaticSerializer methods for the named structs in the go package in dir.
+// Builtin fields are serialized directly, other fields fall back to SerializeValue and DeserializeValue.
+func GenerateSerializers(dir string, type_names []string) ([]byte, error) {
+  fset := token.NewFileSet()
+  packages, err := parser.ParseDir(fset, dir, nil, 0)
+  if err != nil {
+    return nil, err
+  }
+
+  var package_name string
+  structs := map[string]*ast.StructType{}
+  for name, pkg := range(packages) {
+    if strings.HasSuffix(name, "_test") {
+      continue
+    }
+    package_name = name
+
+    for _, file := range(pkg.Files) {
+      for _, decl := range(file.Decls) {
+        gen_decl, is_gen := decl.(*ast.GenDecl)
+        if is_gen == false || gen_decl.Tok != token.TYPE {
+          continue
+        }
+        for _, spec := range(gen_decl.Specs) {
+          type_spec := spec.(*ast.TypeSpec)
+          struct_type, is_struct := type_spec.Type.(*ast.StructType)
+          if is_struct && type_spec.TypeParams == nil {
+            structs[type_spec.Name.Name] = struct_type
+          }
+        }
+      }
+    }
+  }
+
+  imports := map[string]bool{
+    "encoding/binary": true,
+    "fmt": true,
+  }
+  generated := []staticStruct{}
+  for _, name := range(type_names) {
+    fields, err := staticFields(structs, name, "")
+    if err != nil {
+      return nil, err
+    }
+
+    seen := map[string]bool{}
+    for i := range(fields) {
+      if seen[fields[i].Tag] {
+        return nil, fmt.Errorf("%s has more than one field tagged gv:\"%s\"", name, fields[i].Tag)
+      }
+      seen[fields[i].Tag] = true
+      fields[i].generate(imports)
+    }
+
+    generated = append(generated, staticStruct{name, fields})
+  }
+
+  import_list := []string{}
+  for _, name := range([]string{"encoding/binary", "fmt", "math", "reflect"}) {
+    if imports[name] {
+      import_list = append(import_list, name)
+    }
+  }
+
+  var source bytes.Buffer
+  err = staticSerializerTemplate.Execute(&source, map[string]any{
+    "Package": package_name,
+    "Imports": import_list,
+    "Structs": generated,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  formatted, err := format.Source(source.Bytes())
+  if err != nil {
+    return nil, fmt.Errorf("Generated serializers don't parse: %w", err)
+  }
+  return formatted, nil
+}
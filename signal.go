@@ -2,11 +2,14 @@ package graphvent
 
 import (
   "fmt"
+  "slices"
   "time"
 
  "github.com/google/uuid"
 )
 
+//go:generate go run ./cmd/gvgen -type TimeoutSignal,SuccessSignal,ErrorSignal,FieldDiff,StatusSignal,AliasSignal,DependencySignal,LinkSignal,LockSignal,UnlockSignal
+
 type TimeoutSignal struct {
   ResponseHeader
 }
@@ -139,7 +142,7 @@ func NewSuccessSignal(req_id uuid.UUID) *SuccessSignal {
 
 type ErrorSignal struct {
   ResponseHeader
-  Error string
+  Error string `gv:"error"`
 }
 func (signal ErrorSignal) String() string {
   return fmt.Sprintf("ErrorSignal(%s, %s)", signal.ResponseHeader, signal.Error)
@@ -161,26 +164,142 @@ func NewACLTimeoutSignal(req_id uuid.UUID) *ACLTimeoutSignal {
   return sig
 }
 
+// The values of a node field before and after it was changed
+type FieldDiff struct {
+  Field string `gv:"field"`
+  Old SerializedValue `gv:"old"`
+  New SerializedValue `gv:"new"`
+}
+
 type StatusSignal struct {
   SignalHeader
   Source NodeID `gv:"source"`
+  // Node fields that were changed
   Fields []string `gv:"fields"`
+  // Extension fields that were changed, including those not mapped to node fields
+  Changes Changes `gv:"changes"`
+  // Old and new values of the changed node fields, only included if enabled for the node type
+  Diffs []FieldDiff `gv:"diffs"`
 }
 func (signal StatusSignal) String() string {
   return fmt.Sprintf("StatusSignal(%s: %+v)", signal.Source, signal.Fields)
 }
-func NewStatusSignal(source NodeID, fields []string) *StatusSignal {
+func NewStatusSignal(source NodeID, fields []string, changes Changes, diffs []FieldDiff) *StatusSignal {
   return &StatusSignal{
     NewSignalHeader(),
     source,
     fields,
+    changes,
+    diffs,
+  }
+}
+
+// Add the fields, changes, and diffs from a later StatusSignal, keeping the oldest value of each diffed field
+func (signal *StatusSignal) merge(fields []string, changes Changes, diffs []FieldDiff) {
+  for _, field := range(fields) {
+    if slices.Contains(signal.Fields, field) == false {
+      signal.Fields = append(signal.Fields, field)
+    }
+  }
+
+  for _, change := range(changes) {
+    signal.Changes.Add(change.Extension, change.Op, change.Field)
+  }
+
+  for _, diff := range(diffs) {
+    merged := false
+    for i, existing := range(signal.Diffs) {
+      if existing.Field == diff.Field {
+        signal.Diffs[i].New = diff.New
+        merged = true
+        break
+      }
+    }
+    if merged == false {
+      signal.Diffs = append(signal.Diffs, diff)
+    }
+  }
+}
+
+// Sent by a GQL node to itself when an authenticated client opens a session
+type SessionStartedSignal struct {
+  SignalHeader
+  Session uuid.UUID `gv:"session"`
+  Client NodeID `gv:"client"`
+  Transport string `gv:"transport"`
+  RemoteAddr string `gv:"remote_addr"`
+}
+func (signal SessionStartedSignal) String() string {
+  return fmt.Sprintf("SessionStartedSignal(%s, %s, %s, %s)", signal.Session, signal.Client, signal.Transport, signal.RemoteAddr)
+}
+func NewSessionStartedSignal(session uuid.UUID, client NodeID, transport string, remote_addr string) *SessionStartedSignal {
+  return &SessionStartedSignal{
+    NewSignalHeader(),
+    session,
+    client,
+    transport,
+    remote_addr,
+  }
+}
+
+// Sent by a GQL node to itself when an authenticated client's session ends
+type SessionEndedSignal struct {
+  SignalHeader
+  Session uuid.UUID `gv:"session"`
+  Client NodeID `gv:"client"`
+  Transport string `gv:"transport"`
+  RemoteAddr string `gv:"remote_addr"`
+}
+func (signal SessionEndedSignal) String() string {
+  return fmt.Sprintf("SessionEndedSignal(%s, %s, %s, %s)", signal.Session, signal.Client, signal.Transport, signal.RemoteAddr)
+}
+func NewSessionEndedSignal(session uuid.UUID, client NodeID, transport string, remote_addr string) *SessionEndedSignal {
+  return &SessionEndedSignal{
+    NewSignalHeader(),
+    session,
+    client,
+    transport,
+    remote_addr,
+  }
+}
+
+// Sent to a node when an alias is assigned to it("add") or removed from it("remove")
+type AliasSignal struct {
+  SignalHeader
+  Action string `gv:"action"`
+  Alias string `gv:"alias"`
+}
+func (signal AliasSignal) String() string {
+  return fmt.Sprintf("AliasSignal(%s, %s, %s)", signal.SignalHeader, signal.Action, signal.Alias)
+}
+func NewAliasSignal(action string, alias string) *AliasSignal {
+  return &AliasSignal{
+    NewSignalHeader(),
+    action,
+    alias,
+  }
+}
+
+// Sent by a lockable to a node it's adding("add") or removing("remove") as a requirement,
+// so the requirement can keep track of the lockables that depend on it
+type DependencySignal struct {
+  SignalHeader
+  Action string `gv:"action"`
+}
+func (signal DependencySignal) String() string {
+  return fmt.Sprintf("DependencySignal(%s, %s)", signal.SignalHeader, signal.Action)
+}
+func NewDependencySignal(action string) *DependencySignal {
+  return &DependencySignal{
+    NewSignalHeader(),
+    action,
   }
 }
 
 type LinkSignal struct {
   SignalHeader
-  NodeID NodeID
-  Action string
+  NodeID NodeID `gv:"node_id"`
+  Action string `gv:"action"`
 }
 
 const (
@@ -222,6 +341,34 @@ func NewUnlockSignal() *UnlockSignal {
   }
 }
 
+// Resets a wedged lockable to Unlocked, only accepted from the lockable itself
+type ForceUnlockSignal struct {
+  SignalHeader
+}
+func (signal ForceUnlockSignal) String() string {
+  return fmt.Sprintf("ForceUnlockSignal(%s)", signal.SignalHeader)
+}
+
+func NewForceUnlockSignal() *ForceUnlockSignal {
+  return &ForceUnlockSignal{
+    NewSignalHeader(),
+  }
+}
+
+// Sent by a lockable to its owner when a ForceUnlockSignal took the lock from it
+type LockLostSignal struct {
+  SignalHeader
+}
+func (signal LockLostSignal) String() string {
+  return fmt.Sprintf("LockLostSignal(%s)", signal.SignalHeader)
+}
+
+func NewLockLostSignal() *LockLostSignal {
+  return &LockLostSignal{
+    NewSignalHeader(),
+  }
+}
+
 
 type ReadSignal struct {
   SignalHeader
@@ -243,6 +390,7 @@ type ReadResultSignal struct {
   ResponseHeader
   NodeID NodeID
   NodeType NodeType
+  // Values of the fields that were read, or an error for fields the node couldn't read
   Fields map[string]any
 }
 
@@ -259,3 +407,23 @@ func NewReadResultSignal(req_id uuid.UUID, node_id NodeID, node_type NodeType, f
   }
 }
 
+// Get the value of a field from a ReadResultSignal as T.
+// Returns an error if the node didn't return the field, couldn't read it, or it isn't a T.
+func ReadResultField[T any](signal *ReadResultSignal, field string) (T, error) {
+  var zero T
+  value, returned := signal.Fields[field]
+  if returned == false {
+    return zero, fmt.Errorf("%s did not return %s", signal.NodeID, field)
+  }
+
+  err, is_err := value.(error)
+  if is_err {
+    return zero, err
+  }
+
+  typed, ok := value.(T)
+  if ok == false {
+    return zero, fmt.Errorf("%s of %s is %T, not %T", field, signal.NodeID, value, zero)
+  }
+  return typed, nil
+}
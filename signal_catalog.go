@@ -0,0 +1,106 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "net/http"
+  "reflect"
+  "slices"
+  "strings"
+
+  "github.com/graphql-go/graphql"
+)
+
+// A serialized field of a signal type in the SignalCatalog
+type SignalCatalogField struct {
+  Name string `json:"name"`
+  Type string `json:"type"`
+  // Name of the field in the GQL object, empty if it's hidden from GQL
+  GQLName string `json:"gql_name,omitempty"`
+  // Whether the field can be set in the SendSignal mutation
+  Input bool `json:"input"`
+}
+
+// A registered signal type, with the names it's known by to the DB and GQL
+type SignalCatalogEntry struct {
+  Name string `json:"name"`
+  SerializedType string `json:"serialized_type"`
+  // Name of the GQL object, empty if the signal isn't exposed to GQL and can't be sent with SendSignal
+  GQLType string `json:"gql_type"`
+  // Whether SendSignal can send the signal on servers that allow it, control signals are exposed to GQL but never sent
+  Sendable bool `json:"sendable"`
+  // Response signals are sent in reply to another signal, with the request's ID in ResponseID
+  Response bool `json:"response"`
+  Fields []SignalCatalogField `json:"fields"`
+}
+
+// Catalog of the signals registered in a context, so clients can be generated against them
+type SignalCatalog struct {
+  Signals []SignalCatalogEntry `json:"signals"`
+}
+
+var signalType = reflect.TypeFor[Signal]()
+var responseSignalType = reflect.TypeFor[ResponseSignal]()
+
+// Build a catalog of every registered signal type, sorted by name
+func (ctx *Context) SignalCatalog() SignalCatalog {
+  catalog := SignalCatalog{
+    Signals: []SignalCatalogEntry{},
+  }
+
+  for reflect_type, type_info := range(ctx.Types) {
+    if reflect_type.Kind() != reflect.Struct || reflect_type == reflect.TypeFor[SignalHeader]() {
+      continue
+    } else if reflect.PointerTo(reflect_type).Implements(signalType) == false {
+      continue
+    }
+
+    entry := SignalCatalogEntry{
+      Name: reflect_type.Name(),
+      SerializedType: type_info.Serialized.String(),
+      Response: reflect.PointerTo(reflect_type).Implements(responseSignalType),
+      Fields: []SignalCatalogField{},
+    }
+    gql_object, is_object := type_info.Type.(*graphql.Object)
+    if is_object {
+      entry.GQLType = gql_object.Name()
+      entry.Sendable = slices.Contains(gqlControlSignals, reflect_type) == false
+    }
+
+    for _, field := range(reflect.VisibleFields(reflect_type)) {
+      gv_tag, tagged_gv := field.Tag.Lookup("gv")
+      if tagged_gv == false {
+        continue
+      }
+
+      catalog_field := SignalCatalogField{
+        Name: gv_tag,
+        Type: field.Type.String(),
+      }
+      gql_name, exposed := gqlFieldName(field, gv_tag)
+      if exposed {
+        catalog_field.GQLName = gql_name
+        catalog_field.Input = gv_tag != "id" && ctx.GQLInputType(field.Type) != nil
+      }
+      entry.Fields = append(entry.Fields, catalog_field)
+    }
+
+    catalog.Signals = append(catalog.Signals, entry)
+  }
+
+  slices.SortFunc(catalog.Signals, func(a, b SignalCatalogEntry) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  return catalog
+}
+
+// Serve the context's SignalCatalog as JSON
+func SignalCatalogHandler(ctx *Context) func(http.ResponseWriter, *http.Request) {
+  return func(w http.ResponseWriter, r *http.Request) {
+    enableCORS(&w)
+    w.Header().Set("Content-Type", "application/json")
+    err := json.NewEncoder(w).Encode(ctx.SignalCatalog())
+    if err != nil {
+      ctx.Log.Logf("gql", "SIGNAL_CATALOG_ERR: %s", err)
+    }
+  }
+}
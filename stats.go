@@ -0,0 +1,259 @@
+package graphvent
+
+import (
+  "slices"
+  "strings"
+
+  "github.com/graphql-go/graphql"
+)
+
+// Counts read from the DB by LoadStats
+type DBStats struct {
+  // Number of nodes created of each type
+  Nodes map[NodeType]int
+  // Number of nodes with each extension
+  Extensions map[ExtType]int
+  // Number of references to other nodes held by each extension field, like LockableExt requirements
+  Edges map[ExtType]map[Tag]int
+  // Size of the DB in bytes
+  Size int64
+}
+
+type NodeTypeStats struct {
+  Type NodeType
+  Name string
+  Persisted int
+  Loaded int
+}
+
+type ExtensionStats struct {
+  Type ExtType
+  Name string
+  Nodes int
+}
+
+type EdgeStats struct {
+  Extension ExtType
+  Name string
+  Field Tag
+  Count int
+}
+
+// Statistics about the nodes in a context and its DB, each list is sorted by name
+type GraphStats struct {
+  NodeTypes []NodeTypeStats
+  Extensions []ExtensionStats
+  Edges []EdgeStats
+  DBSize int64
+  Persisted int
+  Loaded int
+}
+
+// Fraction of the nodes in the DB that are loaded in the context
+func (stats GraphStats) LoadedRatio() float64 {
+  if stats.Persisted == 0 {
+    return 0
+  }
+  return float64(stats.Loaded) / float64(stats.Persisted)
+}
+
+func (ctx *Context) nodeTypeName(node_type NodeType) string {
+  node_info, registered := ctx.NodeTypes[node_type]
+  if registered == false || node_info.Type == nil {
+    return node_type.String()
+  }
+  return node_info.Type.Name()
+}
+
+func (ctx *Context) extensionName(ext_type ExtType) string {
+  ext_info, registered := ctx.Extensions[ext_type]
+  if registered == false {
+    return ext_type.String()
+  }
+  return ext_info.Type.Name()
+}
+
+// Get node counts by type, extension usage, and edge counts from the DB, along with the number of nodes loaded in the context.
+// Scans every key in the DB, so it's meant for occasional capacity planning rather than monitoring.
+func (ctx *Context) Stats() (GraphStats, error) {
+  db_stats, err := ctx.DB.LoadStats(ctx)
+  if err != nil {
+    return GraphStats{}, err
+  }
+
+  loaded := map[NodeType]int{}
+  ctx.nodesLock.RLock()
+  for _, node := range(ctx.nodes) {
+    loaded[node.Node.Type] += 1
+  }
+  ctx.nodesLock.RUnlock()
+
+  stats := GraphStats{
+    NodeTypes: []NodeTypeStats{},
+    Extensions: []ExtensionStats{},
+    Edges: []EdgeStats{},
+    DBSize: db_stats.Size,
+  }
+
+  for node_type, count := range(db_stats.Nodes) {
+    stats.NodeTypes = append(stats.NodeTypes, NodeTypeStats{node_type, ctx.nodeTypeName(node_type), count, loaded[node_type]})
+    stats.Persisted += count
+  }
+  for node_type, count := range(loaded) {
+    _, persisted := db_stats.Nodes[node_type]
+    if persisted == false {
+      stats.NodeTypes = append(stats.NodeTypes, NodeTypeStats{node_type, ctx.nodeTypeName(node_type), 0, count})
+    }
+    stats.Loaded += count
+  }
+
+  for ext_type, count := range(db_stats.Extensions) {
+    stats.Extensions = append(stats.Extensions, ExtensionStats{ext_type, ctx.extensionName(ext_type), count})
+  }
+
+  for ext_type, fields := range(db_stats.Edges) {
+    for field, count := range(fields) {
+      stats.Edges = append(stats.Edges, EdgeStats{ext_type, ctx.extensionName(ext_type), field, count})
+    }
+  }
+
+  slices.SortFunc(stats.NodeTypes, func(a, b NodeTypeStats) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  slices.SortFunc(stats.Extensions, func(a, b ExtensionStats) int {
+    return strings.Compare(a.Name, b.Name)
+  })
+  slices.SortFunc(stats.Edges, func(a, b EdgeStats) int {
+    return strings.Compare(a.Name + "." + string(a.Field), b.Name + "." + string(b.Field))
+  })
+
+  return stats, nil
+}
+
+func statsGQLField(ctx *Context) *graphql.Field {
+  node_type_stats := graphql.NewObject(graphql.ObjectConfig{
+    Name: "NodeTypeStats",
+    Fields: graphql.Fields{
+      "Type": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(NodeTypeStats).Name, nil
+        },
+      },
+      "Persisted": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(NodeTypeStats).Persisted, nil
+        },
+      },
+      "Loaded": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(NodeTypeStats).Loaded, nil
+        },
+      },
+    },
+  })
+
+  extension_stats := graphql.NewObject(graphql.ObjectConfig{
+    Name: "ExtensionStats",
+    Fields: graphql.Fields{
+      "Extension": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(ExtensionStats).Name, nil
+        },
+      },
+      "Nodes": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(ExtensionStats).Nodes, nil
+        },
+      },
+    },
+  })
+
+  edge_stats := graphql.NewObject(graphql.ObjectConfig{
+    Name: "EdgeStats",
+    Fields: graphql.Fields{
+      "Extension": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(EdgeStats).Name, nil
+        },
+      },
+      "Field": &graphql.Field{
+        Type: graphql.String,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return string(p.Source.(EdgeStats).Field), nil
+        },
+      },
+      "Count": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(EdgeStats).Count, nil
+        },
+      },
+    },
+  })
+
+  graph_stats := graphql.NewObject(graphql.ObjectConfig{
+    Name: "GraphStats",
+    Fields: graphql.Fields{
+      "NodeTypes": &graphql.Field{
+        Type: graphql.NewList(node_type_stats),
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(GraphStats).NodeTypes, nil
+        },
+      },
+      "Extensions": &graphql.Field{
+        Type: graphql.NewList(extension_stats),
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(GraphStats).Extensions, nil
+        },
+      },
+      "Edges": &graphql.Field{
+        Type: graphql.NewList(edge_stats),
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(GraphStats).Edges, nil
+        },
+      },
+      // Int is 32 bits in GQL, so the size is returned as a float
+      "DBSize": &graphql.Field{
+        Type: graphql.Float,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return float64(p.Source.(GraphStats).DBSize), nil
+        },
+      },
+      "Persisted": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(GraphStats).Persisted, nil
+        },
+      },
+      "Loaded": &graphql.Field{
+        Type: graphql.Int,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(GraphStats).Loaded, nil
+        },
+      },
+      "LoadedRatio": &graphql.Field{
+        Type: graphql.Float,
+        Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+          return p.Source.(GraphStats).LoadedRatio(), nil
+        },
+      },
+    },
+  })
+
+  return &graphql.Field{
+    Type: graph_stats,
+    Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+      ctx, err := PrepResolve(p)
+      if err != nil {
+        return nil, err
+      }
+      return ctx.Context.Stats()
+    },
+  }
+}
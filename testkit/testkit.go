@@ -0,0 +1,207 @@
+// testkit runs a single graphvent extension on a node that isn't started, so extension tests can feed it signals and
+// check the messages and changes it returns without waiting on node loops:
+//
+//   ctx := testkit.NewContext(t)
+//   err := gv.RegisterExtension[CounterExt](ctx, nil)
+//   h := testkit.Mount(t, ctx, &CounterExt{})
+//   messages, changes := h.Process(source, NewIncrementSignal())
+//   h.ExpectChanges(changes, "count")
+//   testkit.ExpectMessage[*gv.SuccessSignal](h, messages, source)
+//   h.RoundTrip()
+package testkit
+
+import (
+  "crypto/ed25519"
+  "crypto/rand"
+  "reflect"
+  "slices"
+  "testing"
+
+  badger "github.com/dgraph-io/badger/v3"
+  gv "github.com/mekkanized/graphvent"
+)
+
+// Create a context backed by an in-memory DB, logging the components to the console
+func NewContext(t testing.TB, components ...string) *gv.Context {
+  t.Helper()
+
+  db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true))
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() {
+    db.Close()
+  })
+
+  ctx, err := gv.NewContext(&gv.BadgerDB{
+    DB: db,
+  }, gv.NewConsoleLogger(components))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return ctx
+}
+
+// An extension mounted on a node that isn't added to the context, so nothing but the harness calls its methods
+type Harness[E any, T interface { *E; gv.Extension }] struct {
+  T testing.TB
+  Ctx *gv.Context
+  Node *gv.Node
+  Ext T
+  Type gv.ExtType
+}
+
+// Mount ext on a new node with a random key and load it. The extension has to be registered in ctx.
+func Mount[E any, T interface { *E; gv.Extension }](t testing.TB, ctx *gv.Context, ext T) *Harness[E, T] {
+  t.Helper()
+
+  ext_type := gv.ExtTypeFor[E, T]()
+  _, registered := ctx.Extensions[ext_type]
+  if registered == false {
+    t.Fatalf("%s is not registered in the context", reflect.TypeFor[E]())
+  }
+
+  public, key, err := ed25519.GenerateKey(rand.Reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  node := &gv.Node{
+    Key: key,
+    Public: public,
+    ID: gv.KeyID(public),
+    Type: gv.NodeTypeFor("Node"),
+    Extensions: map[gv.ExtType]gv.Extension{
+      ext_type: ext,
+    },
+  }
+
+  err = ext.Load(ctx, node)
+  if err != nil {
+    t.Fatalf("Failed to load %s: %s", reflect.TypeFor[E](), err)
+  }
+
+  harness := &Harness[E, T]{
+    T: t,
+    Ctx: ctx,
+    Node: node,
+    Ext: ext,
+    Type: ext_type,
+  }
+  t.Cleanup(func() {
+    ext.Unload(ctx, node)
+  })
+  return harness
+}
+
+// Process signal from source with the extension, returning the messages and changes it returned
+func (h *Harness[E, T]) Process(source gv.NodeID, signal gv.Signal) ([]gv.Message, gv.Changes) {
+  h.T.Helper()
+
+  h.Ctx.Log.Logf("test", "Processing %s from %s", signal, source)
+  messages, changes := h.Ext.Process(h.Ctx, h.Node, source, signal)
+  for _, change := range(changes) {
+    if change.Extension != h.Type {
+      h.T.Errorf("%s returned a change to %s:%s, which isn't one of its fields", reflect.TypeFor[E](), change.Extension, change.Field)
+    }
+  }
+  return messages, changes
+}
+
+// Fail unless changes lists exactly the fields, in any order
+func (h *Harness[E, T]) ExpectChanges(changes gv.Changes, fields ...gv.Tag) {
+  h.T.Helper()
+
+  changed := changes.ByExtension()[h.Type]
+  for _, field := range(fields) {
+    if slices.Contains(changed, field) == false {
+      h.T.Errorf("Expected %s to change, changed %+v", field, changed)
+    }
+  }
+  for _, field := range(changed) {
+    if slices.Contains(fields, field) == false {
+      h.T.Errorf("Unexpected change to %s", field)
+    }
+  }
+}
+
+// Find the message with a signal of type S sent to dest, failing if there isn't exactly one
+func ExpectMessage[S gv.Signal, E any, T interface { *E; gv.Extension }](h *Harness[E, T], messages []gv.Message, dest gv.NodeID) S {
+  h.T.Helper()
+
+  var found []S
+  for _, message := range(messages) {
+    signal, matches := message.Signal.(S)
+    if matches && message.Node == dest {
+      found = append(found, signal)
+    }
+  }
+
+  if len(found) != 1 {
+    var zero S
+    h.T.Fatalf("Expected one %s to %s, found %d in %+v", reflect.TypeFor[S](), dest, len(found), messages)
+    return zero
+  }
+  return found[0]
+}
+
+// Fail if any message was returned
+func (h *Harness[E, T]) ExpectNoMessages(messages []gv.Message) {
+  h.T.Helper()
+
+  if len(messages) != 0 {
+    h.T.Errorf("Expected no messages, got %+v", messages)
+  }
+}
+
+// Serialize the extension the way it's written to the DB and check every field it's stored with reads back the same
+func (h *Harness[E, T]) RoundTrip() T {
+  h.T.Helper()
+
+  ext_info := h.Ctx.Extensions[h.Type]
+  loaded := RoundTrip[gv.Extension](h.T, h.Ctx, h.Ext).(T)
+
+  original := reflect.ValueOf(h.Ext).Elem()
+  read := reflect.ValueOf(loaded).Elem()
+  for tag, field_info := range(ext_info.Fields) {
+    before, err := original.FieldByIndexErr(field_info.Index)
+    if err != nil {
+      continue
+    }
+    after, err := read.FieldByIndexErr(field_info.Index)
+    if err != nil {
+      h.T.Errorf("Field %s was set before serializing, but not after", tag)
+      continue
+    }
+    if reflect.DeepEqual(before.Interface(), after.Interface()) == false {
+      h.T.Errorf("Field %s changed when serialized: %+v != %+v", tag, before, after)
+    }
+  }
+  return loaded
+}
+
+// Serialize value as a V and deserialize it, failing if either fails or there's data left over
+func RoundTrip[V any](t testing.TB, ctx *gv.Context, value V) V {
+  t.Helper()
+
+  reflect_value := reflect.ValueOf(&value).Elem()
+  size, err := gv.SerializedSize(ctx, reflect_value)
+  if err != nil {
+    t.Fatalf("Failed to size %+v: %s", value, err)
+  }
+
+  // The size of interfaces is an upper bound, so values holding them can be written in fewer than size bytes
+  data := make([]byte, size)
+  written, err := gv.SerializeValue(ctx, reflect_value, data)
+  if err != nil {
+    t.Fatalf("Failed to serialize %+v: %s", value, err)
+  } else if written > size {
+    t.Fatalf("Serialized %+v to %d bytes, but its size is %d", value, written, size)
+  }
+
+  read, err := gv.Deserialize[V](ctx, data[:written])
+  if err != nil {
+    t.Fatalf("Failed to deserialize %+v: %s", value, err)
+  }
+  return read
+}
@@ -0,0 +1,31 @@
+package testkit
+
+import (
+  "testing"
+
+  gv "github.com/mekkanized/graphvent"
+)
+
+func TestHarness(t *testing.T) {
+  ctx := NewContext(t, "test")
+  h := Mount(t, ctx, gv.NewLockableExt(nil))
+  owner := gv.RandID()
+
+  lock := gv.NewLockSignal()
+  messages, changes := h.Process(owner, lock)
+  h.ExpectChanges(changes, "state", "owner", "pending_owner")
+  success := ExpectMessage[*gv.SuccessSignal](h, messages, owner)
+  if success.ReqID != lock.ID() {
+    t.Fatalf("Success responds to %s instead of %s", success.ReqID, lock.ID())
+  }
+
+  loaded := h.RoundTrip()
+  if loaded.State != gv.Locked || *loaded.Owner != owner {
+    t.Fatalf("Wrong lock state after round trip: %+v", loaded)
+  }
+
+  signal := RoundTrip[gv.Signal](t, ctx, gv.NewLockSignal())
+  if _, is_lock := signal.(*gv.LockSignal); is_lock == false {
+    t.Fatalf("Round tripped a LockSignal into %+v", signal)
+  }
+}
@@ -0,0 +1,148 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+// The signal that changed a node in a timeline
+type TimelineSignal struct {
+  Type string `json:"type"`
+  ID uuid.UUID `json:"id"`
+  Source NodeID `json:"source"`
+}
+
+// The state of a node after a version was written, with field values encoded as JSON
+type TimelineState struct {
+  Version uint64 `json:"version"`
+  Time time.Time `json:"time"`
+  // nil for the version written when the node was created or loaded
+  Signal *TimelineSignal `json:"signal"`
+  Changed []string `json:"changed"`
+  Fields map[string]any `json:"fields"`
+}
+
+// The states of a node over a time range, for scrubbing through what happened to it.
+// States starts with the state that was current at From if the history goes back that far.
+type Timeline struct {
+  Node NodeID `json:"node"`
+  From time.Time `json:"from"`
+  To time.Time `json:"to"`
+  States []TimelineState `json:"states"`
+}
+
+// Build the timeline of a node between from and to from its stored history
+func ReadNodeTimeline(ctx *Context, id NodeID, from time.Time, to time.Time) (Timeline, error) {
+  if to.Before(from) {
+    return Timeline{}, fmt.Errorf("Timeline ends at %s, before it starts at %s", to, from)
+  }
+
+  history, err := ReadNodeHistory(ctx, id)
+  if err != nil {
+    return Timeline{}, err
+  }
+
+  timeline := Timeline{
+    Node: id,
+    From: from,
+    To: to,
+    States: []TimelineState{},
+  }
+
+  // The last version written at or before from is the state the timeline starts in
+  first := 0
+  for i, version := range(history) {
+    if version.Time <= from.UnixNano() {
+      first = i
+    }
+  }
+
+  for _, version := range(history[first:]) {
+    if version.Time > to.UnixNano() {
+      break
+    }
+
+    state, err := timelineState(ctx, version)
+    if err != nil {
+      return Timeline{}, fmt.Errorf("Failed to read version %d of %s: %w", version.Version, id, err)
+    }
+    timeline.States = append(timeline.States, state)
+  }
+
+  return timeline, nil
+}
+
+func timelineState(ctx *Context, version NodeVersion) (TimelineState, error) {
+  state := TimelineState{
+    Version: version.Version,
+    Time: time.Unix(0, version.Time),
+    Changed: version.Changed,
+    Fields: map[string]any{},
+  }
+  if state.Changed == nil {
+    state.Changed = []string{}
+  }
+  if version.Trigger.Signal != "" {
+    state.Signal = &TimelineSignal{version.Trigger.Signal, version.Trigger.ID, version.Trigger.Source}
+  }
+
+  for field_name, serialized := range(version.Fields) {
+    value, err := serialized.Deserialize(ctx)
+    if err != nil {
+      return TimelineState{}, err
+    }
+    state.Fields[field_name], err = encodeJSON(ctx, value)
+    if err != nil {
+      return TimelineState{}, err
+    }
+  }
+  return state, nil
+}
+
+// Serve the timeline of a node, taking the node ID and optional RFC 3339 from and to times as query parameters.
+// from defaults to the start of the node's history and to defaults to now.
+func TimelineHandler(ctx *Context) func(http.ResponseWriter, *http.Request) {
+  return func(w http.ResponseWriter, r *http.Request) {
+    enableCORS(&w)
+
+    query := r.URL.Query()
+    id, err := ParseID(query.Get("node"))
+    if err != nil {
+      http.Error(w, fmt.Sprintf("Bad node: %s", err), http.StatusBadRequest)
+      return
+    }
+
+    from := time.Time{}
+    to := time.Now()
+    if query.Has("from") {
+      from, err = time.Parse(time.RFC3339Nano, query.Get("from"))
+      if err != nil {
+        http.Error(w, fmt.Sprintf("Bad from: %s", err), http.StatusBadRequest)
+        return
+      }
+    }
+    if query.Has("to") {
+      to, err = time.Parse(time.RFC3339Nano, query.Get("to"))
+      if err != nil {
+        http.Error(w, fmt.Sprintf("Bad to: %s", err), http.StatusBadRequest)
+        return
+      }
+    }
+
+    timeline, err := ReadNodeTimeline(ctx, id, from, to)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusInternalServerError)
+      return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    err = json.NewEncoder(w).Encode(timeline)
+    if err != nil {
+      ctx.Log.Logf("gql", "TIMELINE_ERR: %s", err)
+    }
+  }
+}
@@ -0,0 +1,364 @@
+package graphvent
+
+import (
+  "fmt"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+// How long a node holds a signal prepared without a deadline before ending the transaction on its own
+const TX_PREPARE_TIMEOUT = 10*time.Second
+// How many signals a node defers while it's prepared, after that they're refused with a tx_deferred_full ErrorSignal
+const TX_MAX_DEFERRED = 1024
+
+// Extensions that implement TxExtension check the signals their node is asked to prepare, so a signal that would fail
+// is refused when it's prepared instead of failing after the transaction commits. The node defers other signals while
+// it's prepared, so the state checked here is the state the signal is processed with.
+type TxExtension interface {
+  CheckPrepare(ctx *Context, node *Node, source NodeID, signal Signal) error
+}
+
+// Asks a node to hold a signal until the transaction is committed or aborted.
+// While a node holds a prepared signal it defers every other signal except reads, so the transaction is isolated.
+// If the transaction hasn't ended by the deadline the node ends it with the decision recorded in the DB, aborting it if there isn't one.
+type PrepareSignal struct {
+  SignalHeader
+  Tx uuid.UUID `gv:"tx"`
+  Deadline time.Time `gv:"deadline"`
+  Signal Signal `gv:"signal"`
+}
+func (signal PrepareSignal) String() string {
+  return fmt.Sprintf("PrepareSignal(%s, %s, %s, %s)", signal.SignalHeader, signal.Tx, signal.Deadline, signal.Signal)
+}
+func NewPrepareSignal(tx uuid.UUID, deadline time.Time, signal Signal) *PrepareSignal {
+  return &PrepareSignal{
+    NewSignalHeader(),
+    tx,
+    deadline,
+    signal,
+  }
+}
+
+// Tells a node to process the signal it prepared for the transaction
+type CommitSignal struct {
+  SignalHeader
+  Tx uuid.UUID `gv:"tx"`
+}
+func (signal CommitSignal) String() string {
+  return fmt.Sprintf("CommitSignal(%s, %s)", signal.SignalHeader, signal.Tx)
+}
+func NewCommitSignal(tx uuid.UUID) *CommitSignal {
+  return &CommitSignal{
+    NewSignalHeader(),
+    tx,
+  }
+}
+
+// Tells a node to drop the signal it prepared for the transaction
+type AbortSignal struct {
+  SignalHeader
+  Tx uuid.UUID `gv:"tx"`
+}
+func (signal AbortSignal) String() string {
+  return fmt.Sprintf("AbortSignal(%s, %s)", signal.SignalHeader, signal.Tx)
+}
+func NewAbortSignal(tx uuid.UUID) *AbortSignal {
+  return &AbortSignal{
+    NewSignalHeader(),
+    tx,
+  }
+}
+
+// A signal a node is holding for a transaction
+type preparedTx struct {
+  Source NodeID
+  Signal *PrepareSignal
+  // Signals received while prepared, processed after the transaction ends
+  Deferred []Message
+}
+
+// Write the prepared signal to the DB, or remove it if the node isn't prepared
+func (node *Node) writePreparedTx(ctx *Context) error {
+  node.writePrepared = true
+  err := ctx.DB.WriteNodeChanges(ctx, node, nil)
+  if err != nil {
+    return err
+  }
+  ctx.publishNodeEvent(EventWriteFlushed, node)
+  return nil
+}
+
+// End the prepared transaction, processing the prepared signal if it committed.
+// Returns the signals deferred while it was prepared, which the caller has to process,
+// and the response the prepared signal sent to it's source when it was processed if there was one.
+func (node *Node) endPreparedTx(ctx *Context, commit bool) ([]Message, ResponseSignal) {
+  prepared := node.prepared
+  node.prepared = nil
+
+  // If this fails the prepared signal is read back when the node is loaded, and ended again when its deadline passes
+  err := node.writePreparedTx(ctx)
+  if err != nil {
+    ctx.Log.Logf("tx", "%s failed to remove prepared %s: %s", node.ID, prepared.Signal.Tx, err)
+  }
+
+  var response ResponseSignal = nil
+  if commit {
+    node.committing = prepared.Signal.Signal.ID()
+    node.handleSignal(ctx, prepared.Source, prepared.Signal.Signal)
+    response = node.commitResponse
+    node.committing = ZeroUUID
+    node.commitResponse = nil
+  }
+  return prepared.Deferred, response
+}
+
+// Check that the node can process the signal it's asked to prepare
+func (node *Node) checkPrepare(ctx *Context, source NodeID, signal Signal) error {
+  err := ctx.checkMaintenanceSignal(signal)
+  if err != nil {
+    return err
+  }
+
+  for ext_type, ext := range(node.Extensions) {
+    tx_ext, checks := ext.(TxExtension)
+    if checks {
+      err := tx_ext.CheckPrepare(ctx, node, source, signal)
+      if err != nil {
+        return fmt.Errorf("%s: %w", ext_type, err)
+      }
+    }
+  }
+  return nil
+}
+
+// Handle transaction signals, and defer other signals while a transaction is prepared.
+// Returns true if the signal was handled.
+func (node *Node) handleTxSignal(ctx *Context, source NodeID, signal Signal) bool {
+  // The deadline of the prepared transaction passed without a commit or abort
+  timeout, is_timeout := signal.(*TimeoutSignal)
+  if is_timeout && source == node.ID && node.prepared != nil && timeout.ReqID == node.prepared.Signal.ID() {
+    commit, err := ctx.DB.WriteTxDecision(ctx, node.prepared.Signal.Tx, false)
+    if err != nil {
+      ctx.Log.Logf("tx", "%s failed to record abort of %s, retrying: %s", node.ID, node.prepared.Signal.Tx, err)
+      node.QueueSignal(time.Now().Add(TX_PREPARE_TIMEOUT), NewTimeoutSignal(timeout.ReqID))
+      return true
+    }
+
+    tx := node.prepared.Signal.Tx
+    ctx.Log.Logf("tx", "%s reached the deadline of %s, commit: %t", node.ID, tx, commit)
+    deferred, response := node.endPreparedTx(ctx, commit)
+    if error_signal, failed := response.(*ErrorSignal); failed {
+      ctx.Log.Logf("tx", "%s failed to commit %s at the deadline: %s", node.ID, tx, error_signal.Error)
+    }
+    for _, msg := range(deferred) {
+      node.handleSignal(ctx, msg.Node, msg.Signal)
+    }
+    return true
+  }
+
+  switch sig := signal.(type) {
+  case *PrepareSignal:
+    if node.prepared != nil {
+      ctx.Send(node, []Message{{source, NewErrorSignal(sig.ID(), "tx_busy")}})
+      return true
+    }
+
+    err := node.checkPrepare(ctx, source, sig.Signal)
+    if err != nil {
+      ctx.Log.Logf("tx", "%s refused to prepare %s: %s", node.ID, sig.Tx, err)
+      ctx.Send(node, []Message{{source, NewErrorSignal(sig.ID(), "tx_refused: %s", err)}})
+      return true
+    }
+
+    deadline := sig.Deadline
+    if deadline.IsZero() {
+      deadline = time.Now().Add(TX_PREPARE_TIMEOUT)
+    }
+
+    // The timeout is written with the prepared signal, so the node still ends the transaction if it's unloaded
+    node.prepared = &preparedTx{
+      Source: source,
+      Signal: sig,
+    }
+    node.QueueSignal(deadline, NewTimeoutSignal(sig.ID()))
+    err = node.writePreparedTx(ctx)
+    if err != nil {
+      ctx.Log.Logf("tx", "%s failed to write prepared %s: %s", node.ID, sig.Tx, err)
+      node.prepared = nil
+      ctx.Send(node, []Message{{source, NewErrorSignal(sig.ID(), "tx_write_failed")}})
+    } else {
+      ctx.Send(node, []Message{{source, NewSuccessSignal(sig.ID())}})
+    }
+    return true
+
+  case *CommitSignal, *AbortSignal:
+    var tx uuid.UUID
+    switch sig := sig.(type) {
+    case *CommitSignal:
+      tx = sig.Tx
+    case *AbortSignal:
+      tx = sig.Tx
+    }
+
+    if node.prepared == nil || node.prepared.Signal.Tx != tx {
+      ctx.Send(node, []Message{{source, NewErrorSignal(signal.ID(), "not_prepared")}})
+      return true
+    }
+
+    _, commit := sig.(*CommitSignal)
+    deferred, response := node.endPreparedTx(ctx, commit)
+    if error_signal, failed := response.(*ErrorSignal); failed {
+      ctx.Send(node, []Message{{source, NewErrorSignal(signal.ID(), "commit_failed: %s", error_signal.Error)}})
+    } else {
+      ctx.Send(node, []Message{{source, NewSuccessSignal(signal.ID())}})
+    }
+
+    for _, msg := range(deferred) {
+      node.handleSignal(ctx, msg.Node, msg.Signal)
+    }
+    return true
+
+  case *ReadSignal:
+    return false
+
+  default:
+    if node.prepared != nil {
+      if len(node.prepared.Deferred) >= TX_MAX_DEFERRED {
+        ctx.Log.Logf("tx", "%s dropped %s, %d signals are already deferred", node.ID, signal, len(node.prepared.Deferred))
+        // Responses aren't answered with errors, so two nodes can't keep erroring at each other
+        if _, is_response := signal.(ResponseSignal); is_response == false {
+          ctx.Send(node, []Message{{source, NewErrorSignal(signal.ID(), "tx_deferred_full")}})
+        }
+        return true
+      }
+      node.prepared.Deferred = append(node.prepared.Deferred, Message{source, signal})
+      return true
+    }
+    return false
+  }
+}
+
+// Send each message's signal to its node as part of one transaction, from source.
+// Every node is asked to prepare the signal, and if any refuse or don't respond within timeout every node is told to abort,
+// so nodes that prepare after the timeout don't hold the signal. The decision is recorded in the DB before any node is told to commit,
+// and nodes that are still prepared twice the timeout after the transaction started end it with the recorded decision.
+// Responses are read from the responses channel, and other signals read from it while waiting are dropped.
+func RunTransaction(ctx *Context, source *Node, responses chan Signal, messages []Message, timeout time.Duration) error {
+  tx := uuid.New()
+  deadline := time.Now().Add(2*timeout)
+
+  prepare_signals := make([]*PrepareSignal, len(messages))
+  prepare_messages := make([]Message, len(messages))
+  for i, msg := range(messages) {
+    prepare_signals[i] = NewPrepareSignal(tx, deadline, msg.Signal)
+    prepare_messages[i] = Message{msg.Node, prepare_signals[i]}
+  }
+
+  err := ctx.Send(source, prepare_messages)
+  if err != nil {
+    return err
+  }
+
+  prepare_ids := make([]uuid.UUID, len(prepare_signals))
+  for i, prepare_signal := range(prepare_signals) {
+    prepare_ids[i] = prepare_signal.ID()
+  }
+  prepare_responses := waitForResponses(responses, timeout, prepare_ids)
+
+  prepared := map[NodeID]bool{}
+  var prepare_err error = nil
+  for i, prepare_signal := range(prepare_signals) {
+    switch response := prepare_responses[prepare_signal.ID()].(type) {
+    case nil:
+      prepare_err = fmt.Errorf("%s did not respond to prepare", messages[i].Node)
+    case *SuccessSignal:
+      prepared[messages[i].Node] = true
+    case *ErrorSignal:
+      prepare_err = fmt.Errorf("%s refused to prepare: %s", messages[i].Node, response.Error)
+    default:
+      prepare_err = fmt.Errorf("Bad prepare response from %s: %+v", messages[i].Node, response)
+    }
+  }
+
+  // Nodes that reached the deadline first have recorded an abort, which every node follows
+  commit, err := ctx.DB.WriteTxDecision(ctx, tx, prepare_err == nil)
+  if err != nil {
+    commit = false
+    if prepare_err == nil {
+      prepare_err = fmt.Errorf("Failed to record commit of %s: %w", tx, err)
+    }
+  } else if commit == false && prepare_err == nil {
+    prepare_err = fmt.Errorf("%s was aborted by a node that reached the deadline", tx)
+  }
+
+  end_messages := []Message{}
+  for _, msg := range(messages) {
+    if commit {
+      end_messages = append(end_messages, Message{msg.Node, NewCommitSignal(tx)})
+    } else {
+      end_messages = append(end_messages, Message{msg.Node, NewAbortSignal(tx)})
+    }
+  }
+
+  err = ctx.Send(source, end_messages)
+  if err != nil {
+    return err
+  }
+
+  end_ids := make([]uuid.UUID, len(end_messages))
+  for i, msg := range(end_messages) {
+    end_ids[i] = msg.Signal.ID()
+  }
+  end_responses := waitForResponses(responses, timeout, end_ids)
+
+  var end_err error = nil
+  for _, msg := range(end_messages) {
+    switch response := end_responses[msg.Signal.ID()].(type) {
+    case nil:
+      if prepared[msg.Node] {
+        end_err = fmt.Errorf("%s did not respond to %s", msg.Node, msg.Signal)
+      }
+    case *ErrorSignal:
+      // Nodes that didn't prepare have nothing to abort
+      if prepared[msg.Node] || commit {
+        end_err = fmt.Errorf("%s failed %s: %s", msg.Node, msg.Signal, response.Error)
+      }
+    }
+  }
+
+  // Nodes that didn't respond still need the decision when they reach the deadline
+  if end_err != nil {
+    return end_err
+  }
+
+  err = ctx.DB.RemoveTxDecision(ctx, tx)
+  if err != nil {
+    ctx.Log.Logf("tx", "Failed to remove decision of %s: %s", tx, err)
+  }
+
+  return prepare_err
+}
+
+// Wait until there's a response to each request or the timeout passes, returning the responses received
+func waitForResponses(responses chan Signal, timeout time.Duration, req_ids []uuid.UUID) map[uuid.UUID]ResponseSignal {
+  results := map[uuid.UUID]ResponseSignal{}
+  waiting := map[uuid.UUID]bool{}
+  for _, req_id := range(req_ids) {
+    waiting[req_id] = true
+  }
+
+  timeout_channel := time.After(timeout)
+  for len(results) < len(waiting) {
+    select {
+    case signal := <-responses:
+      response, ok := signal.(ResponseSignal)
+      if ok && waiting[response.ResponseID()] {
+        results[response.ResponseID()] = response
+      }
+    case <-timeout_channel:
+      return results
+    }
+  }
+  return results
+}
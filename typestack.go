@@ -0,0 +1,94 @@
+package graphvent
+
+import (
+  "encoding/binary"
+  "fmt"
+  "reflect"
+)
+
+// Deepest nesting of maps, pointers, slices, and arrays ValidateTypeStack accepts
+const TYPE_STACK_MAX_DEPTH = 32
+
+// Longest array ValidateTypeStack accepts, so a corrupt length can't make it build a huge array type
+const TYPE_STACK_MAX_ARRAY = 1 << 20
+
+// Check the type stack at the start of a SerializedValue against the registry without deserializing the value,
+// returning the type it describes or an error with the byte offset of the first unknown or invalid type.
+// A nil interface has no type and returns nil without an error.
+func ValidateTypeStack(ctx *Context, value SerializedValue) (reflect.Type, error) {
+  data, err := value.decompress()
+  if err != nil {
+    return nil, err
+  }
+  return validateTypeStack(ctx, data)
+}
+
+func validateTypeStack(ctx *Context, data []byte) (reflect.Type, error) {
+  if len(data) >= 8 && SerializedType(binary.BigEndian.Uint64(data[0:8])) == NilInterfaceType {
+    return nil, nil
+  }
+  t, _, err := validateStack(ctx, data, 0, 0)
+  return t, err
+}
+
+func validateStack(ctx *Context, data []byte, offset int, depth int) (reflect.Type, int, error) {
+  if depth > TYPE_STACK_MAX_DEPTH {
+    return nil, 0, fmt.Errorf("Type stack nested deeper than %d at byte %d", TYPE_STACK_MAX_DEPTH, offset)
+  } else if len(data) < offset + 8 {
+    return nil, 0, fmt.Errorf("Type stack ended at byte %d, %d bytes left", offset, len(data) - offset)
+  }
+
+  first := SerializedType(binary.BigEndian.Uint64(data[offset:offset+8]))
+  info, registered := ctx.TypesReverse[first]
+  if registered {
+    return info.Reflect, offset + 8, nil
+  }
+
+  switch first {
+  case SerializeType(reflect.Map):
+    key_type, after_key, err := validateStack(ctx, data, offset + 8, depth + 1)
+    if err != nil {
+      return nil, 0, err
+    } else if key_type.Comparable() == false {
+      return nil, 0, fmt.Errorf("Map at byte %d has key type %s, which isn't comparable", offset, key_type)
+    }
+
+    elem_type, after_elem, err := validateStack(ctx, data, after_key, depth + 1)
+    if err != nil {
+      return nil, 0, err
+    }
+    return reflect.MapOf(key_type, elem_type), after_elem, nil
+
+  case SerializeType(reflect.Pointer):
+    elem_type, after, err := validateStack(ctx, data, offset + 8, depth + 1)
+    if err != nil {
+      return nil, 0, err
+    }
+    return reflect.PointerTo(elem_type), after, nil
+
+  case SerializeType(reflect.Slice):
+    elem_type, after, err := validateStack(ctx, data, offset + 8, depth + 1)
+    if err != nil {
+      return nil, 0, err
+    }
+    return reflect.SliceOf(elem_type), after, nil
+
+  case SerializeType(reflect.Array):
+    if len(data) < offset + 16 {
+      return nil, 0, fmt.Errorf("Array at byte %d has no length", offset)
+    }
+    length := binary.BigEndian.Uint64(data[offset+8:offset+16])
+    if length > TYPE_STACK_MAX_ARRAY {
+      return nil, 0, fmt.Errorf("Array at byte %d has length %d, longer than %d", offset, length, TYPE_STACK_MAX_ARRAY)
+    }
+
+    elem_type, after, err := validateStack(ctx, data, offset + 16, depth + 1)
+    if err != nil {
+      return nil, 0, err
+    }
+    return reflect.ArrayOf(int(length), elem_type), after, nil
+
+  default:
+    return nil, 0, fmt.Errorf("Unknown type %s at byte %d of type stack", first, offset)
+  }
+}
@@ -0,0 +1,255 @@
+package graphvent
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "slices"
+  "sync"
+  "time"
+)
+
+// Sent by a Watchdog when a node's inbox stops making progress
+type WatchdogAlertSignal struct {
+  SignalHeader
+  Node NodeID `gv:"node"`
+  // Number of messages delivered to the node that it hasn't started processing
+  Depth int `gv:"depth"`
+  // Set if the node's inbox is bounded and at capacity
+  Full bool `gv:"full"`
+  // The oldest queued message has been waiting since at least this time
+  WaitingSince time.Time `gv:"waiting_since"`
+  // Type of the signal the node is processing, empty if it's idle
+  Processing string `gv:"processing"`
+  ProcessingSince time.Time `gv:"processing_since"`
+}
+func (signal WatchdogAlertSignal) String() string {
+  return fmt.Sprintf("WatchdogAlertSignal(%s, %s, %d, %t, %s)", signal.SignalHeader, signal.Node, signal.Depth, signal.Full, signal.Processing)
+}
+func NewWatchdogAlertSignal(node NodeID, depth int, full bool, waiting_since time.Time, processing string, processing_since time.Time) *WatchdogAlertSignal {
+  return &WatchdogAlertSignal{
+    NewSignalHeader(),
+    node,
+    depth,
+    full,
+    waiting_since,
+    processing,
+    processing_since,
+  }
+}
+
+type WatchdogConfig struct {
+  // How often the loaded nodes are checked
+  Interval time.Duration
+  // A node is stuck once it has had messages queued for this long without starting one
+  MaxAge time.Duration
+  // Node sent a WatchdogAlertSignal when a node becomes stuck, alerts are only logged if ZeroID
+  Target NodeID
+}
+
+// Signal a node is processing and when it started
+type processingSignal struct {
+  signal Signal
+  start time.Time
+}
+
+// Periodically checks the inboxes of loaded nodes, alerting once when a node's inbox is full or
+// its oldest message is older than MaxAge, and keeping the alert until the node makes progress.
+type Watchdog struct {
+  ctx *Context
+  config WatchdogConfig
+
+  lock sync.Mutex
+  stuck map[NodeID]*WatchdogAlertSignal
+
+  stop chan struct{}
+  done chan struct{}
+}
+
+// Create a Watchdog and start checking the context's nodes every config.Interval
+func NewWatchdog(ctx *Context, config WatchdogConfig) (*Watchdog, error) {
+  if config.Interval <= 0 || config.MaxAge <= 0 {
+    return nil, fmt.Errorf("Watchdog needs a positive interval and max age, got %s and %s", config.Interval, config.MaxAge)
+  }
+
+  watchdog := &Watchdog{
+    ctx: ctx,
+    config: config,
+    stuck: map[NodeID]*WatchdogAlertSignal{},
+    stop: make(chan struct{}),
+    done: make(chan struct{}),
+  }
+
+  go func() {
+    defer close(watchdog.done)
+    ticker := time.NewTicker(config.Interval)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-watchdog.stop:
+        return
+      case <-ticker.C:
+        watchdog.Check()
+      }
+    }
+  }()
+
+  return watchdog, nil
+}
+
+func (watchdog *Watchdog) Stop() {
+  close(watchdog.stop)
+  <-watchdog.done
+}
+
+// Check every loaded node, sending alerts for nodes that became stuck since the last check
+func (watchdog *Watchdog) Check() {
+  ctx := watchdog.ctx
+  now := time.Now()
+
+  ctx.nodesLock.RLock()
+  alerts := []*WatchdogAlertSignal{}
+  stuck := map[NodeID]*WatchdogAlertSignal{}
+  sources := map[NodeID]*Node{}
+  for id, context_node := range(ctx.nodes) {
+    alert := watchdog.checkNode(context_node.Node, now)
+    if alert != nil {
+      stuck[id] = alert
+      sources[id] = context_node.Node
+    }
+  }
+  ctx.nodesLock.RUnlock()
+
+  watchdog.lock.Lock()
+  for id, alert := range(stuck) {
+    if _, already_stuck := watchdog.stuck[id]; already_stuck == false {
+      alerts = append(alerts, alert)
+    }
+  }
+  watchdog.stuck = stuck
+  watchdog.lock.Unlock()
+
+  for _, alert := range(alerts) {
+    ctx.Log.Logf("watchdog", "STUCK: %s", alert)
+    if watchdog.config.Target != ZeroID {
+      err := ctx.Send(sources[alert.Node], []Message{{watchdog.config.Target, alert}})
+      if err != nil {
+        ctx.Log.Logf("watchdog", "ALERT_SEND_ERR: %s", err)
+      }
+    }
+  }
+}
+
+func (watchdog *Watchdog) checkNode(node *Node, now time.Time) *WatchdogAlertSignal {
+  depth := node.queued.Load()
+  if depth <= 0 {
+    return nil
+  }
+
+  config := watchdog.ctx.NodeTypes[node.Type].Inbox
+  full := config.Strategy != InboxGrow && depth >= int64(config.Capacity)
+  waiting_since := time.Unix(0, node.progress.Load())
+  if full == false && now.Sub(waiting_since) < watchdog.config.MaxAge {
+    return nil
+  }
+
+  processing := ""
+  var processing_since time.Time
+  current := node.processing.Load()
+  if current != nil && current != evictingSignal {
+    processing = signalTypeName(current.signal)
+    processing_since = current.start
+  }
+
+  return NewWatchdogAlertSignal(node.ID, int(depth), full, waiting_since, processing, processing_since)
+}
+
+// Alerts for the nodes that were stuck at the last check, sorted by how long they've been waiting
+func (watchdog *Watchdog) Stuck() []*WatchdogAlertSignal {
+  watchdog.lock.Lock()
+  defer watchdog.lock.Unlock()
+
+  stuck := make([]*WatchdogAlertSignal, 0, len(watchdog.stuck))
+  for _, alert := range(watchdog.stuck) {
+    stuck = append(stuck, alert)
+  }
+  slices.SortFunc(stuck, func(a, b *WatchdogAlertSignal) int {
+    return a.WaitingSince.Compare(b.WaitingSince)
+  })
+  return stuck
+}
+
+// Record that a message was delivered to the node
+func (node *Node) enqueued() {
+  if node.queued.Add(1) == 1 {
+    node.progress.Store(time.Now().UnixNano())
+  }
+}
+
+// Record that the node took a delivered message from its inbox
+func (node *Node) dequeued() {
+  node.progress.Store(time.Now().UnixNano())
+  node.queued.Add(-1)
+}
+
+type HealthNode struct {
+  Node NodeID `json:"node"`
+  Depth int `json:"depth"`
+  Full bool `json:"full"`
+  WaitingSince time.Time `json:"waiting_since"`
+  Processing string `json:"processing,omitempty"`
+}
+
+type HealthDB struct {
+  Healthy bool `json:"healthy"`
+  Queued int `json:"queued"`
+  Error string `json:"error,omitempty"`
+}
+
+type HealthStatus struct {
+  // "ok", or "degraded" if any node is stuck or the DB is queueing writes
+  Status string `json:"status"`
+  Stuck []HealthNode `json:"stuck"`
+  // Set when the context's DB is a DegradedDB
+  DB *HealthDB `json:"db,omitempty"`
+}
+
+// Report the nodes the context's Watchdog found stuck and whether the DB is failing, with a 503 status if either is unhealthy
+func HealthHandler(ctx *Context) func(http.ResponseWriter, *http.Request) {
+  return func(w http.ResponseWriter, r *http.Request) {
+    enableCORS(&w)
+    w.Header().Set("Content-Type", "application/json")
+
+    health := HealthStatus{
+      Status: "ok",
+      Stuck: []HealthNode{},
+    }
+    if ctx.Watchdog != nil {
+      for _, alert := range(ctx.Watchdog.Stuck()) {
+        health.Stuck = append(health.Stuck, HealthNode{alert.Node, alert.Depth, alert.Full, alert.WaitingSince, alert.Processing})
+      }
+    }
+
+    db, degradable := ctx.DB.(*DegradedDB)
+    if degradable {
+      healthy, queued, err := db.Health()
+      health.DB = &HealthDB{
+        Healthy: healthy,
+        Queued: queued,
+      }
+      if err != nil {
+        health.DB.Error = err.Error()
+      }
+    }
+
+    if len(health.Stuck) > 0 || (health.DB != nil && health.DB.Healthy == false) {
+      health.Status = "degraded"
+      w.WriteHeader(http.StatusServiceUnavailable)
+    }
+
+    err := json.NewEncoder(w).Encode(health)
+    if err != nil {
+      ctx.Log.Logf("gql", "HEALTH_ERR: %s", err)
+    }
+  }
+}